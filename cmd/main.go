@@ -1,35 +1,88 @@
 package main
 
 import (
+	"context"
+	"digital-marketplace/internal/config"
 	"digital-marketplace/internal/controllers"
 	"digital-marketplace/internal/database"
-	"log"
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/metrics"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"digital-marketplace/internal/tracing"
+	"digital-marketplace/internal/worker"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
-	"text/template"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 )
 
 func main() {
-	err := godotenv.Load()
+	configPath := flag.String("config", "", "YAML-файл конфигурации (по умолчанию CONFIG_FILE)")
+	flag.Parse()
+
+	// Настройки из YAML, .env и переменных окружения, проверенные целиком
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Println("Failed to load .env file:", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	// Check if environment variable is read correctly
-	log.Println("SMTP_HOST:", os.Getenv("SMTP_HOST"))
-	router := gin.Default()
+	// Журнал в JSON (или текстом) в stdout; уровень и формат задаются LOG_LEVEL и LOG_FORMAT
+	logger := logging.Setup(cfg.Log, os.Stdout)
+	logger.Info("Конфигурация загружена", slog.String("config", cfg.Redacted()))
+
+	// Трассировка OpenTelemetry; экспортер задается TRACING_EXPORTER (по умолчанию выключена)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Error("Не удалось настроить трассировку", logging.Err(err))
+		os.Exit(1)
+	}
+
+	services.ConfigureMail(cfg.SMTP)
+	services.ConfigureDownloads(cfg.Downloads)
+	services.SetBaseURL(cfg.BaseURL)
+
+	// Вместо стандартных логгера и recovery gin - журнал с ID запроса
+	router := gin.New()
+	// Адрес клиента (лимиты запросов, журнал аудита, проверка скачиваний) берется из
+	// X-Forwarded-For только от доверенных прокси, иначе его легко подменить
+	if err := router.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		logger.Error("Некорректный список доверенных прокси", logging.Err(err))
+		os.Exit(1)
+	}
+	router.Use(controllers.RequestLogger(), controllers.Tracing(), controllers.RecoveryLogger(), controllers.HTTPMetrics())
 
 	// Initialize the database
-	database.InitDB()
+	if err := database.InitDB(cfg.Database); err != nil {
+		logger.Error("Не удалось подключиться к базе данных", logging.Err(err))
+		os.Exit(1)
+	}
+
+	// Репозитории поверх PostgreSQL, общие для всех контроллеров и фоновых задач
+	repos := repository.NewGorm(database.DB)
+
+	// Теги, созданные до появления слагов, получают слаг при запуске
+	if err := services.NewTagService(repos.Tags).BackfillSlugs(); err != nil {
+		logger.Warn("Не удалось заполнить слаги тегов", logging.Err(err))
+	}
+
+	// Пользователи из ADMIN_EMAILS получают роль admin; остальные роли назначаются в /admin/users
+	if promoted, err := services.NewAdminService(repos.Users, repos.Products, repos.Orders, repos.Audit, repos.Sessions).PromoteAdmins(context.Background(), cfg.AdminEmails); err != nil {
+		logger.Warn("Не удалось назначить администраторов из ADMIN_EMAILS", logging.Err(err))
+	} else if promoted > 0 {
+		logger.Info("Назначены администраторы из ADMIN_EMAILS", slog.Int("count", promoted))
+	}
 
 	// Load HTML templates with дополнительными функциями
-	router.SetFuncMap(template.FuncMap{
-		"subtract": func(a, b float64) float64 {
-			return a - b
-		},
-	})
+	router.SetFuncMap(controllers.TemplateFuncs())
 	router.LoadHTMLGlob("web/templates/*")
 
 	// Serve static files
@@ -38,60 +91,133 @@ func main() {
 	// Do NOT serve /uploads directly, use new protected routes instead
 	// router.Static("/uploads", "./uploads") // Commented out for security
 
-	// Добавляем эндпоинт для проверки работоспособности (health check)
-	router.GET("/health", func(c *gin.Context) {
-		c.String(200, "OK")
-	})
+	// Проверки для оркестратора: живость процесса и готовность зависимостей (БД, каталог загрузок, SMTP).
+	// /health оставлен для старых проверок и работает как живость.
+	if err := os.MkdirAll("./uploads", 0755); err != nil {
+		logger.Error("Не удалось создать каталог загрузок", logging.Err(err))
+		os.Exit(1)
+	}
+	health := controllers.NewHealthController(cfg.SMTP, "./uploads")
+	router.GET("/health", health.Live)
+	router.GET("/health/live", health.Live)
+	router.GET("/health/ready", health.Ready)
+
+	// Метрики для Prometheus: пул соединений с БД, действующие ссылки на скачивание
+	// и счетчики из internal/metrics. Доступ ограничен списком сетей и паролем из конфигурации.
+	if cfg.Metrics.Enabled {
+		if sqlDB, err := database.DB.DB(); err == nil {
+			metrics.RegisterDBStats(sqlDB, cfg.Database.Name)
+		}
+		metrics.RegisterGauge("download_tokens_active", "Число действующих ссылок на скачивание.", func() float64 {
+			return float64(services.ActiveDownloadTokens(time.Now()))
+		})
+		router.GET("/metrics", controllers.MetricsAccess(cfg.Metrics), gin.WrapH(metrics.Handler()))
+	}
+
+	// Фоновые задачи (письма, рассылки, очистка токенов) завершаются вместе с сервером
+	jobs := worker.NewSupervisor()
+
+	// CSRF-защита для всех маршрутов ниже (проверяются все запросы, кроме GET/HEAD/OPTIONS)
+	router.Use(controllers.CSRFProtection())
+
+	// Ограничение частоты запросов. Лимиты задаются для каждой группы маршрутов
+	// в конфигурации в формате "10/1m".
+	rateLimiter := services.NewRateLimitService(services.NewRateLimitStore(cfg.RateLimit.Store, database.DB), services.DefaultLockoutPolicy)
+	authIPLimit := rateLimitRule(cfg.RateLimit.AuthIP)
+	authAccountLimit := rateLimitRule(cfg.RateLimit.AuthAccount)
+	earnLimit := rateLimitRule(cfg.RateLimit.Earn)
+	apiLimit := rateLimitRule(cfg.RateLimit.API)
+
+	authIPLimiter := controllers.RateLimitByIP(rateLimiter, "auth", authIPLimit)
+	authAccountLimiter := controllers.RateLimitByAccount(rateLimiter, "auth", authAccountLimit)
 
 	// Initialize controllers
-	auth := controllers.NewAuthController()
-	upload := controllers.NewUploadController()
-	buy := controllers.NewBuyController()
-	prod := controllers.NewProductController()      // Product controller
-	cart := controllers.NewCartController()         // Cart controller
-	order := controllers.NewOrderController()       // Order controller
-	download := controllers.NewDownloadController() // Download controller
+	auth := controllers.NewAuthController(repos, rateLimiter, cfg.OAuth)
+	upload := controllers.NewUploadController(repos)
+	buy := controllers.NewBuyController(repos, jobs)
+	prod := controllers.NewProductController(repos)        // Product controller
+	cart := controllers.NewCartController(repos)           // Cart controller
+	order := controllers.NewOrderController(repos, jobs)   // Order controller
+	download := controllers.NewDownloadController(repos)   // Download controller
+	review := controllers.NewReviewController(repos, prod) // Отзывы на странице товара
+	wishlist := controllers.NewWishlistController(repos)   // Избранное
+	storefront := controllers.NewStorefrontController(repos, auth)
+	admin := controllers.NewAdminController(repos, jobs) // Раздел администратора
+
+	// Сессии входа: cookie содержит случайный токен, пользователь и роль проверяются по БД на каждый запрос
+	sessions := auth.Sessions()
 
 	// Public routes (only set login status)
 	public := router.Group("/")
-	public.Use(controllers.SetLoginStatus()) // Middleware to set login status
+	public.Use(controllers.SetLoginStatus(sessions)) // Middleware to set login status
 	{
 		public.GET("/", auth.ShowHome) // Homepage handler
 		public.GET("/register", auth.ShowRegister)
-		public.POST("/register", auth.Register)
+		public.POST("/register", authIPLimiter, authAccountLimiter, auth.Register)
 		public.GET("/login", auth.ShowLogin)
-		public.POST("/login", auth.Login)
-		public.GET("/products", prod.ShowProductsPage) // Новый вариант, рендерит HTML страницу
+		public.POST("/login", authIPLimiter, authAccountLimiter, auth.Login)
+		public.GET("/products", prod.ShowProductsPage)      // Новый вариант, рендерит HTML страницу
+		public.GET("/products/:id", prod.ShowProductDetail) // /products/42-slug; /products/42 перенаправляется на канонический URL
 
 		// OAuth routes
-		public.GET("/auth/github", auth.InitiateGithubLogin)
-		public.GET("/auth/github/callback", auth.HandleGithubCallback)
+		public.GET("/auth/:provider", auth.InitiateOAuthLogin)
+		public.GET("/auth/:provider/callback", auth.HandleOAuthCallback)
+		public.GET("/auth-link/confirm", auth.ShowLinkConfirm)
+		public.POST("/auth-link/confirm", authIPLimiter, auth.ConfirmLink)
+		public.GET("/auth-link/email", auth.ShowLinkEmailConfirm)
+		public.POST("/auth-link/email", authIPLimiter, auth.ConfirmLinkByEmail)
 
 		// Route to download with token (public but token-protected)
 		public.GET("/download/:token", download.HandleDownload)
 
 		// Route to serve product images (public)
 		public.GET("/images/products/:productID", download.ServeProductImage)
+
+		// Публичные витрины продавцов
+		public.GET("/u/:username", storefront.ShowStorefront)
+		public.GET("/images/avatars/:userID", storefront.ServeAvatar)
 	}
 
 	// Routes requiring authentication
 	authenticated := router.Group("/")
-	authenticated.Use(controllers.AuthRequired()) // Middleware to require authentication
+	authenticated.Use(controllers.AuthRequired(sessions)) // Middleware to require authentication
 	{
-		authenticated.GET("/logout", auth.Logout)
+		authenticated.POST("/logout", auth.Logout)
 		authenticated.GET("/upload", upload.ShowUploadPage)
 		authenticated.POST("/upload", upload.HandleUpload)
 		authenticated.GET("/buy/:productID", buy.ShowBuyPage)
 		authenticated.POST("/buy/:productID", buy.HandleBuy)
 		authenticated.GET("/profile", auth.ShowProfile)                     // Profile page
 		authenticated.POST("/profile/change-password", auth.ChangePassword) // Change password handler
-		authenticated.POST("/earn-money", auth.EarnMoney)                   // Маршрут для заработка денег
+		authenticated.POST("/profile/identities/:identityID/unlink", auth.UnlinkIdentity)
+		authenticated.POST("/profile/tokens", auth.CreateAccessToken)                 // Создание токена доступа к API
+		authenticated.POST("/profile/tokens/:tokenID/revoke", auth.RevokeAccessToken) // Отзыв токена
+		authenticated.POST("/profile/storefront", storefront.SaveSettings)            // Настройки витрины продавца
+		authenticated.POST("/earn-money",
+			controllers.RateLimitByIP(rateLimiter, "earn", earnLimit),
+			controllers.RateLimitByAccount(rateLimiter, "earn", earnLimit),
+			auth.EarnMoney) // Маршрут для заработка денег
 
 		// Cart routes
 		authenticated.GET("/cart", cart.ShowCart)                       // Show cart
 		authenticated.POST("/cart/add/:productID", cart.AddToCart)      // Add product to cart (POST to avoid accidental adds)
 		authenticated.POST("/cart/remove/:itemID", cart.RemoveFromCart) // Remove product from cart (POST)
 
+		// Избранное: подписка на письма о снижении цены и новых версиях
+		authenticated.GET("/wishlist", wishlist.ShowWishlist)
+		authenticated.POST("/wishlist/add/:productID", wishlist.AddToWishlist)
+		authenticated.POST("/wishlist/remove/:productID", wishlist.RemoveFromWishlist)
+
+		// Управление товаром со страницы товара (только продавец)
+		authenticated.POST("/products/:id/price", prod.UpdatePrice)
+		authenticated.POST("/products/:id/versions", prod.PublishVersion)
+		authenticated.POST("/products/:id/watermark", prod.UpdateWatermark)
+
+		// Отзывы: оставить может только покупатель, ответить - только продавец
+		authenticated.POST("/products/:id/reviews", review.SaveReview)
+		authenticated.POST("/reviews/:reviewID/reply", review.ReplyToReview)
+		authenticated.POST("/reviews/:reviewID/flag", review.FlagReview)
+
 		// Checkout routes
 		authenticated.POST("/checkout", order.Checkout)              // Checkout handler
 		authenticated.GET("/order/success/", order.ShowOrderSuccess) // Order success page
@@ -101,16 +227,137 @@ func main() {
 		authenticated.GET("/files/products/:productID", download.ServeProductFile) // Direct access to product files
 	}
 
-	// API routes (JSON endpoints)
-	api := router.Group("/api")
+	// Раздел администратора: каждому маршруту нужно свое право роли (models.Role.Can)
+	adminGroup := router.Group("/admin")
+	adminGroup.Use(controllers.AuthRequired(sessions), controllers.RequirePermission(models.PermAdminPanel))
 	{
-		// Добавляем маршруты для API продуктов и тегов
-		api.GET("/products", prod.GetProductsAPI) // Получение списка продуктов (JSON)
-		api.GET("/tags", prod.GetTags)            // Получение списка тегов (JSON)
-		// Сюда можно добавить другие API эндпоинты в будущем
+		adminGroup.GET("", admin.ShowIndex)
+
+		canViewUsers := controllers.RequirePermission(models.PermUsersView)
+		adminGroup.GET("/users", canViewUsers, admin.ShowUsers)
+		adminGroup.GET("/users/:id", canViewUsers, admin.ShowUser)
+		adminGroup.POST("/users/:id/balance", controllers.RequirePermission(models.PermUsersBalance), admin.AdjustBalance)
+		adminGroup.POST("/users/:id/ban", controllers.RequirePermission(models.PermUsersBan), admin.BanUser)
+		adminGroup.POST("/users/:id/unban", controllers.RequirePermission(models.PermUsersBan), admin.UnbanUser)
+		adminGroup.POST("/users/:id/role", controllers.RequirePermission(models.PermUsersRoles), admin.SetUserRole)
+
+		canModerateProducts := controllers.RequirePermission(models.PermProductsModerate)
+		adminGroup.GET("/products", canModerateProducts, admin.ShowProducts)
+		adminGroup.POST("/products/:id/unlist", canModerateProducts, admin.UnlistProduct)
+		adminGroup.POST("/products/:id/relist", canModerateProducts, admin.RelistProduct)
+
+		canViewOrders := controllers.RequirePermission(models.PermOrdersView)
+		adminGroup.GET("/orders", canViewOrders, admin.ShowOrders)
+		adminGroup.GET("/orders/:id", canViewOrders, admin.ShowOrder)
+		adminGroup.POST("/orders/:id/resend", controllers.RequirePermission(models.PermOrdersResendMail), admin.ResendOrderMail)
+
+		canModerateReviews := controllers.RequirePermission(models.PermReviewsModerate)
+		adminGroup.GET("/reviews", canModerateReviews, admin.ShowReviews)
+		adminGroup.POST("/reviews/:id/hide", canModerateReviews, admin.HideReview)
+		adminGroup.POST("/reviews/:id/restore", canModerateReviews, admin.RestoreReview)
+
+		canManageTags := controllers.RequirePermission(models.PermTagsManage)
+		adminGroup.GET("/tags", canManageTags, admin.ShowTags)
+		adminGroup.POST("/tags/:id/rename", canManageTags, admin.RenameTag)
+		adminGroup.POST("/tags/:id/parent", canManageTags, admin.SetTagParent)
+		adminGroup.POST("/tags/:id/merge", canManageTags, admin.MergeTag)
+		adminGroup.POST("/tags/:id/delete", canManageTags, admin.DeleteTag)
+
+		canViewAudit := controllers.RequirePermission(models.PermAuditView)
+		adminGroup.GET("/audit", canViewAudit, admin.ShowAudit)
+		adminGroup.GET("/audit/export", canViewAudit, admin.ExportAudit)
+	}
+
+	// API routes (JSON endpoints), включая версионированный /api/v1 и спецификацию OpenAPI
+	controllers.RegisterAPIRoutes(router, controllers.APIRoutesConfig{
+		Repos:       repos,
+		RateLimiter: rateLimiter,
+		APILimit:    apiLimit,
+		AuthIPLimit: authIPLimit,
+		Jobs:        jobs,
+	})
+
+	// Рассылка писем об избранном: события копятся в БД и уходят одним письмом на пользователя
+	wishlistService := services.NewWishlistService(repos.Wishlist, repos.Products, repos.Users)
+	jobs.Go("wishlist-notifier", func(ctx context.Context) {
+		wishlistService.RunNotifier(ctx, cfg.Wishlist.NotifyInterval)
+	})
+
+	// Очистка просроченных ссылок на скачивание, старых токенов API, сессий и запросов на привязку аккаунтов
+	tokenService := services.NewTokenService(repos.Tokens, repos.Users)
+	jobs.Every("token-sweeper", cfg.Cleanup.Interval, func(ctx context.Context) {
+		jobLogger := logging.FromContext(ctx)
+		if removed := services.SweepExpiredDownloadTokens(time.Now()); removed > 0 {
+			jobLogger.Info("Удалены просроченные ссылки на скачивание", slog.Int("count", removed))
+		}
+		removed, err := tokenService.DeleteExpiredTokens(cfg.Cleanup.TokenRetention)
+		if err != nil {
+			jobLogger.Error("Ошибка очистки токенов API", logging.Err(err))
+		} else if removed > 0 {
+			jobLogger.Info("Удалены отозванные и истекшие токены API", slog.Int64("count", removed))
+		}
+		if removed, err := sessions.DeleteExpired(); err != nil {
+			jobLogger.Error("Ошибка очистки сессий", logging.Err(err))
+		} else if removed > 0 {
+			jobLogger.Info("Удалены истекшие сессии", slog.Int64("count", removed))
+		}
+		if removed, err := auth.OAuth().DeleteExpiredPendingLinks(); err != nil {
+			jobLogger.Error("Ошибка очистки запросов на привязку аккаунтов", logging.Err(err))
+		} else if removed > 0 {
+			jobLogger.Info("Удалены истекшие запросы на привязку аккаунтов", slog.Int64("count", removed))
+		}
+	})
+
+	server := &http.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
 	}
 
 	// Start server
-	log.Println("Server started on port http://localhost:8080")
-	router.Run(":8080")
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("Сервер запущен", slog.String("addr", cfg.HTTP.Addr), slog.String("base_url", cfg.BaseURL))
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// Ждем SIGINT/SIGTERM (docker stop, деплой) или ошибку запуска сервера
+	stop, cancelSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancelSignals()
+	select {
+	case err := <-serverErr:
+		logger.Error("Ошибка HTTP-сервера", logging.Err(err))
+		os.Exit(1)
+	case <-stop.Done():
+	}
+	cancelSignals() // Повторный сигнал завершает процесс сразу
+
+	logger.Info("Получен сигнал остановки, завершаем текущие запросы", slog.Duration("timeout", cfg.HTTP.ShutdownTimeout))
+	health.SetDraining()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+
+	// Сначала дожидаемся запросов: они могут поставить в очередь письма о заказах
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Не все запросы завершились до таймаута", logging.Err(err))
+	}
+	if err := jobs.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Не все фоновые задачи завершились до таймаута", logging.Err(err))
+	}
+	// Спаны писем и запросов отправляются после того, как все задачи завершились
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Warn("Не удалось отправить оставшиеся спаны", logging.Err(err))
+	}
+	if sqlDB, err := database.DB.DB(); err == nil {
+		sqlDB.Close()
+	}
+	logger.Info("Сервер остановлен")
+}
+
+// rateLimitRule переводит правило из конфигурации в правило сервиса ограничения частоты
+func rateLimitRule(rule config.RateRule) services.RateLimitRule {
+	return services.RateLimitRule{Limit: rule.Limit, Period: rule.Period}
 }
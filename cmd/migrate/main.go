@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/database"
+	"digital-marketplace/internal/database/migrations"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Каталог с файлами миграций относительно корня проекта (для команды create)
+const defaultMigrationsDir = "internal/database/migrations"
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	if os.Args[1] == "create" {
+		create(os.Args[2:])
+		return
+	}
+
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.Connect(cfg.Database)
+	if err != nil {
+		log.Fatal("Ошибка подключения к базе данных: ", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal("Ошибка подключения к базе данных: ", err)
+	}
+	runner, err := migrations.New(sqlDB)
+	if err != nil {
+		log.Fatal("Ошибка загрузки миграций: ", err)
+	}
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "up":
+		applied, err := runner.Up(ctx)
+		for _, m := range applied {
+			fmt.Println("Применена миграция", m)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Схема актуальна, новых миграций нет")
+		}
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			n, err := strconv.Atoi(os.Args[2])
+			if err != nil || n < 1 {
+				log.Fatal("Число шагов отката должно быть положительным целым")
+			}
+			steps = n
+		}
+		rolledBack, err := runner.Down(ctx, steps)
+		for _, m := range rolledBack {
+			fmt.Println("Откачена миграция", m)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(rolledBack) == 0 {
+			fmt.Println("Нет примененных миграций")
+		}
+	case "status":
+		statuses, err := runner.Status(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printStatus(statuses)
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Использование:
+  migrate up                применить все новые миграции
+  migrate down [N]          откатить N последних миграций (по умолчанию 1)
+  migrate status            показать примененные и ожидающие миграции
+  migrate create [-dir каталог] название
+                            создать пару файлов NNNN_название.up.sql / .down.sql`)
+	os.Exit(2)
+}
+
+func create(args []string) {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	dir := flags.String("dir", defaultMigrationsDir, "каталог с миграциями")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	upPath, downPath, err := migrations.Create(*dir, flags.Arg(0))
+	if err != nil {
+		log.Fatal("Ошибка создания миграции: ", err)
+	}
+	fmt.Println("Созданы файлы:")
+	fmt.Println(" ", upPath)
+	fmt.Println(" ", downPath)
+}
+
+func printStatus(statuses []migrations.Status) {
+	pending := 0
+	for _, s := range statuses {
+		state := "ожидает"
+		switch {
+		case s.Missing:
+			state = "применена, файла нет"
+		case s.AppliedAt != nil && s.Modified:
+			state = "применена, файл изменен"
+		case s.AppliedAt != nil:
+			state = "применена"
+		default:
+			pending++
+		}
+		appliedAt := ""
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d  %-30s %-25s %s\n", s.Version, s.Name, state, appliedAt)
+	}
+	fmt.Printf("Ожидают применения: %d\n", pending)
+}
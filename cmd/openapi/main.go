@@ -0,0 +1,100 @@
+// Команда openapi выводит спецификацию JSON API и проверяет, что она совпадает
+// с маршрутами, которые регистрирует приложение.
+//
+//	go run ./cmd/openapi generate [-o openapi.json]
+//	go run ./cmd/openapi check
+//
+// check завершается с кодом 1, если маршрут из controllers.RegisterAPIRoutes не описан
+// в controllers.APISpec (или наоборот) либо документ внутренне несогласован.
+// База данных для проверки не нужна.
+package main
+
+import (
+	"digital-marketplace/internal/controllers"
+	"digital-marketplace/internal/openapi"
+	"digital-marketplace/internal/repository/memory"
+	"digital-marketplace/internal/services"
+	"digital-marketplace/internal/worker"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "generate":
+		generate(os.Args[2:])
+	case "check":
+		os.Exit(check())
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Использование: openapi generate [-o файл] | openapi check")
+	os.Exit(2)
+}
+
+func generate(args []string) {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	output := flags.String("o", "", "файл для записи (по умолчанию stdout)")
+	flags.Parse(args)
+
+	data, err := controllers.APISpec().JSON()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Ошибка сериализации спецификации:", err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "Ошибка записи файла:", err)
+		os.Exit(1)
+	}
+}
+
+func check() int {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+
+	// Лимиты и данные не влияют на список маршрутов, хранилища - в памяти
+	limiter := services.NewRateLimitService(services.NewMemoryRateLimitStore(), services.DefaultLockoutPolicy)
+	rule := services.RateLimitRule{Limit: 1, Period: time.Minute}
+	controllers.RegisterAPIRoutes(router, controllers.APIRoutesConfig{
+		Repos:       memory.New().Repositories(),
+		RateLimiter: limiter,
+		APILimit:    rule,
+		AuthIPLimit: rule,
+		Jobs:        worker.NewSupervisor(),
+	})
+
+	var routes []openapi.Route
+	for _, route := range router.Routes() {
+		routes = append(routes, openapi.Route{Method: route.Method, Path: route.Path})
+	}
+
+	doc := controllers.APISpec()
+	problems := append(doc.Validate(), openapi.CheckRoutes(doc, routes, "/api")...)
+	if len(problems) > 0 {
+		fmt.Fprintln(os.Stderr, "Спецификация OpenAPI расходится с маршрутами:")
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, " -", problem)
+		}
+		return 1
+	}
+
+	fmt.Printf("OK: %d операций описаны и зарегистрированы\n", len(doc.Operations()))
+	return 0
+}
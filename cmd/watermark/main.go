@@ -0,0 +1,131 @@
+// Команда watermark находит покупателя по водяному знаку из файла, который попал
+// в открытый доступ.
+//
+//	go run ./cmd/watermark lookup файл...
+//	go run ./cmd/watermark lookup -code WM-XXXXXXXXXXXXXXXX
+//
+// Коды ищутся в комментарии и файле лицензии архива, в метаданных PDF и изображений.
+// Если изображение пересохранили без метаданных, код можно переписать с надписи на нем
+// и передать в -code. Команда завершается с кодом 1, если ни один код не найден в базе.
+package main
+
+import (
+	"context"
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/database"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"digital-marketplace/internal/watermark"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "lookup" {
+		usage()
+	}
+
+	flags := flag.NewFlagSet("lookup", flag.ExitOnError)
+	code := flags.String("code", "", "код водяного знака вместо файлов")
+	flags.Parse(os.Args[2:])
+	if (*code == "") == (flags.NArg() == 0) {
+		usage()
+	}
+
+	// Коды и файлы, в которых они найдены
+	var codes []string
+	sources := make(map[string][]string)
+	if *code != "" {
+		normalized := strings.ToUpper(strings.TrimSpace(*code))
+		if !watermark.ValidCode(normalized) {
+			log.Fatalf("Некорректный код %q: ожидается WM- и 16 символов A-Z, 2-7", *code)
+		}
+		codes = append(codes, normalized)
+	}
+	for _, path := range flags.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal("Ошибка чтения файла: ", err)
+		}
+		found := watermark.Find(data)
+		if len(found) == 0 {
+			fmt.Printf("%s: водяной знак не найден\n", path)
+		}
+		for _, c := range found {
+			if sources[c] == nil {
+				codes = append(codes, c)
+			}
+			sources[c] = append(sources[c], path)
+		}
+	}
+	if len(codes) == 0 {
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := database.Connect(cfg.Database)
+	if err != nil {
+		log.Fatal("Ошибка подключения к базе данных: ", err)
+	}
+	repos := repository.NewGorm(db)
+
+	watermarkService := services.NewWatermarkService(repos.Downloads, repos.Audit, repos.Orders, repos.Users, repos.Products)
+	matched := 0
+	for _, c := range codes {
+		match, err := watermarkService.Lookup(context.Background(), c)
+		if errors.Is(err, services.ErrWatermarkNotFound) {
+			fmt.Printf("%s: код не найден среди скачиваний\n", c)
+			continue
+		}
+		if err != nil {
+			log.Fatal("Ошибка поиска водяного знака: ", err)
+		}
+		matched++
+		printMatch(match, sources[c])
+	}
+	if matched == 0 {
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Использование:
+  watermark lookup файл...       найти покупателя по водяным знакам в файлах
+  watermark lookup -code КОД     найти покупателя по коду, например, с надписи на изображении`)
+	os.Exit(2)
+}
+
+func printMatch(match *services.WatermarkMatch, files []string) {
+	fmt.Println(match.Code)
+	if len(files) > 0 {
+		fmt.Printf("  Файлы:       %s\n", strings.Join(files, ", "))
+	}
+
+	if match.Buyer != nil {
+		fmt.Printf("  Покупатель:  #%d %s <%s>\n", match.BuyerID, match.Buyer.Username, match.Buyer.Email)
+	} else {
+		fmt.Printf("  Покупатель:  #%d (аккаунт удален)\n", match.BuyerID)
+	}
+	if match.OrderID != 0 {
+		fmt.Printf("  Заказ:       #%d\n", match.OrderID)
+	} else {
+		fmt.Println("  Заказ:       не найден")
+	}
+	if match.Product != nil {
+		fmt.Printf("  Товар:       #%d %q, версия %d\n", match.ProductID, match.Product.Title, match.Version)
+	} else {
+		fmt.Printf("  Товар:       #%d (удален), версия %d\n", match.ProductID, match.Version)
+	}
+	fmt.Printf("  Скачан:      %s, IP %s, способ %s\n",
+		match.DownloadedAt.Local().Format("2006-01-02 15:04:05"), match.IP, match.Via)
+	if match.FromAudit {
+		fmt.Println("  Источник:    журнал аудита (запись о скачивании удалена)")
+	}
+}
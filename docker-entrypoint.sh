@@ -6,7 +6,7 @@ until pg_isready -h $DB_HOST -p $DB_PORT -U $DB_USER; do
 done
 
 echo "Running migrations..."
-./migrate
+./migrate up || exit 1
 
 echo "Starting application..."
 exec ./app 
\ No newline at end of file
@@ -0,0 +1,681 @@
+// Package config собирает настройки приложения в одну типизированную структуру.
+//
+// Источники по возрастанию приоритета:
+//  1. значения по умолчанию (Defaults);
+//  2. YAML-файл, если указан (флаг -config или переменная CONFIG_FILE);
+//  3. файл .env в рабочем каталоге (не перекрывает уже заданные переменные окружения);
+//  4. переменные окружения.
+//
+// Для любой переменной KEY можно вместо значения указать путь к файлу в KEY_FILE
+// (например, DB_PASSWORD_FILE=/run/secrets/db_password) - так передаются секреты Docker и Kubernetes.
+// После загрузки конфигурация проверяется целиком, ошибки выводятся одним списком.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Config - все настройки приложения
+type Config struct {
+	Log         LogConfig       `yaml:"log"`
+	HTTP        HTTPConfig      `yaml:"http"`
+	BaseURL     string          `yaml:"base_url"` // Публичный адрес сайта для ссылок в письмах и canonical URL
+	Database    DatabaseConfig  `yaml:"database"`
+	SMTP        SMTPConfig      `yaml:"smtp"`
+	OAuth       OAuthConfig     `yaml:"oauth"`
+	AdminEmails []string        `yaml:"admin_emails"` // Получают роль admin при запуске
+	RateLimit   RateLimitConfig `yaml:"rate_limit"`
+	Wishlist    WishlistConfig  `yaml:"wishlist"`
+	Downloads   DownloadsConfig `yaml:"downloads"`
+	Cleanup     CleanupConfig   `yaml:"cleanup"`
+	Metrics     MetricsConfig   `yaml:"metrics"`
+	Tracing     TracingConfig   `yaml:"tracing"`
+}
+
+// LogConfig - журнал приложения
+type LogConfig struct {
+	Level  slog.Level `yaml:"level"`  // debug, info, warn или error
+	Format string     `yaml:"format"` // json или text
+}
+
+// HTTPConfig - параметры HTTP-сервера
+type HTTPConfig struct {
+	Addr              string        `yaml:"addr"` // Адрес прослушивания, например ":8080"
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`  // Включает загрузку файла товара
+	WriteTimeout      time.Duration `yaml:"write_timeout"` // Включает отдачу файла при скачивании
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"` // Сколько ждать текущие запросы и фоновые задачи при остановке
+	// Прокси (nginx), которым разрешено передавать адрес клиента в X-Forwarded-For и X-Real-IP.
+	// От остальных адресов заголовки игнорируются; пустой список - всегда адрес TCP-соединения.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// DatabaseConfig - подключение к PostgreSQL
+type DatabaseConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password Secret `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+}
+
+// DSN возвращает строку подключения для драйвера PostgreSQL
+func (c DatabaseConfig) DSN() string {
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s",
+		c.Host, c.User, c.Password.Value(), c.Name, c.Port, c.SSLMode)
+}
+
+// SMTPConfig - отправка писем. Пустой Host отключает почту.
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password Secret `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// Enabled сообщает, настроена ли отправка почты
+func (c SMTPConfig) Enabled() bool {
+	return c.Host != ""
+}
+
+// OAuthConfig - провайдеры входа. Провайдер включается, если задан его client ID (для OIDC - issuer).
+type OAuthConfig struct {
+	RedirectBase string            `yaml:"redirect_base"` // Адрес, на который провайдеры возвращают пользователя
+	GitHub       OAuthClientConfig `yaml:"github"`
+	Google       OAuthClientConfig `yaml:"google"`
+	GitLab       GitLabOAuthConfig `yaml:"gitlab"`
+	OIDC         OIDCConfig        `yaml:"oidc"`
+}
+
+// OAuthClientConfig - учетные данные OAuth-приложения
+type OAuthClientConfig struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret Secret `yaml:"client_secret"`
+}
+
+// GitLabOAuthConfig - GitLab.com или собственный экземпляр GitLab
+type GitLabOAuthConfig struct {
+	OAuthClientConfig `yaml:",inline"`
+	BaseURL           string `yaml:"base_url"`
+}
+
+// OIDCConfig - произвольный провайдер OpenID Connect
+type OIDCConfig struct {
+	OAuthClientConfig `yaml:",inline"`
+	Issuer            string `yaml:"issuer"`
+	DisplayName       string `yaml:"display_name"`
+}
+
+// RateLimitConfig - ограничение частоты запросов
+type RateLimitConfig struct {
+	Store       string   `yaml:"store"` // memory или postgres
+	AuthIP      RateRule `yaml:"auth_ip"`
+	AuthAccount RateRule `yaml:"auth_account"`
+	Earn        RateRule `yaml:"earn"`
+	API         RateRule `yaml:"api"`
+}
+
+// WishlistConfig - рассылка писем об избранном
+type WishlistConfig struct {
+	NotifyInterval time.Duration `yaml:"notify_interval"`
+}
+
+// DownloadsConfig - ограничение скачиваний купленных товаров и поиск раздачи ссылок
+type DownloadsConfig struct {
+	PerPurchaseLimit int           `yaml:"per_purchase_limit"` // Сколько раз покупатель может скачать товар; 0 - без ограничения
+	AnomalyIPs       int           `yaml:"anomaly_ips"`        // Столько разных IP за AnomalyWindow считается раздачей; 0 - не проверять
+	AnomalyWindow    time.Duration `yaml:"anomaly_window"`
+}
+
+// CleanupConfig - периодическая очистка просроченных токенов
+type CleanupConfig struct {
+	Interval       time.Duration `yaml:"interval"`
+	TokenRetention time.Duration `yaml:"token_retention"` // Сколько хранить отозванные и истекшие токены API
+}
+
+// MetricsConfig - эндпоинт /metrics для Prometheus. Доступ разрешен адресам из AllowedNetworks,
+// а если заданы Username и Password - также по логину и паролю (basic auth) с любого адреса.
+type MetricsConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	AllowedNetworks []string `yaml:"allowed_networks"` // IP-адреса или подсети CIDR
+	Username        string   `yaml:"username"`
+	Password        Secret   `yaml:"password"`
+}
+
+// BasicAuth сообщает, включен ли доступ по логину и паролю
+func (c MetricsConfig) BasicAuth() bool {
+	return c.Username != "" && c.Password != ""
+}
+
+// TracingConfig - трассировка OpenTelemetry
+type TracingConfig struct {
+	Exporter     string  `yaml:"exporter"`      // none, stdout, file или otlp
+	File         string  `yaml:"file"`          // Файл для exporter=file (JSON, по спану на строку)
+	OTLPEndpoint string  `yaml:"otlp_endpoint"` // Адрес коллектора для exporter=otlp, например http://otel-collector:4318
+	SampleRatio  float64 `yaml:"sample_ratio"`  // Доля новых трасс, которые записываются: от 0 до 1
+	ServiceName  string  `yaml:"service_name"`
+}
+
+// Defaults возвращает конфигурацию по умолчанию для локального запуска
+func Defaults() Config {
+	return Config{
+		Log: LogConfig{Level: slog.LevelInfo, Format: "json"},
+		HTTP: HTTPConfig{
+			Addr:              ":8080",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       5 * time.Minute,
+			WriteTimeout:      30 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		BaseURL: "http://localhost:8080",
+		Database: DatabaseConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "postgres",
+			Password: "postgres",
+			Name:     "marketplace",
+			SSLMode:  "disable",
+		},
+		SMTP: SMTPConfig{Port: 587},
+		OAuth: OAuthConfig{
+			GitLab: GitLabOAuthConfig{BaseURL: "https://gitlab.com"},
+			OIDC:   OIDCConfig{DisplayName: "SSO"},
+		},
+		RateLimit: RateLimitConfig{
+			Store:       "memory",
+			AuthIP:      RateRule{Limit: 20, Period: time.Minute},
+			AuthAccount: RateRule{Limit: 5, Period: time.Minute},
+			Earn:        RateRule{Limit: 10, Period: time.Minute},
+			API:         RateRule{Limit: 120, Period: time.Minute},
+		},
+		Wishlist: WishlistConfig{NotifyInterval: 15 * time.Minute},
+		Downloads: DownloadsConfig{
+			PerPurchaseLimit: 20,
+			AnomalyIPs:       5,
+			AnomalyWindow:    24 * time.Hour,
+		},
+		Cleanup: CleanupConfig{
+			Interval:       time.Hour,
+			TokenRetention: 30 * 24 * time.Hour,
+		},
+		Metrics: MetricsConfig{
+			Enabled:         true,
+			AllowedNetworks: []string{"127.0.0.1/32", "::1/128"},
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			File:        "traces.jsonl",
+			SampleRatio: 1,
+			ServiceName: "digital-marketplace",
+		},
+	}
+}
+
+// Load читает конфигурацию из всех источников и проверяет ее.
+// path - YAML-файл; пустой path означает "взять из CONFIG_FILE, а если не задан - без файла".
+func Load(path string) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("чтение .env: %w", err)
+	}
+	return load(path, os.LookupEnv)
+}
+
+func load(path string, lookup func(string) (string, bool)) (*Config, error) {
+	cfg := Defaults()
+
+	if path == "" {
+		path, _ = lookup("CONFIG_FILE")
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("чтение файла конфигурации: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("разбор файла конфигурации %s: %w", path, err)
+		}
+	}
+
+	env := &envReader{lookup: lookup}
+	env.apply(&cfg)
+
+	problems := append(env.problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	return &cfg, nil
+}
+
+// ValidationError перечисляет все ошибки конфигурации сразу
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "некорректная конфигурация:\n - " + strings.Join(e.Problems, "\n - ")
+}
+
+// --- Переменные окружения ---
+
+// envReader переносит переменные окружения в конфигурацию и копит ошибки разбора
+type envReader struct {
+	lookup   func(string) (string, bool)
+	problems []string
+}
+
+func (r *envReader) apply(c *Config) {
+	r.level(&c.Log.Level, "LOG_LEVEL")
+	r.str(&c.Log.Format, "LOG_FORMAT")
+
+	r.str(&c.HTTP.Addr, "HTTP_ADDR")
+	r.duration(&c.HTTP.ReadHeaderTimeout, "HTTP_READ_HEADER_TIMEOUT")
+	r.duration(&c.HTTP.ReadTimeout, "HTTP_READ_TIMEOUT")
+	r.duration(&c.HTTP.WriteTimeout, "HTTP_WRITE_TIMEOUT")
+	r.duration(&c.HTTP.IdleTimeout, "HTTP_IDLE_TIMEOUT")
+	r.duration(&c.HTTP.ShutdownTimeout, "HTTP_SHUTDOWN_TIMEOUT")
+	r.list(&c.HTTP.TrustedProxies, "HTTP_TRUSTED_PROXIES")
+	r.str(&c.BaseURL, "BASE_URL")
+
+	r.str(&c.Database.Host, "DB_HOST")
+	r.int(&c.Database.Port, "DB_PORT")
+	r.str(&c.Database.User, "DB_USER")
+	r.secret(&c.Database.Password, "DB_PASSWORD")
+	r.str(&c.Database.Name, "DB_NAME")
+	r.str(&c.Database.SSLMode, "DB_SSLMODE")
+
+	r.str(&c.SMTP.Host, "SMTP_HOST")
+	r.int(&c.SMTP.Port, "SMTP_PORT")
+	r.str(&c.SMTP.User, "SMTP_USER")
+	r.secret(&c.SMTP.Password, "SMTP_PASS")
+	r.str(&c.SMTP.From, "SMTP_FROM_EMAIL")
+
+	r.str(&c.OAuth.RedirectBase, "OAUTH_REDIRECT_BASE")
+	r.str(&c.OAuth.GitHub.ClientID, "GITHUB_CLIENT_ID")
+	r.secret(&c.OAuth.GitHub.ClientSecret, "GITHUB_CLIENT_SECRET")
+	r.str(&c.OAuth.Google.ClientID, "GOOGLE_CLIENT_ID")
+	r.secret(&c.OAuth.Google.ClientSecret, "GOOGLE_CLIENT_SECRET")
+	r.str(&c.OAuth.GitLab.ClientID, "GITLAB_CLIENT_ID")
+	r.secret(&c.OAuth.GitLab.ClientSecret, "GITLAB_CLIENT_SECRET")
+	r.str(&c.OAuth.GitLab.BaseURL, "GITLAB_BASE_URL")
+	r.str(&c.OAuth.OIDC.Issuer, "OIDC_ISSUER")
+	r.str(&c.OAuth.OIDC.ClientID, "OIDC_CLIENT_ID")
+	r.secret(&c.OAuth.OIDC.ClientSecret, "OIDC_CLIENT_SECRET")
+	r.str(&c.OAuth.OIDC.DisplayName, "OIDC_DISPLAY_NAME")
+
+	r.list(&c.AdminEmails, "ADMIN_EMAILS")
+
+	r.str(&c.RateLimit.Store, "RATE_LIMIT_STORE")
+	r.rule(&c.RateLimit.AuthIP, "RATE_LIMIT_AUTH_IP")
+	r.rule(&c.RateLimit.AuthAccount, "RATE_LIMIT_AUTH_ACCOUNT")
+	r.rule(&c.RateLimit.Earn, "RATE_LIMIT_EARN")
+	r.rule(&c.RateLimit.API, "RATE_LIMIT_API")
+
+	r.duration(&c.Wishlist.NotifyInterval, "WISHLIST_NOTIFY_INTERVAL")
+
+	r.int(&c.Downloads.PerPurchaseLimit, "DOWNLOAD_LIMIT_PER_PURCHASE")
+	r.int(&c.Downloads.AnomalyIPs, "DOWNLOAD_ANOMALY_IPS")
+	r.duration(&c.Downloads.AnomalyWindow, "DOWNLOAD_ANOMALY_WINDOW")
+
+	r.duration(&c.Cleanup.Interval, "CLEANUP_INTERVAL")
+	r.duration(&c.Cleanup.TokenRetention, "ACCESS_TOKEN_RETENTION")
+
+	r.bool(&c.Metrics.Enabled, "METRICS_ENABLED")
+	r.list(&c.Metrics.AllowedNetworks, "METRICS_ALLOWED_NETWORKS")
+	r.str(&c.Metrics.Username, "METRICS_USER")
+	r.secret(&c.Metrics.Password, "METRICS_PASSWORD")
+
+	r.str(&c.Tracing.Exporter, "TRACING_EXPORTER")
+	r.str(&c.Tracing.File, "TRACING_FILE")
+	r.str(&c.Tracing.OTLPEndpoint, "TRACING_OTLP_ENDPOINT")
+	r.float(&c.Tracing.SampleRatio, "TRACING_SAMPLE_RATIO")
+	r.str(&c.Tracing.ServiceName, "TRACING_SERVICE_NAME")
+}
+
+// value возвращает значение KEY или содержимое файла из KEY_FILE.
+// Пустая переменная считается незаданной, чтобы "KEY=" в .env не затирало YAML и значения по умолчанию.
+func (r *envReader) value(key string) (string, bool) {
+	value, _ := r.lookup(key)
+	file, _ := r.lookup(key + "_FILE")
+
+	switch {
+	case file != "" && value != "":
+		r.problems = append(r.problems, fmt.Sprintf("%s: заданы одновременно %s и %s_FILE", key, key, key))
+		return "", false
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			r.problems = append(r.problems, fmt.Sprintf("%s_FILE: %v", key, err))
+			return "", false
+		}
+		// Редакторы и echo добавляют перевод строки в конец файла - он не часть секрета
+		return strings.TrimRight(string(data), "\r\n"), true
+	default:
+		return value, value != ""
+	}
+}
+
+func (r *envReader) str(dst *string, key string) {
+	if value, ok := r.value(key); ok {
+		*dst = strings.TrimSpace(value)
+	}
+}
+
+func (r *envReader) secret(dst *Secret, key string) {
+	if value, ok := r.value(key); ok {
+		*dst = Secret(value)
+	}
+}
+
+func (r *envReader) int(dst *int, key string) {
+	if value, ok := r.value(key); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			r.problems = append(r.problems, fmt.Sprintf("%s: ожидается целое число, получено %q", key, value))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (r *envReader) float(dst *float64, key string) {
+	if value, ok := r.value(key); ok {
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			r.problems = append(r.problems, fmt.Sprintf("%s: ожидается число, получено %q", key, value))
+			return
+		}
+		*dst = f
+	}
+}
+
+func (r *envReader) bool(dst *bool, key string) {
+	if value, ok := r.value(key); ok {
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			r.problems = append(r.problems, fmt.Sprintf("%s: ожидается true или false, получено %q", key, value))
+			return
+		}
+		*dst = b
+	}
+}
+
+func (r *envReader) duration(dst *time.Duration, key string) {
+	if value, ok := r.value(key); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			r.problems = append(r.problems, fmt.Sprintf("%s: ожидается длительность вида 15m или 1h, получено %q", key, value))
+			return
+		}
+		*dst = d
+	}
+}
+
+func (r *envReader) level(dst *slog.Level, key string) {
+	if value, ok := r.value(key); ok {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+			r.problems = append(r.problems, fmt.Sprintf("%s: ожидается debug, info, warn или error, получено %q", key, value))
+			return
+		}
+		*dst = level
+	}
+}
+
+func (r *envReader) list(dst *[]string, key string) {
+	if value, ok := r.value(key); ok {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*dst = items
+	}
+}
+
+func (r *envReader) rule(dst *RateRule, key string) {
+	if value, ok := r.value(key); ok {
+		rule, err := ParseRateRule(value)
+		if err != nil {
+			r.problems = append(r.problems, fmt.Sprintf("%s: %v", key, err))
+			return
+		}
+		*dst = rule
+	}
+}
+
+// --- Проверка ---
+
+func (c *Config) validate() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.HTTP.Addr); err != nil {
+		add("HTTP_ADDR: ожидается адрес вида :8080 или 127.0.0.1:8080, получено %q", c.HTTP.Addr)
+	}
+	for _, proxy := range c.HTTP.TrustedProxies {
+		if _, err := ParseNetwork(proxy); err != nil {
+			add("HTTP_TRUSTED_PROXIES: %v", err)
+		}
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		add("LOG_FORMAT: ожидается json или text, получено %q", c.Log.Format)
+	}
+
+	// Нулевой таймаут в net/http означает "без ограничения", поэтому запрещены только отрицательные
+	httpTimeouts := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"HTTP_READ_HEADER_TIMEOUT", c.HTTP.ReadHeaderTimeout},
+		{"HTTP_READ_TIMEOUT", c.HTTP.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.HTTP.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", c.HTTP.IdleTimeout},
+	}
+	for _, t := range httpTimeouts {
+		if t.value < 0 {
+			add("%s: таймаут не может быть отрицательным, получено %s", t.key, t.value)
+		}
+	}
+	if c.HTTP.ShutdownTimeout <= 0 {
+		add("HTTP_SHUTDOWN_TIMEOUT: таймаут должен быть положительным, получено %s", c.HTTP.ShutdownTimeout)
+	}
+	if !isHTTPURL(c.BaseURL) {
+		add("BASE_URL: ожидается абсолютный http(s) адрес, получено %q", c.BaseURL)
+	}
+
+	if c.Database.Host == "" {
+		add("DB_HOST: не задан")
+	}
+	if !validPort(c.Database.Port) {
+		add("DB_PORT: порт должен быть от 1 до 65535, получено %d", c.Database.Port)
+	}
+	if c.Database.User == "" {
+		add("DB_USER: не задан")
+	}
+	if c.Database.Name == "" {
+		add("DB_NAME: не задано")
+	}
+	switch c.Database.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		add("DB_SSLMODE: неизвестный режим %q", c.Database.SSLMode)
+	}
+
+	if c.SMTP.Enabled() {
+		if !validPort(c.SMTP.Port) {
+			add("SMTP_PORT: порт должен быть от 1 до 65535, получено %d", c.SMTP.Port)
+		}
+		if c.SMTP.User != "" && c.SMTP.Password == "" {
+			add("SMTP_PASS: задан SMTP_USER, но не задан пароль")
+		}
+	}
+	if c.SMTP.From != "" {
+		if _, err := mail.ParseAddress(c.SMTP.From); err != nil {
+			add("SMTP_FROM_EMAIL: некорректный адрес %q", c.SMTP.From)
+		}
+	}
+
+	oauthClients := []struct {
+		prefix string
+		client OAuthClientConfig
+	}{
+		{"GITHUB", c.OAuth.GitHub},
+		{"GOOGLE", c.OAuth.Google},
+		{"GITLAB", c.OAuth.GitLab.OAuthClientConfig},
+	}
+	for _, p := range oauthClients {
+		if p.client.ClientID != "" && p.client.ClientSecret == "" {
+			add("%s_CLIENT_SECRET: задан %s_CLIENT_ID, но не задан секрет", p.prefix, p.prefix)
+		}
+	}
+	if c.OAuth.GitLab.ClientID != "" && !isHTTPURL(c.OAuth.GitLab.BaseURL) {
+		add("GITLAB_BASE_URL: ожидается http(s) адрес, получено %q", c.OAuth.GitLab.BaseURL)
+	}
+	if c.OAuth.OIDC.Issuer != "" {
+		if !isHTTPURL(c.OAuth.OIDC.Issuer) {
+			add("OIDC_ISSUER: ожидается http(s) адрес, получено %q", c.OAuth.OIDC.Issuer)
+		}
+		if c.OAuth.OIDC.ClientID == "" {
+			add("OIDC_CLIENT_ID: задан OIDC_ISSUER, но не задан client ID")
+		}
+	}
+	if c.OAuth.RedirectBase != "" && !isHTTPURL(c.OAuth.RedirectBase) {
+		add("OAUTH_REDIRECT_BASE: ожидается абсолютный http(s) адрес, получено %q", c.OAuth.RedirectBase)
+	}
+
+	for _, email := range c.AdminEmails {
+		if _, err := mail.ParseAddress(email); err != nil {
+			add("ADMIN_EMAILS: некорректный адрес %q", email)
+		}
+	}
+
+	switch c.RateLimit.Store {
+	case "memory", "postgres":
+	default:
+		add("RATE_LIMIT_STORE: ожидается memory или postgres, получено %q", c.RateLimit.Store)
+	}
+	rateRules := []struct {
+		key  string
+		rule RateRule
+	}{
+		{"RATE_LIMIT_AUTH_IP", c.RateLimit.AuthIP},
+		{"RATE_LIMIT_AUTH_ACCOUNT", c.RateLimit.AuthAccount},
+		{"RATE_LIMIT_EARN", c.RateLimit.Earn},
+		{"RATE_LIMIT_API", c.RateLimit.API},
+	}
+	for _, r := range rateRules {
+		if r.rule.Limit <= 0 || r.rule.Period <= 0 {
+			add("%s: лимит и период должны быть положительными, получено %s", r.key, r.rule)
+		}
+	}
+
+	if c.Wishlist.NotifyInterval <= 0 {
+		add("WISHLIST_NOTIFY_INTERVAL: период должен быть положительным, получено %s", c.Wishlist.NotifyInterval)
+	}
+	if c.Downloads.PerPurchaseLimit < 0 {
+		add("DOWNLOAD_LIMIT_PER_PURCHASE: лимит не может быть отрицательным, получено %d", c.Downloads.PerPurchaseLimit)
+	}
+	if c.Downloads.AnomalyIPs < 0 {
+		add("DOWNLOAD_ANOMALY_IPS: число адресов не может быть отрицательным, получено %d", c.Downloads.AnomalyIPs)
+	}
+	if c.Downloads.AnomalyIPs > 0 && c.Downloads.AnomalyWindow <= 0 {
+		add("DOWNLOAD_ANOMALY_WINDOW: период должен быть положительным, получено %s", c.Downloads.AnomalyWindow)
+	}
+	if c.Cleanup.Interval <= 0 {
+		add("CLEANUP_INTERVAL: период должен быть положительным, получено %s", c.Cleanup.Interval)
+	}
+	if c.Cleanup.TokenRetention < 0 {
+		add("ACCESS_TOKEN_RETENTION: срок не может быть отрицательным, получено %s", c.Cleanup.TokenRetention)
+	}
+
+	for _, network := range c.Metrics.AllowedNetworks {
+		if _, err := ParseNetwork(network); err != nil {
+			add("METRICS_ALLOWED_NETWORKS: %v", err)
+		}
+	}
+	if (c.Metrics.Username == "") != (c.Metrics.Password == "") {
+		add("METRICS_USER, METRICS_PASSWORD: для доступа по паролю нужно задать оба значения")
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "file":
+		if c.Tracing.File == "" {
+			add("TRACING_FILE: не задан файл для exporter=file")
+		}
+	case "otlp":
+		if !isHTTPURL(c.Tracing.OTLPEndpoint) {
+			add("TRACING_OTLP_ENDPOINT: ожидается http(s) адрес коллектора, получено %q", c.Tracing.OTLPEndpoint)
+		}
+	default:
+		add("TRACING_EXPORTER: ожидается none, stdout, file или otlp, получено %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("TRACING_SAMPLE_RATIO: ожидается число от 0 до 1, получено %g", c.Tracing.SampleRatio)
+	}
+	if c.Tracing.ServiceName == "" {
+		add("TRACING_SERVICE_NAME: не задано")
+	}
+	return problems
+}
+
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// ParseNetwork разбирает подсеть CIDR или отдельный IP-адрес (как подсеть /32 или /128)
+func ParseNetwork(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("некорректная подсеть %q", value)
+		}
+		return network, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("некорректный IP-адрес %q", value)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// --- Вывод ---
+
+// Redacted возвращает конфигурацию в виде YAML со скрытыми секретами - для журнала при запуске
+func (c *Config) Redacted() string {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lookupFrom возвращает функцию поиска переменных окружения по словарю
+func lookupFrom(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+// writeFile создает файл во временном каталоге теста и возвращает путь к нему
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEnvReaderValue(t *testing.T) {
+	secret := writeFile(t, "db_password", "s3cr3t\n")
+	crlf := writeFile(t, "crlf", "line\r\n")
+	inner := writeFile(t, "inner", "  с пробелами  \n")
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		want        string
+		wantOK      bool
+		wantProblem string
+	}{
+		{"не задана", nil, "", false, ""},
+		{"значение", map[string]string{"KEY": "value"}, "value", true, ""},
+		{"пустое значение считается незаданным", map[string]string{"KEY": ""}, "", false, ""},
+		{"значение из файла", map[string]string{"KEY_FILE": secret}, "s3cr3t", true, ""},
+		{"перевод строки Windows", map[string]string{"KEY_FILE": crlf}, "line", true, ""},
+		{"пробелы внутри файла сохраняются", map[string]string{"KEY_FILE": inner}, "  с пробелами  ", true, ""},
+		{"пустой KEY_FILE не мешает KEY", map[string]string{"KEY": "value", "KEY_FILE": ""}, "value", true, ""},
+		{"заданы KEY и KEY_FILE", map[string]string{"KEY": "value", "KEY_FILE": secret}, "", false,
+			"KEY: заданы одновременно KEY и KEY_FILE"},
+		{"файла нет", map[string]string{"KEY_FILE": missing}, "", false, "KEY_FILE: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &envReader{lookup: lookupFrom(tt.env)}
+			got, ok := r.value("KEY")
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("value = %q, %v, ожидалось %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+			switch {
+			case tt.wantProblem == "" && len(r.problems) > 0:
+				t.Errorf("лишние ошибки: %v", r.problems)
+			case tt.wantProblem != "" && (len(r.problems) != 1 || !strings.HasPrefix(r.problems[0], tt.wantProblem)):
+				t.Errorf("ошибки %v, ожидалась %q", r.problems, tt.wantProblem)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	password := writeFile(t, "db_password", "from-file\n")
+	yamlFile := writeFile(t, "config.yaml", "http:\n  addr: \":9090\"\ndatabase:\n  host: db.internal\n  port: 6543\nrate_limit:\n  api: 60/1m\n")
+
+	tests := []struct {
+		name  string
+		path  string
+		env   map[string]string
+		check func(t *testing.T, cfg *Config)
+	}{
+		{"значения по умолчанию", "", nil, func(t *testing.T, cfg *Config) {
+			if cfg.HTTP.Addr != ":8080" || cfg.Database.Password.Value() != "postgres" || cfg.Log.Level != slog.LevelInfo {
+				t.Errorf("конфигурация по умолчанию: %+v", cfg)
+			}
+		}},
+		{"переменные окружения", "", map[string]string{
+			"LOG_LEVEL":            "debug",
+			"DB_PORT":              " 5433 ",
+			"HTTP_TRUSTED_PROXIES": "10.0.0.1, ,10.0.0.0/8",
+			"RATE_LIMIT_EARN":      "3/10s",
+			"METRICS_ENABLED":      "false",
+			"BASE_URL":             "https://shop.example.com/",
+		}, func(t *testing.T, cfg *Config) {
+			if cfg.Log.Level != slog.LevelDebug || cfg.Database.Port != 5433 || cfg.Metrics.Enabled {
+				t.Errorf("конфигурация: %+v", cfg)
+			}
+			if len(cfg.HTTP.TrustedProxies) != 2 || cfg.HTTP.TrustedProxies[1] != "10.0.0.0/8" {
+				t.Errorf("HTTP_TRUSTED_PROXIES: %v", cfg.HTTP.TrustedProxies)
+			}
+			if cfg.RateLimit.Earn != (RateRule{Limit: 3, Period: 10 * time.Second}) {
+				t.Errorf("RATE_LIMIT_EARN: %s", cfg.RateLimit.Earn)
+			}
+			if cfg.BaseURL != "https://shop.example.com" {
+				t.Errorf("BASE_URL: %q", cfg.BaseURL)
+			}
+		}},
+		{"секрет из файла", "", map[string]string{"DB_PASSWORD_FILE": password}, func(t *testing.T, cfg *Config) {
+			if cfg.Database.Password.Value() != "from-file" {
+				t.Errorf("DB_PASSWORD: %q", cfg.Database.Password.Value())
+			}
+		}},
+		{"YAML из CONFIG_FILE, окружение важнее", "", map[string]string{"CONFIG_FILE": yamlFile, "DB_PORT": "7000"}, func(t *testing.T, cfg *Config) {
+			if cfg.HTTP.Addr != ":9090" || cfg.Database.Host != "db.internal" || cfg.Database.Port != 7000 {
+				t.Errorf("конфигурация: %+v", cfg)
+			}
+			if cfg.RateLimit.API != (RateRule{Limit: 60, Period: time.Minute}) {
+				t.Errorf("rate_limit.api: %s", cfg.RateLimit.API)
+			}
+		}},
+		{"путь из аргумента важнее CONFIG_FILE", yamlFile, map[string]string{"CONFIG_FILE": "/nonexistent.yaml"}, func(t *testing.T, cfg *Config) {
+			if cfg.HTTP.Addr != ":9090" {
+				t.Errorf("HTTP_ADDR: %q", cfg.HTTP.Addr)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := load(tt.path, lookupFrom(tt.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadCollectsAllProblems(t *testing.T) {
+	password := writeFile(t, "db_password", "from-file")
+	_, err := load("", lookupFrom(map[string]string{
+		"DB_PORT":          "порт",
+		"DB_PASSWORD":      "inline",
+		"DB_PASSWORD_FILE": password,
+		"LOG_FORMAT":       "xml",
+		"RATE_LIMIT_API":   "10",
+		"SMTP_HOST":        "smtp.example.com",
+		"SMTP_USER":        "mailer",
+		"GITHUB_CLIENT_ID": "client",
+	}))
+
+	var validation *ValidationError
+	if !errors.As(err, &validation) {
+		t.Fatalf("ошибка %v, ожидалась ValidationError", err)
+	}
+	// Ошибки разбора переменных идут первыми, затем ошибки проверки всей конфигурации
+	want := []string{"DB_PORT:", "DB_PASSWORD:", "RATE_LIMIT_API:", "LOG_FORMAT:", "SMTP_PASS:", "GITHUB_CLIENT_SECRET:"}
+	if len(validation.Problems) != len(want) {
+		t.Fatalf("ошибки %q, ожидалось %d", validation.Problems, len(want))
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(validation.Problems[i], prefix) {
+			t.Errorf("ошибка %d: %q, ожидалась %s...", i+1, validation.Problems[i], prefix)
+		}
+	}
+	if message := err.Error(); strings.Count(message, "\n - ") != len(want) {
+		t.Errorf("текст ошибки: %s", message)
+	}
+	if strings.Contains(err.Error(), "inline") || strings.Contains(err.Error(), "from-file") {
+		t.Errorf("секрет попал в текст ошибки: %s", err)
+	}
+}
+
+func TestRedactedHidesSecrets(t *testing.T) {
+	secrets := map[string]string{
+		"DB_PASSWORD":          "db-pass-123",
+		"SMTP_PASS":            "smtp-pass-456",
+		"GITHUB_CLIENT_SECRET": "gh-secret-789",
+		"OIDC_CLIENT_SECRET":   "oidc-secret-000",
+		"METRICS_PASSWORD":     "metrics-pass-111",
+	}
+	env := map[string]string{
+		"SMTP_HOST":        "smtp.example.com",
+		"SMTP_USER":        "mailer",
+		"GITHUB_CLIENT_ID": "gh-client",
+		"OIDC_ISSUER":      "https://sso.example.com",
+		"OIDC_CLIENT_ID":   "oidc-client",
+		"METRICS_USER":     "prometheus",
+	}
+	for key, value := range secrets {
+		env[key] = value
+	}
+	cfg, err := load("", lookupFrom(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := cfg.Redacted()
+	for key, value := range secrets {
+		if strings.Contains(out, value) {
+			t.Errorf("%s виден в Redacted():\n%s", key, out)
+		}
+	}
+	if got := strings.Count(out, redacted); got != len(secrets) {
+		t.Errorf("заглушек %d, ожидалось %d:\n%s", got, len(secrets), out)
+	}
+	// Остальные настройки выводятся как есть
+	for _, value := range []string{"smtp.example.com", "gh-client", "prometheus"} {
+		if !strings.Contains(out, value) {
+			t.Errorf("%q нет в Redacted():\n%s", value, out)
+		}
+	}
+	if cfg.Database.Password.Value() != "db-pass-123" {
+		t.Errorf("значение секрета изменилось: %q", cfg.Database.Password.Value())
+	}
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted - то, что выводится вместо значения секрета
+const redacted = "[скрыто]"
+
+// Secret - строка, которая не попадает в логи: fmt, %v и YAML выводят вместо нее заглушку.
+// Настоящее значение доступно только через Value.
+type Secret string
+
+// Value возвращает значение секрета
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// String скрывает значение при выводе через fmt
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString скрывает значение при выводе через %#v
+func (s Secret) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+// LogValue скрывает значение в структурированном журнале
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// MarshalYAML скрывает значение в YAML (Config.Redacted)
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// RateRule - правило ограничения частоты: не более Limit запросов за Period.
+// В переменных окружения и YAML записывается как "10/1m".
+type RateRule struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParseRateRule разбирает правило вида "10/1m" (10 запросов в минуту)
+func ParseRateRule(value string) (RateRule, error) {
+	value = strings.TrimSpace(value)
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return RateRule{}, fmt.Errorf("ожидается правило вида 10/1m, получено %q", value)
+	}
+
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || limit <= 0 {
+		return RateRule{}, fmt.Errorf("некорректный лимит в правиле %q", value)
+	}
+
+	period, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || period <= 0 {
+		return RateRule{}, fmt.Errorf("некорректный период в правиле %q", value)
+	}
+
+	return RateRule{Limit: limit, Period: period}, nil
+}
+
+// String возвращает правило в том же формате, в котором оно задается
+func (r RateRule) String() string {
+	return fmt.Sprintf("%d/%s", r.Limit, r.Period)
+}
+
+// UnmarshalYAML читает правило из строки "10/1m"
+func (r *RateRule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return errors.New("правило ограничения частоты должно быть строкой вида 10/1m")
+	}
+	rule, err := ParseRateRule(node.Value)
+	if err != nil {
+		return err
+	}
+	*r = rule
+	return nil
+}
+
+// MarshalYAML записывает правило строкой "10/1m"
+func (r RateRule) MarshalYAML() (interface{}, error) {
+	return r.String(), nil
+}
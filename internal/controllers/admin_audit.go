@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/services"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuditPageSize - сколько записей журнала показывает одна страница /admin/audit
+const adminAuditPageSize = 100
+
+// auditDateLayout - формат дат в фильтре журнала (поля from и to)
+const auditDateLayout = "2006-01-02"
+
+// auditExportRecord - строка выгрузки журнала в JSONL
+type auditExportRecord struct {
+	ID         uint            `json:"id"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	ActorID    *uint           `json:"actorId"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"targetType,omitempty"`
+	TargetID   *uint           `json:"targetId"`
+	IP         string          `json:"ip,omitempty"`
+	UserAgent  string          `json:"userAgent,omitempty"`
+	Details    json.RawMessage `json:"details"`
+	Note       string          `json:"note,omitempty"`
+}
+
+// auditCSVHeader - заголовок выгрузки журнала в CSV
+var auditCSVHeader = []string{"id", "created_at", "actor_id", "action", "target_type", "target_id", "ip", "user_agent", "details", "note"}
+
+// ShowAudit показывает журнал аудита с фильтрами, новые записи первыми (GET /admin/audit)
+func (ac *AdminController) ShowAudit(c *gin.Context) {
+	filter, errMsg := auditFilterFromQuery(c)
+	if errMsg != "" {
+		ac.renderAudit(c, http.StatusBadRequest, nil, errMsg)
+		return
+	}
+
+	events, err := ac.auditService.Search(filter, adminAuditPageSize)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки журнала аудита", logging.Err(err))
+		ac.renderAudit(c, http.StatusInternalServerError, nil, "Не удалось загрузить журнал аудита")
+		return
+	}
+	ac.renderAudit(c, http.StatusOK, events, "")
+}
+
+// ExportAudit выгружает журнал по тем же фильтрам в CSV или JSONL (GET /admin/audit/export?format=csv|jsonl).
+// Постраничный параметр before не учитывается: выгружаются все подходящие записи.
+func (ac *AdminController) ExportAudit(c *gin.Context) {
+	filter, errMsg := auditFilterFromQuery(c)
+	if errMsg != "" {
+		ac.renderAudit(c, http.StatusBadRequest, nil, errMsg)
+		return
+	}
+	filter.BeforeID = 0
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		ac.renderAudit(c, http.StatusBadRequest, nil, "Формат выгрузки должен быть csv или jsonl")
+		return
+	}
+
+	// Сама выгрузка тоже попадает в журнал: кто и с какими фильтрами забрал записи
+	recordAuditEvent(c, ac.auditService, auditActor(c), services.AuditEntry{
+		Action:  services.AuditLogExport,
+		Details: map[string]interface{}{"format": format, "filter": auditExportQuery(c)},
+	})
+
+	fileName := "audit-" + time.Now().UTC().Format("20060102-150405") + "." + format
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Status(http.StatusOK)
+
+	var err error
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		err = ac.exportAuditCSV(c, filter)
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		err = ac.exportAuditJSONL(c, filter)
+	}
+	// Заголовки уже отправлены, поэтому ошибку остается только записать в лог
+	if err != nil {
+		requestLogger(c).Error("Ошибка выгрузки журнала аудита", logging.Err(err))
+	}
+}
+
+func (ac *AdminController) exportAuditCSV(c *gin.Context, filter services.AuditFilter) error {
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write(auditCSVHeader); err != nil {
+		return err
+	}
+	err := ac.auditService.Export(c.Request.Context(), filter, func(event models.AuditEvent) error {
+		return w.Write([]string{
+			strconv.FormatUint(uint64(event.ID), 10),
+			event.CreatedAt.UTC().Format(time.RFC3339),
+			optionalID(event.ActorID),
+			event.Action,
+			event.TargetType,
+			optionalID(event.TargetID),
+			csvSafe(event.IP),
+			csvSafe(event.UserAgent),
+			csvSafe(event.Details),
+			csvSafe(event.Note),
+		})
+	})
+	w.Flush()
+	if err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+func (ac *AdminController) exportAuditJSONL(c *gin.Context, filter services.AuditFilter) error {
+	encoder := json.NewEncoder(c.Writer)
+	return ac.auditService.Export(c.Request.Context(), filter, func(event models.AuditEvent) error {
+		details := json.RawMessage(event.Details)
+		if !json.Valid(details) {
+			details = json.RawMessage("{}")
+		}
+		return encoder.Encode(auditExportRecord{
+			ID:         event.ID,
+			CreatedAt:  event.CreatedAt.UTC(),
+			ActorID:    event.ActorID,
+			Action:     event.Action,
+			TargetType: event.TargetType,
+			TargetID:   event.TargetID,
+			IP:         event.IP,
+			UserAgent:  event.UserAgent,
+			Details:    details,
+			Note:       event.Note,
+		})
+	})
+}
+
+func (ac *AdminController) renderAudit(c *gin.Context, status int, events []models.AuditEvent, errMsg string) {
+	olderURL := ""
+	if len(events) == adminAuditPageSize {
+		query := c.Request.URL.Query()
+		query.Set("before", strconv.FormatUint(uint64(events[len(events)-1].ID), 10))
+		olderURL = "/admin/audit?" + query.Encode()
+	}
+
+	renderTemplateWithStatus(c, status, "admin_audit.html", gin.H{
+		"Events":      events,
+		"Filter":      c.Request.URL.Query(),
+		"TargetTypes": services.AuditTargetTypes,
+		"OlderURL":    olderURL,
+		"ExportCSV":   auditExportURL(c, "csv"),
+		"ExportJSONL": auditExportURL(c, "jsonl"),
+		"Error":       errMsg,
+	})
+}
+
+// auditFilterFromQuery разбирает фильтры журнала из строки запроса.
+// Возвращает сообщение об ошибке, если какой-то параметр задан некорректно.
+func auditFilterFromQuery(c *gin.Context) (services.AuditFilter, string) {
+	filter := services.AuditFilter{
+		Action:     strings.TrimSpace(c.Query("action")),
+		TargetType: c.Query("target_type"),
+		IP:         strings.TrimSpace(c.Query("ip")),
+	}
+
+	ids := []struct {
+		param string
+		dst   *uint
+	}{
+		{"actor", &filter.ActorID},
+		{"target_id", &filter.TargetID},
+		{"before", &filter.BeforeID},
+	}
+	for _, id := range ids {
+		value := strings.TrimPrefix(strings.TrimSpace(c.Query(id.param)), "#")
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return filter, fmt.Sprintf("Некорректный ID в параметре %s", id.param)
+		}
+		*id.dst = uint(parsed)
+	}
+
+	if filter.TargetType != "" {
+		known := false
+		for _, targetType := range services.AuditTargetTypes {
+			known = known || targetType == filter.TargetType
+		}
+		if !known {
+			return filter, "Неизвестный тип объекта"
+		}
+	}
+
+	if from := c.Query("from"); from != "" {
+		date, err := time.ParseInLocation(auditDateLayout, from, time.Local)
+		if err != nil {
+			return filter, "Дата начала должна быть в формате ГГГГ-ММ-ДД"
+		}
+		filter.From = date
+	}
+	if to := c.Query("to"); to != "" {
+		date, err := time.ParseInLocation(auditDateLayout, to, time.Local)
+		if err != nil {
+			return filter, "Дата окончания должна быть в формате ГГГГ-ММ-ДД"
+		}
+		// Дата окончания входит в период целиком
+		filter.To = date.AddDate(0, 0, 1)
+	}
+	return filter, ""
+}
+
+// auditExportURL - ссылка на выгрузку журнала в формате format с текущими фильтрами
+func auditExportURL(c *gin.Context, format string) string {
+	query := auditExportQuery(c)
+	if query != "" {
+		query += "&"
+	}
+	return "/admin/audit/export?" + query + "format=" + format
+}
+
+// auditExportQuery - текущие фильтры журнала без постраничного параметра и формата
+func auditExportQuery(c *gin.Context) string {
+	query := url.Values{}
+	for key, values := range c.Request.URL.Query() {
+		if key == "before" || key == "format" {
+			continue
+		}
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	return query.Encode()
+}
+
+// optionalID форматирует необязательный ID для CSV (пустая строка вместо NULL)
+func optionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+// csvSafe не дает табличным редакторам принять значение из журнала за формулу:
+// User-Agent и комментарии задаются пользователями и могут начинаться с "=", "+", "-" или "@".
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,618 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"digital-marketplace/internal/worker"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminHistoryLimit - сколько последних записей журнала аудита показывать на карточках
+const adminHistoryLimit = 50
+
+// AdminController обслуживает раздел администратора /admin. Доступ к каждому маршруту
+// ограничен правом роли (RequirePermission); каждое действие записывается в журнал аудита.
+type AdminController struct {
+	validationService *services.ValidationService
+	tagService        *services.TagService
+	adminService      *services.AdminService
+	auditService      *services.AuditService
+	reviewService     *services.ReviewService
+	products          repository.ProductRepository
+	orders            repository.OrderRepository
+	mailer            *orderMailer
+}
+
+func NewAdminController(repos repository.Repositories, jobs *worker.Supervisor) *AdminController {
+	return &AdminController{
+		validationService: services.NewValidationService(),
+		tagService:        services.NewTagService(repos.Tags),
+		adminService:      services.NewAdminService(repos.Users, repos.Products, repos.Orders, repos.Audit, repos.Sessions),
+		auditService:      services.NewAuditService(repos.Audit),
+		reviewService:     services.NewReviewService(repos.Reviews, repos.Products, repos.Orders),
+		products:          repos.Products,
+		orders:            repos.Orders,
+		mailer:            newOrderMailer(repos, jobs),
+	}
+}
+
+// ShowIndex открывает первый доступный сотруднику раздел (GET /admin)
+func (ac *AdminController) ShowIndex(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+	if user.Role.Can(models.PermUsersView) {
+		c.Redirect(http.StatusFound, "/admin/users")
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/tags")
+}
+
+// --- Пользователи ---
+
+// ShowUsers ищет пользователей по email, имени или ID с фильтрами по роли и блокировке (GET /admin/users)
+func (ac *AdminController) ShowUsers(c *gin.Context) {
+	query := c.Query("q")
+	role := models.Role(c.Query("role"))
+	if !role.Valid() {
+		role = ""
+	}
+	bannedOnly := c.Query("banned") == "1"
+
+	status, errMsg := http.StatusOK, ""
+	users, err := ac.adminService.SearchUsers(query, role, bannedOnly)
+	if err != nil {
+		requestLogger(c).Error("Ошибка поиска пользователей", logging.Err(err))
+		status, errMsg = http.StatusInternalServerError, "Не удалось загрузить пользователей"
+	}
+
+	renderTemplateWithStatus(c, status, "admin_users.html", gin.H{
+		"Users":      users,
+		"Query":      query,
+		"Role":       role,
+		"BannedOnly": bannedOnly,
+		"Roles":      models.Roles,
+		"Error":      errMsg,
+	})
+}
+
+// ShowUser показывает карточку пользователя: баланс, роль, блокировку, товары, заказы
+// и историю действий сотрудников (GET /admin/users/:id)
+func (ac *AdminController) ShowUser(c *gin.Context) {
+	id, ok := ac.idParam(c, "Некорректный ID пользователя")
+	if !ok {
+		return
+	}
+	ac.renderUser(c, http.StatusOK, id, "")
+}
+
+// AdjustBalance начисляет или списывает кредиты с обязательной причиной (POST /admin/users/:id/balance)
+func (ac *AdminController) AdjustBalance(c *gin.Context) {
+	id, ok := ac.idParam(c, "Некорректный ID пользователя")
+	if !ok {
+		return
+	}
+
+	amount, err := strconv.ParseFloat(strings.TrimSpace(c.PostForm("amount")), 64)
+	if err != nil {
+		ac.renderUser(c, http.StatusBadRequest, id, "Сумма должна быть числом")
+		return
+	}
+	if valid, errMsg := ac.validationService.ValidateBalanceAdjustment(amount); !valid {
+		ac.renderUser(c, http.StatusBadRequest, id, errMsg)
+		return
+	}
+	note := c.PostForm("note")
+	if valid, errMsg := ac.validationService.ValidateAuditNote(note); !valid {
+		ac.renderUser(c, http.StatusBadRequest, id, errMsg)
+		return
+	}
+
+	_, err = ac.adminService.AdjustBalance(c.Request.Context(), auditActor(c), id, amount, note)
+	ac.finishUserAction(c, id, err, "изменения баланса")
+}
+
+// BanUser блокирует аккаунт (POST /admin/users/:id/ban)
+func (ac *AdminController) BanUser(c *gin.Context) {
+	id, ok := ac.idParam(c, "Некорректный ID пользователя")
+	if !ok {
+		return
+	}
+	reason := c.PostForm("reason")
+	if valid, errMsg := ac.validationService.ValidateAuditNote(reason); !valid {
+		ac.renderUser(c, http.StatusBadRequest, id, errMsg)
+		return
+	}
+
+	err := ac.adminService.Ban(c.Request.Context(), auditActor(c), id, reason)
+	ac.finishUserAction(c, id, err, "блокировки пользователя")
+}
+
+// UnbanUser снимает блокировку (POST /admin/users/:id/unban)
+func (ac *AdminController) UnbanUser(c *gin.Context) {
+	id, ok := ac.idParam(c, "Некорректный ID пользователя")
+	if !ok {
+		return
+	}
+	note := c.PostForm("note")
+	if valid, errMsg := ac.validationService.ValidateAuditNote(note); !valid {
+		ac.renderUser(c, http.StatusBadRequest, id, errMsg)
+		return
+	}
+
+	err := ac.adminService.Unban(c.Request.Context(), auditActor(c), id, note)
+	ac.finishUserAction(c, id, err, "разблокировки пользователя")
+}
+
+// SetUserRole назначает роль (POST /admin/users/:id/role)
+func (ac *AdminController) SetUserRole(c *gin.Context) {
+	id, ok := ac.idParam(c, "Некорректный ID пользователя")
+	if !ok {
+		return
+	}
+	note := c.PostForm("note")
+	if valid, errMsg := ac.validationService.ValidateAuditNote(note); !valid {
+		ac.renderUser(c, http.StatusBadRequest, id, errMsg)
+		return
+	}
+
+	err := ac.adminService.SetRole(c.Request.Context(), auditActor(c), id, models.Role(c.PostForm("role")), note)
+	ac.finishUserAction(c, id, err, "назначения роли")
+}
+
+// finishUserAction возвращает на карточку пользователя после действия или показывает ошибку
+func (ac *AdminController) finishUserAction(c *gin.Context, userID uint, err error, action string) {
+	if err == nil {
+		c.Redirect(http.StatusFound, "/admin/users/"+strconv.FormatUint(uint64(userID), 10))
+		return
+	}
+	status, errMsg := ac.actionError(c, err, action)
+	ac.renderUser(c, status, userID, errMsg)
+}
+
+func (ac *AdminController) renderUser(c *gin.Context, status int, userID uint, errMsg string) {
+	target, err := ac.adminService.GetUser(userID)
+	if err != nil {
+		if !errors.Is(err, services.ErrUserNotFound) {
+			requestLogger(c).Error("Ошибка загрузки пользователя", logging.UserID(userID), logging.Err(err))
+		}
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Пользователь не найден"})
+		return
+	}
+
+	products, err := ac.products.ListBySeller(userID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки товаров пользователя", logging.UserID(userID), logging.Err(err))
+	}
+	orders, err := ac.orders.ListByUser(userID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки заказов пользователя", logging.UserID(userID), logging.Err(err))
+	}
+	events, err := ac.auditService.ListForTarget(services.AuditTargetUser, userID, adminHistoryLimit)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки журнала аудита", logging.UserID(userID), logging.Err(err))
+	}
+
+	renderTemplateWithStatus(c, status, "admin_user.html", gin.H{
+		"Target":   target,
+		"Products": products,
+		"Orders":   orders,
+		"Events":   events,
+		"Roles":    models.Roles,
+		"Error":    errMsg,
+	})
+}
+
+// --- Товары ---
+
+// ShowProducts ищет товары по названию, email продавца или ID (GET /admin/products)
+func (ac *AdminController) ShowProducts(c *gin.Context) {
+	ac.renderProducts(c, http.StatusOK, "")
+}
+
+// UnlistProduct снимает товар с продажи (POST /admin/products/:id/unlist)
+func (ac *AdminController) UnlistProduct(c *gin.Context) {
+	id, ok := ac.idParam(c, "Некорректный ID продукта")
+	if !ok {
+		return
+	}
+	reason := c.PostForm("reason")
+	if valid, errMsg := ac.validationService.ValidateAuditNote(reason); !valid {
+		ac.renderProducts(c, http.StatusBadRequest, errMsg)
+		return
+	}
+
+	err := ac.adminService.Unlist(c.Request.Context(), auditActor(c), id, reason)
+	ac.finishProductAction(c, err, "снятия товара с продажи")
+}
+
+// RelistProduct возвращает товар в продажу (POST /admin/products/:id/relist)
+func (ac *AdminController) RelistProduct(c *gin.Context) {
+	id, ok := ac.idParam(c, "Некорректный ID продукта")
+	if !ok {
+		return
+	}
+	note := c.PostForm("note")
+	if valid, errMsg := ac.validationService.ValidateAuditNote(note); !valid {
+		ac.renderProducts(c, http.StatusBadRequest, errMsg)
+		return
+	}
+
+	err := ac.adminService.Relist(c.Request.Context(), auditActor(c), id, note)
+	ac.finishProductAction(c, err, "возврата товара в продажу")
+}
+
+// finishProductAction возвращает к списку товаров с тем же поиском, что был в форме
+func (ac *AdminController) finishProductAction(c *gin.Context, err error, action string) {
+	if err == nil {
+		c.Redirect(http.StatusFound, "/admin/products?q="+url.QueryEscape(c.PostForm("q")))
+		return
+	}
+	status, errMsg := ac.actionError(c, err, action)
+	ac.renderProducts(c, status, errMsg)
+}
+
+func (ac *AdminController) renderProducts(c *gin.Context, status int, errMsg string) {
+	query := c.Query("q")
+	if c.Request.Method == http.MethodPost {
+		query = c.PostForm("q")
+	}
+	unlistedOnly := c.Query("unlisted") == "1"
+
+	products, err := ac.adminService.SearchProducts(query, unlistedOnly)
+	if err != nil {
+		requestLogger(c).Error("Ошибка поиска товаров", logging.Err(err))
+		if errMsg == "" {
+			status, errMsg = http.StatusInternalServerError, "Не удалось загрузить товары"
+		}
+	}
+
+	renderTemplateWithStatus(c, status, "admin_products.html", gin.H{
+		"Products":     products,
+		"Query":        query,
+		"UnlistedOnly": unlistedOnly,
+		"Error":        errMsg,
+	})
+}
+
+// --- Заказы ---
+
+// ShowOrders показывает последние заказы; поиск по номеру заказа или email покупателя (GET /admin/orders)
+func (ac *AdminController) ShowOrders(c *gin.Context) {
+	query := c.Query("q")
+	status, errMsg := http.StatusOK, ""
+	orders, err := ac.adminService.ListOrders(query)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки заказов", logging.Err(err))
+		status, errMsg = http.StatusInternalServerError, "Не удалось загрузить заказы"
+	}
+
+	renderTemplateWithStatus(c, status, "admin_orders.html", gin.H{
+		"Orders": orders,
+		"Query":  query,
+		"Error":  errMsg,
+	})
+}
+
+// ShowOrder показывает заказ с товарами и историей повторных отправок письма (GET /admin/orders/:id)
+func (ac *AdminController) ShowOrder(c *gin.Context) {
+	id, ok := ac.idParam(c, "Некорректный ID заказа")
+	if !ok {
+		return
+	}
+	notice := ""
+	if c.Query("resent") == "1" {
+		notice = "Confirmation email queued."
+	}
+	ac.renderOrder(c, http.StatusOK, id, notice, "")
+}
+
+// ResendOrderMail повторно отправляет покупателю письмо со ссылками на скачивание
+// (POST /admin/orders/:id/resend). Письмо уходит в фоне, как и при оформлении заказа.
+func (ac *AdminController) ResendOrderMail(c *gin.Context) {
+	id, ok := ac.idParam(c, "Некорректный ID заказа")
+	if !ok {
+		return
+	}
+
+	order, err := ac.adminService.GetOrder(id)
+	if err != nil {
+		status, errMsg := ac.actionError(c, err, "повторной отправки письма")
+		renderTemplateWithStatus(c, status, "error.html", gin.H{"Error": errMsg})
+		return
+	}
+
+	note := c.PostForm("note")
+	if utf8.RuneCountInString(strings.TrimSpace(note)) > services.MaxAuditNoteLen {
+		ac.renderOrder(c, http.StatusBadRequest, id, "", "Комментарий слишком длинный")
+		return
+	}
+	if err := ac.adminService.RecordMailResend(c.Request.Context(), auditActor(c), *order, note); err != nil {
+		status, errMsg := ac.actionError(c, err, "повторной отправки письма")
+		ac.renderOrder(c, status, id, "", errMsg)
+		return
+	}
+	ac.mailer.send(c.Request.Context(), order.User.Email, order.UserID, order.ID)
+
+	c.Redirect(http.StatusFound, "/admin/orders/"+strconv.FormatUint(uint64(id), 10)+"?resent=1")
+}
+
+func (ac *AdminController) renderOrder(c *gin.Context, status int, orderID uint, notice, errMsg string) {
+	order, err := ac.adminService.GetOrder(orderID)
+	if err != nil {
+		if !errors.Is(err, services.ErrOrderNotFound) {
+			requestLogger(c).Error("Ошибка загрузки заказа", logging.OrderID(orderID), logging.Err(err))
+		}
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Заказ не найден"})
+		return
+	}
+	events, err := ac.auditService.ListForTarget(services.AuditTargetOrder, orderID, adminHistoryLimit)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки журнала аудита", logging.OrderID(orderID), logging.Err(err))
+	}
+
+	renderTemplateWithStatus(c, status, "admin_order.html", gin.H{
+		"Order":  order,
+		"Events": events,
+		"Notice": notice,
+		"Error":  errMsg,
+	})
+}
+
+// --- Отзывы ---
+
+// ShowReviews показывает отзывы с жалобами и скрытые отзывы (GET /admin/reviews)
+func (ac *AdminController) ShowReviews(c *gin.Context) {
+	ac.renderReviews(c, http.StatusOK, "")
+}
+
+// HideReview скрывает отзыв (POST /admin/reviews/:id/hide)
+func (ac *AdminController) HideReview(c *gin.Context) {
+	ac.moderateReview(c, true)
+}
+
+// RestoreReview снова показывает отзыв и снимает жалобу (POST /admin/reviews/:id/restore)
+func (ac *AdminController) RestoreReview(c *gin.Context) {
+	ac.moderateReview(c, false)
+}
+
+func (ac *AdminController) moderateReview(c *gin.Context, hidden bool) {
+	id, ok := ac.idParam(c, "Некорректный ID отзыва")
+	if !ok {
+		return
+	}
+	note := c.PostForm("note")
+	if valid, errMsg := ac.validationService.ValidateAuditNote(note); !valid {
+		ac.renderReviews(c, http.StatusBadRequest, errMsg)
+		return
+	}
+
+	if err := ac.reviewService.Moderate(c.Request.Context(), auditActor(c), id, hidden, note); err != nil {
+		status, errMsg := ac.actionError(c, err, "модерации отзыва")
+		ac.renderReviews(c, status, errMsg)
+		return
+	}
+	c.Redirect(http.StatusFound, "/admin/reviews")
+}
+
+func (ac *AdminController) renderReviews(c *gin.Context, status int, errMsg string) {
+	reviews, err := ac.reviewService.ListForModeration(adminHistoryLimit)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки отзывов", logging.Err(err))
+		if errMsg == "" {
+			status, errMsg = http.StatusInternalServerError, "Не удалось загрузить отзывы"
+		}
+	}
+
+	renderTemplateWithStatus(c, status, "admin_reviews.html", gin.H{
+		"Reviews": reviews,
+		"Error":   errMsg,
+	})
+}
+
+// idParam разбирает параметр :id. При ошибке страница уже отрисована.
+func (ac *AdminController) idParam(c *gin.Context, errMsg string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		renderTemplateWithStatus(c, http.StatusBadRequest, "error.html", gin.H{"Error": errMsg})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// actionError переводит ошибку действия сотрудника в код ответа и сообщение.
+// Неожиданные ошибки записываются в журнал.
+func (ac *AdminController) actionError(c *gin.Context, err error, action string) (int, string) {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound), errors.Is(err, services.ErrProductNotFound),
+		errors.Is(err, services.ErrOrderNotFound), errors.Is(err, services.ErrReviewNotFound):
+		return http.StatusNotFound, err.Error()
+	case errors.Is(err, services.ErrAuditNoteRequired), errors.Is(err, services.ErrZeroAmount),
+		errors.Is(err, services.ErrInvalidRole):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, services.ErrNegativeBalance):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, services.ErrSelfModeration), errors.Is(err, services.ErrStaffProtected):
+		return http.StatusForbidden, err.Error()
+	default:
+		requestLogger(c).Error("Ошибка действия администратора", slog.String("action", action), logging.Err(err))
+		return http.StatusInternalServerError, "Не удалось выполнить действие. Попробуйте снова."
+	}
+}
+
+// --- Теги ---
+
+// adminTagRow - строка дерева тегов на странице администратора
+type adminTagRow struct {
+	services.TagUsage
+	Depth      int    // Уровень вложенности, 0 - тег верхнего уровня
+	Parent     uint   // ID родителя, 0 для тегов верхнего уровня (удобнее *uint в шаблоне)
+	ParentName string // Имя родителя, пустое для тегов верхнего уровня
+}
+
+// ShowTags показывает все теги деревом с числом товаров и формами управления (GET /admin/tags)
+func (ac *AdminController) ShowTags(c *gin.Context) {
+	ac.renderTags(c, http.StatusOK, "")
+}
+
+// RenameTag переименовывает тег (POST /admin/tags/:id/rename)
+func (ac *AdminController) RenameTag(c *gin.Context) {
+	id, ok := ac.tagIDParam(c)
+	if !ok {
+		return
+	}
+
+	name := c.PostForm("name")
+	if valid, errMsg := ac.validationService.ValidateTagName(name); !valid {
+		ac.renderTags(c, http.StatusBadRequest, errMsg)
+		return
+	}
+
+	_, err := ac.tagService.Rename(id, name)
+	ac.finishTagAction(c, err, "переименования тега", services.AuditEntry{
+		Action: services.AuditTagRename, TargetType: services.AuditTargetTag, TargetID: id,
+		Details: map[string]interface{}{"name": services.NormalizeTagName(name)},
+	})
+}
+
+// SetTagParent переносит тег в другую категорию (POST /admin/tags/:id/parent).
+// Пустое значение parent_id делает тег тегом верхнего уровня.
+func (ac *AdminController) SetTagParent(c *gin.Context) {
+	id, ok := ac.tagIDParam(c)
+	if !ok {
+		return
+	}
+
+	var parentID *uint
+	if value := c.PostForm("parent_id"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			ac.renderTags(c, http.StatusBadRequest, "Некорректный родительский тег")
+			return
+		}
+		parent := uint(parsed)
+		parentID = &parent
+	}
+
+	ac.finishTagAction(c, ac.tagService.SetParent(id, parentID), "изменения родителя тега", services.AuditEntry{
+		Action: services.AuditTagSetParent, TargetType: services.AuditTargetTag, TargetID: id,
+		Details: map[string]interface{}{"parent_id": parentID},
+	})
+}
+
+// MergeTag объединяет тег с другим (POST /admin/tags/:id/merge): товары переходят
+// к тегу target_id, а сам тег удаляется
+func (ac *AdminController) MergeTag(c *gin.Context) {
+	id, ok := ac.tagIDParam(c)
+	if !ok {
+		return
+	}
+
+	targetID, err := strconv.ParseUint(c.PostForm("target_id"), 10, 64)
+	if err != nil {
+		ac.renderTags(c, http.StatusBadRequest, "Выберите тег, с которым нужно объединить")
+		return
+	}
+
+	ac.finishTagAction(c, ac.tagService.Merge(id, uint(targetID)), "объединения тегов", services.AuditEntry{
+		Action: services.AuditTagMerge, TargetType: services.AuditTargetTag, TargetID: id,
+		Details: map[string]interface{}{"target_id": targetID},
+	})
+}
+
+// DeleteTag удаляет тег (POST /admin/tags/:id/delete)
+func (ac *AdminController) DeleteTag(c *gin.Context) {
+	id, ok := ac.tagIDParam(c)
+	if !ok {
+		return
+	}
+
+	ac.finishTagAction(c, ac.tagService.Delete(id), "удаления тега", services.AuditEntry{
+		Action: services.AuditTagDelete, TargetType: services.AuditTargetTag, TargetID: id,
+	})
+}
+
+func (ac *AdminController) tagIDParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		ac.renderTags(c, http.StatusBadRequest, "Некорректный ID тега")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// finishTagAction записывает успешное действие в журнал аудита и возвращает на список тегов
+// или показывает ошибку. TagService выполняет действия в своих транзакциях, поэтому запись
+// в журнал делается после них; ошибка записи не отменяет уже выполненное действие.
+func (ac *AdminController) finishTagAction(c *gin.Context, err error, action string, entry services.AuditEntry) {
+	switch {
+	case err == nil:
+		recordAuditEvent(c, ac.auditService, auditActor(c), entry)
+		c.Redirect(http.StatusFound, "/admin/tags")
+	case errors.Is(err, services.ErrTagNotFound):
+		ac.renderTags(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrTagExists), errors.Is(err, services.ErrTagCycle):
+		ac.renderTags(c, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrTagMergeSelf), errors.Is(err, services.ErrTagBadParent):
+		ac.renderTags(c, http.StatusBadRequest, err.Error())
+	default:
+		requestLogger(c).Error("Ошибка действия администратора", slog.String("action", action), logging.Err(err))
+		ac.renderTags(c, http.StatusInternalServerError, "Не удалось выполнить действие. Попробуйте снова.")
+	}
+}
+
+func (ac *AdminController) renderTags(c *gin.Context, status int, errMsg string) {
+	tags, err := ac.tagService.List()
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки тегов", logging.Err(err))
+		if errMsg == "" {
+			status, errMsg = http.StatusInternalServerError, "Не удалось загрузить теги"
+		}
+	}
+
+	renderTemplateWithStatus(c, status, "admin_tags.html", gin.H{
+		"Tags":  tagTree(tags),
+		"Error": errMsg,
+	})
+}
+
+// tagTree раскладывает теги (отсортированные по имени) в порядке обхода дерева:
+// за каждым тегом идут его дочерние теги
+func tagTree(tags []services.TagUsage) []adminTagRow {
+	children := make(map[uint][]services.TagUsage)
+	names := make(map[uint]string, len(tags))
+	for _, tag := range tags {
+		names[tag.ID] = tag.Name
+	}
+	var roots []services.TagUsage
+	for _, tag := range tags {
+		if tag.ParentID != nil && names[*tag.ParentID] != "" {
+			children[*tag.ParentID] = append(children[*tag.ParentID], tag)
+		} else {
+			roots = append(roots, tag)
+		}
+	}
+
+	rows := make([]adminTagRow, 0, len(tags))
+	var walk func(level []services.TagUsage, depth int)
+	walk = func(level []services.TagUsage, depth int) {
+		for _, tag := range level {
+			row := adminTagRow{TagUsage: tag, Depth: depth}
+			if tag.ParentID != nil {
+				row.Parent, row.ParentName = *tag.ParentID, names[*tag.ParentID]
+			}
+			rows = append(rows, row)
+			walk(children[tag.ID], depth+1)
+		}
+	}
+	walk(roots, 0)
+	return rows
+}
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"digital-marketplace/internal/worker"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newAdminRouter регистрирует маршруты /admin с теми же проверками прав, что в cmd/main.go
+func newAdminRouter(t *testing.T, repos repository.Repositories, sessions *services.SessionService) *gin.Engine {
+	t.Helper()
+	admin := NewAdminController(repos, worker.NewSupervisor())
+	router := newTestRouter(t)
+	group := router.Group("/admin", AuthRequired(sessions), RequirePermission(models.PermAdminPanel))
+	group.GET("/users/:id", RequirePermission(models.PermUsersView), admin.ShowUser)
+	group.POST("/users/:id/balance", RequirePermission(models.PermUsersBalance), admin.AdjustBalance)
+	group.POST("/users/:id/ban", RequirePermission(models.PermUsersBan), admin.BanUser)
+	group.POST("/users/:id/role", RequirePermission(models.PermUsersRoles), admin.SetUserRole)
+	group.POST("/products/:id/unlist", RequirePermission(models.PermProductsModerate), admin.UnlistProduct)
+	group.POST("/products/:id/relist", RequirePermission(models.PermProductsModerate), admin.RelistProduct)
+	group.GET("/audit/export", RequirePermission(models.PermAuditView), admin.ExportAudit)
+	return router
+}
+
+func TestAdminAdjustBalanceWritesAudit(t *testing.T) {
+	store, repos := newTestStore()
+	admin := testUser(t, repos, "admin@example.com", models.RoleAdmin)
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+	router := newAdminRouter(t, repos, sessions)
+	cookie := loginCookie(t, sessions, admin)
+	target := "/admin/users/" + idString(buyer.ID) + "/balance"
+
+	if w := serveForm(router, target, url.Values{"amount": {"50"}, "note": {"Компенсация"}}, cookie); w.Code != http.StatusFound {
+		t.Fatalf("начисление: статус %d", w.Code)
+	}
+	if w := serveForm(router, target, url.Values{"amount": {"-80"}, "note": {"Возврат"}}, cookie); w.Code != http.StatusConflict {
+		t.Errorf("списание ниже нуля: статус %d, ожидался 409", w.Code)
+	}
+	if saved, _ := repos.Users.FindByID(buyer.ID); saved.Balance != 50 {
+		t.Errorf("баланс %v, ожидался 50", saved.Balance)
+	}
+
+	// Отклоненное списание в журнал не попадает
+	events := store.AuditEvents()
+	if len(events) != 1 {
+		t.Fatalf("ожидалась одна запись журнала, получено %d", len(events))
+	}
+	event := events[0]
+	if event.Action != services.AuditUserBalanceAdjust || event.ActorID == nil || *event.ActorID != admin.ID || event.Note != "Компенсация" {
+		t.Errorf("неожиданная запись журнала: %+v", event)
+	}
+	var details map[string]float64
+	if err := json.Unmarshal([]byte(event.Details), &details); err != nil {
+		t.Fatal(err)
+	}
+	if details["balance_before"] != 0 || details["balance_after"] != 50 {
+		t.Errorf("подробности записи: %v", details)
+	}
+
+	w := serve(router, http.MethodGet, "/admin/users/"+idString(buyer.ID), cookie)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Компенсация") {
+		t.Errorf("карточка пользователя: статус %d, запись журнала не показана", w.Code)
+	}
+}
+
+func TestAdminBanProtectsStaff(t *testing.T) {
+	store, repos := newTestStore()
+	admin := testUser(t, repos, "admin@example.com", models.RoleAdmin)
+	moderator := testUser(t, repos, "mod@example.com", models.RoleModerator)
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+	router := newAdminRouter(t, repos, sessions)
+	form := url.Values{"reason": {"Спам"}}
+
+	if w := serveForm(router, "/admin/users/"+idString(admin.ID)+"/ban", form, loginCookie(t, sessions, moderator)); w.Code != http.StatusForbidden {
+		t.Errorf("модератор блокирует администратора: статус %d, ожидался 403", w.Code)
+	}
+	if saved, _ := repos.Users.FindByID(admin.ID); saved.Banned() {
+		t.Fatal("администратор заблокирован модератором")
+	}
+
+	buyerCookie := loginCookie(t, sessions, buyer)
+	if w := serveForm(router, "/admin/users/"+idString(buyer.ID)+"/ban", form, loginCookie(t, sessions, moderator)); w.Code != http.StatusFound {
+		t.Fatalf("блокировка покупателя: статус %d", w.Code)
+	}
+	saved, _ := repos.Users.FindByID(buyer.ID)
+	if !saved.Banned() || saved.BanReason != "Спам" {
+		t.Errorf("покупатель не заблокирован: %+v", saved)
+	}
+	// Блокировка завершает все сессии пользователя
+	if _, err := sessions.Authenticate(buyerCookie.Value); !errors.Is(err, services.ErrInvalidSession) {
+		t.Errorf("сессия заблокированного пользователя действует: %v", err)
+	}
+
+	// Повторная блокировка ничего не меняет и не пишет в журнал
+	serveForm(router, "/admin/users/"+idString(buyer.ID)+"/ban", form, loginCookie(t, sessions, admin))
+	if events := store.AuditEvents(); len(events) != 1 || events[0].Action != services.AuditUserBan {
+		t.Errorf("журнал после блокировки: %+v", events)
+	}
+}
+
+func TestAdminSetRoleRevokesSessions(t *testing.T) {
+	_, repos := newTestStore()
+	admin := testUser(t, repos, "admin@example.com", models.RoleAdmin)
+	moderator := testUser(t, repos, "mod@example.com", models.RoleModerator)
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+	router := newAdminRouter(t, repos, sessions)
+	adminCookie := loginCookie(t, sessions, admin)
+	moderatorCookie := loginCookie(t, sessions, moderator)
+	target := "/admin/users/" + idString(moderator.ID) + "/role"
+
+	// Та же роль ничего не меняет, и сессии остаются
+	if w := serveForm(router, target, url.Values{"role": {string(models.RoleModerator)}, "note": {"Проверка"}}, adminCookie); w.Code != http.StatusFound {
+		t.Fatalf("назначение той же роли: статус %d", w.Code)
+	}
+	if _, err := sessions.Authenticate(moderatorCookie.Value); err != nil {
+		t.Fatalf("сессия завершена без изменения роли: %v", err)
+	}
+
+	if w := serveForm(router, target, url.Values{"role": {string(models.RoleUser)}, "note": {"Сменил команду"}}, adminCookie); w.Code != http.StatusFound {
+		t.Fatalf("снятие роли: статус %d", w.Code)
+	}
+	if _, err := sessions.Authenticate(moderatorCookie.Value); !errors.Is(err, services.ErrInvalidSession) {
+		t.Errorf("сессия после смены роли действует: %v", err)
+	}
+	if _, err := sessions.Authenticate(adminCookie.Value); err != nil {
+		t.Errorf("сессия администратора завершена: %v", err)
+	}
+}
+
+func TestAdminUnlistRemovesFromCarts(t *testing.T) {
+	store, repos := newTestStore()
+	moderator := testUser(t, repos, "mod@example.com", models.RoleModerator)
+	seller := testUser(t, repos, "seller@example.com", models.RoleSeller)
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	product := testProduct(store, seller, "Шаблон", 100)
+	if err := repos.Carts.Add(&models.CartItem{UserID: buyer.ID, ProductID: product.ID}); err != nil {
+		t.Fatal(err)
+	}
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+	router := newAdminRouter(t, repos, sessions)
+	cookie := loginCookie(t, sessions, moderator)
+
+	if w := serveForm(router, "/admin/products/"+idString(product.ID)+"/unlist", url.Values{"reason": {"Нарушение правил"}}, cookie); w.Code != http.StatusFound {
+		t.Fatalf("снятие с продажи: статус %d", w.Code)
+	}
+	if saved, _ := repos.Products.FindByID(product.ID); saved.Listed() || saved.UnlistReason != "Нарушение правил" {
+		t.Errorf("товар не снят с продажи: %+v", saved)
+	}
+	if items, _ := repos.Carts.ListByUser(buyer.ID); len(items) != 0 {
+		t.Errorf("снятый товар остался в корзине: %+v", items)
+	}
+
+	if w := serveForm(router, "/admin/products/"+idString(product.ID)+"/relist", url.Values{"note": {"Исправлено"}}, cookie); w.Code != http.StatusFound {
+		t.Fatalf("возврат в продажу: статус %d", w.Code)
+	}
+	if saved, _ := repos.Products.FindByID(product.ID); !saved.Listed() {
+		t.Error("товар не возвращен в продажу")
+	}
+	if w := serveForm(router, "/admin/products/999/relist", url.Values{"note": {"Исправлено"}}, cookie); w.Code != http.StatusNotFound {
+		t.Errorf("несуществующий товар: статус %d, ожидался 404", w.Code)
+	}
+
+	events := store.AuditEvents()
+	if len(events) != 2 || events[0].Action != services.AuditProductUnlist || events[1].Action != services.AuditProductRelist {
+		t.Errorf("журнал: %+v", events)
+	}
+}
+
+func TestAdminExportAuditJSONL(t *testing.T) {
+	store, repos := newTestStore()
+	admin := testUser(t, repos, "admin@example.com", models.RoleAdmin)
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+	audit := services.NewAuditService(repos.Audit)
+	for _, action := range []string{services.AuditAuthLogin, services.AuditAuthLogout, services.AuditOrderBuy} {
+		if err := audit.Record(context.Background(), services.AuditActor{UserID: admin.ID}, services.AuditEntry{Action: action}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := newAdminRouter(t, repos, sessions)
+
+	w := serve(router, http.MethodGet, "/admin/audit/export?format=jsonl&action=auth.", loginCookie(t, sessions, admin))
+	if w.Code != http.StatusOK {
+		t.Fatalf("выгрузка: статус %d", w.Code)
+	}
+	var actions []string
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var event struct {
+			Action string `json:"action"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("строка %q: %v", scanner.Text(), err)
+		}
+		actions = append(actions, event.Action)
+	}
+	if strings.Join(actions, ",") != "auth.logout,auth.login" {
+		t.Errorf("выгружены действия %v, ожидались auth.logout и auth.login", actions)
+	}
+
+	// Сама выгрузка записывается в журнал
+	events := store.AuditEvents()
+	if last := events[len(events)-1]; last.Action != services.AuditLogExport {
+		t.Errorf("последняя запись журнала %q, ожидалась %q", last.Action, services.AuditLogExport)
+	}
+}
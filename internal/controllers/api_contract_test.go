@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// checkAPI выполняет запрос к API, проверяет код ответа и сверяет ответ с документом OpenAPI
+func checkAPI(t *testing.T, router http.Handler, method, target, token string, body []byte, want int) *httptest.ResponseRecorder {
+	t.Helper()
+	w := serveAPI(router, method, target, token, body)
+	if w.Code != want {
+		t.Errorf("%s %s: статус %d, ожидался %d: %s", method, target, w.Code, want, w.Body)
+	}
+	path, _, _ := strings.Cut(target, "?")
+	if err := APISpec().ValidateResponse(method, path, w.Code, w.Body.Bytes()); err != nil {
+		t.Errorf("%s %s: ответ не соответствует документу: %v", method, target, err)
+	}
+	return w
+}
+
+// TestAPIResponsesMatchSpec проходит по операциям /api/v1 поверх репозиториев в памяти
+// и проверяет каждый ответ, включая ошибки, по схемам документа.
+func TestAPIResponsesMatchSpec(t *testing.T) {
+	store, repos := newTestStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seller := models.User{Email: "seller@example.com", Username: "seller", Password: string(hash), Role: models.RoleSeller}
+	if err := repos.Users.Create(&seller); err != nil {
+		t.Fatal(err)
+	}
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	stranger := testUser(t, repos, "stranger@example.com", models.RoleUser)
+	sold := testProduct(store, seller, "Проданный", 100)
+	unsold := testProduct(store, seller, "Непроданный", 50)
+	font := testProduct(store, seller, "Шрифт", 30)
+	store.AddTag(&models.Tag{Name: "Иконки", Slug: "ikonki"}, sold.ID)
+	order := models.Order{UserID: buyer.ID, Items: []models.OrderItem{{ProductID: sold.ID}}}
+	store.AddOrder(&order)
+
+	router := newTestAPI(t, repos)
+	testUploads(t)
+	sellerToken := testAccessToken(t, repos, seller)
+	buyerToken := testAccessToken(t, repos, buyer)
+	strangerToken := testAccessToken(t, repos, stranger)
+	soldPath := "/api/v1/products/" + idString(sold.ID)
+	unsoldPath := "/api/v1/products/" + idString(unsold.ID)
+
+	// Вход и токены
+	checkAPI(t, router, http.MethodPost, "/api/v1/auth/login", "", []byte(`{"email": "seller@example.com", "password": "correct horse"}`), http.StatusCreated)
+	checkAPI(t, router, http.MethodPost, "/api/v1/auth/login", "", []byte(`{"email": "seller@example.com", "password": "wrong"}`), http.StatusUnauthorized)
+	checkAPI(t, router, http.MethodPost, "/api/v1/auth/login", "", []byte(`{`), http.StatusBadRequest)
+	checkAPI(t, router, http.MethodGet, "/api/v1/profile", "", nil, http.StatusUnauthorized)
+	checkAPI(t, router, http.MethodGet, "/api/v1/profile", sellerToken, nil, http.StatusOK)
+	checkAPI(t, router, http.MethodPatch, "/api/v1/profile/storefront", sellerToken, []byte(`{"bio": "Шаблоны и иконки", "links": ["https://example.com"]}`), http.StatusOK)
+	checkAPI(t, router, http.MethodGet, "/api/v1/tokens", sellerToken, nil, http.StatusOK)
+	checkAPI(t, router, http.MethodPost, "/api/v1/tokens", sellerToken, []byte(`{"name": ""}`), http.StatusUnprocessableEntity)
+	checkAPI(t, router, http.MethodPost, "/api/v1/tokens", sellerToken, []byte(`{"name": "CI", "expiresInDays": 30}`), http.StatusCreated)
+	checkAPI(t, router, http.MethodDelete, "/api/v1/tokens/999", sellerToken, nil, http.StatusNotFound)
+
+	// Товары
+	checkAPI(t, router, http.MethodGet, "/api/v1/products?sort=price_asc&limit=2", buyerToken, nil, http.StatusOK)
+	checkAPI(t, router, http.MethodGet, "/api/v1/products?cursor=garbage", buyerToken, nil, http.StatusBadRequest)
+	checkAPI(t, router, http.MethodGet, soldPath, buyerToken, nil, http.StatusOK)
+	checkAPI(t, router, http.MethodGet, "/api/v1/products/999", buyerToken, nil, http.StatusNotFound)
+	checkAPI(t, router, http.MethodGet, "/api/v1/products/abc", buyerToken, nil, http.StatusBadRequest)
+	checkAPI(t, router, http.MethodPatch, soldPath, buyerToken, []byte(`{"price": 1}`), http.StatusForbidden)
+	checkAPI(t, router, http.MethodPatch, soldPath, sellerToken, []byte(`{"price": -5}`), http.StatusUnprocessableEntity)
+	checkAPI(t, router, http.MethodPatch, soldPath, sellerToken, []byte(`{"price": 90, "tags": ["Иконки", "Шаблоны"]}`), http.StatusOK)
+	checkAPI(t, router, http.MethodDelete, soldPath, sellerToken, nil, http.StatusConflict)
+
+	// Скачивание
+	checkAPI(t, router, http.MethodPost, soldPath+"/download-link", buyerToken, nil, http.StatusCreated)
+	checkAPI(t, router, http.MethodPost, soldPath+"/download-link", strangerToken, nil, http.StatusForbidden)
+	checkAPI(t, router, http.MethodGet, soldPath+"/download-stats", sellerToken, nil, http.StatusOK)
+	checkAPI(t, router, http.MethodGet, soldPath+"/download-stats", buyerToken, nil, http.StatusForbidden)
+
+	// Отзывы
+	checkAPI(t, router, http.MethodPut, soldPath+"/review", strangerToken, []byte(`{"rating": 5, "text": "Отлично"}`), http.StatusForbidden)
+	checkAPI(t, router, http.MethodPut, soldPath+"/review", buyerToken, []byte(`{"rating": 9}`), http.StatusUnprocessableEntity)
+	checkAPI(t, router, http.MethodPut, soldPath+"/review", buyerToken, []byte(`{"rating": 4, "text": "Хороший шаблон"}`), http.StatusCreated)
+	checkAPI(t, router, http.MethodPut, soldPath+"/review", buyerToken, []byte(`{"rating": 5, "text": "Отличный шаблон"}`), http.StatusOK)
+	checkAPI(t, router, http.MethodGet, soldPath+"/reviews", strangerToken, nil, http.StatusOK)
+	review, err := repos.Reviews.FindByUser(buyer.ID, sold.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reviewPath := "/api/v1/reviews/" + idString(review.ID)
+	checkAPI(t, router, http.MethodPost, reviewPath+"/reply", strangerToken, []byte(`{"reply": "Спасибо"}`), http.StatusForbidden)
+	checkAPI(t, router, http.MethodPost, reviewPath+"/reply", sellerToken, []byte(`{"reply": "Спасибо"}`), http.StatusOK)
+	checkAPI(t, router, http.MethodPost, reviewPath+"/flag", strangerToken, nil, http.StatusNoContent)
+	checkAPI(t, router, http.MethodPost, "/api/v1/reviews/999/flag", strangerToken, nil, http.StatusNotFound)
+
+	// Витрина продавца
+	checkAPI(t, router, http.MethodGet, "/api/v1/sellers/seller", buyerToken, nil, http.StatusOK)
+	checkAPI(t, router, http.MethodGet, "/api/v1/sellers/nobody", buyerToken, nil, http.StatusNotFound)
+
+	// Корзина
+	checkAPI(t, router, http.MethodPost, "/api/v1/cart/items", sellerToken, []byte(`{"productId": `+idString(unsold.ID)+`}`), http.StatusUnprocessableEntity)
+	checkAPI(t, router, http.MethodPost, "/api/v1/cart/items", buyerToken, []byte(`{"productId": 999}`), http.StatusNotFound)
+	checkAPI(t, router, http.MethodPost, "/api/v1/cart/items", buyerToken, []byte(`{"productId": `+idString(unsold.ID)+`}`), http.StatusCreated)
+	checkAPI(t, router, http.MethodPost, "/api/v1/cart/items", buyerToken, []byte(`{"productId": `+idString(unsold.ID)+`}`), http.StatusOK)
+	checkAPI(t, router, http.MethodGet, "/api/v1/cart", buyerToken, nil, http.StatusOK)
+	items, err := repos.Carts.ListByUser(buyer.ID)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("корзина покупателя: %+v, %v", items, err)
+	}
+	checkAPI(t, router, http.MethodDelete, "/api/v1/cart/items/"+idString(items[0].ID), buyerToken, nil, http.StatusNoContent)
+	checkAPI(t, router, http.MethodDelete, "/api/v1/cart/items/"+idString(items[0].ID), buyerToken, nil, http.StatusNotFound)
+
+	// Покупки
+	fontPath := "/api/v1/products/" + idString(font.ID)
+	checkAPI(t, router, http.MethodPost, "/api/v1/checkout", strangerToken, nil, http.StatusUnprocessableEntity)
+	checkAPI(t, router, http.MethodPost, fontPath+"/buy", strangerToken, nil, http.StatusPaymentRequired)
+	checkAPI(t, router, http.MethodPost, soldPath+"/buy", buyerToken, nil, http.StatusConflict)
+	checkAPI(t, router, http.MethodPost, fontPath+"/buy", sellerToken, nil, http.StatusUnprocessableEntity)
+	checkAPI(t, router, http.MethodPost, "/api/v1/products/999/buy", strangerToken, nil, http.StatusNotFound)
+	stranger.Balance = 100
+	if err := repos.Users.Save(&stranger); err != nil {
+		t.Fatal(err)
+	}
+	checkAPI(t, router, http.MethodPost, fontPath+"/buy", strangerToken, nil, http.StatusCreated)
+	checkAPI(t, router, http.MethodPost, "/api/v1/cart/items", buyerToken, []byte(`{"productId": `+idString(font.ID)+`}`), http.StatusCreated)
+	buyer.Balance = 100
+	if err := repos.Users.Save(&buyer); err != nil {
+		t.Fatal(err)
+	}
+	checkAPI(t, router, http.MethodPost, "/api/v1/checkout", buyerToken, nil, http.StatusCreated)
+
+	// Избранное
+	checkAPI(t, router, http.MethodPost, "/api/v1/wishlist/items", buyerToken, []byte(`{}`), http.StatusBadRequest)
+	checkAPI(t, router, http.MethodPost, "/api/v1/wishlist/items", buyerToken, []byte(`{"productId": `+idString(unsold.ID)+`}`), http.StatusCreated)
+	checkAPI(t, router, http.MethodPost, "/api/v1/wishlist/items", buyerToken, []byte(`{"productId": `+idString(unsold.ID)+`}`), http.StatusOK)
+	checkAPI(t, router, http.MethodGet, "/api/v1/wishlist", buyerToken, nil, http.StatusOK)
+	checkAPI(t, router, http.MethodDelete, "/api/v1/wishlist/items/"+idString(unsold.ID), buyerToken, nil, http.StatusNoContent)
+	checkAPI(t, router, http.MethodDelete, "/api/v1/wishlist/items/"+idString(unsold.ID), buyerToken, nil, http.StatusNotFound)
+
+	// Заказы
+	checkAPI(t, router, http.MethodGet, "/api/v1/orders", buyerToken, nil, http.StatusOK)
+	checkAPI(t, router, http.MethodGet, "/api/v1/orders/"+idString(order.ID), buyerToken, nil, http.StatusOK)
+	checkAPI(t, router, http.MethodGet, "/api/v1/orders/"+idString(order.ID), strangerToken, nil, http.StatusNotFound)
+
+	// Удаление и выход
+	checkAPI(t, router, http.MethodDelete, unsoldPath, sellerToken, nil, http.StatusNoContent)
+	checkAPI(t, router, http.MethodDelete, unsoldPath, sellerToken, nil, http.StatusNotFound)
+	checkAPI(t, router, http.MethodDelete, "/api/v1/auth/token", strangerToken, nil, http.StatusNoContent)
+	checkAPI(t, router, http.MethodGet, "/api/v1/profile", strangerToken, nil, http.StatusUnauthorized)
+}
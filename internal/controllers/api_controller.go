@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/metrics"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"digital-marketplace/internal/worker"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Максимальный срок действия токена, который можно запросить при создании (в днях)
+const maxAccessTokenDays = 365
+
+// APIController обслуживает версионированный JSON API (/api/v1).
+// Все ответы имеют вид {"data": ...} или {"error": {"code", "message"}}.
+type APIController struct {
+	validationService *services.ValidationService
+	searchService     *services.ProductSearchService
+	tokenService      *services.TokenService
+	auditService      *services.AuditService
+	orderService      *services.OrderService
+	reviewService     *services.ReviewService
+	wishlistService   *services.WishlistService
+	storefrontService *services.StorefrontService
+	fileService       *services.FileService
+	downloadService   *services.DownloadService
+	rateLimiter       *services.RateLimitService
+	uploads           *UploadController
+	mailer            *orderMailer
+	users             repository.UserRepository
+	products          repository.ProductRepository
+	tags              repository.TagRepository
+	carts             repository.CartRepository
+	orders            repository.OrderRepository
+}
+
+func NewAPIController(repos repository.Repositories, rateLimiter *services.RateLimitService, jobs *worker.Supervisor) *APIController {
+	return &APIController{
+		validationService: services.NewValidationService(),
+		searchService:     services.NewProductSearchService(repos.ProductSearch),
+		tokenService:      services.NewTokenService(repos.Tokens, repos.Users),
+		auditService:      services.NewAuditService(repos.Audit),
+		orderService:      services.NewOrderService(repos.Orders, repos.Carts),
+		reviewService:     services.NewReviewService(repos.Reviews, repos.Products, repos.Orders),
+		wishlistService:   services.NewWishlistService(repos.Wishlist, repos.Products, repos.Users),
+		storefrontService: services.NewStorefrontService(repos.Users, repos.Products),
+		fileService:       services.NewFileService(repos.Products),
+		downloadService:   services.NewDownloadService(repos.Downloads, repos.Audit),
+		rateLimiter:       rateLimiter,
+		uploads:           NewUploadController(repos),
+		mailer:            newOrderMailer(repos, jobs),
+		users:             repos.Users,
+		products:          repos.Products,
+		tags:              repos.Tags,
+		carts:             repos.Carts,
+		orders:            repos.Orders,
+	}
+}
+
+// TokenService возвращает сервис токенов для middleware APITokenRequired
+func (api *APIController) TokenService() *services.TokenService {
+	return api.tokenService
+}
+
+// --- Форматы ответов ---
+
+type apiUser struct {
+	ID        uint      `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Balance   float64   `json:"balance"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type apiToken struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Token      string     `json:"token,omitempty"` // Заполняется только в ответе на создание токена
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func newAPIUser(user models.User) apiUser {
+	return apiUser{
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Balance:   user.Balance,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+func newAPIToken(token models.PersonalAccessToken, plain string) apiToken {
+	return apiToken{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Token:      plain,
+		LastUsedAt: token.LastUsedAt,
+		ExpiresAt:  token.ExpiresAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+// --- Аутентификация ---
+
+type apiLoginRequest struct {
+	Email         string `json:"email"`
+	Password      string `json:"password"`
+	TokenName     string `json:"tokenName"`
+	ExpiresInDays int    `json:"expiresInDays"`
+}
+
+type apiCreateTokenRequest struct {
+	Name          string `json:"name"`
+	ExpiresInDays int    `json:"expiresInDays"`
+}
+
+// Login выдает новый токен доступа по email и паролю.
+// Действуют те же блокировки после неудачных попыток, что и у формы входа.
+func (api *APIController) Login(c *gin.Context) {
+	var req apiLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректное тело запроса")
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+
+	if valid, errMsg := api.validationService.ValidateEmail(email); !valid {
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+		return
+	}
+
+	if lockedFor := api.rateLimiter.LoginLockedFor(c.Request.Context(), email); lockedFor > 0 {
+		recordLoginFailure(c, api.auditService, "api", email, 0, loginFailureLocked)
+		abortTooManyRequests(c, lockedFor)
+		return
+	}
+
+	user, err := api.users.FindByEmail(email)
+	if err != nil ||
+		bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		requestLogger(c).Warn("Неудачная попытка входа через API", logging.Email(email))
+		var userID uint
+		if user != nil {
+			userID = user.ID
+		}
+		recordLoginFailure(c, api.auditService, "api", email, userID, loginFailureCredentials)
+		metrics.LoginFailures.WithLabelValues("api").Inc()
+		if lockedFor := api.rateLimiter.RegisterLoginFailure(c.Request.Context(), email); lockedFor > 0 {
+			abortTooManyRequests(c, lockedFor)
+			return
+		}
+		apiError(c, http.StatusUnauthorized, apiCodeUnauthorized, "Неверные учетные данные")
+		return
+	}
+	api.rateLimiter.ResetLoginFailures(c.Request.Context(), email)
+	setCurrentUser(c, *user)
+	if user.Banned() {
+		requestLogger(c).Warn("Попытка входа в заблокированный аккаунт через API")
+		recordLoginFailure(c, api.auditService, "api", email, user.ID, loginFailureBanned)
+		apiError(c, http.StatusForbidden, apiCodeForbidden, bannedMessage(*user))
+		return
+	}
+	requestLogger(c).Info("Вход выполнен через API")
+	metrics.Logins.WithLabelValues("api").Inc()
+	recordLogin(c, api.auditService, *user, "api")
+
+	name := req.TokenName
+	if strings.TrimSpace(name) == "" {
+		name = "API login " + time.Now().Format("2006-01-02 15:04")
+	}
+	api.issueToken(c, user.ID, name, req.ExpiresInDays)
+}
+
+// Logout отзывает токен, которым подписан текущий запрос
+func (api *APIController) Logout(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+	token, exists := apiTokenFromContext(c)
+	if !exists {
+		apiError(c, http.StatusUnauthorized, apiCodeUnauthorized, "Запрос не аутентифицирован токеном")
+		return
+	}
+
+	if err := api.tokenService.RevokeToken(user.ID, token.ID); err != nil {
+		requestLogger(c).Error("Ошибка отзыва токена API", slog.Uint64("token_id", uint64(token.ID)), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось отозвать токен")
+		return
+	}
+	recordAuditEvent(c, api.auditService, auditActor(c), services.AuditEntry{
+		Action:     services.AuditAuthLogout,
+		TargetType: services.AuditTargetToken,
+		TargetID:   token.ID,
+	})
+	c.Status(http.StatusNoContent)
+}
+
+// --- Профиль и токены ---
+
+// GetProfile возвращает данные текущего пользователя
+func (api *APIController) GetProfile(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+	apiOK(c, http.StatusOK, newAPIUser(user))
+}
+
+// ListTokens возвращает активные токены текущего пользователя (без самих значений)
+func (api *APIController) ListTokens(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	tokens, err := api.tokenService.ListTokens(user.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки токенов пользователя", logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось загрузить токены")
+		return
+	}
+
+	result := make([]apiToken, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, newAPIToken(token, ""))
+	}
+	apiOK(c, http.StatusOK, result)
+}
+
+// CreateToken создает новый токен. Значение токена возвращается только в этом ответе.
+func (api *APIController) CreateToken(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	var req apiCreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректное тело запроса")
+		return
+	}
+	api.issueToken(c, user.ID, req.Name, req.ExpiresInDays)
+}
+
+// RevokeToken отзывает токен текущего пользователя по ID
+func (api *APIController) RevokeToken(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	tokenID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректный ID токена")
+		return
+	}
+
+	if err := api.tokenService.RevokeToken(user.ID, uint(tokenID)); err != nil {
+		if errors.Is(err, services.ErrTokenNotFound) {
+			apiError(c, http.StatusNotFound, apiCodeNotFound, err.Error())
+			return
+		}
+		requestLogger(c).Error("Ошибка отзыва токена API", slog.Uint64("token_id", tokenID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось отозвать токен")
+		return
+	}
+	recordTokenRevoke(c, api.auditService, uint(tokenID))
+	c.Status(http.StatusNoContent)
+}
+
+// issueToken проверяет имя и срок действия, создает токен и отправляет его в ответе
+func (api *APIController) issueToken(c *gin.Context, userID uint, name string, expiresInDays int) {
+	ttl, errMsg := validateTokenRequest(name, expiresInDays)
+	if errMsg != "" {
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+		return
+	}
+
+	plain, token, err := api.tokenService.CreateToken(userID, name, ttl)
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyTokens) {
+			apiError(c, http.StatusConflict, apiCodeConflict, err.Error())
+			return
+		}
+		requestLogger(c).Error("Ошибка создания токена API", logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось создать токен")
+		return
+	}
+	recordTokenCreate(c, api.auditService, *token)
+	apiOK(c, http.StatusCreated, newAPIToken(*token, plain))
+}
+
+// validateTokenRequest проверяет имя токена и срок действия (0 дней - бессрочный)
+func validateTokenRequest(name string, expiresInDays int) (time.Duration, string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, "Укажите название токена"
+	}
+	if len([]rune(name)) > 100 {
+		return 0, "Название токена не должно превышать 100 символов"
+	}
+	if expiresInDays < 0 || expiresInDays > maxAccessTokenDays {
+		return 0, "Срок действия токена должен быть от 0 до 365 дней"
+	}
+	return time.Duration(expiresInDays) * 24 * time.Hour, ""
+}
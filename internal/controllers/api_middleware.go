@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/services"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiV1Prefix - префикс версионированного JSON API. Запросы к нему аутентифицируются
+// только токенами доступа из заголовка Authorization, без cookie.
+const apiV1Prefix = "/api/v1/"
+
+// Коды ошибок JSON API (поле error.code в ответе)
+const (
+	apiCodeBadRequest      = "bad_request"
+	apiCodeValidation      = "validation_failed"
+	apiCodeUnauthorized    = "unauthorized"
+	apiCodeForbidden       = "forbidden"
+	apiCodeNotFound        = "not_found"
+	apiCodeConflict        = "conflict"
+	apiCodePaymentRequired = "insufficient_funds"
+	apiCodeRateLimited     = "rate_limited"
+	apiCodeInternal        = "internal_error"
+)
+
+// apiErrorBody - единый формат ошибки JSON API: {"error": {"code": "...", "message": "..."}}
+type apiErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// isAPIv1Path сообщает, относится ли запрос к версионированному JSON API
+func isAPIv1Path(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, apiV1Prefix)
+}
+
+// apiOK отправляет успешный ответ в формате {"data": ...}
+func apiOK(c *gin.Context, status int, data interface{}) {
+	c.JSON(status, gin.H{"data": data})
+}
+
+// apiOKPage отправляет страницу списка вместе с метаданными пагинации
+func apiOKPage(c *gin.Context, data interface{}, pagination interface{}) {
+	c.JSON(http.StatusOK, gin.H{"data": data, "pagination": pagination})
+}
+
+// apiError прерывает запрос и отправляет ошибку в едином формате
+func apiError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": apiErrorBody{Code: code, Message: message}})
+}
+
+// APITokenRequired аутентифицирует запрос по персональному токену доступа
+// (заголовок "Authorization: Bearer dmp_..."). Пользователь и токен сохраняются в контексте
+// под ключами "user" и "api_token", поэтому getUserFromContext работает так же, как для HTML-страниц.
+func APITokenRequired(tokenService *services.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		scheme, plain, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(plain) == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="api"`)
+			apiError(c, http.StatusUnauthorized, apiCodeUnauthorized, "Требуется токен доступа в заголовке Authorization")
+			return
+		}
+
+		user, token, err := tokenService.Authenticate(strings.TrimSpace(plain))
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			apiError(c, http.StatusUnauthorized, apiCodeUnauthorized, err.Error())
+			return
+		}
+		// Токены заблокированного пользователя не отзываются, но перестают действовать
+		if user.Banned() {
+			c.Header("WWW-Authenticate", `Bearer realm="api", error="invalid_token"`)
+			apiError(c, http.StatusUnauthorized, apiCodeUnauthorized, bannedMessage(user))
+			return
+		}
+
+		c.Set("is_logged_in", true)
+		setCurrentUser(c, user)
+		c.Set("api_token", token)
+		c.Next()
+	}
+}
+
+// apiTokenFromContext возвращает токен, которым аутентифицирован текущий запрос
+func apiTokenFromContext(c *gin.Context) (models.PersonalAccessToken, bool) {
+	value, exists := c.Get("api_token")
+	if !exists {
+		return models.PersonalAccessToken{}, false
+	}
+	token, ok := value.(models.PersonalAccessToken)
+	return token, ok
+}
@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type apiCartItem struct {
+	ID        uint       `json:"id"`
+	Product   apiProduct `json:"product"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type apiCart struct {
+	Items      []apiCartItem `json:"items"`
+	TotalPrice float64       `json:"totalPrice"`
+	Balance    float64       `json:"balance"`
+}
+
+type apiOrder struct {
+	ID         uint         `json:"id"`
+	Items      []apiProduct `json:"items"`
+	TotalPrice float64      `json:"totalPrice"`
+	CreatedAt  time.Time    `json:"createdAt"`
+}
+
+type apiAddToCartRequest struct {
+	ProductID uint `json:"productId"`
+}
+
+func (api *APIController) newAPIOrder(c *gin.Context, order models.Order) apiOrder {
+	products := make([]models.Product, 0, len(order.Items))
+	var total float64
+	for _, item := range order.Items {
+		products = append(products, item.Product)
+		total += item.Product.Price
+	}
+	return apiOrder{
+		ID:         order.ID,
+		Items:      api.newAPIProducts(c, products),
+		TotalPrice: total,
+		CreatedAt:  order.CreatedAt,
+	}
+}
+
+// --- Корзина ---
+
+// GetCart возвращает содержимое корзины, ее стоимость и баланс пользователя
+func (api *APIController) GetCart(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	cartItems, err := api.carts.ListByUser(user.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки корзины", logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось загрузить корзину")
+		return
+	}
+
+	products := make([]models.Product, 0, len(cartItems))
+	for _, item := range cartItems {
+		products = append(products, item.Product)
+	}
+	converted := api.newAPIProducts(c, products)
+
+	cart := apiCart{Items: make([]apiCartItem, 0, len(cartItems)), Balance: user.Balance}
+	for i, item := range cartItems {
+		cart.Items = append(cart.Items, apiCartItem{ID: item.ID, Product: converted[i], CreatedAt: item.CreatedAt})
+		cart.TotalPrice += item.Product.Price
+	}
+	apiOK(c, http.StatusOK, cart)
+}
+
+// AddToCart добавляет товар в корзину. Повторное добавление возвращает существующую позицию.
+func (api *APIController) AddToCart(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	var req apiAddToCartRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.ProductID == 0 {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Укажите productId")
+		return
+	}
+
+	found, err := api.products.FindByID(req.ProductID)
+	if err != nil || !found.Listed() {
+		apiError(c, http.StatusNotFound, apiCodeNotFound, "Продукт не найден")
+		return
+	}
+	product := *found
+	if product.UserID == user.ID {
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, "Вы не можете купить свой собственный товар")
+		return
+	}
+
+	existing, err := api.carts.Find(user.ID, product.ID)
+	if err == nil {
+		apiOK(c, http.StatusOK, apiCartItem{ID: existing.ID, Product: api.newAPIProducts(c, []models.Product{product})[0], CreatedAt: existing.CreatedAt})
+		return
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		requestLogger(c).Error("Ошибка проверки корзины", logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось добавить товар в корзину")
+		return
+	}
+
+	item := models.CartItem{UserID: user.ID, ProductID: product.ID}
+	if err := api.carts.Add(&item); err != nil {
+		requestLogger(c).Error("Ошибка добавления товара в корзину", logging.ProductID(product.ID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось добавить товар в корзину")
+		return
+	}
+	apiOK(c, http.StatusCreated, apiCartItem{ID: item.ID, Product: api.newAPIProducts(c, []models.Product{product})[0], CreatedAt: item.CreatedAt})
+}
+
+// RemoveFromCart удаляет позицию корзины по ее ID
+func (api *APIController) RemoveFromCart(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	itemID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректный ID позиции корзины")
+		return
+	}
+
+	if err := api.carts.Remove(user.ID, uint(itemID)); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			apiError(c, http.StatusNotFound, apiCodeNotFound, "Позиция корзины не найдена")
+			return
+		}
+		requestLogger(c).Error("Ошибка удаления позиции из корзины", slog.Uint64("cart_item_id", itemID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось удалить товар из корзины")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// --- Заказы ---
+
+// Checkout оформляет заказ из корзины
+func (api *APIController) Checkout(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	order, err := api.orderService.Checkout(c.Request.Context(), auditActor(c))
+	if err != nil {
+		api.abortOrderError(c, err)
+		return
+	}
+	api.respondWithOrder(c, user, order.ID)
+}
+
+// BuyProduct покупает один товар, минуя корзину
+func (api *APIController) BuyProduct(c *gin.Context) {
+	product, ok := api.loadProductParam(c)
+	if !ok {
+		return
+	}
+	user, _ := getUserFromContext(c)
+
+	order, err := api.orderService.BuyProduct(c.Request.Context(), auditActor(c), product.ID)
+	if err != nil {
+		api.abortOrderError(c, err)
+		return
+	}
+	api.respondWithOrder(c, user, order.ID)
+}
+
+// ListOrders возвращает заказы текущего пользователя
+func (api *APIController) ListOrders(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	orders, err := api.orderService.ListOrders(user.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки заказов", logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось загрузить заказы")
+		return
+	}
+
+	result := make([]apiOrder, 0, len(orders))
+	for _, order := range orders {
+		result = append(result, api.newAPIOrder(c, order))
+	}
+	apiOK(c, http.StatusOK, result)
+}
+
+// GetOrder возвращает заказ текущего пользователя по ID
+func (api *APIController) GetOrder(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	orderID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректный ID заказа")
+		return
+	}
+
+	order, err := api.orderService.GetOrder(user.ID, uint(orderID))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			apiError(c, http.StatusNotFound, apiCodeNotFound, "Заказ не найден")
+			return
+		}
+		requestLogger(c).Error("Ошибка загрузки заказа", logging.OrderID(uint(orderID)), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось загрузить заказ")
+		return
+	}
+	apiOK(c, http.StatusOK, api.newAPIOrder(c, *order))
+}
+
+// respondWithOrder отправляет письмо с подтверждением и возвращает созданный заказ
+func (api *APIController) respondWithOrder(c *gin.Context, user models.User, orderID uint) {
+	if valid, _ := api.validationService.ValidateEmail(user.Email); valid {
+		api.mailer.send(c.Request.Context(), user.Email, user.ID, orderID)
+	}
+
+	order, err := api.orderService.GetOrder(user.ID, orderID)
+	if err != nil {
+		requestLogger(c).Error("Заказ создан, но не загружен", logging.OrderID(orderID), logging.Err(err))
+		apiOK(c, http.StatusCreated, gin.H{"id": orderID})
+		return
+	}
+	apiOK(c, http.StatusCreated, api.newAPIOrder(c, *order))
+}
+
+// abortOrderError переводит ошибки OrderService в ответы API
+func (api *APIController) abortOrderError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrCartEmpty):
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, "Корзина пуста")
+	case errors.Is(err, services.ErrProductNotFound):
+		apiError(c, http.StatusNotFound, apiCodeNotFound, "Продукт не найден")
+	case errors.Is(err, services.ErrProductUnlisted):
+		apiError(c, http.StatusConflict, apiCodeConflict, "Товар снят с продажи. Удалите его из корзины")
+	case errors.Is(err, services.ErrOwnProduct):
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, "Вы не можете купить свой собственный товар")
+	case errors.Is(err, services.ErrAlreadyPurchased):
+		apiError(c, http.StatusConflict, apiCodeConflict, "Вы уже приобрели этот товар ранее")
+	case errors.Is(err, services.ErrInsufficientFunds):
+		apiError(c, http.StatusPaymentRequired, apiCodePaymentRequired, "Недостаточно средств на балансе")
+	default:
+		requestLogger(c).Error("Ошибка оформления заказа", logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Ошибка сохранения заказа. Попробуйте снова.")
+	}
+}
@@ -0,0 +1,454 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/metrics"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type apiProduct struct {
+	ID          uint      `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Price       float64   `json:"price"`
+	SellerID    uint      `json:"sellerId"`
+	Tags        []string  `json:"tags"`
+	ImageURL    string    `json:"imageUrl,omitempty"`
+	URL         string    `json:"url"` // Путь страницы товара на сайте
+	Version     int       `json:"version"`
+	Watermark   bool      `json:"watermark"` // Скачиваемые покупателями файлы получают водяной знак
+	RatingAvg   float64   `json:"ratingAvg"`
+	ReviewCount int       `json:"reviewCount"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Rank        float64   `json:"rank,omitempty"`    // Релевантность, только при поиске (?q=)
+	Snippet     string    `json:"snippet,omitempty"` // HTML-фрагмент описания с <mark> вокруг совпадений
+}
+
+// apiPagination - метаданные курсорной пагинации
+type apiPagination struct {
+	Next  string `json:"next,omitempty"` // Передайте в параметр cursor, чтобы получить следующую страницу
+	Limit int    `json:"limit"`
+	Sort  string `json:"sort"`
+}
+
+func newAPIPagination(page *services.ProductPage) apiPagination {
+	return apiPagination{Next: page.Next, Limit: page.Limit, Sort: string(page.Sort)}
+}
+
+type apiDownloadLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// apiDownloadStats - статистика скачиваний товара покупателями
+type apiDownloadStats struct {
+	ProductID        uint       `json:"productId"`
+	Downloads        int64      `json:"downloads"`
+	RecentDownloads  int64      `json:"recentDownloads"` // За последние 30 дней
+	Buyers           int64      `json:"buyers"`
+	Bytes            int64      `json:"bytes"`
+	LastDownloadAt   *time.Time `json:"lastDownloadAt"`
+	SuspiciousBuyers int64      `json:"suspiciousBuyers"` // Покупатели, скачивавшие с подозрительно многих адресов
+	Limit            int        `json:"limit"`            // Лимит скачиваний одной покупки, 0 - без ограничения
+}
+
+// apiUpdateProductRequest - частичное обновление товара, отсутствующие поля не меняются
+type apiUpdateProductRequest struct {
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Price       *float64  `json:"price"`
+	Tags        *[]string `json:"tags"`
+	Watermark   *bool     `json:"watermark"`
+}
+
+func newAPIProduct(product models.Product, tags []string) apiProduct {
+	if tags == nil {
+		tags = []string{}
+	}
+	result := apiProduct{
+		ID:          product.ID,
+		Title:       product.Title,
+		Description: product.Description,
+		Price:       product.Price,
+		SellerID:    product.UserID,
+		Tags:        tags,
+		URL:         services.ProductPath(product.ID, product.Title),
+		Version:     product.Version,
+		Watermark:   product.Watermark,
+		RatingAvg:   product.RatingAvg,
+		ReviewCount: product.ReviewCount,
+		CreatedAt:   product.CreatedAt,
+	}
+	if product.ImagePath != "" {
+		result.ImageURL = "/images/products/" + strconv.FormatUint(uint64(product.ID), 10)
+	}
+	return result
+}
+
+// productTagNames загружает названия тегов для набора товаров одним запросом
+func (api *APIController) productTagNames(c *gin.Context, productIDs []uint) map[uint][]string {
+	result := make(map[uint][]string)
+	if len(productIDs) == 0 {
+		return result
+	}
+
+	names, err := api.tags.NamesByProducts(productIDs)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки тегов товаров", logging.Err(err))
+		return result
+	}
+	return names
+}
+
+// newAPIProducts преобразует список товаров вместе с их тегами
+func (api *APIController) newAPIProducts(c *gin.Context, products []models.Product) []apiProduct {
+	ids := make([]uint, 0, len(products))
+	for _, product := range products {
+		ids = append(ids, product.ID)
+	}
+	tags := api.productTagNames(c, ids)
+
+	result := make([]apiProduct, 0, len(products))
+	for _, product := range products {
+		result = append(result, newAPIProduct(product, tags[product.ID]))
+	}
+	return result
+}
+
+// newAPIProductHits преобразует результаты поиска, сохраняя релевантность и фрагменты описания
+func (api *APIController) newAPIProductHits(c *gin.Context, hits []services.ProductHit) []apiProduct {
+	products := make([]models.Product, 0, len(hits))
+	for _, hit := range hits {
+		products = append(products, hit.Product)
+	}
+
+	result := api.newAPIProducts(c, products)
+	for i, hit := range hits {
+		result[i].Rank = hit.Rank
+		result[i].Snippet = string(hit.Snippet)
+	}
+	return result
+}
+
+// loadProductParam загружает товар по параметру :id. При ошибке ответ уже отправлен.
+func (api *APIController) loadProductParam(c *gin.Context) (models.Product, bool) {
+	var product models.Product
+
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректный ID продукта")
+		return product, false
+	}
+	if valid, errMsg := api.validationService.ValidateProductID(uint(productID)); !valid {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, errMsg)
+		return product, false
+	}
+
+	found, err := api.products.FindByID(uint(productID))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			apiError(c, http.StatusNotFound, apiCodeNotFound, "Продукт не найден")
+		} else {
+			requestLogger(c).Error("Ошибка загрузки товара", logging.ProductID(uint(productID)), logging.Err(err))
+			apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось загрузить продукт")
+		}
+		return product, false
+	}
+	return *found, true
+}
+
+// loadOwnProductParam загружает товар по :id и проверяет, что он принадлежит текущему пользователю
+func (api *APIController) loadOwnProductParam(c *gin.Context) (models.Product, bool) {
+	product, ok := api.loadProductParam(c)
+	if !ok {
+		return product, false
+	}
+	user, _ := getUserFromContext(c)
+	if product.UserID != user.ID {
+		apiError(c, http.StatusForbidden, apiCodeForbidden, "Изменять товар может только его владелец")
+		return product, false
+	}
+	return product, true
+}
+
+// ListProducts возвращает страницу товаров. Параметры те же, что у /api/products:
+// q, tags, tag_mode, min_price, max_price, seller_id, seller, sort, cursor и limit.
+func (api *APIController) ListProducts(c *gin.Context) {
+	page, err := findProducts(c, api.validationService, api.searchService)
+	if err != nil {
+		var paramErr *productQueryError
+		if errors.As(err, &paramErr) {
+			apiError(c, http.StatusBadRequest, apiCodeBadRequest, paramErr.message)
+			return
+		}
+		requestLogger(c).Error("Ошибка загрузки товаров", logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось загрузить товары")
+		return
+	}
+	apiOKPage(c, api.newAPIProductHits(c, page.Items), newAPIPagination(page))
+}
+
+// GetProduct возвращает один товар. Снятый с продажи товар виден только его владельцу.
+func (api *APIController) GetProduct(c *gin.Context) {
+	product, ok := api.loadProductParam(c)
+	if !ok {
+		return
+	}
+	if user, _ := getUserFromContext(c); !product.Listed() && product.UserID != user.ID {
+		apiError(c, http.StatusNotFound, apiCodeNotFound, "Продукт не найден")
+		return
+	}
+	apiOK(c, http.StatusOK, newAPIProduct(product, api.productTagNames(c, []uint{product.ID})[product.ID]))
+}
+
+// CreateProduct создает товар из multipart-формы с теми же полями, что и страница /upload:
+// title, description, price, tags (через запятую), image и files, а также watermark (true или false).
+func (api *APIController) CreateProduct(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	price, err := strconv.ParseFloat(strings.TrimSpace(c.PostForm("price")), 64)
+	if err != nil {
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, "Неверный формат цены. Введите числовое значение")
+		return
+	}
+
+	watermarkEnabled := false
+	if value := strings.TrimSpace(c.PostForm("watermark")); value != "" {
+		if watermarkEnabled, err = strconv.ParseBool(value); err != nil {
+			apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, "Поле watermark должно быть true или false")
+			return
+		}
+	}
+
+	image, err := c.FormFile("image")
+	if err != nil {
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, "Не передано изображение товара (поле image)")
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Ожидается multipart/form-data")
+		return
+	}
+
+	product, errMsg := api.uploads.createProduct(c, user, productUploadInput{
+		Title:       strings.TrimSpace(c.PostForm("title")),
+		Description: strings.TrimSpace(c.PostForm("description")),
+		Price:       price,
+		NewTagNames: strings.Split(c.PostForm("tags"), ","),
+		Image:       image,
+		Files:       form.File["files"],
+		Watermark:   watermarkEnabled,
+	})
+	if errMsg != "" {
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+		return
+	}
+
+	apiOK(c, http.StatusCreated, newAPIProduct(*product, api.productTagNames(c, []uint{product.ID})[product.ID]))
+}
+
+// UpdateProduct изменяет название, описание, цену, теги и водяные знаки товара (только владелец)
+func (api *APIController) UpdateProduct(c *gin.Context) {
+	product, ok := api.loadOwnProductParam(c)
+	if !ok {
+		return
+	}
+
+	var req apiUpdateProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректное тело запроса")
+		return
+	}
+
+	var changes repository.ProductChanges
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if valid, errMsg := api.validationService.ValidateTitle(title); !valid {
+			apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+			return
+		}
+		changes.Title = &title
+	}
+	if req.Description != nil {
+		description := strings.TrimSpace(*req.Description)
+		if valid, errMsg := api.validationService.ValidateDescription(description); !valid {
+			apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+			return
+		}
+		changes.Description = &description
+	}
+	if req.Price != nil {
+		if valid, errMsg := api.validationService.ValidatePrice(*req.Price); !valid {
+			apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+			return
+		}
+		changes.Price = req.Price
+	}
+	if req.Watermark != nil {
+		changes.Watermark = req.Watermark
+	}
+
+	if req.Tags != nil {
+		tagIDs, errMsg := api.uploads.resolveTagIDs(nil, *req.Tags)
+		if errMsg != "" {
+			apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+			return
+		}
+		changes.TagIDs = &tagIDs
+	}
+
+	// Подписчики из избранного узнают о снижении цены из очередного письма
+	if err := api.products.Update(c.Request.Context(), &product, changes); err != nil {
+		requestLogger(c).Error("Ошибка обновления товара", logging.ProductID(product.ID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось сохранить изменения")
+		return
+	}
+	apiOK(c, http.StatusOK, newAPIProduct(product, api.productTagNames(c, []uint{product.ID})[product.ID]))
+}
+
+// PublishVersion заменяет файлы товара новой версией (только владелец).
+// Подписчики из избранного получат письмо о выходе версии.
+func (api *APIController) PublishVersion(c *gin.Context) {
+	product, ok := api.loadOwnProductParam(c)
+	if !ok {
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Ожидается multipart/form-data")
+		return
+	}
+
+	updated, errMsg := api.uploads.publishNewVersion(c, product, form.File["files"])
+	if errMsg != "" {
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+		return
+	}
+	apiOK(c, http.StatusOK, newAPIProduct(*updated, api.productTagNames(c, []uint{updated.ID})[updated.ID]))
+}
+
+// DeleteProduct удаляет товар (только владелец). Купленные товары удалить нельзя,
+// иначе покупатели потеряют доступ к файлам.
+func (api *APIController) DeleteProduct(c *gin.Context) {
+	product, ok := api.loadOwnProductParam(c)
+	if !ok {
+		return
+	}
+
+	orderedCount, err := api.orders.CountByProduct(product.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка проверки заказов товара", logging.ProductID(product.ID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось удалить товар")
+		return
+	}
+	if orderedCount > 0 {
+		apiError(c, http.StatusConflict, apiCodeConflict, "Товар уже покупали, его нельзя удалить")
+		return
+	}
+
+	if err := api.products.Delete(c.Request.Context(), product.ID); err != nil {
+		requestLogger(c).Error("Ошибка удаления товара", logging.ProductID(product.ID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось удалить товар")
+		return
+	}
+
+	// Файлы удаляем после успешного коммита; ошибка здесь не влияет на ответ
+	for _, path := range []string{product.FilePath, product.ImagePath} {
+		if path != "" {
+			if err := os.Remove(filepath.Join(".", strings.TrimPrefix(path, "/"))); err != nil && !os.IsNotExist(err) {
+				requestLogger(c).Warn("Не удалось удалить файл товара", logging.ProductID(product.ID), slog.String("path", path), logging.Err(err))
+			}
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// CreateDownloadLink выдает временную ссылку на скачивание файлов товара
+// владельцу или покупателю товара
+func (api *APIController) CreateDownloadLink(c *gin.Context) {
+	product, ok := api.loadProductParam(c)
+	if !ok {
+		return
+	}
+
+	user, _ := getUserFromContext(c)
+	if product.UserID != user.ID && !api.orderService.HasPurchased(c.Request.Context(), user.ID, product.ID) {
+		apiError(c, http.StatusForbidden, apiCodeForbidden, "У вас нет доступа к этому продукту. Пожалуйста, приобретите его сначала.")
+		return
+	}
+
+	// Ссылку с исчерпанным лимитом не выдаем, но само скачивание по ней еще раз
+	// резервируется в лимите (см. DownloadController.HandleDownload)
+	if err := api.downloadService.CheckLimit(user.ID, product); err != nil {
+		if errors.Is(err, services.ErrDownloadLimitReached) {
+			metrics.DownloadsRejected.Inc()
+			apiError(c, http.StatusForbidden, apiCodeForbidden, downloadLimitMessage(api.downloadService))
+			return
+		}
+		requestLogger(c).Error("Ошибка проверки лимита скачиваний", logging.ProductID(product.ID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось проверить лимит скачиваний")
+		return
+	}
+
+	token, err := api.fileService.GenerateDownloadToken(product.ID, user.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка создания ссылки на скачивание", logging.ProductID(product.ID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось создать ссылку для скачивания")
+		return
+	}
+	info, err := api.fileService.GetDownloadInfo(token)
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось создать ссылку для скачивания")
+		return
+	}
+
+	apiOK(c, http.StatusCreated, apiDownloadLink{
+		URL:       api.fileService.GenerateDownloadURL(token, services.BaseURL()),
+		ExpiresAt: info.ExpireTime,
+	})
+}
+
+// GetDownloadStats возвращает владельцу статистику скачиваний товара покупателями
+func (api *APIController) GetDownloadStats(c *gin.Context) {
+	product, ok := api.loadProductParam(c)
+	if !ok {
+		return
+	}
+	if user, _ := getUserFromContext(c); product.UserID != user.ID {
+		apiError(c, http.StatusForbidden, apiCodeForbidden, "Статистика скачиваний доступна только владельцу товара")
+		return
+	}
+
+	stats, err := api.downloadService.Stats([]uint{product.ID})
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки статистики скачиваний", logging.ProductID(product.ID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось загрузить статистику скачиваний")
+		return
+	}
+	productStats := stats[product.ID]
+	apiOK(c, http.StatusOK, apiDownloadStats{
+		ProductID:        product.ID,
+		Downloads:        productStats.Downloads,
+		RecentDownloads:  productStats.RecentDownloads,
+		Buyers:           productStats.Buyers,
+		Bytes:            productStats.Bytes,
+		LastDownloadAt:   productStats.LastDownloadAt,
+		SuspiciousBuyers: productStats.SuspiciousBuyers,
+		Limit:            api.downloadService.Limit(),
+	})
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/models"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestAPIUpdateProduct(t *testing.T) {
+	store, repos := newTestStore()
+	seller := testUser(t, repos, "seller@example.com", models.RoleSeller)
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	product := testProduct(store, seller, "Шаблон", 100)
+	store.AddTag(&models.Tag{Name: "старый", Slug: "staryi"}, product.ID)
+	if _, err := repos.Wishlist.Add(&models.WishlistItem{UserID: buyer.ID, ProductID: product.ID}); err != nil {
+		t.Fatal(err)
+	}
+	router := newTestAPI(t, repos)
+	target := "/api/v1/products/" + idString(product.ID)
+
+	if w := serveAPI(router, http.MethodPatch, target, testAccessToken(t, repos, buyer), []byte(`{"price": 1}`)); w.Code != http.StatusForbidden {
+		t.Errorf("чужой товар: статус %d, ожидался 403", w.Code)
+	}
+	token := testAccessToken(t, repos, seller)
+	if w := serveAPI(router, http.MethodPatch, target, token, []byte(`{"price": -5}`)); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("отрицательная цена: статус %d, ожидался 422", w.Code)
+	}
+
+	w := serveAPI(router, http.MethodPatch, target, token, []byte(`{"title": " Новый шаблон ", "price": 60, "watermark": true, "tags": ["Иконки"]}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("обновление: статус %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Data apiProduct `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	got := resp.Data
+	if got.Title != "Новый шаблон" || got.Price != 60 || !got.Watermark || len(got.Tags) != 1 || got.Tags[0] != "Иконки" {
+		t.Errorf("неожиданный ответ: %+v", got)
+	}
+	if saved, _ := repos.Products.FindByID(product.ID); saved.Title != "Новый шаблон" || saved.Price != 60 || !saved.Watermark {
+		t.Errorf("товар в хранилище не изменен: %+v", saved)
+	}
+
+	events := store.WishlistNotifications()
+	if len(events) != 1 || events[0].Kind != models.WishlistNotificationPriceDrop || events[0].NewPrice != 60 {
+		t.Errorf("ожидалось уведомление о снижении цены до 60: %+v", events)
+	}
+}
+
+func TestAPIDeleteProduct(t *testing.T) {
+	store, repos := newTestStore()
+	seller := testUser(t, repos, "seller@example.com", models.RoleSeller)
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	sold := testProduct(store, seller, "Проданный", 100)
+	unsold := testProduct(store, seller, "Непроданный", 100)
+	store.AddOrder(&models.Order{UserID: buyer.ID, Items: []models.OrderItem{{ProductID: sold.ID}}})
+	if _, err := repos.Wishlist.Add(&models.WishlistItem{UserID: buyer.ID, ProductID: unsold.ID}); err != nil {
+		t.Fatal(err)
+	}
+	router := newTestAPI(t, repos)
+	token := testAccessToken(t, repos, seller)
+
+	if w := serveAPI(router, http.MethodDelete, "/api/v1/products/"+idString(sold.ID), token, nil); w.Code != http.StatusConflict {
+		t.Errorf("купленный товар: статус %d, ожидался 409", w.Code)
+	}
+	if w := serveAPI(router, http.MethodDelete, "/api/v1/products/"+idString(unsold.ID), testAccessToken(t, repos, buyer), nil); w.Code != http.StatusForbidden {
+		t.Errorf("чужой товар: статус %d, ожидался 403", w.Code)
+	}
+	if w := serveAPI(router, http.MethodDelete, "/api/v1/products/"+idString(unsold.ID), token, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("удаление: статус %d: %s", w.Code, w.Body)
+	}
+
+	if _, err := repos.Products.FindByID(unsold.ID); err == nil {
+		t.Error("товар не удален")
+	}
+	if in, _ := repos.Wishlist.Contains(buyer.ID, unsold.ID); in {
+		t.Error("удаленный товар остался в избранном")
+	}
+	if w := serveAPI(router, http.MethodDelete, "/api/v1/products/"+idString(unsold.ID), token, nil); w.Code != http.StatusNotFound {
+		t.Errorf("повторное удаление: статус %d, ожидался 404", w.Code)
+	}
+}
+
+func TestAPITokensCreateAndRevoke(t *testing.T) {
+	_, repos := newTestStore()
+	user := testUser(t, repos, "user@example.com", models.RoleUser)
+	other := testUser(t, repos, "other@example.com", models.RoleUser)
+	router := newTestAPI(t, repos)
+	token := testAccessToken(t, repos, user)
+
+	w := serveAPI(router, http.MethodPost, "/api/v1/tokens", token, []byte(`{"name": "CI"}`))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("создание токена: статус %d: %s", w.Code, w.Body)
+	}
+	var created struct {
+		Data struct {
+			ID    uint   `json:"id"`
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if w := serveAPI(router, http.MethodGet, "/api/v1/profile", created.Data.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("запрос с новым токеном: статус %d", w.Code)
+	}
+
+	revoke := "/api/v1/tokens/" + idString(created.Data.ID)
+	if w := serveAPI(router, http.MethodDelete, revoke, testAccessToken(t, repos, other), nil); w.Code != http.StatusNotFound {
+		t.Errorf("отзыв чужого токена: статус %d, ожидался 404", w.Code)
+	}
+	if w := serveAPI(router, http.MethodDelete, revoke, token, nil); w.Code != http.StatusNoContent {
+		t.Fatalf("отзыв токена: статус %d: %s", w.Code, w.Body)
+	}
+	if w := serveAPI(router, http.MethodGet, "/api/v1/profile", created.Data.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("запрос с отозванным токеном: статус %d, ожидался 401", w.Code)
+	}
+}
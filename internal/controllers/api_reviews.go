@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type apiReview struct {
+	ID            uint       `json:"id"`
+	ProductID     uint       `json:"productId"`
+	AuthorID      uint       `json:"authorId"`
+	AuthorName    string     `json:"authorName"`
+	Rating        int        `json:"rating"`
+	Text          string     `json:"text"`
+	SellerReply   string     `json:"sellerReply,omitempty"`
+	SellerReplyAt *time.Time `json:"sellerReplyAt,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+type apiReviewRequest struct {
+	Rating int    `json:"rating"`
+	Text   string `json:"text"`
+}
+
+type apiReviewReplyRequest struct {
+	Reply string `json:"reply"`
+}
+
+func newAPIReview(review models.Review) apiReview {
+	return apiReview{
+		ID:            review.ID,
+		ProductID:     review.ProductID,
+		AuthorID:      review.UserID,
+		AuthorName:    review.User.Username,
+		Rating:        review.Rating,
+		Text:          review.Text,
+		SellerReply:   review.SellerReply,
+		SellerReplyAt: review.SellerReplyAt,
+		CreatedAt:     review.CreatedAt,
+		UpdatedAt:     review.UpdatedAt,
+	}
+}
+
+// ListReviews возвращает видимые отзывы о товаре, новые первыми
+func (api *APIController) ListReviews(c *gin.Context) {
+	product, ok := api.loadProductParam(c)
+	if !ok {
+		return
+	}
+
+	reviews, err := api.reviewService.ListReviews(product.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки отзывов", logging.ProductID(product.ID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось загрузить отзывы")
+		return
+	}
+
+	result := make([]apiReview, 0, len(reviews))
+	for _, review := range reviews {
+		result = append(result, newAPIReview(review))
+	}
+	apiOK(c, http.StatusOK, result)
+}
+
+// SaveReview создает (201) или изменяет (200) отзыв текущего пользователя о товаре
+func (api *APIController) SaveReview(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	product, ok := api.loadProductParam(c)
+	if !ok {
+		return
+	}
+
+	var req apiReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректное тело запроса")
+		return
+	}
+	if valid, errMsg := api.validationService.ValidateReview(req.Rating, req.Text); !valid {
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+		return
+	}
+
+	review, created, err := api.reviewService.SaveReview(user.ID, product.ID, req.Rating, req.Text)
+	if err != nil {
+		if errors.Is(err, services.ErrReviewNotAllowed) {
+			apiError(c, http.StatusForbidden, apiCodeForbidden, "Отзыв могут оставить только покупатели товара")
+			return
+		}
+		requestLogger(c).Error("Ошибка сохранения отзыва", logging.ProductID(product.ID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось сохранить отзыв")
+		return
+	}
+
+	review.User = user
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	apiOK(c, status, newAPIReview(*review))
+}
+
+// ReplyToReview сохраняет ответ продавца на отзыв о его товаре
+func (api *APIController) ReplyToReview(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	reviewID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректный ID отзыва")
+		return
+	}
+
+	var req apiReviewReplyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректное тело запроса")
+		return
+	}
+	if valid, errMsg := api.validationService.ValidateReviewReply(req.Reply); !valid {
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+		return
+	}
+
+	review, err := api.reviewService.Reply(user.ID, uint(reviewID), req.Reply)
+	if err != nil {
+		api.abortReviewError(c, err)
+		return
+	}
+	apiOK(c, http.StatusOK, newAPIReview(*review))
+}
+
+// FlagReview отправляет отзыв на проверку модератору
+func (api *APIController) FlagReview(c *gin.Context) {
+	reviewID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректный ID отзыва")
+		return
+	}
+
+	if err := api.reviewService.Flag(uint(reviewID)); err != nil {
+		api.abortReviewError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// abortReviewError отвечает ошибкой операции с отзывом
+func (api *APIController) abortReviewError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrReviewNotFound):
+		apiError(c, http.StatusNotFound, apiCodeNotFound, "Отзыв не найден")
+	case errors.Is(err, services.ErrNotProductSeller):
+		apiError(c, http.StatusForbidden, apiCodeForbidden, "Ответить на отзыв может только продавец товара")
+	default:
+		requestLogger(c).Error("Ошибка операции с отзывом", logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось выполнить операцию с отзывом")
+	}
+}
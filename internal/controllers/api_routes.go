@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"digital-marketplace/internal/worker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIRoutesConfig - зависимости и лимиты для маршрутов JSON API
+type APIRoutesConfig struct {
+	Repos       repository.Repositories
+	RateLimiter *services.RateLimitService
+	APILimit    services.RateLimitRule // Лимит запросов к /api с одного IP и для одного аккаунта
+	AuthIPLimit services.RateLimitRule // Лимит попыток входа с одного IP (общий с формой /login)
+	Jobs        *worker.Supervisor     // Фоновые задачи (письма о заказах)
+}
+
+// RegisterAPIRoutes регистрирует все JSON-эндпоинты под /api. Список маршрутов
+// должен совпадать со спецификацией из APISpec - это проверяет команда cmd/openapi.
+func RegisterAPIRoutes(router gin.IRouter, cfg APIRoutesConfig) *gin.RouterGroup {
+	prod := NewProductController(cfg.Repos)
+	apiV1 := NewAPIController(cfg.Repos, cfg.RateLimiter, cfg.Jobs)
+
+	api := router.Group("/api")
+	api.Use(RateLimitByIP(cfg.RateLimiter, "api", cfg.APILimit))
+	{
+		// Спецификация и документация
+		api.GET("/openapi.json", ServeOpenAPISpec)
+		api.GET("/docs", ServeAPIDocs)
+
+		// Добавляем маршруты для API продуктов и тегов
+		api.GET("/products", prod.GetProductsAPI) // Получение списка продуктов (JSON)
+		api.GET("/tags", prod.GetTags)            // Получение списка тегов (JSON)
+	}
+
+	// Версионированный JSON API. Аутентификация - персональные токены доступа
+	// (Authorization: Bearer ...), которые создаются в профиле или через /api/v1/auth/login.
+	v1 := api.Group("/v1")
+	{
+		v1.POST("/auth/login", RateLimitByIP(cfg.RateLimiter, "auth", cfg.AuthIPLimit), apiV1.Login)
+
+		v1Auth := v1.Group("/")
+		v1Auth.Use(APITokenRequired(apiV1.TokenService()))
+		v1Auth.Use(RateLimitByAccount(cfg.RateLimiter, "api", cfg.APILimit))
+		{
+			v1Auth.DELETE("/auth/token", apiV1.Logout)
+
+			v1Auth.GET("/profile", apiV1.GetProfile)
+			v1Auth.PATCH("/profile/storefront", apiV1.UpdateStorefront)
+			v1Auth.GET("/tokens", apiV1.ListTokens)
+			v1Auth.POST("/tokens", apiV1.CreateToken)
+			v1Auth.DELETE("/tokens/:id", apiV1.RevokeToken)
+
+			v1Auth.GET("/products", apiV1.ListProducts)
+			v1Auth.POST("/products", apiV1.CreateProduct)
+			v1Auth.GET("/products/:id", apiV1.GetProduct)
+			v1Auth.PATCH("/products/:id", apiV1.UpdateProduct)
+			v1Auth.DELETE("/products/:id", apiV1.DeleteProduct)
+			v1Auth.POST("/products/:id/versions", apiV1.PublishVersion)
+			v1Auth.POST("/products/:id/buy", apiV1.BuyProduct)
+			v1Auth.POST("/products/:id/download-link", apiV1.CreateDownloadLink)
+			v1Auth.GET("/products/:id/download-stats", apiV1.GetDownloadStats)
+			v1Auth.GET("/products/:id/reviews", apiV1.ListReviews)
+			v1Auth.PUT("/products/:id/review", apiV1.SaveReview)
+			v1Auth.POST("/reviews/:id/reply", apiV1.ReplyToReview)
+			v1Auth.POST("/reviews/:id/flag", apiV1.FlagReview)
+
+			v1Auth.GET("/sellers/:username", apiV1.GetStorefront)
+
+			v1Auth.GET("/cart", apiV1.GetCart)
+			v1Auth.POST("/cart/items", apiV1.AddToCart)
+			v1Auth.DELETE("/cart/items/:id", apiV1.RemoveFromCart)
+			v1Auth.POST("/checkout", apiV1.Checkout)
+
+			v1Auth.GET("/wishlist", apiV1.GetWishlist)
+			v1Auth.POST("/wishlist/items", apiV1.AddToWishlist)
+			v1Auth.DELETE("/wishlist/items/:id", apiV1.RemoveFromWishlist)
+
+			v1Auth.GET("/orders", apiV1.ListOrders)
+			v1Auth.GET("/orders/:id", apiV1.GetOrder)
+		}
+	}
+
+	return api
+}
@@ -0,0 +1,375 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/openapi"
+	"digital-marketplace/internal/services"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiErrorResponse - тело ошибки /api/v1 (см. apiError)
+type apiErrorResponse struct {
+	Error apiErrorBody `json:"error"`
+}
+
+// legacyAPIError - тело ошибки старых эндпоинтов /api/products и /api/tags
+type legacyAPIError struct {
+	Error string `json:"error"`
+}
+
+// Тег безопасности для операций, требующих персональный токен
+var bearerSecurity = []map[string][]string{{"bearerAuth": {}}}
+
+// APISpec строит спецификацию OpenAPI для всех маршрутов из RegisterAPIRoutes.
+// Схемы берутся из тех же типов, которые отдают обработчики, поэтому новые поля
+// попадают в документ автоматически, а новые маршруты нужно описать здесь.
+func APISpec() *openapi.Document {
+	doc := openapi.New("Digital Marketplace API", "1.0.0",
+		"JSON API маркетплейса. Эндпоинты /api/v1 требуют персональный токен доступа "+
+			"(Authorization: Bearer dmp_...), который создается в профиле или через POST /api/v1/auth/login.")
+	doc.Components.SecuritySchemes["bearerAuth"] = &openapi.SecurityScheme{
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "dmp_<token>",
+		Description:  "Персональный токен доступа",
+	}
+	doc.Tags = []openapi.Tag{
+		{Name: "auth", Description: "Получение и отзыв токенов"},
+		{Name: "profile", Description: "Профиль и токены доступа"},
+		{Name: "products", Description: "Каталог и управление товарами"},
+		{Name: "reviews", Description: "Отзывы покупателей и ответы продавцов"},
+		{Name: "sellers", Description: "Витрины продавцов"},
+		{Name: "cart", Description: "Корзина и оформление заказа"},
+		{Name: "wishlist", Description: "Избранное и уведомления о снижении цены и новых версиях"},
+		{Name: "orders", Description: "История покупок"},
+		{Name: "legacy", Description: "Неверсионированные эндпоинты, которые использует страница /products"},
+		{Name: "meta", Description: "Спецификация и документация"},
+	}
+
+	// data оборачивает схему в успешный ответ {"data": ...}
+	data := func(v interface{}) *openapi.Schema {
+		return openapi.ObjectOf(map[string]*openapi.Schema{"data": doc.SchemaOf(v)}, "data")
+	}
+	errorSchema := doc.SchemaOf(apiErrorResponse{})
+	legacyErrorSchema := doc.SchemaOf(legacyAPIError{})
+	rateLimited := &openapi.Response{
+		Description: "Превышен лимит запросов",
+		Headers: map[string]*openapi.Header{
+			"Retry-After": {Description: "Через сколько секунд повторить запрос", Schema: &openapi.Schema{Type: "integer"}},
+		},
+		Content: openapi.JSONContent(errorSchema),
+	}
+	// v1 добавляет стандартные ответы об ошибках к операции /api/v1
+	v1 := func(op openapi.Operation, errorStatuses ...int) openapi.Operation {
+		if op.Responses == nil {
+			op.Responses = map[string]*openapi.Response{}
+		}
+		for _, status := range errorStatuses {
+			op.Responses[strconv.Itoa(status)] = openapi.JSONResponse(http.StatusText(status), errorSchema)
+		}
+		op.Responses["429"] = rateLimited
+		if op.Security != nil {
+			op.Responses["401"] = openapi.JSONResponse("Токен отсутствует, истек или отозван либо аккаунт заблокирован", errorSchema)
+		}
+		op.Responses["500"] = openapi.JSONResponse("Внутренняя ошибка", errorSchema)
+		return op
+	}
+	noContent := &openapi.Response{Description: "Выполнено"}
+
+	// --- Спецификация и документация ---
+	doc.Add(http.MethodGet, "/api/openapi.json", openapi.Operation{
+		Tags: []string{"meta"}, OperationID: "getOpenAPISpec", Summary: "Спецификация OpenAPI 3",
+		Responses: map[string]*openapi.Response{"200": openapi.JSONResponse("Документ OpenAPI", &openapi.Schema{Type: "object"})},
+	})
+	doc.Add(http.MethodGet, "/api/docs", openapi.Operation{
+		Tags: []string{"meta"}, OperationID: "getAPIDocs", Summary: "Просмотр документации в браузере",
+		Responses: map[string]*openapi.Response{"200": {
+			Description: "HTML-страница",
+			Content:     map[string]*openapi.MediaType{"text/html": {Schema: &openapi.Schema{Type: "string"}}},
+		}},
+	})
+
+	// --- Старые эндпоинты ---
+	doc.Add(http.MethodGet, "/api/products", openapi.Operation{
+		Tags: []string{"legacy"}, OperationID: "legacyListProducts",
+		Summary: "Get a page of products, optionally searched, filtered and sorted",
+		Description: "If 'q' is provided, products are searched by title, tags and description (prefix and typo-tolerant matching) and sorted by relevance. " +
+			"The body is a plain array; when more products are available the cursor of the next page is returned in the X-Next-Cursor and Link headers.",
+		Parameters: productListParams(),
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "Страница товаров",
+				Headers: map[string]*openapi.Header{
+					"X-Next-Cursor": {Description: "Значение параметра cursor для следующей страницы", Schema: &openapi.Schema{Type: "string"}},
+					"Link":          {Description: `Ссылка на следующую страницу (rel="next")`, Schema: &openapi.Schema{Type: "string"}},
+				},
+				Content: openapi.JSONContent(openapi.ArrayOf(doc.SchemaOf(services.ProductHit{}))),
+			},
+			"400": openapi.JSONResponse("Недопустимый параметр запроса", legacyErrorSchema),
+			"429": rateLimited,
+			"500": openapi.JSONResponse("Ошибка базы данных", legacyErrorSchema),
+		},
+	})
+	doc.Add(http.MethodGet, "/api/tags", openapi.Operation{
+		Tags: []string{"legacy"}, OperationID: "legacyListTags", Summary: "Get all available tags",
+		Responses: map[string]*openapi.Response{
+			"200": openapi.JSONResponse("Список тегов с числом товаров", openapi.ArrayOf(doc.SchemaOf(services.TagUsage{}))),
+			"429": rateLimited,
+			"500": openapi.JSONResponse("Ошибка базы данных", legacyErrorSchema),
+		},
+	})
+
+	// --- Аутентификация ---
+	doc.Add(http.MethodPost, "/api/v1/auth/login", v1(openapi.Operation{
+		Tags: []string{"auth"}, OperationID: "login", Summary: "Получить токен по email и паролю",
+		Description: "После серии неудачных попыток вход блокируется, ответ 429 содержит Retry-After. Для заблокированного аккаунта - 403.",
+		RequestBody: openapi.JSONBody(doc.SchemaOf(apiLoginRequest{})),
+		Responses:   map[string]*openapi.Response{"201": openapi.JSONResponse("Новый токен", data(apiToken{}))},
+	}, http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusUnprocessableEntity, http.StatusConflict))
+	doc.Add(http.MethodDelete, "/api/v1/auth/token", v1(openapi.Operation{
+		Tags: []string{"auth"}, OperationID: "logout", Summary: "Отозвать текущий токен", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"204": noContent},
+	}))
+
+	// --- Профиль и токены ---
+	doc.Add(http.MethodGet, "/api/v1/profile", v1(openapi.Operation{
+		Tags: []string{"profile"}, OperationID: "getProfile", Summary: "Текущий пользователь", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"200": openapi.JSONResponse("Профиль", data(apiUser{}))},
+	}))
+	doc.Add(http.MethodPatch, "/api/v1/profile/storefront", v1(openapi.Operation{
+		Tags: []string{"sellers"}, OperationID: "updateStorefront", Summary: "Изменить свою витрину", Security: bearerSecurity,
+		Description: "Отсутствующие поля не меняются; links заменяет весь список (до 5 ссылок http/https). Аватар загружается в профиле на сайте.",
+		RequestBody: openapi.JSONBody(doc.SchemaOf(apiUpdateStorefrontRequest{})),
+		Responses:   map[string]*openapi.Response{"200": openapi.JSONResponse("Витрина", data(apiStorefront{}))},
+	}, http.StatusBadRequest, http.StatusUnprocessableEntity))
+	doc.Add(http.MethodGet, "/api/v1/tokens", v1(openapi.Operation{
+		Tags: []string{"profile"}, OperationID: "listTokens", Summary: "Активные токены", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"200": openapi.JSONResponse("Токены без значений", data([]apiToken{}))},
+	}))
+	doc.Add(http.MethodPost, "/api/v1/tokens", v1(openapi.Operation{
+		Tags: []string{"profile"}, OperationID: "createToken", Summary: "Создать токен", Security: bearerSecurity,
+		Description: "Значение токена (поле token) возвращается только в этом ответе. expiresInDays = 0 - бессрочный токен.",
+		RequestBody: openapi.JSONBody(doc.SchemaOf(apiCreateTokenRequest{})),
+		Responses:   map[string]*openapi.Response{"201": openapi.JSONResponse("Новый токен", data(apiToken{}))},
+	}, http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusConflict))
+	doc.Add(http.MethodDelete, "/api/v1/tokens/:id", v1(openapi.Operation{
+		Tags: []string{"profile"}, OperationID: "revokeToken", Summary: "Отозвать токен", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"204": noContent},
+	}, http.StatusBadRequest, http.StatusNotFound))
+
+	// --- Товары ---
+	doc.Add(http.MethodGet, "/api/v1/products", v1(openapi.Operation{
+		Tags: []string{"products"}, OperationID: "listProducts", Summary: "Список товаров", Security: bearerSecurity,
+		Description: "С параметром q товары ищутся по названию, тегам и описанию (по префиксам слов и с учетом опечаток) " +
+			"и по умолчанию сортируются по релевантности; в ответ добавляются rank и snippet. " +
+			"Если есть следующая страница, pagination.next содержит значение для параметра cursor.",
+		Parameters: productListParams(),
+		Responses: map[string]*openapi.Response{"200": openapi.JSONResponse("Страница товаров", openapi.ObjectOf(map[string]*openapi.Schema{
+			"data":       doc.SchemaOf([]apiProduct{}),
+			"pagination": doc.SchemaOf(apiPagination{}),
+		}, "data", "pagination"))},
+	}, http.StatusBadRequest))
+	doc.Add(http.MethodPost, "/api/v1/products", v1(openapi.Operation{
+		Tags: []string{"products"}, OperationID: "createProduct", Summary: "Загрузить товар", Security: bearerSecurity,
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{
+			"multipart/form-data": {Schema: openapi.ObjectOf(map[string]*openapi.Schema{
+				"title":       {Type: "string"},
+				"description": {Type: "string"},
+				"price":       {Type: "number", Format: "double"},
+				"tags":        {Type: "string", Description: "Названия тегов через запятую"},
+				"image":       {Type: "string", Format: "binary"},
+				"files":       openapi.ArrayOf(&openapi.Schema{Type: "string", Format: "binary"}),
+				"watermark":   {Type: "boolean", Description: "Встраивать в файлы, скачанные покупателями, номер покупателя и заказа"},
+			}, "title", "price", "image")},
+		}},
+		Responses: map[string]*openapi.Response{"201": openapi.JSONResponse("Созданный товар", data(apiProduct{}))},
+	}, http.StatusBadRequest, http.StatusUnprocessableEntity))
+	doc.Add(http.MethodGet, "/api/v1/products/:id", v1(openapi.Operation{
+		Tags: []string{"products"}, OperationID: "getProduct", Summary: "Товар по ID", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"200": openapi.JSONResponse("Товар", data(apiProduct{}))},
+	}, http.StatusBadRequest, http.StatusNotFound))
+	doc.Add(http.MethodPatch, "/api/v1/products/:id", v1(openapi.Operation{
+		Tags: []string{"products"}, OperationID: "updateProduct", Summary: "Изменить товар (только владелец)", Security: bearerSecurity,
+		Description: "Отсутствующие поля не меняются; tags заменяет весь набор тегов. " +
+			"При снижении цены пользователи, у которых товар в избранном, получат письмо.",
+		RequestBody: openapi.JSONBody(doc.SchemaOf(apiUpdateProductRequest{})),
+		Responses:   map[string]*openapi.Response{"200": openapi.JSONResponse("Обновленный товар", data(apiProduct{}))},
+	}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity))
+	doc.Add(http.MethodDelete, "/api/v1/products/:id", v1(openapi.Operation{
+		Tags: []string{"products"}, OperationID: "deleteProduct", Summary: "Удалить товар (только владелец)", Security: bearerSecurity,
+		Description: "Товары, которые уже покупали, удалить нельзя (409).",
+		Responses:   map[string]*openapi.Response{"204": noContent},
+	}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict))
+	doc.Add(http.MethodPost, "/api/v1/products/:id/versions", v1(openapi.Operation{
+		Tags: []string{"products"}, OperationID: "publishProductVersion", Summary: "Опубликовать новую версию файлов (только владелец)", Security: bearerSecurity,
+		Description: "Файлы заменяют текущий архив товара, version увеличивается на 1. " +
+			"Пользователи, у которых товар в избранном, получат письмо о новой версии.",
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]*openapi.MediaType{
+			"multipart/form-data": {Schema: openapi.ObjectOf(map[string]*openapi.Schema{
+				"files": openapi.ArrayOf(&openapi.Schema{Type: "string", Format: "binary"}),
+			}, "files")},
+		}},
+		Responses: map[string]*openapi.Response{"200": openapi.JSONResponse("Товар с новой версией", data(apiProduct{}))},
+	}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity))
+	doc.Add(http.MethodPost, "/api/v1/products/:id/buy", v1(openapi.Operation{
+		Tags: []string{"products"}, OperationID: "buyProduct", Summary: "Купить товар без корзины", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"201": openapi.JSONResponse("Созданный заказ", data(apiOrder{}))},
+	}, http.StatusBadRequest, http.StatusPaymentRequired, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity))
+	doc.Add(http.MethodPost, "/api/v1/products/:id/download-link", v1(openapi.Operation{
+		Tags: []string{"products"}, OperationID: "createDownloadLink", Summary: "Временная ссылка на скачивание", Security: bearerSecurity,
+		Description: "Доступно владельцу товара и покупателям. Покупатель, исчерпавший лимит скачиваний " +
+			"(DOWNLOAD_LIMIT_PER_PURCHASE), получит 403; скачивания по ссылке учитываются в его лимите.",
+		Responses: map[string]*openapi.Response{"201": openapi.JSONResponse("Ссылка", data(apiDownloadLink{}))},
+	}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound))
+	doc.Add(http.MethodGet, "/api/v1/products/:id/download-stats", v1(openapi.Operation{
+		Tags: []string{"products"}, OperationID: "getDownloadStats", Summary: "Статистика скачиваний (только владелец)", Security: bearerSecurity,
+		Description: "Скачивания самого владельца не учитываются. suspiciousBuyers - покупатели, скачивавшие товар " +
+			"с DOWNLOAD_ANOMALY_IPS и более адресов за DOWNLOAD_ANOMALY_WINDOW.",
+		Responses: map[string]*openapi.Response{"200": openapi.JSONResponse("Статистика", data(apiDownloadStats{}))},
+	}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound))
+
+	// --- Отзывы ---
+	doc.Add(http.MethodGet, "/api/v1/products/:id/reviews", v1(openapi.Operation{
+		Tags: []string{"reviews"}, OperationID: "listReviews", Summary: "Отзывы о товаре", Security: bearerSecurity,
+		Description: "Отзывы, скрытые модератором, не возвращаются.",
+		Responses:   map[string]*openapi.Response{"200": openapi.JSONResponse("Отзывы, новые первыми", data([]apiReview{}))},
+	}, http.StatusBadRequest, http.StatusNotFound))
+	doc.Add(http.MethodPut, "/api/v1/products/:id/review", v1(openapi.Operation{
+		Tags: []string{"reviews"}, OperationID: "saveReview", Summary: "Оставить или изменить свой отзыв", Security: bearerSecurity,
+		Description: "Доступно только покупателям товара; у пользователя может быть один отзыв на товар. Оценка от 1 до 5.",
+		RequestBody: openapi.JSONBody(doc.SchemaOf(apiReviewRequest{})),
+		Responses: map[string]*openapi.Response{
+			"200": openapi.JSONResponse("Отзыв изменен", data(apiReview{})),
+			"201": openapi.JSONResponse("Отзыв создан", data(apiReview{})),
+		},
+	}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity))
+	doc.Add(http.MethodPost, "/api/v1/reviews/:id/reply", v1(openapi.Operation{
+		Tags: []string{"reviews"}, OperationID: "replyToReview", Summary: "Ответ продавца на отзыв", Security: bearerSecurity,
+		Description: "Доступно только продавцу товара; повторный вызов заменяет ответ.",
+		RequestBody: openapi.JSONBody(doc.SchemaOf(apiReviewReplyRequest{})),
+		Responses:   map[string]*openapi.Response{"200": openapi.JSONResponse("Отзыв с ответом", data(apiReview{}))},
+	}, http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity))
+	doc.Add(http.MethodPost, "/api/v1/reviews/:id/flag", v1(openapi.Operation{
+		Tags: []string{"reviews"}, OperationID: "flagReview", Summary: "Пожаловаться на отзыв", Security: bearerSecurity,
+		Description: "Отзыв отмечается для проверки модератором.",
+		Responses:   map[string]*openapi.Response{"204": noContent},
+	}, http.StatusBadRequest, http.StatusNotFound))
+
+	// --- Витрины продавцов ---
+	doc.Add(http.MethodGet, "/api/v1/sellers/:username", v1(openapi.Operation{
+		Tags: []string{"sellers"}, OperationID: "getStorefront", Summary: "Витрина продавца", Security: bearerSecurity,
+		Description: "Имя ищется без учета регистра. Товары продавца - GET /api/v1/products?seller_id=...",
+		Responses:   map[string]*openapi.Response{"200": openapi.JSONResponse("Витрина со статистикой", data(apiStorefront{}))},
+	}, http.StatusNotFound))
+
+	// --- Корзина ---
+	doc.Add(http.MethodGet, "/api/v1/cart", v1(openapi.Operation{
+		Tags: []string{"cart"}, OperationID: "getCart", Summary: "Содержимое корзины", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"200": openapi.JSONResponse("Корзина", data(apiCart{}))},
+	}))
+	doc.Add(http.MethodPost, "/api/v1/cart/items", v1(openapi.Operation{
+		Tags: []string{"cart"}, OperationID: "addToCart", Summary: "Добавить товар в корзину", Security: bearerSecurity,
+		RequestBody: openapi.JSONBody(doc.SchemaOf(apiAddToCartRequest{})),
+		Responses: map[string]*openapi.Response{
+			"200": openapi.JSONResponse("Товар уже был в корзине", data(apiCartItem{})),
+			"201": openapi.JSONResponse("Товар добавлен", data(apiCartItem{})),
+		},
+	}, http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity))
+	doc.Add(http.MethodDelete, "/api/v1/cart/items/:id", v1(openapi.Operation{
+		Tags: []string{"cart"}, OperationID: "removeFromCart", Summary: "Удалить позицию корзины", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"204": noContent},
+	}, http.StatusBadRequest, http.StatusNotFound))
+	doc.Add(http.MethodPost, "/api/v1/checkout", v1(openapi.Operation{
+		Tags: []string{"cart"}, OperationID: "checkout", Summary: "Оформить заказ из корзины", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"201": openapi.JSONResponse("Созданный заказ", data(apiOrder{}))},
+	}, http.StatusPaymentRequired, http.StatusConflict, http.StatusUnprocessableEntity))
+
+	// --- Избранное ---
+	doc.Add(http.MethodGet, "/api/v1/wishlist", v1(openapi.Operation{
+		Tags: []string{"wishlist"}, OperationID: "getWishlist", Summary: "Избранное пользователя", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"200": openapi.JSONResponse("Товары, последние добавленные первыми", data([]apiWishlistItem{}))},
+	}))
+	doc.Add(http.MethodPost, "/api/v1/wishlist/items", v1(openapi.Operation{
+		Tags: []string{"wishlist"}, OperationID: "addToWishlist", Summary: "Добавить товар в избранное", Security: bearerSecurity,
+		Description: "Пока товар в избранном, пользователь получает письма о снижении цены и новых версиях.",
+		RequestBody: openapi.JSONBody(doc.SchemaOf(apiAddToWishlistRequest{})),
+		Responses: map[string]*openapi.Response{
+			"200": openapi.JSONResponse("Товар уже был в избранном", data(apiWishlistItem{})),
+			"201": openapi.JSONResponse("Товар добавлен", data(apiWishlistItem{})),
+		},
+	}, http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity))
+	doc.Add(http.MethodDelete, "/api/v1/wishlist/items/:id", v1(openapi.Operation{
+		Tags: []string{"wishlist"}, OperationID: "removeFromWishlist", Summary: "Удалить товар из избранного", Security: bearerSecurity,
+		Description: "id - ID товара.",
+		Responses:   map[string]*openapi.Response{"204": noContent},
+	}, http.StatusBadRequest, http.StatusNotFound))
+
+	// --- Заказы ---
+	doc.Add(http.MethodGet, "/api/v1/orders", v1(openapi.Operation{
+		Tags: []string{"orders"}, OperationID: "listOrders", Summary: "Заказы пользователя", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"200": openapi.JSONResponse("Заказы, новые первыми", data([]apiOrder{}))},
+	}))
+	doc.Add(http.MethodGet, "/api/v1/orders/:id", v1(openapi.Operation{
+		Tags: []string{"orders"}, OperationID: "getOrder", Summary: "Заказ по ID", Security: bearerSecurity,
+		Responses: map[string]*openapi.Response{"200": openapi.JSONResponse("Заказ", data(apiOrder{}))},
+	}, http.StatusBadRequest, http.StatusNotFound))
+
+	return doc
+}
+
+var (
+	apiSpecOnce sync.Once
+	apiSpecJSON []byte
+)
+
+// ServeOpenAPISpec отдает спецификацию в формате JSON (строится один раз при первом запросе)
+func ServeOpenAPISpec(c *gin.Context) {
+	apiSpecOnce.Do(func() {
+		var err error
+		if apiSpecJSON, err = APISpec().JSON(); err != nil {
+			requestLogger(c).Error("Не удалось сериализовать спецификацию OpenAPI", logging.Err(err))
+		}
+	})
+	if apiSpecJSON == nil {
+		c.JSON(http.StatusInternalServerError, legacyAPIError{Error: "Спецификация недоступна"})
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", apiSpecJSON)
+}
+
+// ServeAPIDocs отдает встроенную страницу просмотра спецификации
+func ServeAPIDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", openapi.ViewerHTML)
+}
+
+// productListParams - параметры списка товаров, общие для /api/products и /api/v1/products (см. parseProductQuery)
+func productListParams() []openapi.Parameter {
+	number := func(name, description string) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "query", Description: description,
+			Schema: &openapi.Schema{Type: "number", Format: "double", Minimum: openapi.Float(0)}}
+	}
+	enum := func(name, description string, values ...interface{}) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "query", Description: description,
+			Schema: &openapi.Schema{Type: "string", Enum: values}}
+	}
+
+	return []openapi.Parameter{
+		openapi.QueryParam("q", "Строка поиска, до 200 символов"),
+		openapi.QueryParam("tags", "Теги через запятую"),
+		enum("tag_mode", "all (по умолчанию) - товары со всеми тегами, any - хотя бы с одним", "all", "any"),
+		number("min_price", "Минимальная цена включительно"),
+		number("max_price", "Максимальная цена включительно"),
+		{Name: "seller_id", In: "query", Description: "ID продавца", Schema: &openapi.Schema{Type: "integer", Minimum: openapi.Float(1)}},
+		openapi.QueryParam("seller", "Имя пользователя продавца"),
+		enum("sort", "Порядок; по умолчанию relevance при поиске по q, иначе newest",
+			string(services.SortRelevance), string(services.SortNewest), string(services.SortPriceAsc),
+			string(services.SortPriceDesc), string(services.SortPopular)),
+		openapi.QueryParam("cursor", "Курсор следующей страницы из предыдущего ответа"),
+		{Name: "limit", In: "query", Description: "Размер страницы",
+			Schema: &openapi.Schema{Type: "integer", Minimum: openapi.Float(1), Maximum: openapi.Float(services.MaxProductPageSize)}},
+	}
+}
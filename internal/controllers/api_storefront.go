@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/services"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiStorefront - публичная витрина продавца. Товары продавца отдает GET /api/v1/products?seller_id=...
+type apiStorefront struct {
+	SellerID    uint                     `json:"sellerId"`
+	Username    string                   `json:"username"`
+	Bio         string                   `json:"bio"`
+	AvatarURL   string                   `json:"avatarUrl,omitempty"`
+	Links       []services.SocialLink    `json:"links"`
+	URL         string                   `json:"url"` // Путь витрины на сайте
+	MemberSince time.Time                `json:"memberSince"`
+	Stats       services.StorefrontStats `json:"stats"`
+}
+
+// apiUpdateStorefrontRequest - частичное обновление витрины, отсутствующие поля не меняются
+type apiUpdateStorefrontRequest struct {
+	Bio   *string   `json:"bio"`
+	Links *[]string `json:"links"`
+}
+
+func (api *APIController) newAPIStorefront(user models.User) (apiStorefront, error) {
+	stats, err := api.storefrontService.Stats(user.ID)
+	return apiStorefront{
+		SellerID:    user.ID,
+		Username:    user.Username,
+		Bio:         user.Bio,
+		AvatarURL:   avatarURL(user),
+		Links:       services.UserSocialLinks(user),
+		URL:         services.StorefrontPath(user.Username),
+		MemberSince: user.CreatedAt,
+		Stats:       stats,
+	}, err
+}
+
+// GetStorefront возвращает витрину продавца по имени (без учета регистра)
+func (api *APIController) GetStorefront(c *gin.Context) {
+	seller, err := api.storefrontService.FindSeller(c.Param("username"))
+	if err != nil {
+		if !errors.Is(err, services.ErrStorefrontNotFound) {
+			requestLogger(c).Error("Ошибка загрузки витрины", slog.String("username", c.Param("username")), logging.Err(err))
+		}
+		apiError(c, http.StatusNotFound, apiCodeNotFound, "Продавец не найден")
+		return
+	}
+
+	storefront, err := api.newAPIStorefront(*seller)
+	if err != nil {
+		requestLogger(c).Error("Ошибка подсчета статистики продавца", slog.Uint64("seller_id", uint64(seller.ID)), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось загрузить витрину")
+		return
+	}
+	apiOK(c, http.StatusOK, storefront)
+}
+
+// UpdateStorefront изменяет описание и ссылки витрины текущего пользователя
+func (api *APIController) UpdateStorefront(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	var req apiUpdateStorefrontRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректное тело запроса")
+		return
+	}
+
+	bio := user.Bio
+	if req.Bio != nil {
+		bio = *req.Bio
+		if valid, errMsg := api.validationService.ValidateBio(bio); !valid {
+			apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+			return
+		}
+	}
+	links := services.ParseSocialLinks(user.SocialLinks)
+	if req.Links != nil {
+		links = services.ParseSocialLinks(strings.Join(*req.Links, "\n"))
+		if valid, errMsg := api.validationService.ValidateSocialLinks(links); !valid {
+			apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, errMsg)
+			return
+		}
+	}
+
+	if err := api.storefrontService.UpdateSettings(user.ID, bio, links); err != nil {
+		requestLogger(c).Error("Ошибка сохранения витрины", logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось сохранить настройки витрины")
+		return
+	}
+
+	user.Bio = strings.TrimSpace(bio)
+	user.SocialLinks = strings.Join(links, "\n")
+	storefront, err := api.newAPIStorefront(user)
+	if err != nil {
+		requestLogger(c).Error("Ошибка подсчета статистики продавца", logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось загрузить витрину")
+		return
+	}
+	apiOK(c, http.StatusOK, storefront)
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type apiWishlistItem struct {
+	Product apiProduct `json:"product"`
+	AddedAt time.Time  `json:"addedAt"`
+}
+
+type apiAddToWishlistRequest struct {
+	ProductID uint `json:"productId"`
+}
+
+// GetWishlist возвращает избранное пользователя, последние добавленные первыми
+func (api *APIController) GetWishlist(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	items, err := api.wishlistService.List(user.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки избранного", logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось загрузить избранное")
+		return
+	}
+
+	products := make([]models.Product, 0, len(items))
+	for _, item := range items {
+		products = append(products, item.Product)
+	}
+	converted := api.newAPIProducts(c, products)
+
+	result := make([]apiWishlistItem, 0, len(items))
+	for i, item := range items {
+		result = append(result, apiWishlistItem{Product: converted[i], AddedAt: item.CreatedAt})
+	}
+	apiOK(c, http.StatusOK, result)
+}
+
+// AddToWishlist добавляет товар в избранное. Повторное добавление возвращает существующую запись.
+func (api *APIController) AddToWishlist(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	var req apiAddToWishlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.ProductID == 0 {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Укажите productId")
+		return
+	}
+
+	item, created, err := api.wishlistService.Add(user.ID, req.ProductID)
+	switch {
+	case errors.Is(err, services.ErrProductNotFound):
+		apiError(c, http.StatusNotFound, apiCodeNotFound, "Продукт не найден")
+		return
+	case errors.Is(err, services.ErrWishlistOwnProduct):
+		apiError(c, http.StatusUnprocessableEntity, apiCodeValidation, "Нельзя добавить в избранное свой собственный товар")
+		return
+	case err != nil:
+		requestLogger(c).Error("Ошибка добавления товара в избранное", logging.ProductID(req.ProductID), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось добавить товар в избранное")
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	apiOK(c, status, apiWishlistItem{Product: api.newAPIProducts(c, []models.Product{item.Product})[0], AddedAt: item.CreatedAt})
+}
+
+// RemoveFromWishlist удаляет товар из избранного; :id - ID товара
+func (api *APIController) RemoveFromWishlist(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		apiError(c, http.StatusBadRequest, apiCodeBadRequest, "Некорректный ID продукта")
+		return
+	}
+
+	if err := api.wishlistService.Remove(user.ID, uint(productID)); err != nil {
+		if errors.Is(err, services.ErrWishlistItemNotFound) {
+			apiError(c, http.StatusNotFound, apiCodeNotFound, "Товара нет в избранном")
+			return
+		}
+		requestLogger(c).Error("Ошибка удаления товара из избранного", logging.ProductID(uint(productID)), logging.Err(err))
+		apiError(c, http.StatusInternalServerError, apiCodeInternal, "Не удалось удалить товар из избранного")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
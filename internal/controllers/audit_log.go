@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/services"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditActor описывает текущего пользователя и его запрос для журнала аудита
+func auditActor(c *gin.Context) services.AuditActor {
+	user, _ := getUserFromContext(c)
+	return auditActorFor(c, user)
+}
+
+// auditActorFor описывает запрос от имени user. Нужен там, где пользователь
+// еще не сохранен в контексте: при входе через OAuth или подтверждении привязки.
+func auditActorFor(c *gin.Context, user models.User) services.AuditActor {
+	return services.AuditActor{
+		UserID:    user.ID,
+		Role:      user.Role,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// recordAuditEvent записывает событие, которое не связано с транзакцией сервиса.
+// Ошибка записи не прерывает запрос: она попадает в лог, а пользователь получает обычный ответ.
+func recordAuditEvent(c *gin.Context, auditService *services.AuditService, actor services.AuditActor, entry services.AuditEntry) {
+	if err := auditService.Record(c.Request.Context(), actor, entry); err != nil {
+		requestLogger(c).Error("Не удалось записать действие в журнал аудита", slog.String("action", entry.Action), logging.Err(err))
+	}
+}
+
+// Причины неудачного входа (details.reason в событии auth.login_failed)
+const (
+	loginFailureCredentials = "invalid_credentials"
+	loginFailureLocked      = "locked"
+	loginFailureBanned      = "banned"
+)
+
+// recordLogin записывает успешный вход user способом method (password, api, oauth)
+func recordLogin(c *gin.Context, auditService *services.AuditService, user models.User, method string) {
+	recordAuditEvent(c, auditService, auditActorFor(c, user), services.AuditEntry{
+		Action:     services.AuditAuthLogin,
+		TargetType: services.AuditTargetUser,
+		TargetID:   user.ID,
+		Details:    map[string]interface{}{"method": method},
+	})
+}
+
+// recordLoginFailure записывает отказ во входе. userID известен, только если аккаунт с email существует;
+// действующего лица у такой записи нет, остаются IP и User-Agent.
+func recordLoginFailure(c *gin.Context, auditService *services.AuditService, method, email string, userID uint, reason string) {
+	recordAuditEvent(c, auditService, auditActorFor(c, models.User{}), services.AuditEntry{
+		Action:     services.AuditAuthLoginFailed,
+		TargetType: services.AuditTargetUser,
+		TargetID:   userID,
+		Details:    map[string]interface{}{"method": method, "email": email, "reason": reason},
+	})
+}
+
+// recordTokenCreate записывает выпуск токена доступа к API
+func recordTokenCreate(c *gin.Context, auditService *services.AuditService, token models.PersonalAccessToken) {
+	details := map[string]interface{}{"name": token.Name, "prefix": token.Prefix}
+	if token.ExpiresAt != nil {
+		details["expires_at"] = token.ExpiresAt
+	}
+	recordAuditEvent(c, auditService, auditActor(c), services.AuditEntry{
+		Action:     services.AuditAuthTokenCreate,
+		TargetType: services.AuditTargetToken,
+		TargetID:   token.ID,
+		Details:    details,
+	})
+}
+
+// recordTokenRevoke записывает отзыв токена доступа к API
+func recordTokenRevoke(c *gin.Context, auditService *services.AuditService, tokenID uint) {
+	recordAuditEvent(c, auditService, auditActor(c), services.AuditEntry{
+		Action:     services.AuditAuthTokenRevoke,
+		TargetType: services.AuditTargetToken,
+		TargetID:   tokenID,
+	})
+}
@@ -1,18 +1,24 @@
 package controllers
 
 import (
-	"digital-marketplace/internal/database"
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/metrics"
 	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
 	"digital-marketplace/internal/services"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
 )
 
 // Старые регулярные выражения (можно удалить, так как они теперь в ValidationService)
@@ -32,6 +38,12 @@ func isValidUsername(username string) bool {
 // renderTemplate is a helper function to render HTML templates
 // It automatically adds IsLoggedIn and User data to the template context if available
 func renderTemplate(c *gin.Context, templateName string, data gin.H) {
+	renderTemplateWithStatus(c, http.StatusOK, templateName, data)
+}
+
+// renderTemplateWithStatus is the same as renderTemplate but allows a non-200 status code
+// (e.g. 429 when login is temporarily locked)
+func renderTemplateWithStatus(c *gin.Context, status int, templateName string, data gin.H) {
 	baseData := gin.H{
 		"IsLoggedIn":      false, // Default to false
 		"User":            nil,
@@ -40,6 +52,11 @@ func renderTemplate(c *gin.Context, templateName string, data gin.H) {
 		"PasswordSuccess": nil, // Ensure PasswordSuccess is always available
 	}
 
+	// CSRF token (set by CSRFProtection) for hidden fields in every POST form
+	csrfToken := csrfTokenFromContext(c)
+	baseData["CSRFToken"] = csrfToken
+	baseData["CSRFField"] = csrfField(csrfToken)
+
 	// Check login status from context (set by AuthRequired or SetLoginStatus)
 	if loggedInValue, exists := c.Get("is_logged_in"); exists {
 		if isLoggedInBool, ok := loggedInValue.(bool); ok {
@@ -60,7 +77,7 @@ func renderTemplate(c *gin.Context, templateName string, data gin.H) {
 		baseData[key] = value
 	}
 
-	c.HTML(http.StatusOK, templateName, baseData)
+	c.HTML(status, templateName, baseData)
 }
 
 // Moved from base_controller.go
@@ -78,67 +95,116 @@ func getUserFromContext(c *gin.Context) (models.User, bool) {
 	return userModel, true
 }
 
-// Middleware to check if user is authenticated
-func AuthRequired() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		cookie, err := c.Cookie("user_id")
-		if err != nil || cookie == "" {
-			c.Set("is_logged_in", false)
-			c.Redirect(http.StatusFound, "/login")
-			c.Abort()
-			return
+// sessionCookie - cookie с токеном сессии входа (см. services.SessionService)
+const sessionCookie = "session"
+
+// legacyUserIDCookie - cookie с ID пользователя без подписи из прежних версий. Она больше
+// не дает входа и удаляется, чтобы не путать пользователей с устаревшими cookie.
+const legacyUserIDCookie = "user_id"
+
+// currentSessionUser находит пользователя по cookie сессии. Недействительная cookie удаляется.
+func currentSessionUser(c *gin.Context, sessions *services.SessionService) (*models.User, bool) {
+	if _, err := c.Cookie(legacyUserIDCookie); err == nil {
+		c.SetCookie(legacyUserIDCookie, "", -1, "/", "", false, true)
+	}
+
+	token, err := c.Cookie(sessionCookie)
+	if err != nil || token == "" {
+		return nil, false
+	}
+	user, err := sessions.Authenticate(token)
+	if err != nil {
+		if !errors.Is(err, services.ErrInvalidSession) {
+			requestLogger(c).Error("Ошибка проверки сессии", logging.Err(err))
+		}
+		c.SetCookie(sessionCookie, "", -1, "/", "", false, true)
+		return nil, false
+	}
+	return user, true
+}
+
+// startSession начинает сессию пользователя и записывает ее токен в cookie
+func startSession(c *gin.Context, sessions *services.SessionService, user models.User) error {
+	token, err := sessions.Create(user.ID)
+	if err != nil {
+		return err
+	}
+	c.SetCookie(sessionCookie, token, int(services.SessionTTL.Seconds()), "/", "", false, true)
+	return nil
+}
+
+// endSession завершает текущую сессию и удаляет cookie
+func endSession(c *gin.Context, sessions *services.SessionService) {
+	if token, err := c.Cookie(sessionCookie); err == nil {
+		if err := sessions.Revoke(token); err != nil {
+			requestLogger(c).Error("Ошибка завершения сессии", logging.Err(err))
 		}
+	}
+	c.SetCookie(sessionCookie, "", -1, "/", "", false, true)
+}
 
-		userID, err := strconv.ParseUint(cookie, 10, 64)
-		if err != nil {
-			// Invalid cookie value
+// AuthRequired пускает только пользователей с действующей сессией. Пользователь, его роль
+// и блокировка загружаются из базы при каждом запросе, поэтому смена роли или блокировка
+// действуют сразу.
+func AuthRequired(sessions *services.SessionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentSessionUser(c, sessions)
+		if !ok {
 			c.Set("is_logged_in", false)
-			c.SetCookie("user_id", "", -1, "/", "", false, true) // Clear invalid cookie
 			c.Redirect(http.StatusFound, "/login")
 			c.Abort()
 			return
 		}
 
-		var user models.User
-		result := database.DB.First(&user, uint(userID))
-		if result.Error != nil {
-			// User not found in DB
+		// Заблокированный пользователь теряет сессию
+		if user.Banned() {
 			c.Set("is_logged_in", false)
-			c.SetCookie("user_id", "", -1, "/", "", false, true) // Clear cookie for non-existent user
-			c.Redirect(http.StatusFound, "/login")
+			endSession(c, sessions)
+			renderTemplateWithStatus(c, http.StatusForbidden, "error.html", gin.H{"Error": bannedMessage(*user)})
 			c.Abort()
 			return
 		}
 
 		// Set user info and login status in context
 		c.Set("is_logged_in", true)
-		c.Set("user", user)
+		setCurrentUser(c, *user)
 
 		c.Next()
 	}
 }
 
-// Middleware to set login status for public pages
-func SetLoginStatus() gin.HandlerFunc {
+// RequirePermission пускает только пользователей, чья роль дает право perm
+// (см. models.Role.Can). Ставится после AuthRequired.
+func RequirePermission(perm models.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		cookie, err := c.Cookie("user_id")
-		isLoggedIn := false
-		var currentUser models.User // Define user variable here
-		userExists := false
-
-		if err == nil && cookie != "" {
-			userID, err := strconv.ParseUint(cookie, 10, 64)
-			if err == nil {
-				// We check if the user actually exists.
-				if database.DB.First(&currentUser, uint(userID)).Error == nil {
-					isLoggedIn = true
-					userExists = true
-				}
-			}
+		user, ok := getUserFromContext(c)
+		if !ok || !user.Role.Can(perm) {
+			requestLogger(c).Warn("Нет прав на действие", slog.String("permission", string(perm)))
+			renderTemplateWithStatus(c, http.StatusForbidden, "error.html", gin.H{"Error": "Недостаточно прав для этого раздела"})
+			c.Abort()
+			return
 		}
+		c.Next()
+	}
+}
+
+// bannedMessage - сообщение для заблокированного пользователя с причиной блокировки
+func bannedMessage(user models.User) string {
+	if user.BanReason == "" {
+		return "Аккаунт заблокирован"
+	}
+	return "Аккаунт заблокирован: " + user.BanReason
+}
+
+// Middleware to set login status for public pages
+func SetLoginStatus(sessions *services.SessionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Заблокированный пользователь на публичных страницах считается гостем
+		user, isLoggedIn := currentSessionUser(c, sessions)
+		isLoggedIn = isLoggedIn && !user.Banned()
 		c.Set("is_logged_in", isLoggedIn)
-		if userExists {
-			c.Set("user", currentUser) // Set user data in context if they exist
+		if isLoggedIn {
+			setCurrentUser(c, *user) // Set user data in context if they exist
 		}
 		c.Next()
 	}
@@ -147,15 +213,43 @@ func SetLoginStatus() gin.HandlerFunc {
 type AuthController struct {
 	oauthService      *services.OAuthService
 	validationService *services.ValidationService
+	tokenService      *services.TokenService
+	auditService      *services.AuditService
+	balanceService    *services.BalanceService
+	downloadService   *services.DownloadService
+	rateLimiter       *services.RateLimitService
+	sessions          *services.SessionService
+	users             repository.UserRepository
+	products          repository.ProductRepository
+	orders            repository.OrderRepository
 }
 
-func NewAuthController() *AuthController {
+func NewAuthController(repos repository.Repositories, rateLimiter *services.RateLimitService, oauth config.OAuthConfig) *AuthController {
 	return &AuthController{
-		oauthService:      services.NewOAuthService(),
+		oauthService:      services.NewOAuthService(repos.Users, repos.Identities, oauth),
 		validationService: services.NewValidationService(),
+		tokenService:      services.NewTokenService(repos.Tokens, repos.Users),
+		auditService:      services.NewAuditService(repos.Audit),
+		balanceService:    services.NewBalanceService(repos.Users),
+		downloadService:   services.NewDownloadService(repos.Downloads, repos.Audit),
+		rateLimiter:       rateLimiter,
+		sessions:          services.NewSessionService(repos.Sessions, repos.Users),
+		users:             repos.Users,
+		products:          repos.Products,
+		orders:            repos.Orders,
 	}
 }
 
+// Sessions возвращает сервис сессий входа (для AuthRequired и SetLoginStatus)
+func (ac *AuthController) Sessions() *services.SessionService {
+	return ac.sessions
+}
+
+// OAuth возвращает сервис входа через внешние аккаунты (для очистки истекших запросов на привязку)
+func (ac *AuthController) OAuth() *services.OAuthService {
+	return ac.oauthService
+}
+
 // ShowHome renders the index page
 func (ac *AuthController) ShowHome(c *gin.Context) {
 	renderTemplate(c, "index.html", gin.H{})
@@ -211,8 +305,7 @@ func (ac *AuthController) Register(c *gin.Context) {
 	}
 
 	// Add validation (e.g., check if email exists)
-	var existingUser models.User
-	if database.DB.Where("email = ?", email).First(&existingUser).Error == nil {
+	if _, err := ac.users.FindByEmail(email); err == nil {
 		// User already exists
 		renderTemplate(c, "register.html", gin.H{
 			"Error":    "Пользователь с таким email уже существует",
@@ -222,7 +315,7 @@ func (ac *AuthController) Register(c *gin.Context) {
 	}
 
 	// Проверка, не занято ли уже имя пользователя
-	if database.DB.Where("username = ?", username).First(&existingUser).Error == nil {
+	if _, err := ac.users.FindByUsername(username); err == nil {
 		renderTemplate(c, "register.html", gin.H{
 			"Error": "Это имя пользователя уже занято",
 			"Email": email,
@@ -239,8 +332,7 @@ func (ac *AuthController) Register(c *gin.Context) {
 		CreatedAt: time.Now(),
 	}
 
-	result := database.DB.Create(&user)
-	if result.Error != nil {
+	if err := ac.users.Create(&user); err != nil {
 		// Use renderTemplate to show error on the same page
 		renderTemplate(c, "register.html", gin.H{
 			"Error":    "Ошибка регистрации. Попробуйте снова.",
@@ -249,6 +341,13 @@ func (ac *AuthController) Register(c *gin.Context) {
 		})
 		return
 	}
+	metrics.Registrations.WithLabelValues("password").Inc()
+	recordAuditEvent(c, ac.auditService, auditActorFor(c, user), services.AuditEntry{
+		Action:     services.AuditAuthRegister,
+		TargetType: services.AuditTargetUser,
+		TargetID:   user.ID,
+		Details:    map[string]interface{}{"method": "password"},
+	})
 
 	c.Redirect(http.StatusFound, "/login") // Use StatusFound for redirects
 }
@@ -266,7 +365,7 @@ func (ac *AuthController) ShowLogin(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/profile")
 		return
 	}
-	renderTemplate(c, "login.html", gin.H{})
+	ac.renderLogin(c, http.StatusOK, gin.H{})
 }
 
 func (ac *AuthController) Login(c *gin.Context) {
@@ -275,39 +374,91 @@ func (ac *AuthController) Login(c *gin.Context) {
 
 	// Валидация email с использованием ValidationService
 	if valid, errMsg := ac.validationService.ValidateEmail(email); !valid {
-		renderTemplate(c, "login.html", gin.H{
+		ac.renderLogin(c, http.StatusOK, gin.H{
 			"Error": errMsg,
 		})
 		return
 	}
 
-	var user models.User
-	result := database.DB.Where("email = ?", email).First(&user)
-	if result.Error != nil {
-		log.Printf("%s: %v", c.Request.URL.Path, result.Error)
-		// Use renderTemplate to show error
-		renderTemplate(c, "login.html", gin.H{
-			"Error": "Неверные учетные данные",
-		})
+	// Проверяем, не заблокирован ли вход для этого аккаунта после серии неудачных попыток
+	if lockedFor := ac.rateLimiter.LoginLockedFor(c.Request.Context(), email); lockedFor > 0 {
+		recordLoginFailure(c, ac.auditService, "password", email, 0, loginFailureLocked)
+		ac.renderLoginLocked(c, lockedFor)
 		return
 	}
 
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	user, err := ac.users.FindByEmail(email)
 	if err != nil {
-		// Use renderTemplate to show error
-		renderTemplate(c, "login.html", gin.H{
-			"Error": "Неверные учетные данные",
-		})
+		// Неудача для несуществующего email тоже учитывается, чтобы не раскрывать наличие аккаунта
+		ac.handleLoginFailure(c, email, 0)
 		return
 	}
 
-	// Use http.SameSiteLaxMode for broader compatibility
-	c.SetCookie("user_id", fmt.Sprintf("%d", user.ID), 3600*24*7, "/", "", false, true) // Longer cookie duration (1 week)
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if err != nil {
+		ac.handleLoginFailure(c, email, user.ID)
+		return
+	}
+
+	ac.rateLimiter.ResetLoginFailures(c.Request.Context(), email)
+	setCurrentUser(c, *user)
+	// О блокировке сообщаем только после проверки пароля, чтобы не раскрывать ее посторонним
+	if user.Banned() {
+		requestLogger(c).Warn("Попытка входа в заблокированный аккаунт")
+		recordLoginFailure(c, ac.auditService, "password", email, user.ID, loginFailureBanned)
+		ac.renderLogin(c, http.StatusForbidden, gin.H{"Error": bannedMessage(*user)})
+		return
+	}
+	requestLogger(c).Info("Вход выполнен")
+	metrics.Logins.WithLabelValues("password").Inc()
+	if err := startSession(c, ac.sessions, *user); err != nil {
+		requestLogger(c).Error("Ошибка создания сессии", logging.Err(err))
+		ac.renderLogin(c, http.StatusInternalServerError, gin.H{"Error": "Не удалось выполнить вход. Попробуйте снова."})
+		return
+	}
+	recordLogin(c, ac.auditService, *user, "password")
 	c.Redirect(http.StatusFound, "/profile")
 }
 
+// renderLogin renders the login page together with the configured OAuth providers
+func (ac *AuthController) renderLogin(c *gin.Context, status int, data gin.H) {
+	data["OAuthProviders"] = ac.oauthService.Providers()
+	renderTemplateWithStatus(c, status, "login.html", data)
+}
+
+// handleLoginFailure учитывает неудачную попытку входа и показывает ошибку (или сообщение о блокировке).
+// userID - аккаунт с этим email, если он существует.
+func (ac *AuthController) handleLoginFailure(c *gin.Context, email string, userID uint) {
+	requestLogger(c).Warn("Неудачная попытка входа", logging.Email(email))
+	metrics.LoginFailures.WithLabelValues("password").Inc()
+	recordLoginFailure(c, ac.auditService, "password", email, userID, loginFailureCredentials)
+	if lockedFor := ac.rateLimiter.RegisterLoginFailure(c.Request.Context(), email); lockedFor > 0 {
+		ac.renderLoginLocked(c, lockedFor)
+		return
+	}
+	ac.renderLogin(c, http.StatusOK, gin.H{
+		"Error": "Неверные учетные данные",
+	})
+}
+
+// renderLoginLocked показывает форму входа с кодом 429 и заголовком Retry-After
+func (ac *AuthController) renderLoginLocked(c *gin.Context, lockedFor time.Duration) {
+	seconds := retryAfterSeconds(lockedFor)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	ac.renderLogin(c, http.StatusTooManyRequests, gin.H{
+		"Error": fmt.Sprintf("Слишком много неудачных попыток входа. Повторите через %d сек.", seconds),
+	})
+}
+
 func (ac *AuthController) Logout(c *gin.Context) {
-	c.SetCookie("user_id", "", -1, "/", "", false, true) // Clear cookie
+	if user, loggedIn := getUserFromContext(c); loggedIn {
+		recordAuditEvent(c, ac.auditService, auditActor(c), services.AuditEntry{
+			Action:     services.AuditAuthLogout,
+			TargetType: services.AuditTargetUser,
+			TargetID:   user.ID,
+		})
+	}
+	endSession(c, ac.sessions)
 	c.Redirect(http.StatusFound, "/")
 }
 
@@ -320,23 +471,75 @@ func (ac *AuthController) ShowProfile(c *gin.Context) {
 	}
 
 	// Загружаем все товары, созданные пользователем
-	var products []models.Product
-	database.DB.Where("user_id = ?", user.ID).Find(&products)
+	products, err := ac.products.ListBySeller(user.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки товаров пользователя", logging.Err(err))
+	}
+
+	// Статистика скачиваний товаров покупателями
+	productIDs := make([]uint, 0, len(products))
+	for _, product := range products {
+		productIDs = append(productIDs, product.ID)
+	}
+	downloadStats, err := ac.downloadService.Stats(productIDs)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки статистики скачиваний", logging.Err(err))
+	}
 
 	// Загружаем все заказы пользователя с присоединёнными товарами
-	var orders []models.Order
-	database.DB.Preload("Items").Preload("Items.Product").Where("user_id = ?", user.ID).Find(&orders)
+	orders, err := ac.orders.ListByUser(user.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки заказов пользователя", logging.Err(err))
+	}
+
+	// Привязанные внешние аккаунты и провайдеры, которые еще можно привязать
+	identities, err := ac.oauthService.Identities(user.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки привязанных аккаунтов", logging.Err(err))
+	}
+	linked := make(map[string]bool)
+	for _, identity := range identities {
+		linked[identity.Provider] = true
+	}
+	var linkableProviders []services.OAuthProvider
+	for _, provider := range ac.oauthService.Providers() {
+		if !linked[provider.Name()] {
+			linkableProviders = append(linkableProviders, provider)
+		}
+	}
+
+	// Токены доступа к API
+	tokens, err := ac.tokenService.ListTokens(user.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки токенов пользователя", logging.Err(err))
+	}
 
 	// Проверяем наличие сообщения об успешном заработке денег
 	earnSuccess, _ := c.Get("earn_success")
+	identityError, _ := c.Get("identity_error")
+	newToken, _ := c.Get("new_token")
+	tokenError, _ := c.Get("token_error")
+	storefrontError, _ := c.Get("storefront_error")
 
 	renderTemplate(c, "profile.html", gin.H{
-		"Username":    user.Username,
-		"Email":       user.Email,
-		"Balance":     user.Balance,
-		"Products":    products,
-		"Orders":      orders,
-		"EarnSuccess": earnSuccess,
+		"Username":          user.Username,
+		"Bio":               user.Bio,
+		"SocialLinks":       user.SocialLinks,
+		"AvatarURL":         avatarURL(user),
+		"StorefrontPath":    services.StorefrontPath(user.Username),
+		"StorefrontError":   storefrontError,
+		"Email":             user.Email,
+		"Balance":           user.Balance,
+		"Products":          products,
+		"DownloadStats":     downloadStats,
+		"Orders":            orders,
+		"EarnSuccess":       earnSuccess,
+		"Identities":        identities,
+		"LinkableProviders": linkableProviders,
+		"IdentityError":     identityError,
+		"Tokens":            tokens,
+		"NewToken":          newToken,
+		"TokenError":        tokenError,
 	})
 }
 
@@ -355,6 +558,11 @@ func (ac *AuthController) ChangePassword(c *gin.Context) {
 
 	// 1. Verify current password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)); err != nil {
+		recordAuditEvent(c, ac.auditService, auditActor(c), services.AuditEntry{
+			Action:     services.AuditAuthPasswordFailed,
+			TargetType: services.AuditTargetUser,
+			TargetID:   user.ID,
+		})
 		renderTemplate(c, "profile.html", gin.H{
 			"PasswordError": "Текущий пароль неверен",
 		})
@@ -381,82 +589,493 @@ func (ac *AuthController) ChangePassword(c *gin.Context) {
 	}
 
 	// 5. Update password in database
-	result := database.DB.Model(&user).Update("password", string(newHash))
-	if result.Error != nil {
+	if err := ac.users.UpdatePassword(user.ID, string(newHash)); err != nil {
 		renderTemplate(c, "profile.html", gin.H{
 			"PasswordError": "Не удалось обновить пароль в базе данных",
 		})
 		return
 	}
 
-	// 6. Redirect or show success message
+	recordAuditEvent(c, ac.auditService, auditActor(c), services.AuditEntry{
+		Action:     services.AuditAuthPasswordChange,
+		TargetType: services.AuditTargetUser,
+		TargetID:   user.ID,
+	})
+
+	// 6. Пароль часто меняют после утечки, поэтому завершаем все сессии аккаунта
+	// и начинаем новую только для текущего браузера
+	if err := ac.sessions.RevokeAll(user.ID); err != nil {
+		requestLogger(c).Error("Ошибка завершения сессий после смены пароля", logging.Err(err))
+	}
+	if err := startSession(c, ac.sessions, user); err != nil {
+		requestLogger(c).Error("Ошибка создания сессии", logging.Err(err))
+		c.SetCookie(sessionCookie, "", -1, "/", "", false, true)
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	// 7. Redirect or show success message
 	renderTemplate(c, "profile.html", gin.H{
 		"PasswordSuccess": "Пароль успешно изменен",
 	})
 }
 
-// InitiateGithubLogin redirects to GitHub for authentication
-func (ac *AuthController) InitiateGithubLogin(c *gin.Context) {
+// InitiateOAuthLogin redirects to the OAuth provider from the :provider route parameter
+func (ac *AuthController) InitiateOAuthLogin(c *gin.Context) {
+	provider, ok := ac.oauthService.Provider(c.Param("provider"))
+	if !ok {
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Неизвестный способ входа"})
+		return
+	}
+
 	state, err := ac.oauthService.GenerateState()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate state"})
+		requestLogger(c).Error("Ошибка генерации OAuth state", logging.Err(err))
+		ac.renderLogin(c, http.StatusInternalServerError, gin.H{"Error": "Не удалось начать вход. Попробуйте снова."})
 		return
 	}
+	verifier := oauth2.GenerateVerifier()
 
-	// Сохраняем state в cookie для проверки в callback
-	c.SetCookie("oauth_state", state, 600, "/", "", false, true) // 10 минут
+	// Сохраняем state и PKCE verifier в cookie для проверки в callback
+	c.SetCookie("oauth_state", state, 600, "/", "", false, true)       // 10 минут
+	c.SetCookie("oauth_verifier", verifier, 600, "/", "", false, true) // 10 минут
 
-	// Перенаправляем на GitHub для авторизации
-	url := ac.oauthService.GetGithubAuthURL(state)
-	c.Redirect(http.StatusFound, url)
+	// link=1 из профиля означает привязку провайдера к текущему аккаунту, а не вход
+	if c.Query("link") == "1" {
+		if _, loggedIn := getUserFromContext(c); loggedIn {
+			c.SetCookie("oauth_link", "1", 600, "/", "", false, true)
+		}
+	}
+	// confirm_link=1 со страницы подтверждения: вход через уже привязанный аккаунт подтверждает привязку
+	if c.Query("confirm_link") == "1" {
+		if pending, _ := c.Cookie("oauth_pending"); pending != "" {
+			c.SetCookie("oauth_link_confirm", "1", 600, "/", "", false, true)
+		}
+	}
+
+	// Перенаправляем к провайдеру для авторизации
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(state, verifier))
 }
 
-// HandleGithubCallback processes the GitHub OAuth callback
-func (ac *AuthController) HandleGithubCallback(c *gin.Context) {
+// oauthSessionExpiredMessage is shown when the callback does not match the state and PKCE
+// cookies set by InitiateOAuthLogin: the login took too long, was replayed or was forged
+const oauthSessionExpiredMessage = "Сеанс входа устарел. Попробуйте войти снова."
+
+// HandleOAuthCallback processes the OAuth callback for the :provider route parameter
+func (ac *AuthController) HandleOAuthCallback(c *gin.Context) {
+	providerName := c.Param("provider")
+	provider, ok := ac.oauthService.Provider(providerName)
+	if !ok {
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Неизвестный способ входа"})
+		return
+	}
+
 	// Получаем и проверяем state
 	expectedState, err := c.Cookie("oauth_state")
-	if err != nil || c.Query("state") != expectedState {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid state parameter"})
+	if err != nil || expectedState == "" || c.Query("state") != expectedState {
+		requestLogger(c).Warn("OAuth callback с неверным state", slog.String("provider", providerName))
+		ac.renderLogin(c, http.StatusBadRequest, gin.H{"Error": oauthSessionExpiredMessage})
+		return
+	}
+	verifier, err := c.Cookie("oauth_verifier")
+	if err != nil || verifier == "" {
+		requestLogger(c).Warn("OAuth callback без PKCE verifier", slog.String("provider", providerName))
+		ac.renderLogin(c, http.StatusBadRequest, gin.H{"Error": oauthSessionExpiredMessage})
 		return
 	}
 
-	// Очищаем cookie state
+	// Очищаем cookie state и verifier
 	c.SetCookie("oauth_state", "", -1, "/", "", false, true)
+	c.SetCookie("oauth_verifier", "", -1, "/", "", false, true)
 
 	// Получаем code из параметров запроса
 	code := c.Query("code")
 	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Code not provided"})
+		// Провайдер возвращает error вместо code, если пользователь отказался от входа
+		message := "Провайдер не вернул код авторизации. Попробуйте снова."
+		if c.Query("error") != "" {
+			message = fmt.Sprintf("Вход через %s отменен.", provider.DisplayName())
+		}
+		ac.renderLogin(c, http.StatusBadRequest, gin.H{"Error": message})
+		return
+	}
+
+	// Подтверждение привязки входом через внешний аккаунт, уже привязанный к тому же пользователю
+	if confirmCookie, _ := c.Cookie("oauth_link_confirm"); confirmCookie == "1" {
+		c.SetCookie("oauth_link_confirm", "", -1, "/", "", false, true)
+		ac.confirmLinkWithProvider(c, providerName, code, verifier)
 		return
 	}
 
+	// Привязка к текущему аккаунту возможна, только если ее явно запросили из профиля
+	var currentUser *models.User
+	if linkCookie, _ := c.Cookie("oauth_link"); linkCookie == "1" {
+		if user, loggedIn := getUserFromContext(c); loggedIn {
+			currentUser = &user
+		}
+		c.SetCookie("oauth_link", "", -1, "/", "", false, true)
+	}
+
 	// Обрабатываем код авторизации через сервис
-	user, err := ac.oauthService.HandleGithubCallback(code)
+	result, err := ac.oauthService.HandleCallback(c.Request.Context(), providerName, code, verifier, currentUser)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("GitHub login failed: %v", err)})
+		requestLogger(c).Warn("Ошибка входа через OAuth", slog.String("provider", providerName), logging.Err(err))
+		switch {
+		case errors.Is(err, services.ErrIdentityOwnedByOther):
+			renderTemplateWithStatus(c, http.StatusConflict, "error.html", gin.H{"Error": "Этот внешний аккаунт уже привязан к другому пользователю"})
+		case errors.Is(err, services.ErrEmailNotVerified):
+			renderTemplateWithStatus(c, http.StatusForbidden, "error.html", gin.H{"Error": "Провайдер не подтвердил ваш email. Подтвердите email у провайдера и попробуйте снова"})
+		default:
+			ac.renderLogin(c, http.StatusInternalServerError, gin.H{
+				"Error": fmt.Sprintf("Не удалось войти через %s. Попробуйте снова.", provider.DisplayName()),
+			})
+		}
 		return
 	}
 
-	// Устанавливаем cookie с ID пользователя
-	c.SetCookie("user_id", fmt.Sprintf("%d", user.ID), 3600*24*7, "/", "", false, true)
+	// Найден аккаунт с тем же email: требуем подтверждения паролем вместо автоматического входа
+	if result.PendingToken != "" {
+		c.SetCookie("oauth_pending", result.PendingToken, 600, "/", "", false, true)
+		c.Redirect(http.StatusFound, "/auth-link/confirm")
+		return
+	}
+
+	if result.User.Banned() {
+		requestLogger(c).Warn("Попытка входа в заблокированный аккаунт через OAuth", logging.UserID(result.User.ID))
+		recordLoginFailure(c, ac.auditService, "oauth:"+providerName, result.User.Email, result.User.ID, loginFailureBanned)
+		renderTemplateWithStatus(c, http.StatusForbidden, "error.html", gin.H{"Error": bannedMessage(*result.User)})
+		return
+	}
+
+	if result.Linked {
+		ac.recordIdentityLink(c, *result.User, providerName)
+	}
+	if result.Created {
+		recordAuditEvent(c, ac.auditService, auditActorFor(c, *result.User), services.AuditEntry{
+			Action:     services.AuditAuthRegister,
+			TargetType: services.AuditTargetUser,
+			TargetID:   result.User.ID,
+			Details:    map[string]interface{}{"method": "oauth:" + providerName},
+		})
+	}
+
+	// При привязке пользователь уже вошел, cookie не меняем
+	if currentUser == nil {
+		if err := startSession(c, ac.sessions, *result.User); err != nil {
+			requestLogger(c).Error("Ошибка создания сессии", logging.Err(err))
+			renderTemplateWithStatus(c, http.StatusInternalServerError, "error.html", gin.H{"Error": "Не удалось выполнить вход. Попробуйте снова."})
+			return
+		}
+		metrics.Logins.WithLabelValues("oauth").Inc()
+		recordLogin(c, ac.auditService, *result.User, "oauth:"+providerName)
+	}
 	c.Redirect(http.StatusFound, "/profile")
 }
 
-// EarnMoney позволяет пользователю заработать деньги
-func (ac *AuthController) EarnMoney(c *gin.Context) {
+// ShowLinkConfirm asks the owner of an existing account to confirm linking a new provider
+func (ac *AuthController) ShowLinkConfirm(c *gin.Context) {
+	token, _ := c.Cookie("oauth_pending")
+	pending, ok := ac.oauthService.PendingLink(token)
+	if !ok {
+		renderPendingLinkExpired(c)
+		return
+	}
+
+	ac.renderLinkConfirm(c, http.StatusOK, *pending, gin.H{})
+}
+
+// ConfirmLink links the pending identity after the user re-enters the account password.
+// The same form cancels the request or asks for a confirmation link by email.
+func (ac *AuthController) ConfirmLink(c *gin.Context) {
+	token, _ := c.Cookie("oauth_pending")
+	pending, ok := ac.oauthService.PendingLink(token)
+	if !ok {
+		renderPendingLinkExpired(c)
+		return
+	}
+
+	switch c.PostForm("action") {
+	case "cancel":
+		if err := ac.oauthService.CancelPendingLink(token); err != nil {
+			requestLogger(c).Error("Ошибка отмены привязки аккаунта", logging.UserID(pending.UserID), logging.Err(err))
+		}
+		c.SetCookie("oauth_pending", "", -1, "/", "", false, true)
+		c.Redirect(http.StatusFound, "/login")
+		return
+	case "email":
+		ac.sendLinkEmail(c, token, *pending)
+		return
+	}
+
+	// Подтверждение паролем подчиняется тем же блокировкам, что и обычный вход
+	if lockedFor := ac.rateLimiter.LoginLockedFor(c.Request.Context(), pending.Email); lockedFor > 0 {
+		seconds := retryAfterSeconds(lockedFor)
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		ac.renderLinkConfirm(c, http.StatusTooManyRequests, *pending, gin.H{
+			"Error": fmt.Sprintf("Слишком много неудачных попыток. Повторите через %d сек.", seconds),
+		})
+		return
+	}
+
+	user, err := ac.oauthService.ConfirmPendingLink(c.Request.Context(), token, c.PostForm("password"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrLinkConfirmationWrong):
+			recordLoginFailure(c, ac.auditService, "link_confirm", pending.Email, pending.UserID, loginFailureCredentials)
+			ac.rateLimiter.RegisterLoginFailure(c.Request.Context(), pending.Email)
+			ac.renderLinkConfirm(c, http.StatusOK, *pending, gin.H{"Error": "Неверный пароль"})
+		case errors.Is(err, services.ErrPendingLinkNotFound):
+			renderPendingLinkExpired(c)
+		default:
+			requestLogger(c).Error("Ошибка подтверждения привязки аккаунта", logging.UserID(pending.UserID), logging.Err(err))
+			renderTemplateWithStatus(c, http.StatusInternalServerError, "error.html", gin.H{"Error": "Не удалось привязать аккаунт"})
+		}
+		return
+	}
+
+	ac.rateLimiter.ResetLoginFailures(c.Request.Context(), pending.Email)
+	ac.completeLinkConfirm(c, *user, pending.Provider)
+}
+
+// sendLinkEmail отправляет владельцу аккаунта ссылку, подтверждающую привязку без пароля
+func (ac *AuthController) sendLinkEmail(c *gin.Context, token string, pending models.PendingIdentityLink) {
+	err := ac.oauthService.SendPendingLinkEmail(c.Request.Context(), token)
+	switch {
+	case errors.Is(err, services.ErrLinkEmailAlreadySent):
+		ac.renderLinkConfirm(c, http.StatusOK, pending, gin.H{"Info": "Письмо со ссылкой уже отправлено на " + pending.Email})
+	case errors.Is(err, services.ErrPendingLinkNotFound):
+		renderPendingLinkExpired(c)
+	case err != nil:
+		requestLogger(c).Error("Ошибка отправки письма для привязки аккаунта", logging.UserID(pending.UserID), logging.Err(err))
+		ac.renderLinkConfirm(c, http.StatusOK, pending, gin.H{"Error": "Не удалось отправить письмо. Попробуйте позже"})
+	default:
+		pending.EmailTokenHash = new(string) // Скрывает кнопку повторной отправки
+		ac.renderLinkConfirm(c, http.StatusOK, pending, gin.H{"Info": "Мы отправили ссылку для подтверждения на " + pending.Email})
+	}
+}
+
+// confirmLinkWithProvider завершает вход через внешний аккаунт, начатый со страницы подтверждения:
+// привязка подтверждается, если этот аккаунт уже привязан к тому же пользователю
+func (ac *AuthController) confirmLinkWithProvider(c *gin.Context, providerName, code, verifier string) {
+	token, _ := c.Cookie("oauth_pending")
+	pending, user, err := ac.oauthService.ConfirmPendingLinkWithProvider(c.Request.Context(), token, providerName, code, verifier)
+	switch {
+	case errors.Is(err, services.ErrPendingLinkNotFound):
+		renderPendingLinkExpired(c)
+	case errors.Is(err, services.ErrLinkIdentityMismatch):
+		recordLoginFailure(c, ac.auditService, "link_confirm", pending.Email, pending.UserID, loginFailureCredentials)
+		ac.renderLinkConfirm(c, http.StatusForbidden, *pending, gin.H{"Error": "Этот внешний аккаунт не привязан к вашему аккаунту"})
+	case err != nil:
+		requestLogger(c).Warn("Ошибка подтверждения привязки через OAuth", slog.String("provider", providerName), logging.Err(err))
+		renderTemplateWithStatus(c, http.StatusInternalServerError, "error.html", gin.H{"Error": "Не удалось привязать аккаунт"})
+	default:
+		ac.completeLinkConfirm(c, *user, pending.Provider)
+	}
+}
+
+// ShowLinkEmailConfirm shows the confirmation from the email link. The link itself changes nothing:
+// mail scanners open links, so the identity is linked only after the form is submitted.
+func (ac *AuthController) ShowLinkEmailConfirm(c *gin.Context) {
+	token := c.Query("token")
+	pending, ok := ac.oauthService.PendingLinkByEmailToken(token)
+	if !ok {
+		renderPendingLinkExpired(c)
+		return
+	}
+
+	ac.renderLinkConfirm(c, http.StatusOK, *pending, gin.H{"EmailToken": token})
+}
+
+// ConfirmLinkByEmail links the pending identity by the token from the confirmation email
+func (ac *AuthController) ConfirmLinkByEmail(c *gin.Context) {
+	pending, user, err := ac.oauthService.ConfirmPendingLinkByEmail(c.Request.Context(), c.PostForm("token"))
+	if err != nil {
+		if errors.Is(err, services.ErrPendingLinkNotFound) {
+			renderPendingLinkExpired(c)
+			return
+		}
+		requestLogger(c).Error("Ошибка подтверждения привязки аккаунта по ссылке", logging.UserID(pending.UserID), logging.Err(err))
+		renderTemplateWithStatus(c, http.StatusInternalServerError, "error.html", gin.H{"Error": "Не удалось привязать аккаунт"})
+		return
+	}
+
+	ac.completeLinkConfirm(c, *user, pending.Provider)
+}
+
+// completeLinkConfirm записывает подтвержденную привязку provider и начинает сессию владельца аккаунта
+func (ac *AuthController) completeLinkConfirm(c *gin.Context, user models.User, provider string) {
+	c.SetCookie("oauth_pending", "", -1, "/", "", false, true)
+	ac.recordIdentityLink(c, user, provider)
+	if user.Banned() {
+		recordLoginFailure(c, ac.auditService, "oauth:"+provider, user.Email, user.ID, loginFailureBanned)
+		renderTemplateWithStatus(c, http.StatusForbidden, "error.html", gin.H{"Error": bannedMessage(user)})
+		return
+	}
+	if err := startSession(c, ac.sessions, user); err != nil {
+		requestLogger(c).Error("Ошибка создания сессии", logging.Err(err))
+		renderTemplateWithStatus(c, http.StatusInternalServerError, "error.html", gin.H{"Error": "Не удалось выполнить вход. Попробуйте снова."})
+		return
+	}
+	recordLogin(c, ac.auditService, user, "oauth:"+provider)
+	c.Redirect(http.StatusFound, "/profile")
+}
+
+// renderPendingLinkExpired сообщает, что запрос на привязку истек или уже подтвержден
+func renderPendingLinkExpired(c *gin.Context) {
+	renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Запрос на привязку аккаунта истек. Попробуйте войти снова"})
+}
+
+// recordIdentityLink записывает привязку внешнего аккаунта provider к user
+func (ac *AuthController) recordIdentityLink(c *gin.Context, user models.User, provider string) {
+	recordAuditEvent(c, ac.auditService, auditActorFor(c, user), services.AuditEntry{
+		Action:     services.AuditAuthIdentityLink,
+		TargetType: services.AuditTargetUser,
+		TargetID:   user.ID,
+		Details:    map[string]interface{}{"provider": provider},
+	})
+}
+
+// renderLinkConfirm renders the link confirmation page for a pending identity.
+// Providers already linked to the account are offered as a password-less confirmation.
+func (ac *AuthController) renderLinkConfirm(c *gin.Context, status int, pending models.PendingIdentityLink, data gin.H) {
+	providerName := pending.Provider
+	if provider, ok := ac.oauthService.Provider(pending.Provider); ok {
+		providerName = provider.DisplayName()
+	}
+
+	identities, err := ac.oauthService.Identities(pending.UserID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки привязанных аккаунтов", logging.Err(err))
+	}
+	seen := make(map[string]bool)
+	var linkedProviders []services.OAuthProvider
+	for _, identity := range identities {
+		if provider, ok := ac.oauthService.Provider(identity.Provider); ok && !seen[provider.Name()] {
+			seen[provider.Name()] = true
+			linkedProviders = append(linkedProviders, provider)
+		}
+	}
+
+	data["Email"] = pending.Email
+	data["ProviderName"] = providerName
+	data["LinkedProviders"] = linkedProviders
+	data["EmailSent"] = pending.EmailTokenHash != nil
+	renderTemplateWithStatus(c, status, "link_confirm.html", data)
+}
+
+// UnlinkIdentity removes a linked external identity from the current user's account
+func (ac *AuthController) UnlinkIdentity(c *gin.Context) {
+	user, exists := getUserFromContext(c)
+	if !exists {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	identityID, err := strconv.ParseUint(c.Param("identityID"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid identity ID"})
+		return
+	}
+
+	identity, err := ac.oauthService.UnlinkIdentity(user, uint(identityID))
+	if err != nil {
+		message := "Не удалось отвязать аккаунт"
+		if errors.Is(err, services.ErrLastLoginMethod) {
+			message = "Нельзя отвязать единственный способ входа: у аккаунта нет пароля"
+		}
+		c.Set("identity_error", message)
+		ac.ShowProfile(c)
+		return
+	}
+	recordAuditEvent(c, ac.auditService, auditActor(c), services.AuditEntry{
+		Action:     services.AuditAuthIdentityUnlink,
+		TargetType: services.AuditTargetUser,
+		TargetID:   user.ID,
+		Details:    map[string]interface{}{"provider": identity.Provider, "identity_id": identity.ID},
+	})
+
+	c.Redirect(http.StatusFound, "/profile")
+}
+
+// CreateAccessToken создает персональный токен доступа к API из формы профиля.
+// Значение токена показывается на странице профиля один раз.
+func (ac *AuthController) CreateAccessToken(c *gin.Context) {
+	user, exists := getUserFromContext(c)
+	if !exists {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+
+	name := c.PostForm("name")
+	expiresInDays := 0
+	if days := strings.TrimSpace(c.PostForm("expires_in_days")); days != "" {
+		parsed, err := strconv.Atoi(days)
+		if err != nil {
+			c.Set("token_error", "Срок действия должен быть числом дней")
+			ac.ShowProfile(c)
+			return
+		}
+		expiresInDays = parsed
+	}
+
+	ttl, errMsg := validateTokenRequest(name, expiresInDays)
+	if errMsg != "" {
+		c.Set("token_error", errMsg)
+		ac.ShowProfile(c)
+		return
+	}
+
+	plain, token, err := ac.tokenService.CreateToken(user.ID, name, ttl)
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyTokens) {
+			c.Set("token_error", "Превышено максимальное количество токенов. Отзовите неиспользуемые.")
+		} else {
+			requestLogger(c).Error("Ошибка создания токена API", logging.Err(err))
+			c.Set("token_error", "Не удалось создать токен")
+		}
+		ac.ShowProfile(c)
+		return
+	}
+
+	recordTokenCreate(c, ac.auditService, *token)
+	c.Set("new_token", plain)
+	ac.ShowProfile(c)
+}
+
+// RevokeAccessToken отзывает персональный токен доступа
+func (ac *AuthController) RevokeAccessToken(c *gin.Context) {
 	user, exists := getUserFromContext(c)
 	if !exists {
 		c.Redirect(http.StatusFound, "/login")
 		return
 	}
 
-	// Прибавляем 10 к балансу пользователя
-	user.Balance += 10.0
+	tokenID, err := strconv.ParseUint(c.Param("tokenID"), 10, 64)
+	if err == nil {
+		err = ac.tokenService.RevokeToken(user.ID, uint(tokenID))
+	}
+	if err != nil {
+		requestLogger(c).Error("Ошибка отзыва токена API", slog.String("token_id", c.Param("tokenID")), logging.Err(err))
+		c.Set("token_error", "Не удалось отозвать токен")
+		ac.ShowProfile(c)
+		return
+	}
+	recordTokenRevoke(c, ac.auditService, uint(tokenID))
+
+	c.Redirect(http.StatusFound, "/profile")
+}
+
+// EarnMoney позволяет пользователю заработать деньги
+func (ac *AuthController) EarnMoney(c *gin.Context) {
+	if _, exists := getUserFromContext(c); !exists {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
 
-	// Сохраняем обновленный баланс в базе данных
-	if err := database.DB.Save(&user).Error; err != nil {
+	// Прибавляем 10 к балансу пользователя; начисление попадает в журнал аудита
+	if _, err := ac.balanceService.Earn(c.Request.Context(), auditActor(c), 10.0); err != nil {
 		// Если произошла ошибка, выводим ее в логи и перенаправляем на страницу профиля
-		fmt.Printf("Ошибка при обновлении баланса пользователя %d: %v\n", user.ID, err)
+		requestLogger(c).Error("Ошибка обновления баланса", logging.Err(err))
 		c.Redirect(http.StatusFound, "/profile")
 		return
 	}
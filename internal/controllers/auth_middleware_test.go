@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/services"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAdminRoutesRequireSession(t *testing.T) {
+	_, repos := newTestStore()
+	admin := testUser(t, repos, "admin@example.com", models.RoleAdmin)
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+
+	router := newTestRouter(t)
+	router.GET("/admin/users", AuthRequired(sessions), RequirePermission(models.PermUsersView),
+		func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		want   int
+	}{
+		{"без cookie", nil, http.StatusFound},
+		// Прежняя cookie с ID пользователя больше не дает входа
+		{"поддельный user_id", &http.Cookie{Name: legacyUserIDCookie, Value: strconv.Itoa(int(admin.ID))}, http.StatusFound},
+		{"поддельная сессия", &http.Cookie{Name: sessionCookie, Value: strconv.Itoa(int(admin.ID))}, http.StatusFound},
+		{"покупатель", loginCookie(t, sessions, buyer), http.StatusForbidden},
+		{"администратор", loginCookie(t, sessions, admin), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cookies []*http.Cookie
+			if tt.cookie != nil {
+				cookies = append(cookies, tt.cookie)
+			}
+			w := serve(router, http.MethodGet, "/admin/users", cookies...)
+			if w.Code != tt.want {
+				t.Errorf("статус %d, ожидался %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthRequiredChecksRoleAndBanOnEveryRequest(t *testing.T) {
+	_, repos := newTestStore()
+	moderator := testUser(t, repos, "mod@example.com", models.RoleModerator)
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+	cookie := loginCookie(t, sessions, moderator)
+
+	router := newTestRouter(t)
+	router.GET("/admin", AuthRequired(sessions), RequirePermission(models.PermAdminPanel),
+		func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	if w := serve(router, http.MethodGet, "/admin", cookie); w.Code != http.StatusNoContent {
+		t.Fatalf("модератор: статус %d", w.Code)
+	}
+
+	// Роль снята - та же сессия больше не открывает раздел
+	moderator.Role = models.RoleUser
+	repos.Users.Save(&moderator)
+	if w := serve(router, http.MethodGet, "/admin", cookie); w.Code != http.StatusForbidden {
+		t.Fatalf("после снятия роли: статус %d, ожидался 403", w.Code)
+	}
+
+	// Блокировка завершает сессию
+	moderator.Role = models.RoleModerator
+	moderator.BannedAt = &moderator.CreatedAt
+	repos.Users.Save(&moderator)
+	if w := serve(router, http.MethodGet, "/admin", cookie); w.Code != http.StatusForbidden {
+		t.Fatalf("после блокировки: статус %d, ожидался 403", w.Code)
+	}
+	if _, err := sessions.Authenticate(cookie.Value); err == nil {
+		t.Fatal("сессия заблокированного пользователя не удалена")
+	}
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	_, repos := newTestStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("old secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Email: "buyer@example.com", Username: "buyer", Password: string(hash), Role: models.RoleUser}
+	if err := repos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAuthController(repos, services.NewRateLimitService(services.NewMemoryRateLimitStore(), services.DefaultLockoutPolicy), config.OAuthConfig{})
+	sessions := auth.Sessions()
+
+	router := newTestRouter(t)
+	router.POST("/profile/change-password", AuthRequired(sessions), auth.ChangePassword)
+	router.GET("/profile", AuthRequired(sessions), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	// Сессия на другом устройстве, например у того, кто узнал старый пароль
+	other := loginCookie(t, sessions, user)
+	current := loginCookie(t, sessions, user)
+
+	form := url.Values{"current_password": {"old secret"}, "new_password": {"new secret"}, "confirm_new_password": {"new secret"}}
+	w := serveForm(router, "/profile/change-password", form, current)
+	if w.Code != http.StatusOK {
+		t.Fatalf("смена пароля: статус %d", w.Code)
+	}
+	var fresh *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookie && cookie.MaxAge > 0 {
+			fresh = cookie
+		}
+	}
+	if fresh == nil || fresh.Value == current.Value {
+		t.Fatalf("текущему браузеру не выдана новая сессия: %v", w.Result().Cookies())
+	}
+
+	for name, cookie := range map[string]*http.Cookie{"другое устройство": other, "прежняя сессия": current} {
+		if w := serve(router, http.MethodGet, "/profile", cookie); w.Code != http.StatusFound || w.Header().Get("Location") != "/login" {
+			t.Errorf("%s после смены пароля: статус %d, ожидался переход на /login", name, w.Code)
+		}
+	}
+	if w := serve(router, http.MethodGet, "/profile", fresh); w.Code != http.StatusNoContent {
+		t.Errorf("новая сессия: статус %d", w.Code)
+	}
+}
@@ -0,0 +1,447 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"digital-marketplace/internal/services/oidcstub"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newOAuthTest запускает заглушку OIDC от имени stubUser и регистрирует маршруты входа через нее, как в cmd/main.go
+func newOAuthTest(t *testing.T, repos repository.Repositories, stubUser oidcstub.User) (*oidcstub.Server, *gin.Engine, *services.SessionService) {
+	t.Helper()
+	stub := oidcstub.NewServer("marketplace", "secret", stubUser)
+	t.Cleanup(stub.Close)
+
+	cfg := config.OAuthConfig{
+		RedirectBase: "http://marketplace.test",
+		OIDC: config.OIDCConfig{
+			OAuthClientConfig: config.OAuthClientConfig{ClientID: stub.ClientID, ClientSecret: config.Secret(stub.ClientSecret)},
+			Issuer:            stub.Issuer(),
+			DisplayName:       "Тестовый провайдер",
+		},
+	}
+	limiter := services.NewRateLimitService(services.NewMemoryRateLimitStore(), services.DefaultLockoutPolicy)
+	auth := NewAuthController(repos, limiter, cfg)
+
+	router := newTestRouter(t)
+	public := router.Group("/", SetLoginStatus(auth.Sessions()))
+	public.GET("/auth/:provider", auth.InitiateOAuthLogin)
+	public.GET("/auth/:provider/callback", auth.HandleOAuthCallback)
+	public.GET("/auth-link/confirm", auth.ShowLinkConfirm)
+	public.POST("/auth-link/confirm", auth.ConfirmLink)
+	public.GET("/auth-link/email", auth.ShowLinkEmailConfirm)
+	public.POST("/auth-link/email", auth.ConfirmLinkByEmail)
+	return stub, router, auth.Sessions()
+}
+
+// oauthBrowser хранит cookie приложения между запросами и проходит вход через заглушку OIDC
+type oauthBrowser struct {
+	t       *testing.T
+	router  http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func newOAuthBrowser(t *testing.T, router http.Handler, cookies ...*http.Cookie) *oauthBrowser {
+	b := &oauthBrowser{t: t, router: router, cookies: make(map[string]*http.Cookie)}
+	for _, cookie := range cookies {
+		b.cookies[cookie.Name] = cookie
+	}
+	return b
+}
+
+func (b *oauthBrowser) do(method, target string, form url.Values) *httptest.ResponseRecorder {
+	cookies := make([]*http.Cookie, 0, len(b.cookies))
+	for _, cookie := range b.cookies {
+		cookies = append(cookies, cookie)
+	}
+	var w *httptest.ResponseRecorder
+	if method == http.MethodPost {
+		w = serveForm(b.router, target, form, cookies...)
+	} else {
+		w = serve(b.router, method, target, cookies...)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(b.cookies, cookie.Name)
+		} else {
+			b.cookies[cookie.Name] = cookie
+		}
+	}
+	return w
+}
+
+// start открывает /auth/oidc и возвращает путь callback с кодом, который выдала заглушка
+func (b *oauthBrowser) start(query string) string {
+	b.t.Helper()
+	w := b.do(http.MethodGet, "/auth/oidc"+query, nil)
+	if w.Code != http.StatusFound {
+		b.t.Fatalf("начало входа: статус %d", w.Code)
+	}
+
+	// Заглушка сразу перенаправляет обратно; по перенаправлению не переходим, чтобы забрать код
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		b.t.Fatalf("авторизация у провайдера: статус %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	if callback.Host != "marketplace.test" || callback.Path != "/auth/oidc/callback" {
+		b.t.Fatalf("провайдер вернул на %s", callback)
+	}
+	return callback.RequestURI()
+}
+
+// login проходит вход целиком и возвращает ответ на callback
+func (b *oauthBrowser) login(query string) *httptest.ResponseRecorder {
+	b.t.Helper()
+	return b.do(http.MethodGet, b.start(query), nil)
+}
+
+// sessionUser возвращает пользователя сессии браузера
+func (b *oauthBrowser) sessionUser(sessions *services.SessionService) *models.User {
+	b.t.Helper()
+	cookie, ok := b.cookies[sessionCookie]
+	if !ok {
+		b.t.Fatal("сессия не начата")
+	}
+	user, err := sessions.Authenticate(cookie.Value)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	return user
+}
+
+func auditActions(events []models.AuditEvent) string {
+	actions := make([]string, len(events))
+	for i, event := range events {
+		actions[i] = event.Action
+	}
+	return strings.Join(actions, ",")
+}
+
+func TestOAuthLoginCreatesAndReusesAccount(t *testing.T) {
+	store, repos := newTestStore()
+	_, router, sessions := newOAuthTest(t, repos, oidcstub.User{
+		Subject: "sub-1", Email: "new@example.com", EmailVerified: true, PreferredUsername: "newbie",
+	})
+
+	first := newOAuthBrowser(t, router)
+	w := first.login("")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/profile" {
+		t.Fatalf("первый вход: статус %d, переход на %q", w.Code, w.Header().Get("Location"))
+	}
+	user := first.sessionUser(sessions)
+	if user.Email != "new@example.com" || user.Username != "newbie" || !user.GeneratedPassword {
+		t.Errorf("созданный пользователь: %+v", user)
+	}
+	if _, ok := first.cookies["oauth_state"]; ok {
+		t.Error("cookie state не удалена после входа")
+	}
+
+	// Повторный вход тем же внешним аккаунтом попадает в тот же профиль
+	second := newOAuthBrowser(t, router)
+	if w := second.login(""); w.Code != http.StatusFound {
+		t.Fatalf("повторный вход: статус %d", w.Code)
+	}
+	if again := second.sessionUser(sessions); again.ID != user.ID {
+		t.Errorf("повторный вход в пользователя %d, ожидался %d", again.ID, user.ID)
+	}
+	identities, err := repos.Identities.ListByUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].Provider != "oidc" || identities[0].Subject != "sub-1" {
+		t.Errorf("привязки: %+v", identities)
+	}
+	if got := auditActions(store.AuditEvents()); got != "auth.register,auth.login,auth.login" {
+		t.Errorf("журнал: %s", got)
+	}
+}
+
+// assertLoginError проверяет, что ответ - страница входа с кодом status и сообщением message
+func assertLoginError(t *testing.T, w *httptest.ResponseRecorder, status int, message string) {
+	t.Helper()
+	if w.Code != status {
+		t.Errorf("статус %d, ожидался %d", w.Code, status)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Content-Type %q, ожидалась страница входа", w.Header().Get("Content-Type"))
+	}
+	if body := w.Body.String(); !strings.Contains(body, `<div class="error-message">`+message) || !strings.Contains(body, `action="/login"`) {
+		t.Errorf("на странице входа нет ошибки %q:\n%s", message, body)
+	}
+}
+
+func TestOAuthCallbackRejectsForgedRequests(t *testing.T) {
+	_, repos := newTestStore()
+	stub, router, _ := newOAuthTest(t, repos, oidcstub.User{Subject: "sub-1", Email: "new@example.com", EmailVerified: true})
+
+	if w := serve(router, http.MethodGet, "/auth/github"); w.Code != http.StatusNotFound {
+		t.Errorf("неизвестный провайдер: статус %d, ожидался 404", w.Code)
+	}
+
+	t.Run("чужой state", func(t *testing.T) {
+		b := newOAuthBrowser(t, router)
+		callback := b.start("")
+		forged := strings.Replace(callback, "state=", "state=forged", 1)
+		assertLoginError(t, b.do(http.MethodGet, forged, nil), http.StatusBadRequest, oauthSessionExpiredMessage)
+	})
+
+	t.Run("без verifier", func(t *testing.T) {
+		b := newOAuthBrowser(t, router)
+		callback := b.start("")
+		delete(b.cookies, "oauth_verifier")
+		assertLoginError(t, b.do(http.MethodGet, callback, nil), http.StatusBadRequest, oauthSessionExpiredMessage)
+	})
+
+	t.Run("подмененный verifier", func(t *testing.T) {
+		// Провайдер отклоняет обмен кода, если verifier не соответствует challenge
+		b := newOAuthBrowser(t, router)
+		callback := b.start("")
+		b.cookies["oauth_verifier"].Value = strings.Repeat("x", 43)
+		assertLoginError(t, b.do(http.MethodGet, callback, nil), http.StatusInternalServerError,
+			"Не удалось войти через Тестовый провайдер")
+		if _, ok := b.cookies[sessionCookie]; ok {
+			t.Error("сессия начата без проверки PKCE")
+		}
+	})
+
+	t.Run("повтор callback", func(t *testing.T) {
+		b := newOAuthBrowser(t, router)
+		callback := b.start("")
+		if w := b.do(http.MethodGet, callback, nil); w.Code != http.StatusFound {
+			t.Fatalf("вход: статус %d", w.Code)
+		}
+		// state одноразовый: cookie удалена после первого callback
+		assertLoginError(t, b.do(http.MethodGet, callback, nil), http.StatusBadRequest, oauthSessionExpiredMessage)
+	})
+
+	t.Run("отказ у провайдера", func(t *testing.T) {
+		b := newOAuthBrowser(t, router)
+		callback, err := url.Parse(b.start(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := url.Values{"state": {callback.Query().Get("state")}, "error": {"access_denied"}}
+		assertLoginError(t, b.do(http.MethodGet, callback.Path+"?"+query.Encode(), nil), http.StatusBadRequest,
+			"Вход через Тестовый провайдер отменен.")
+		if _, ok := b.cookies["oauth_state"]; ok {
+			t.Error("cookie state не удалена после отказа")
+		}
+	})
+
+	t.Run("email не подтвержден", func(t *testing.T) {
+		stub.SetUser(oidcstub.User{Subject: "sub-2", Email: "unverified@example.com"})
+		b := newOAuthBrowser(t, router)
+		if w := b.login(""); w.Code != http.StatusForbidden {
+			t.Errorf("статус %d, ожидался 403", w.Code)
+		}
+		if _, err := repos.Users.FindByEmail("unverified@example.com"); err == nil {
+			t.Error("создан пользователь с неподтвержденным email")
+		}
+	})
+}
+
+func TestOAuthExistingEmailRequiresPassword(t *testing.T) {
+	store, repos := newTestStore()
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := models.User{Email: "owner@example.com", Username: "owner", Password: string(hash)}
+	if err := repos.Users.Create(&owner); err != nil {
+		t.Fatal(err)
+	}
+	_, router, sessions := newOAuthTest(t, repos, oidcstub.User{Subject: "sub-owner", Email: "owner@example.com", EmailVerified: true})
+
+	// Совпадение email не дает входа без подтверждения владельцем
+	b := newOAuthBrowser(t, router)
+	w := b.login("")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/auth-link/confirm" {
+		t.Fatalf("вход по совпавшему email: статус %d, переход на %q", w.Code, w.Header().Get("Location"))
+	}
+	if _, ok := b.cookies[sessionCookie]; ok {
+		t.Fatal("сессия начата до подтверждения привязки")
+	}
+	if w := b.do(http.MethodGet, "/auth-link/confirm", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "owner@example.com") {
+		t.Errorf("страница подтверждения: статус %d", w.Code)
+	}
+
+	if w := b.do(http.MethodPost, "/auth-link/confirm", url.Values{"password": {"wrong"}}); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Неверный пароль") {
+		t.Errorf("неверный пароль: статус %d", w.Code)
+	}
+	if identities, _ := repos.Identities.ListByUser(owner.ID); len(identities) != 0 {
+		t.Fatalf("аккаунт привязан после неверного пароля: %+v", identities)
+	}
+
+	w = b.do(http.MethodPost, "/auth-link/confirm", url.Values{"password": {"correct horse"}})
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/profile" {
+		t.Fatalf("подтверждение: статус %d", w.Code)
+	}
+	if user := b.sessionUser(sessions); user.ID != owner.ID {
+		t.Errorf("вход в пользователя %d, ожидался %d", user.ID, owner.ID)
+	}
+	if identity, err := repos.Identities.FindBySubject("oidc", "sub-owner"); err != nil || identity.UserID != owner.ID {
+		t.Errorf("привязка: %+v, %v", identity, err)
+	}
+	// Запрос на привязку одноразовый
+	if w := b.do(http.MethodGet, "/auth-link/confirm", nil); w.Code != http.StatusNotFound {
+		t.Errorf("повторное подтверждение: статус %d, ожидался 404", w.Code)
+	}
+	if got := auditActions(store.AuditEvents()); got != "auth.login_failed,auth.identity_link,auth.login" {
+		t.Errorf("журнал: %s", got)
+	}
+}
+
+func TestOAuthLinkFromProfile(t *testing.T) {
+	store, repos := newTestStore()
+	owner := testUser(t, repos, "owner@example.com", models.RoleUser)
+	other := testUser(t, repos, "other@example.com", models.RoleUser)
+	// Email у провайдера другой: привязка из профиля не зависит от email
+	_, router, sessions := newOAuthTest(t, repos, oidcstub.User{Subject: "sub-work", Email: "work@example.com"})
+
+	b := newOAuthBrowser(t, router, loginCookie(t, sessions, owner))
+	w := b.login("?link=1")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/profile" {
+		t.Fatalf("привязка: статус %d, переход на %q", w.Code, w.Header().Get("Location"))
+	}
+	if identity, err := repos.Identities.FindBySubject("oidc", "sub-work"); err != nil || identity.UserID != owner.ID {
+		t.Fatalf("привязка: %+v, %v", identity, err)
+	}
+	if _, ok := b.cookies["oauth_link"]; ok {
+		t.Error("cookie oauth_link не удалена")
+	}
+
+	// Тот же внешний аккаунт нельзя привязать ко второму пользователю
+	if w := newOAuthBrowser(t, router, loginCookie(t, sessions, other)).login("?link=1"); w.Code != http.StatusConflict {
+		t.Errorf("чужая привязка: статус %d, ожидался 409", w.Code)
+	}
+	if got := auditActions(store.AuditEvents()); got != "auth.identity_link" {
+		t.Errorf("журнал: %s", got)
+	}
+}
+
+func TestOAuthLinkConfirmedWithLinkedProvider(t *testing.T) {
+	store, repos := newTestStore()
+	// Аккаунт создан через OAuth: пароля владелец не знает
+	owner := models.User{Email: "owner@example.com", Username: "owner", Password: "-", GeneratedPassword: true}
+	if err := repos.Users.Create(&owner); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Identities.Create(&models.UserIdentity{UserID: owner.ID, Provider: "oidc", Subject: "sub-old"}); err != nil {
+		t.Fatal(err)
+	}
+	_, router, _ := newOAuthTest(t, repos, oidcstub.User{Subject: "sub-new", Email: "owner@example.com", EmailVerified: true})
+
+	b := newOAuthBrowser(t, router)
+	if w := b.login(""); w.Header().Get("Location") != "/auth-link/confirm" {
+		t.Fatalf("вход по совпавшему email: статус %d, переход на %q", w.Code, w.Header().Get("Location"))
+	}
+
+	// Запрос хранится в базе: его подтверждает другой экземпляр приложения
+	stub, router, sessions := newOAuthTest(t, repos, oidcstub.User{Subject: "sub-stranger", Email: "stranger@example.com", EmailVerified: true})
+	b.router = router
+	w := b.do(http.MethodGet, "/auth-link/confirm", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Confirm with Тестовый провайдер") {
+		t.Fatalf("страница подтверждения: статус %d", w.Code)
+	}
+
+	// Вход через внешний аккаунт, не привязанный к владельцу, ничего не подтверждает и не создает
+	if w := b.login("?confirm_link=1"); w.Code != http.StatusForbidden {
+		t.Errorf("чужой внешний аккаунт: статус %d, ожидался 403", w.Code)
+	}
+	if _, err := repos.Users.FindByEmail("stranger@example.com"); err == nil {
+		t.Error("при подтверждении создан пользователь")
+	}
+	if _, err := repos.Identities.FindBySubject("oidc", "sub-new"); err == nil {
+		t.Fatal("аккаунт привязан через чужой внешний аккаунт")
+	}
+
+	stub.SetUser(oidcstub.User{Subject: "sub-old", Email: "owner@example.com", EmailVerified: true})
+	w = b.login("?confirm_link=1")
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/profile" {
+		t.Fatalf("подтверждение: статус %d, переход на %q", w.Code, w.Header().Get("Location"))
+	}
+	if user := b.sessionUser(sessions); user.ID != owner.ID {
+		t.Errorf("вход в пользователя %d, ожидался %d", user.ID, owner.ID)
+	}
+	if identity, err := repos.Identities.FindBySubject("oidc", "sub-new"); err != nil || identity.UserID != owner.ID {
+		t.Errorf("привязка: %+v, %v", identity, err)
+	}
+	if _, ok := b.cookies["oauth_link_confirm"]; ok {
+		t.Error("cookie oauth_link_confirm не удалена")
+	}
+	if w := b.do(http.MethodGet, "/auth-link/confirm", nil); w.Code != http.StatusNotFound {
+		t.Errorf("повторное подтверждение: статус %d, ожидался 404", w.Code)
+	}
+	if got := auditActions(store.AuditEvents()); got != "auth.login_failed,auth.identity_link,auth.login" {
+		t.Errorf("журнал: %s", got)
+	}
+}
+
+func TestOAuthLinkConfirmedByEmail(t *testing.T) {
+	_, repos := newTestStore()
+	owner := testUser(t, repos, "owner@example.com", models.RoleUser)
+	_, router, sessions := newOAuthTest(t, repos, oidcstub.User{})
+
+	hash := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	pending := models.PendingIdentityLink{TokenHash: hash("cookie-token"), UserID: owner.ID, Email: owner.Email,
+		Provider: "oidc", Subject: "sub-owner", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := repos.Identities.CreatePending(&pending); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Identities.SetPendingEmailToken(pending.ID, hash("email-token"), pending.ExpiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ссылку открывают в другом браузере. Переход по ней только показывает форму:
+	// почтовые сканеры открывают ссылки сами
+	b := newOAuthBrowser(t, router)
+	w := b.do(http.MethodGet, "/auth-link/email?token=email-token", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `action="/auth-link/email"`) {
+		t.Fatalf("страница по ссылке: статус %d", w.Code)
+	}
+	if _, err := repos.Identities.FindBySubject("oidc", "sub-owner"); err == nil {
+		t.Fatal("аккаунт привязан без отправки формы")
+	}
+	if w := b.do(http.MethodGet, "/auth-link/email?token=cookie-token", nil); w.Code != http.StatusNotFound {
+		t.Errorf("токен из cookie вместо токена письма: статус %d, ожидался 404", w.Code)
+	}
+
+	w = b.do(http.MethodPost, "/auth-link/email", url.Values{"token": {"email-token"}})
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/profile" {
+		t.Fatalf("подтверждение: статус %d, переход на %q", w.Code, w.Header().Get("Location"))
+	}
+	if user := b.sessionUser(sessions); user.ID != owner.ID {
+		t.Errorf("вход в пользователя %d, ожидался %d", user.ID, owner.ID)
+	}
+	if identity, err := repos.Identities.FindBySubject("oidc", "sub-owner"); err != nil || identity.UserID != owner.ID {
+		t.Errorf("привязка: %+v, %v", identity, err)
+	}
+	if w := b.do(http.MethodPost, "/auth-link/email", url.Values{"token": {"email-token"}}); w.Code != http.StatusNotFound {
+		t.Errorf("повторное подтверждение: статус %d, ожидался 404", w.Code)
+	}
+}
@@ -1,27 +1,38 @@
 package controllers
 
 import (
-	"digital-marketplace/internal/database"
-	"digital-marketplace/internal/models"
+	"context"
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/metrics"
+	"digital-marketplace/internal/repository"
 	"digital-marketplace/internal/services"
+	"digital-marketplace/internal/tracing"
+	"digital-marketplace/internal/worker"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"gopkg.in/gomail.v2"
 )
 
 type BuyController struct {
 	validationService *services.ValidationService
+	orderService      *services.OrderService
+	products          repository.ProductRepository
+	mailer            *orderMailer
 }
 
-func NewBuyController() *BuyController {
+func NewBuyController(repos repository.Repositories, jobs *worker.Supervisor) *BuyController {
 	return &BuyController{
 		validationService: services.NewValidationService(),
+		orderService:      services.NewOrderService(repos.Orders, repos.Carts),
+		products:          repos.Products,
+		mailer:            newOrderMailer(repos, jobs),
 	}
 }
 
@@ -65,13 +76,15 @@ func (bc *BuyController) ShowBuyPage(c *gin.Context) {
 		return
 	}
 
-	var product models.Product
-	// Use Preload to fetch user info if needed in the template later
-	result := database.DB.First(&product, productID)
-	if result.Error != nil {
+	product, err := bc.products.FindByID(uint(productID))
+	if err != nil {
 		renderTemplate(c, "error.html", gin.H{"Error": "Товар не найден"})
 		return
 	}
+	if !product.Listed() {
+		renderTemplate(c, "error.html", gin.H{"Error": "Товар снят с продажи"})
+		return
+	}
 
 	// Проверка, что товар не принадлежит текущему пользователю
 	user, userExists := getUserFromContext(c)
@@ -81,7 +94,7 @@ func (bc *BuyController) ShowBuyPage(c *gin.Context) {
 	}
 
 	renderTemplate(c, "buy.html", gin.H{
-		"Product": product,
+		"Product": *product,
 		"Balance": user.Balance,
 	})
 }
@@ -111,104 +124,46 @@ func (bc *BuyController) HandleBuy(c *gin.Context) {
 		return
 	}
 
-	var product models.Product
 	// Fetch the product again to ensure it exists
-	if err := database.DB.First(&product, productID).Error; err != nil {
+	product, err := bc.products.FindByID(uint(productID))
+	if err != nil {
 		renderTemplate(c, "error.html", gin.H{"Error": "Товар не найден"})
 		return
 	}
 
-	// Проверка, что товар не принадлежит текущему пользователю
-	if user.ID == product.UserID {
-		renderTemplate(c, "error.html", gin.H{"Error": "Вы не можете купить свой собственный товар"})
-		return
-	}
-
-	// Проверка, не куплен ли товар уже ранее
-	var existingOrder models.OrderItem
-	alreadyPurchased := database.DB.
-		Joins("JOIN orders ON orders.id = order_items.order_id").
-		Where("order_items.product_id = ? AND orders.user_id = ?", productID, user.ID).
-		First(&existingOrder).Error == nil
-
-	if alreadyPurchased {
-		renderTemplate(c, "error.html", gin.H{"Error": "Вы уже приобрели этот товар ранее"})
-		return
-	}
-
-	// Проверка наличия средств у пользователя
-	if user.Balance < product.Price {
-		renderTemplate(c, "buy.html", gin.H{
-			"Product": product,
-			"Error":   "Недостаточно средств на балансе. Пожалуйста, заработайте больше кредитов.",
-		})
-		return
-	}
-
-	// --- Create Order and OrderItem in Transaction ---
-	tx := database.DB.Begin()
-
-	// 1. Create Order
-	order := models.Order{
-		UserID:    user.ID,
-		CreatedAt: time.Now(),
-	}
-	if err := tx.Create(&order).Error; err != nil {
-		tx.Rollback()
-		fmt.Printf("Error creating order for product %d by user %d: %v\n", product.ID, user.ID, err)
-		renderTemplate(c, "buy.html", gin.H{
-			"Product": product,
-			"Error":   "Не удалось создать заказ. Попробуйте снова.",
-		})
-		return
-	}
-
-	// 2. Create OrderItem
-	orderItem := models.OrderItem{
-		OrderID:   order.ID,
-		ProductID: product.ID,
-	}
-	if err := tx.Create(&orderItem).Error; err != nil {
-		tx.Rollback()
-		fmt.Printf("Error creating order item for order %d, product %d: %v\n", order.ID, product.ID, err)
-		renderTemplate(c, "buy.html", gin.H{
-			"Product": product,
-			"Error":   "Не удалось добавить товар в заказ. Попробуйте снова.",
-		})
-		return
-	}
-
-	// 3. Уменьшаем баланс пользователя
-	user.Balance -= product.Price
-	if err := tx.Save(&user).Error; err != nil {
-		tx.Rollback()
-		fmt.Printf("Error updating user balance for user %d: %v\n", user.ID, err)
-		renderTemplate(c, "buy.html", gin.H{
-			"Product": product,
-			"Error":   "Ошибка при обновлении баланса. Попробуйте снова.",
-		})
-		return
-	}
-
-	// 4. Commit Transaction
-	fmt.Println("Attempting to commit transaction for single product purchase...")
-	if err := tx.Commit().Error; err != nil {
-		fmt.Println("Error committing transaction:", err)
-		// Rollback is implicitly done if Commit fails
-		renderTemplate(c, "buy.html", gin.H{
-			"Product": product,
-			"Error":   "Ошибка сохранения заказа. Попробуйте снова.",
-		})
+	// Покупка (проверки владельца, повторной покупки, баланса и списание) выполняется в одной транзакции
+	order, err := bc.orderService.BuyProduct(c.Request.Context(), auditActor(c), product.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrProductNotFound):
+			renderTemplate(c, "error.html", gin.H{"Error": "Товар не найден"})
+		case errors.Is(err, services.ErrProductUnlisted):
+			renderTemplate(c, "error.html", gin.H{"Error": "Товар снят с продажи"})
+		case errors.Is(err, services.ErrOwnProduct):
+			renderTemplate(c, "error.html", gin.H{"Error": "Вы не можете купить свой собственный товар"})
+		case errors.Is(err, services.ErrAlreadyPurchased):
+			renderTemplate(c, "error.html", gin.H{"Error": "Вы уже приобрели этот товар ранее"})
+		case errors.Is(err, services.ErrInsufficientFunds):
+			renderTemplate(c, "buy.html", gin.H{
+				"Product": *product,
+				"Error":   "Недостаточно средств на балансе. Пожалуйста, заработайте больше кредитов.",
+			})
+		default:
+			requestLogger(c).Error("Ошибка покупки товара", logging.ProductID(product.ID), logging.Err(err))
+			renderTemplate(c, "buy.html", gin.H{
+				"Product": *product,
+				"Error":   "Ошибка сохранения заказа. Попробуйте снова.",
+			})
+		}
 		return
 	}
-	fmt.Println("Transaction committed successfully!")
 
 	// 5. Send confirmation email (using the copied function)
 	// Валидация email перед отправкой
 	if valid, _ := bc.validationService.ValidateEmail(user.Email); valid {
-		go sendOrderConfirmationEmail(user.Email, order.ID)
+		bc.mailer.send(c.Request.Context(), user.Email, user.ID, order.ID)
 	} else {
-		fmt.Printf("Предупреждение: некорректный email пользователя %d: %s\n", user.ID, user.Email)
+		requestLogger(c).Warn("Некорректный email пользователя, письмо о заказе не отправлено", logging.OrderID(order.ID))
 	}
 
 	// 6. Redirect to a generic success page
@@ -217,29 +172,46 @@ func (bc *BuyController) HandleBuy(c *gin.Context) {
 
 // --- Copied Email Sending Logic (Example using gomail) ---
 // Note: Ideally, this should be in a shared service package.
-// Adjusted to not require filePaths parameter as it fetches items by orderID.
-func sendOrderConfirmationEmail(toEmail string, orderID uint) {
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPortStr := os.Getenv("SMTP_PORT")
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
-	fromEmail := os.Getenv("SMTP_FROM_EMAIL") // Email to send from
-	baseURL := os.Getenv("BASE_URL")
+
+// orderMailer sends order confirmations with download links. Shared by the buy,
+// order and API controllers.
+type orderMailer struct {
+	orders      repository.OrderRepository
+	fileService *services.FileService
+	jobs        *worker.Supervisor
+}
+
+func newOrderMailer(repos repository.Repositories, jobs *worker.Supervisor) *orderMailer {
+	return &orderMailer{
+		orders:      repos.Orders,
+		fileService: services.NewFileService(repos.Products),
+		jobs:        jobs,
+	}
+}
+
+// send отправляет подтверждение заказа покупателю userID в фоне, не задерживая ответ. Письмо, начатое до остановки
+// сервера, дописывается: супервизор ждет его завершения. Записи журнала о письме несут
+// request_id запроса, в котором оформлен заказ.
+func (om *orderMailer) send(ctx context.Context, toEmail string, userID, orderID uint) {
+	ctx = logging.With(ctx, logging.OrderID(orderID))
+	om.jobs.Spawn(ctx, "order-mail", func(ctx context.Context) {
+		om.sendOrderConfirmationEmail(ctx, toEmail, userID, orderID)
+	})
+}
+
+// sendOrderConfirmationEmail fetches the order items by orderID and mails the buyer's download links for them
+func (om *orderMailer) sendOrderConfirmationEmail(ctx context.Context, toEmail string, userID, orderID uint) {
+	logger := logging.FromContext(ctx)
+	smtp := services.MailConfig()
+	fromEmail := smtp.From // Email to send from
+	baseURL := services.BaseURL()
 
 	if fromEmail == "" {
 		fromEmail = "orders@digital-marketplace.com" // Default sender
 	}
-	if baseURL == "" {
-		baseURL = "http://localhost:8080" // Default base URL
-	}
 
-	if smtpHost == "" || smtpPortStr == "" {
-		fmt.Println("SMTP_HOST или SMTP_PORT не установлены. Пропускаем отправку email.")
-		return
-	}
-	smtpPort, err := strconv.Atoi(smtpPortStr)
-	if err != nil {
-		fmt.Println("Invalid SMTP_PORT:", err)
+	if !smtp.Enabled() {
+		logger.Debug("SMTP не настроен, письмо о заказе не отправлено")
 		return
 	}
 
@@ -256,23 +228,22 @@ func sendOrderConfirmationEmail(toEmail string, orderID uint) {
 
 `, orderID)
 
-	var orderItems []models.OrderItem
 	// Fetch order items (including the product details) for the specific order
-	dbResult := database.DB.Preload("Product").Where("order_id = ?", orderID).Find(&orderItems)
-	if dbResult.Error != nil {
-		fmt.Printf("Ошибка получения товаров для заказа %d при отправке email: %v\n", orderID, dbResult.Error)
+	orderItems, err := om.orders.ItemsByOrder(orderID)
+	if err != nil {
+		logger.Error("Ошибка получения товаров заказа для письма", logging.Err(err))
 		// Decide if you want to send the email without product links or just return
 		return
 	}
 
-	fileService := services.NewFileService()
+	fileService := om.fileService
 
 	if len(orderItems) > 0 {
 		for i, item := range orderItems {
 			product := item.Product
-			downloadToken, tokenErr := fileService.GenerateDownloadToken(product.ID)
+			downloadToken, tokenErr := fileService.GenerateDownloadToken(product.ID, userID)
 			if tokenErr != nil {
-				fmt.Printf("Ошибка создания токена для продукта %d (заказ %d): %v\n", product.ID, orderID, tokenErr)
+				logger.Error("Ошибка создания ссылки на скачивание", logging.ProductID(product.ID), logging.Err(tokenErr))
 				continue // Skip this item if token generation fails
 			}
 			downloadURL := fileService.GenerateDownloadURL(downloadToken, baseURL)
@@ -287,14 +258,21 @@ func sendOrderConfirmationEmail(toEmail string, orderID uint) {
 Команда Digital Marketplace`
 	m.SetBody("text/plain", body)
 
-	d := gomail.NewDialer(smtpHost, smtpPort, smtpUser, smtpPass)
-	if smtpHost == "mailhog" { // Specific handling for mailhog
+	d := gomail.NewDialer(smtp.Host, smtp.Port, smtp.User, smtp.Password.Value())
+	if smtp.Host == "mailhog" { // Specific handling for mailhog
 		d.SSL = false
 	}
 
-	if err := d.DialAndSend(m); err != nil {
-		fmt.Printf("Не удалось отправить email подтверждения заказа #%d на %s: %v\n", orderID, toEmail, err)
+	_, span := tracing.Start(ctx, "smtp.send",
+		attribute.String("mail.kind", "order"),
+		semconv.ServerAddress(smtp.Host),
+	)
+	err = d.DialAndSend(m)
+	tracing.End(span, err)
+	metrics.ObserveMail("order", err)
+	if err != nil {
+		logger.Error("Не удалось отправить письмо о заказе", logging.Email(toEmail), logging.Err(err))
 	} else {
-		fmt.Printf("Email подтверждения заказа #%d успешно отправлен на %s\n", orderID, toEmail)
+		logger.Info("Письмо о заказе отправлено", logging.Email(toEmail))
 	}
 }
@@ -1,19 +1,25 @@
 package controllers
 
 import (
-	"digital-marketplace/internal/database"
+	"digital-marketplace/internal/logging"
 	"digital-marketplace/internal/models"
-	"fmt"
+	"digital-marketplace/internal/repository"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
-type CartController struct{}
+type CartController struct {
+	products repository.ProductRepository
+	carts    repository.CartRepository
+}
 
-func NewCartController() *CartController {
-	return &CartController{}
+func NewCartController(repos repository.Repositories) *CartController {
+	return &CartController{
+		products: repos.Products,
+		carts:    repos.Carts,
+	}
 }
 
 // AddToCart adds a product to the user's cart
@@ -34,22 +40,18 @@ func (cc *CartController) AddToCart(c *gin.Context) {
 		return
 	}
 
-	// 3. Check if product exists (optional but good practice)
-	var product models.Product
-	if database.DB.First(&product, uint(productID)).Error != nil {
+	// 3. Check if product exists and is still on sale
+	if product, err := cc.products.FindByID(uint(productID)); err != nil || !product.Listed() {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
 
 	// 4. Check if item is already in cart (optional: prevent duplicates or increase quantity later)
-	var existingItems []models.CartItem
-	result := database.DB.Where("user_id = ? AND product_id = ?", user.ID, uint(productID)).Find(&existingItems)
-
-	isInCart := result.RowsAffected > 0
+	_, err = cc.carts.Find(user.ID, uint(productID))
+	isInCart := err == nil
 
 	if isInCart {
 		// For now, just redirect back or show a message. Later, could increase quantity.
-		fmt.Println("Item already in cart") // Log for now
 		// Optionally add a flash message here
 		c.Redirect(http.StatusFound, c.Request.Referer()) // Redirect back to previous page
 		return
@@ -61,17 +63,15 @@ func (cc *CartController) AddToCart(c *gin.Context) {
 		ProductID: uint(productID),
 	}
 
-	result = database.DB.Create(&cartItem)
-	if result.Error != nil {
+	if err := cc.carts.Add(&cartItem); err != nil {
 		// Handle DB error
-		fmt.Println("Error adding item to cart:", result.Error) // Log error
+		requestLogger(c).Error("Ошибка добавления товара в корзину", logging.ProductID(uint(productID)), logging.Err(err))
 		// Optionally add a flash message for the user
 		c.Redirect(http.StatusFound, c.Request.Referer()) // Redirect back
 		return
 	}
 
 	// 6. Redirect (e.g., back to product page or to the cart)
-	fmt.Println("Item added to cart:", cartItem.ID)
 	// Optionally add a success flash message
 	c.Redirect(http.StatusFound, "/cart") // Redirect to cart page after adding
 }
@@ -86,12 +86,10 @@ func (cc *CartController) ShowCart(c *gin.Context) {
 	}
 
 	// 2. Fetch cart items for the user, preloading associated Product data
-	var cartItems []models.CartItem
-	result := database.DB.Preload("Product").Where("user_id = ?", user.ID).Order("created_at desc").Find(&cartItems)
-
-	if result.Error != nil {
+	cartItems, err := cc.carts.ListByUser(user.ID)
+	if err != nil {
 		// Log the error and potentially show an error page or message
-		fmt.Println("Error fetching cart items:", result.Error)
+		requestLogger(c).Error("Ошибка загрузки корзины", logging.Err(err))
 		// For now, render the cart page with an error message or empty list
 		renderTemplate(c, "cart.html", gin.H{
 			"Items": []models.CartItem{}, // Pass empty slice on error
@@ -135,28 +133,16 @@ func (cc *CartController) RemoveFromCart(c *gin.Context) {
 		return
 	}
 
-	// 3. Find the cart item to ensure it belongs to the current user
-	var cartItem models.CartItem
-	result := database.DB.Where("id = ? AND user_id = ?", uint(itemID), user.ID).First(&cartItem)
-	if result.Error != nil {
-		// Item not found or doesn't belong to the user
-		// Log error or redirect with message
-		fmt.Println("Error finding cart item to remove:", result.Error)
-		c.Redirect(http.StatusFound, "/cart") // Redirect back to cart
-		return
-	}
-
-	// 4. Delete the item from DB
-	deleteResult := database.DB.Delete(&cartItem)
-	if deleteResult.Error != nil {
-		// Handle DB error during deletion
-		fmt.Println("Error removing item from cart:", deleteResult.Error)
+	// 3. Delete the item; the repository only removes items that belong to the current user
+	if err := cc.carts.Remove(user.ID, uint(itemID)); err != nil {
+		// Item not found, doesn't belong to the user or DB error
+		requestLogger(c).Error("Ошибка удаления позиции из корзины", logging.Err(err))
 		// Optionally add a flash message for the user
 		c.Redirect(http.StatusFound, "/cart") // Redirect back
 		return
 	}
 
-	// 5. Redirect back to cart
+	// 4. Redirect back to cart
 	// Optionally add a success flash message
 	c.Redirect(http.StatusFound, "/cart")
 }
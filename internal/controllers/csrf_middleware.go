@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"digital-marketplace/internal/logging"
+	"encoding/hex"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// csrfCookieName - cookie, в которой хранится CSRF-токен (double-submit cookie)
+	csrfCookieName = "csrf_token"
+	// csrfFormField - имя скрытого поля формы с токеном
+	csrfFormField = "csrf_token"
+	// csrfHeaderName - заголовок для передачи токена из JavaScript
+	csrfHeaderName = "X-CSRF-Token"
+	// csrfContextKey - ключ контекста Gin, из которого renderTemplate берет токен
+	csrfContextKey = "csrf_token"
+)
+
+// CSRFProtection защищает все изменяющие состояние запросы от CSRF.
+// Токен хранится в cookie и должен совпадать со значением поля csrf_token формы
+// (или заголовка X-CSRF-Token). Middleware также включает SameSite=Lax для всех
+// cookie, которые приложение устанавливает через c.SetCookie в рамках запроса.
+//
+// Запросы к /api/v1 не проверяются: этот API аутентифицируется только заголовком
+// Authorization, который браузер не подставляет в кросс-доменные запросы сам.
+func CSRFProtection() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isAPIv1Path(c) {
+			c.Next()
+			return
+		}
+
+		// Lax сохраняет cookie при переходе по ссылке и при возврате от OAuth-провайдера,
+		// но не отправляет их в кросс-доменных POST-запросах
+		c.SetSameSite(http.SameSiteLaxMode)
+
+		token, err := c.Cookie(csrfCookieName)
+		if err != nil || !isValidCSRFToken(token) {
+			token, err = generateCSRFToken()
+			if err != nil {
+				requestLogger(c).Error("Не удалось создать CSRF-токен", logging.Err(err))
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			c.SetCookie(csrfCookieName, token, 3600*24*7, "/", "", false, true)
+		}
+		c.Set(csrfContextKey, token)
+
+		if isSafeMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		submitted := c.GetHeader(csrfHeaderName)
+		if submitted == "" {
+			submitted = c.PostForm(csrfFormField)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+			requestLogger(c).Warn("CSRF-проверка не пройдена", slog.String("client_ip", c.ClientIP()))
+			if strings.HasPrefix(c.Request.URL.Path, "/api") {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Недействительный CSRF-токен"})
+				return
+			}
+			renderTemplateWithStatus(c, http.StatusForbidden, "error.html", gin.H{
+				"Error": "Сессия формы устарела. Обновите страницу и попробуйте снова.",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// csrfTokenFromContext возвращает CSRF-токен текущего запроса
+func csrfTokenFromContext(c *gin.Context) string {
+	if value, exists := c.Get(csrfContextKey); exists {
+		if token, ok := value.(string); ok {
+			return token
+		}
+	}
+	return ""
+}
+
+// csrfField возвращает скрытое поле формы с CSRF-токеном для вставки в шаблоны
+func csrfField(token string) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + csrfFormField + `" value="` + template.HTMLEscapeString(token) + `">`)
+}
+
+func generateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// isValidCSRFToken проверяет формат токена из cookie (64 hex-символа)
+func isValidCSRFToken(token string) bool {
+	if len(token) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(token)
+	return err == nil
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveCSRF выполняет запрос с cookie csrf_token (пустая - без cookie), полем формы и заголовком X-CSRF-Token
+func serveCSRF(router http.Handler, method, target, cookie, field, header string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	form := url.Values{}
+	if field != "" {
+		form.Set(csrfFormField, field)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: cookie})
+	}
+	if header != "" {
+		req.Header.Set(csrfHeaderName, header)
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCSRFProtection(t *testing.T) {
+	router := newTestRouter(t)
+	router.Use(CSRFProtection())
+	ok := func(c *gin.Context) { c.String(http.StatusOK, csrfTokenFromContext(c)) }
+	for _, path := range []string{"/cart/add", "/api/v1/checkout", "/api/v1", "/api/v10/checkout", "/api/products"} {
+		router.POST(path, ok)
+	}
+	router.GET("/cart", ok)
+
+	token := strings.Repeat("a1", 32)
+	other := strings.Repeat("b2", 32)
+	tests := []struct {
+		name   string
+		method string
+		target string
+		cookie string
+		field  string
+		header string
+		want   int
+	}{
+		{"без токена", http.MethodPost, "/cart/add", "", "", "", http.StatusForbidden},
+		{"нет поля формы", http.MethodPost, "/cart/add", token, "", "", http.StatusForbidden},
+		{"токен формы не совпадает с cookie", http.MethodPost, "/cart/add", token, other, "", http.StatusForbidden},
+		{"токен без cookie", http.MethodPost, "/cart/add", "", token, "", http.StatusForbidden},
+		// Cookie не того формата заменяется новой, поэтому совпадение с ней не помогает
+		{"cookie не из 64 hex-символов", http.MethodPost, "/cart/add", "abc", "abc", "", http.StatusForbidden},
+		{"токен в поле формы", http.MethodPost, "/cart/add", token, token, "", http.StatusOK},
+		{"токен в заголовке", http.MethodPost, "/cart/add", token, "", token, http.StatusOK},
+		{"заголовок не совпадает с cookie", http.MethodPost, "/cart/add", token, token, other, http.StatusForbidden},
+		{"GET без токена", http.MethodGet, "/cart", "", "", "", http.StatusOK},
+		{"/api/v1/ без токена", http.MethodPost, "/api/v1/checkout", "", "", "", http.StatusOK},
+		{"/api/v1 без слеша проверяется", http.MethodPost, "/api/v1", "", "", "", http.StatusForbidden},
+		{"/api/v10/ проверяется", http.MethodPost, "/api/v10/checkout", "", "", "", http.StatusForbidden},
+		{"старый /api проверяется", http.MethodPost, "/api/products", token, "", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveCSRF(router, tt.method, tt.target, tt.cookie, tt.field, tt.header)
+			if w.Code != tt.want {
+				t.Errorf("статус %d, ожидался %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	// Безопасный запрос выдает токен в cookie и в контекст для шаблонов
+	w := serveCSRF(router, http.MethodGet, "/cart", "", "", "")
+	var issued *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == csrfCookieName {
+			issued = cookie
+		}
+	}
+	if issued == nil || !isValidCSRFToken(issued.Value) || w.Body.String() != issued.Value {
+		t.Fatalf("GET не выдал токен: cookie %v, в контексте %q", issued, w.Body)
+	}
+	if issued.SameSite != http.SameSiteLaxMode || !issued.HttpOnly {
+		t.Errorf("cookie токена без SameSite=Lax или HttpOnly: %v", issued)
+	}
+	if w := serveCSRF(router, http.MethodPost, "/cart/add", issued.Value, issued.Value, ""); w.Code != http.StatusOK {
+		t.Errorf("выданный токен не принят: статус %d", w.Code)
+	}
+
+	// Ошибка на старом /api приходит в JSON, на страницах - страницей ошибки
+	if w := serveCSRF(router, http.MethodPost, "/api/products", "", "", ""); !strings.Contains(w.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("ответ /api: Content-Type %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestLogoutRequiresPostWithCSRFToken(t *testing.T) {
+	_, repos := newTestStore()
+	user := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	auth := NewAuthController(repos, services.NewRateLimitService(services.NewMemoryRateLimitStore(), services.DefaultLockoutPolicy), config.OAuthConfig{})
+	sessions := auth.Sessions()
+
+	router := newTestRouter(t)
+	router.Use(CSRFProtection())
+	router.POST("/logout", AuthRequired(sessions), auth.Logout)
+
+	session := loginCookie(t, sessions, user)
+	token := strings.Repeat("c3", 32)
+
+	if w := serveCSRF(router, http.MethodGet, "/logout", token, "", "", session); w.Code != http.StatusNotFound {
+		t.Errorf("GET /logout: статус %d, ожидался 404", w.Code)
+	}
+	// Форма с другого сайта не знает токена и не завершает сессию
+	if w := serveCSRF(router, http.MethodPost, "/logout", token, "", "", session); w.Code != http.StatusForbidden {
+		t.Errorf("POST без токена: статус %d, ожидался 403", w.Code)
+	}
+	if _, err := sessions.Authenticate(session.Value); err != nil {
+		t.Fatalf("сессия завершена без CSRF-токена: %v", err)
+	}
+
+	if w := serveCSRF(router, http.MethodPost, "/logout", token, token, "", session); w.Code != http.StatusFound {
+		t.Fatalf("выход: статус %d", w.Code)
+	}
+	if _, err := sessions.Authenticate(session.Value); err == nil {
+		t.Error("сессия действует после выхода")
+	}
+}
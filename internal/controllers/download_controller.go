@@ -1,10 +1,17 @@
 package controllers
 
 import (
-	"digital-marketplace/internal/database"
+	"context"
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/metrics"
 	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
 	"digital-marketplace/internal/services"
-	"log"
+	"digital-marketplace/internal/tracing"
+	"digital-marketplace/internal/watermark"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -12,15 +19,26 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type DownloadController struct {
-	fileService *services.FileService
+	fileService      *services.FileService
+	auditService     *services.AuditService
+	downloadService  *services.DownloadService
+	watermarkService *services.WatermarkService
+	products         repository.ProductRepository
+	orders           repository.OrderRepository
 }
 
-func NewDownloadController() *DownloadController {
+func NewDownloadController(repos repository.Repositories) *DownloadController {
 	return &DownloadController{
-		fileService: services.NewFileService(),
+		fileService:      services.NewFileService(repos.Products),
+		auditService:     services.NewAuditService(repos.Audit),
+		downloadService:  services.NewDownloadService(repos.Downloads, repos.Audit),
+		watermarkService: services.NewWatermarkService(repos.Downloads, repos.Audit, repos.Orders, repos.Users, repos.Products),
+		products:         repos.Products,
+		orders:           repos.Orders,
 	}
 }
 
@@ -45,6 +63,14 @@ func (dc *DownloadController) HandleDownload(c *gin.Context) {
 		return
 	}
 
+	product, err := dc.products.FindByID(downloadInfo.ProductID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "Продукт не найден",
+		})
+		return
+	}
+
 	// Проверяем существование файла
 	file, err := os.Open(downloadInfo.FilePath)
 	if err != nil {
@@ -55,6 +81,18 @@ func (dc *DownloadController) HandleDownload(c *gin.Context) {
 	}
 	defer file.Close()
 
+	// Ссылку из письма открывают и без входа, поэтому скачивание записывается на покупателя,
+	// которому выдана ссылка, и учитывается в его лимите
+	actor := auditActorFor(c, models.User{ID: downloadInfo.UserID})
+	resumed := dc.resumesDownload(c, downloadInfo.UserID, *product, downloadInfo.FilePath)
+	var download *models.Download
+	if !resumed {
+		var ok bool
+		if download, ok = reserveDownload(c, dc.downloadService, actor, *product, "link"); !ok {
+			return
+		}
+	}
+
 	// Устанавливаем заголовки для скачивания
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
@@ -62,13 +100,18 @@ func (dc *DownloadController) HandleDownload(c *gin.Context) {
 	c.Header("Content-Type", downloadInfo.ContentType)
 
 	// Отправляем файл
-	c.File(downloadInfo.FilePath)
+	mark := dc.sendProductFile(c, *product, downloadInfo.UserID, downloadInfo.FilePath)
 
 	// Удаляем токен после использования (опционально, можно оставить для повторного скачивания)
 	// dc.fileService.DeleteToken(token)
 
-	// Логируем успешное скачивание
-	log.Printf("Файл успешно скачан: %s", downloadInfo.FileName)
+	if resumed {
+		requestLogger(c).Info("Файл докачан по ссылке", slog.String("file", downloadInfo.FileName), slog.Int("bytes", c.Writer.Size()))
+		return
+	}
+	metrics.ObserveDownload("link", c.Writer.Size())
+	requestLogger(c).Info("Файл скачан по ссылке", slog.String("file", downloadInfo.FileName))
+	dc.completeDownload(c, actor, *product, download, downloadInfo.FileName, mark)
 }
 
 // HandleSecureDownload обрабатывает запрос на защищенное скачивание файла
@@ -99,13 +142,7 @@ func (dc *DownloadController) HandleSecureDownload(c *gin.Context) {
 	}
 
 	// Проверим, что пользователь купил этот продукт
-	var order models.Order
-	result := database.DB.Where("user_id = ?", user.ID).
-		Joins("JOIN order_items ON orders.id = order_items.order_id").
-		Where("order_items.product_id = ?", productID).
-		First(&order)
-
-	if result.Error != nil {
+	if purchased, err := dc.orders.HasPurchased(user.ID, uint(productID)); err != nil || !purchased {
 		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
 			"error": "У вас нет доступа к этому продукту. Пожалуйста, приобретите его сначала.",
 		})
@@ -113,16 +150,19 @@ func (dc *DownloadController) HandleSecureDownload(c *gin.Context) {
 	}
 
 	// Получаем информацию о продукте
-	var product models.Product
-	if err := database.DB.First(&product, productID).Error; err != nil {
+	product, err := dc.products.FindByID(uint(productID))
+	if err != nil {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
 			"error": "Продукт не найден",
 		})
 		return
 	}
+	if !checkDownloadLimit(c, dc.downloadService, user.ID, *product) {
+		return
+	}
 
 	// Создаем токен для скачивания
-	token, err := dc.fileService.GenerateDownloadToken(uint(productID))
+	token, err := dc.fileService.GenerateDownloadToken(uint(productID), user.ID)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
 			"error": "Не удалось создать токен для скачивания",
@@ -156,8 +196,8 @@ func (dc *DownloadController) ServeProductFile(c *gin.Context) {
 	}
 
 	// Проверяем, что пользователь купил этот продукт или является его владельцем
-	var product models.Product
-	if err := database.DB.First(&product, productID).Error; err != nil {
+	product, err := dc.products.FindByID(uint(productID))
+	if err != nil {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
 			"error": "Продукт не найден",
 		})
@@ -166,13 +206,7 @@ func (dc *DownloadController) ServeProductFile(c *gin.Context) {
 
 	// Если пользователь не является владельцем, проверяем, купил ли он продукт
 	if product.UserID != user.ID {
-		var order models.Order
-		result := database.DB.Where("user_id = ?", user.ID).
-			Joins("JOIN order_items ON orders.id = order_items.order_id").
-			Where("order_items.product_id = ?", productID).
-			First(&order)
-
-		if result.Error != nil {
+		if purchased, err := dc.orders.HasPurchased(user.ID, uint(productID)); err != nil || !purchased {
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
 				"error": "У вас нет доступа к этому продукту. Пожалуйста, приобретите его сначала.",
 			})
@@ -190,6 +224,16 @@ func (dc *DownloadController) ServeProductFile(c *gin.Context) {
 		return
 	}
 
+	actor := auditActor(c)
+	resumed := dc.resumesDownload(c, user.ID, *product, fullPath)
+	var download *models.Download
+	if !resumed {
+		var ok bool
+		if download, ok = reserveDownload(c, dc.downloadService, actor, *product, "direct"); !ok {
+			return
+		}
+	}
+
 	// Устанавливаем заголовки для скачивания
 	fileName := filepath.Base(product.FilePath)
 	c.Header("Content-Description", "File Transfer")
@@ -198,10 +242,162 @@ func (dc *DownloadController) ServeProductFile(c *gin.Context) {
 	c.Header("Content-Type", dc.fileService.GuessContentType(filePath))
 
 	// Отправляем файл
-	c.File(fullPath)
+	mark := dc.sendProductFile(c, *product, user.ID, fullPath)
 
-	// Логируем успешное скачивание
-	log.Printf("Пользователь %d скачал файл продукта %d: %s", user.ID, productID, fileName)
+	if resumed {
+		requestLogger(c).Info("Файл товара докачан", logging.ProductID(uint(productID)), slog.String("file", fileName), slog.Int("bytes", c.Writer.Size()))
+		return
+	}
+	metrics.ObserveDownload("direct", c.Writer.Size())
+	requestLogger(c).Info("Файл товара скачан", logging.ProductID(uint(productID)), slog.String("file", fileName))
+	dc.completeDownload(c, actor, *product, download, fileName, mark)
+}
+
+// sendProductFile отдает файл товара пользователю userID. Если продавец включил водяные знаки,
+// покупатель получает копию с водяным знаком, который и возвращается. Если файл не удалось
+// обработать до начала ответа, отдается исходный файл и возвращается nil.
+func (dc *DownloadController) sendProductFile(c *gin.Context, product models.Product, userID uint, path string) *watermark.Mark {
+	if !dc.watermarkService.Enabled(product, userID) || !watermark.Supported(path) {
+		serveFile(c, path)
+		return nil
+	}
+
+	logger := requestLogger(c).With(logging.ProductID(product.ID))
+	mark, err := dc.watermarkService.Issue(c.Request.Context(), userID, product)
+	if err != nil {
+		logger.Error("Не удалось выдать водяной знак, файл отдан без него", logging.Err(err))
+		metrics.WatermarkFailures.Inc()
+		serveFile(c, path)
+		return nil
+	}
+
+	_, span := tracing.Start(c.Request.Context(), "storage.watermark")
+	c.Status(http.StatusOK)
+	err = watermark.Apply(c.Writer, path, mark)
+	span.SetAttributes(attribute.Int("file.bytes", c.Writer.Size()))
+	tracing.End(span, err)
+	switch {
+	case err == nil:
+		return &mark
+	case c.Writer.Written():
+		// Часть файла с водяным знаком уже у покупателя (чаще всего он прервал скачивание),
+		// поэтому код сохраняется вместе со скачиванием
+		logger.Warn("Скачивание файла с водяным знаком прервано", slog.String("watermark", mark.Code), logging.Err(err))
+		return &mark
+	default:
+		logger.Warn("Не удалось встроить водяной знак, файл отдан без него", logging.Err(err))
+		metrics.WatermarkFailures.Inc()
+		serveFile(c, path)
+		return nil
+	}
+}
+
+// completeDownload дописывает в скачивание из reserveDownload размер отданного файла и водяной
+// знак (mark, nil - файл отдан без изменений) и записывает скачивание в журнал аудита.
+// actor - покупатель, на которого зарезервировано скачивание.
+func (dc *DownloadController) completeDownload(c *gin.Context, actor services.AuditActor, product models.Product, download *models.Download, fileName string, mark *watermark.Mark) {
+	bytes := c.Writer.Size()
+	// Покупатель мог закрыть соединение, но отданный файл все равно нужно сохранить
+	ctx := context.WithoutCancel(c.Request.Context())
+	if err := dc.downloadService.Complete(ctx, actor, download, int64(bytes), mark); err != nil {
+		// Ответ уже отправлен, а в лимите скачивание учтено при резервировании
+		requestLogger(c).Error("Не удалось сохранить размер скачивания", logging.ProductID(product.ID), logging.Err(err))
+	}
+
+	details := map[string]interface{}{"file": fileName, "bytes": bytes, "version": product.Version, "via": download.Via}
+	// Код дублируется в журнал: по нему покупатель находится, даже если запись о скачивании удалена
+	if mark != nil {
+		details["watermark"] = mark.Code
+		details["order_id"] = mark.OrderID
+	}
+	recordAuditEvent(c, dc.auditService, actor, services.AuditEntry{
+		Action:     services.AuditFileDownload,
+		TargetType: services.AuditTargetProduct,
+		TargetID:   product.ID,
+		Details:    details,
+	})
+}
+
+// resumesDownload сообщает, докачивает ли запрос файл, скачивание которого уже учтено в лимите.
+// Такой запрос не резервирует новое скачивание: иначе каждый обрыв соединения отнимал бы
+// у покупателя попытку. Докачкой считается только один диапазон "bytes=N-" с N > 0, чтобы
+// запрос не мог получить файл целиком, а копия с водяным знаком всегда отдается полностью
+// и поэтому учитывается заново.
+func (dc *DownloadController) resumesDownload(c *gin.Context, userID uint, product models.Product, path string) bool {
+	if !isRangeContinuation(c.GetHeader("Range")) {
+		return false
+	}
+	if dc.watermarkService.Enabled(product, userID) && watermark.Supported(path) {
+		return false
+	}
+	downloaded, err := dc.downloadService.HasDownloaded(userID, product)
+	if err != nil {
+		requestLogger(c).Error("Ошибка проверки прежних скачиваний", logging.ProductID(product.ID), logging.Err(err))
+		return false
+	}
+	if !downloaded {
+		return false
+	}
+	// При несовпавшем If-Range файл отдается целиком, поэтому докачка его не учитывает
+	c.Request.Header.Del("If-Range")
+	return true
+}
+
+// isRangeContinuation сообщает, запрашивает ли заголовок Range один диапазон с ненулевого байта
+func isRangeContinuation(header string) bool {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return false
+	}
+	start, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return false
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	return err == nil && offset > 0
+}
+
+// reserveDownload учитывает скачивание товара покупателем actor до отдачи файла. Если лимит
+// исчерпан или скачивание не удалось сохранить, запрос уже прерван с кодом 403 или 500
+// и файл отдавать нельзя.
+func reserveDownload(c *gin.Context, downloadService *services.DownloadService, actor services.AuditActor, product models.Product, via string) (*models.Download, bool) {
+	download, err := downloadService.Reserve(c.Request.Context(), actor, product, via)
+	switch {
+	case err == nil:
+		return download, true
+	case errors.Is(err, services.ErrDownloadLimitReached):
+		metrics.DownloadsRejected.Inc()
+		requestLogger(c).Warn("Лимит скачиваний исчерпан", logging.ProductID(product.ID))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": downloadLimitMessage(downloadService)})
+	default:
+		requestLogger(c).Error("Не удалось сохранить скачивание", logging.ProductID(product.ID), logging.Err(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить скачивание"})
+	}
+	return nil, false
+}
+
+// checkDownloadLimit проверяет перед выдачей ссылки, может ли userID еще раз скачать товар.
+// Если нет, запрос уже прерван с кодом 403 (или 500 при ошибке базы). Само скачивание
+// учитывается в лимите через reserveDownload.
+func checkDownloadLimit(c *gin.Context, downloadService *services.DownloadService, userID uint, product models.Product) bool {
+	err := downloadService.CheckLimit(userID, product)
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, services.ErrDownloadLimitReached):
+		metrics.DownloadsRejected.Inc()
+		requestLogger(c).Warn("Лимит скачиваний исчерпан", logging.ProductID(product.ID))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": downloadLimitMessage(downloadService)})
+	default:
+		requestLogger(c).Error("Ошибка проверки лимита скачиваний", logging.ProductID(product.ID), logging.Err(err))
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Не удалось проверить лимит скачиваний"})
+	}
+	return false
+}
+
+// downloadLimitMessage объясняет покупателю, почему скачивание недоступно
+func downloadLimitMessage(downloadService *services.DownloadService) string {
+	return fmt.Sprintf("Лимит скачиваний исчерпан: купленный товар можно скачать не более %d раз. Обратитесь в поддержку.", downloadService.Limit())
 }
 
 // ServeProductImage обрабатывает запрос на отображение изображения продукта
@@ -217,8 +413,8 @@ func (dc *DownloadController) ServeProductImage(c *gin.Context) {
 	}
 
 	// Получаем информацию о продукте
-	var product models.Product
-	if err := database.DB.First(&product, productID).Error; err != nil {
+	product, err := dc.products.FindByID(uint(productID))
+	if err != nil {
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
 			"error": "Продукт не найден",
 		})
@@ -255,3 +451,11 @@ func (dc *DownloadController) ServeProductImage(c *gin.Context) {
 	// Отправляем изображение
 	c.File(fullPath)
 }
+
+// serveFile отдает файл товара в ответ, записывая отправку в спан storage.serve_file
+func serveFile(c *gin.Context, path string) {
+	_, span := tracing.Start(c.Request.Context(), "storage.serve_file")
+	c.File(path)
+	span.SetAttributes(attribute.Int("file.bytes", c.Writer.Size()))
+	span.End()
+}
@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestServeProductFileReservesLimitBeforeStreaming(t *testing.T) {
+	store, repos := newTestStore()
+	seller := testUser(t, repos, "seller@example.com", models.RoleSeller)
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	product := testProduct(store, seller, "Шаблон", 100)
+	store.AddOrder(&models.Order{UserID: buyer.ID, Items: []models.OrderItem{{ProductID: product.ID}}})
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+
+	cfg := config.Defaults().Downloads
+	cfg.PerPurchaseLimit = 2
+	services.ConfigureDownloads(cfg)
+	t.Cleanup(func() { services.ConfigureDownloads(config.Defaults().Downloads) })
+
+	download := NewDownloadController(repos)
+	router := newTestRouter(t)
+	router.GET("/files/products/:productID", AuthRequired(sessions), download.ServeProductFile)
+
+	testUploads(t)
+
+	cookie := loginCookie(t, sessions, buyer)
+	target := "/files/products/" + idString(product.ID)
+	codes := make(chan int, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(router, http.MethodGet, target, cookie).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusOK] != 2 || counts[http.StatusForbidden] != 6 {
+		t.Errorf("параллельные скачивания: %v, ожидалось 2 успешных и 6 отказов", counts)
+	}
+	stats, err := repos.Downloads.Stats([]uint{product.ID}, repository.StatsPeriod{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stats[product.ID]; got.Downloads != 2 || got.Bytes != int64(2*len("архив")) {
+		t.Errorf("статистика скачиваний: %+v", got)
+	}
+
+	// Владельца лимит не ограничивает
+	if w := serve(router, http.MethodGet, target, loginCookie(t, sessions, seller)); w.Code != http.StatusOK {
+		t.Errorf("скачивание владельцем: статус %d", w.Code)
+	}
+}
+
+func TestServeProductFileResumeDoesNotCountAgainstLimit(t *testing.T) {
+	store, repos := newTestStore()
+	seller := testUser(t, repos, "seller@example.com", models.RoleSeller)
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	other := testUser(t, repos, "other@example.com", models.RoleUser)
+	product := testProduct(store, seller, "Шаблон", 100)
+	for _, user := range []models.User{buyer, other} {
+		store.AddOrder(&models.Order{UserID: user.ID, Items: []models.OrderItem{{ProductID: product.ID}}})
+	}
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+
+	cfg := config.Defaults().Downloads
+	cfg.PerPurchaseLimit = 1
+	services.ConfigureDownloads(cfg)
+	t.Cleanup(func() { services.ConfigureDownloads(config.Defaults().Downloads) })
+
+	router := newTestRouter(t)
+	router.GET("/files/products/:productID", AuthRequired(sessions), NewDownloadController(repos).ServeProductFile)
+	testUploads(t)
+
+	target := "/files/products/" + idString(product.ID)
+	download := func(user models.User, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.AddCookie(loginCookie(t, sessions, user))
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	ranged := func(spec string) http.Header { return http.Header{"Range": {spec}} }
+	downloads := func(user models.User) int64 {
+		count, err := repos.Downloads.CountByBuyer(user.ID, product.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// Первая попытка оборвалась, но лимит уже учтен
+	if w := download(buyer, ranged("bytes=0-3")); w.Code != http.StatusPartialContent {
+		t.Fatalf("начало скачивания: статус %d", w.Code)
+	}
+	// "архив" - 10 байт, с 5-го байта остается "хив"
+	w := download(buyer, ranged("bytes=4-"))
+	if w.Code != http.StatusPartialContent || w.Body.String() != "хив" {
+		t.Fatalf("докачка: статус %d, тело %q", w.Code, w.Body)
+	}
+	if w := download(buyer, http.Header{"Range": {"bytes=4-5"}, "If-Range": {`"other"`}}); w.Code != http.StatusPartialContent || w.Body.Len() != 2 {
+		t.Errorf("докачка с If-Range: статус %d, %d байт", w.Code, w.Body.Len())
+	}
+	if got := downloads(buyer); got != 1 {
+		t.Errorf("скачиваний в лимите %d, ожидалось 1", got)
+	}
+
+	// Запросы, которые отдают файл с начала, - новое скачивание сверх лимита
+	for _, header := range []http.Header{nil, ranged("bytes=0-"), ranged("bytes=-10"), ranged("bytes=4-5,0-")} {
+		if w := download(buyer, header); w.Code != http.StatusForbidden {
+			t.Errorf("Range %q: статус %d, ожидался 403", header.Get("Range"), w.Code)
+		}
+	}
+
+	// Без учтенного скачивания докачивать нечего: запрос резервирует скачивание как обычно
+	if w := download(other, ranged("bytes=4-")); w.Code != http.StatusPartialContent {
+		t.Fatalf("первый запрос с Range: статус %d", w.Code)
+	}
+	if got := downloads(other); got != 1 {
+		t.Errorf("скачиваний второго покупателя %d, ожидалось 1", got)
+	}
+}
+
+func TestIsRangeContinuation(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"bytes=0-", false},
+		{"bytes=0-99", false},
+		{"bytes=100-", true},
+		{"bytes=100-199", true},
+		{"bytes= 100-", true},
+		{"bytes=-100", false},
+		{"bytes=100-199,0-", false},
+		{"bytes=abc-", false},
+		{"items=100-", false},
+		{"bytes=100", false},
+	}
+	for _, tt := range tests {
+		if got := isRangeContinuation(tt.header); got != tt.want {
+			t.Errorf("isRangeContinuation(%q) = %v, ожидалось %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"context"
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/database"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Сколько ждать ответа от каждой зависимости при проверке готовности
+const readinessCheckTimeout = 2 * time.Second
+
+// healthCheck - одна проверка готовности. Некритичная проверка (SMTP) попадает в ответ,
+// но не снимает экземпляр с балансировки: без почты сайт продолжает работать.
+type healthCheck struct {
+	name     string
+	critical bool
+	run      func(ctx context.Context) error
+}
+
+// errCheckDisabled - зависимость не настроена, проверять нечего
+var errCheckDisabled = errors.New("отключено")
+
+// HealthController отвечает на проверки живости (/health/live) и готовности (/health/ready)
+type HealthController struct {
+	checks   []healthCheck
+	draining atomic.Bool
+}
+
+// NewHealthController создает контроллер проверок: база данных, каталог загрузок и SMTP
+func NewHealthController(smtp config.SMTPConfig, uploadDir string) *HealthController {
+	return &HealthController{
+		checks: []healthCheck{
+			{name: "database", critical: true, run: pingDatabase},
+			{name: "storage", critical: true, run: func(ctx context.Context) error { return checkUploadDir(uploadDir) }},
+			{name: "smtp", critical: false, run: func(ctx context.Context) error { return dialSMTP(ctx, smtp) }},
+		},
+	}
+}
+
+// SetDraining переводит экземпляр в режим остановки: проверка готовности начинает
+// отвечать 503, чтобы балансировщик перестал присылать новые запросы
+func (hc *HealthController) SetDraining() {
+	hc.draining.Store(true)
+}
+
+// Live отвечает, что процесс жив. Зависимости не проверяются, чтобы сбой базы
+// не приводил к перезапуску контейнера.
+func (hc *HealthController) Live(c *gin.Context) {
+	c.String(http.StatusOK, "OK")
+}
+
+// Ready проверяет зависимости и отвечает 200, если экземпляр готов принимать запросы
+func (hc *HealthController) Ready(c *gin.Context) {
+	if hc.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+
+	ready := true
+	checks := gin.H{}
+	for _, check := range hc.checks {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessCheckTimeout)
+		started := time.Now()
+		err := check.run(ctx)
+		cancel()
+
+		result := gin.H{"durationMs": time.Since(started).Milliseconds()}
+		switch {
+		case errors.Is(err, errCheckDisabled):
+			result["status"] = "disabled"
+		case err != nil:
+			result["status"] = "fail"
+			result["error"] = err.Error()
+			if check.critical {
+				ready = false
+			}
+		default:
+			result["status"] = "ok"
+		}
+		checks[check.name] = result
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"status": status, "checks": checks})
+}
+
+func pingDatabase(ctx context.Context) error {
+	if database.DB == nil {
+		return errors.New("база данных не подключена")
+	}
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// checkUploadDir проверяет, что каталог загрузок существует и доступен для записи
+func checkUploadDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s не является каталогом", dir)
+	}
+	probe, err := os.CreateTemp(dir, ".health-*")
+	if err != nil {
+		return fmt.Errorf("каталог недоступен для записи: %w", err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// dialSMTP проверяет, что SMTP-сервер принимает соединения
+func dialSMTP(ctx context.Context, smtp config.SMTPConfig) error {
+	if !smtp.Enabled() {
+		return errCheckDisabled
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(smtp.Host, strconv.Itoa(smtp.Port)))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"bytes"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/repository/memory"
+	"digital-marketplace/internal/services"
+	"digital-marketplace/internal/worker"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter создает роутер с шаблонами приложения, как в cmd/main.go, но без БД
+func newTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.SetFuncMap(TemplateFuncs())
+	router.LoadHTMLGlob("../../web/templates/*")
+	return router
+}
+
+// newTestAPI регистрирует маршруты /api поверх репозиториев repos с лимитами, которые тесты не исчерпают
+func newTestAPI(t *testing.T, repos repository.Repositories) *gin.Engine {
+	t.Helper()
+	router := newTestRouter(t)
+	rule := services.RateLimitRule{Limit: 1000, Period: time.Minute}
+	RegisterAPIRoutes(router, APIRoutesConfig{
+		Repos:       repos,
+		RateLimiter: services.NewRateLimitService(services.NewMemoryRateLimitStore(), services.DefaultLockoutPolicy),
+		APILimit:    rule,
+		AuthIPLimit: rule,
+		Jobs:        worker.NewSupervisor(),
+	})
+	return router
+}
+
+// testAccessToken выдает пользователю токен доступа к API
+func testAccessToken(t *testing.T, repos repository.Repositories, user models.User) string {
+	t.Helper()
+	plain, _, err := services.NewTokenService(repos.Tokens, repos.Users).CreateToken(user.ID, "тест", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+// testUser сохраняет пользователя в хранилище и возвращает его с присвоенным ID
+func testUser(t *testing.T, repos repository.Repositories, email string, role models.Role) models.User {
+	t.Helper()
+	user := models.User{Email: email, Username: strings.Split(email, "@")[0], Password: "-", Role: role}
+	if err := repos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// newTestStore создает пустое хранилище в памяти и репозитории поверх него
+func newTestStore() (*memory.Store, repository.Repositories) {
+	store := memory.New()
+	return store, store.Repositories()
+}
+
+// serve выполняет запрос с cookie и возвращает ответ
+func serve(router http.Handler, method, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// serveForm отправляет POST с полями формы и cookie
+func serveForm(router http.Handler, target string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// serveAPI выполняет запрос к JSON API с токеном доступа; body == nil - запрос без тела
+func serveAPI(router http.Handler, method, target, token string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// idString форматирует ID для пути запроса
+func idString(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// testProduct сохраняет товар продавца seller
+func testProduct(store *memory.Store, seller models.User, title string, price float64) models.Product {
+	product := models.Product{Title: title, Description: "Описание", Price: price, FilePath: "/uploads/test.zip", UserID: seller.ID}
+	store.AddProduct(&product)
+	return product
+}
+
+// testUploads переходит во временный рабочий каталог с файлом testProduct:
+// файлы товаров читаются относительно рабочего каталога
+func testUploads(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "uploads"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "uploads", "test.zip"), []byte("архив"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+}
+
+// loginCookie начинает сессию пользователя и возвращает cookie с ее токеном
+func loginCookie(t *testing.T, sessions *services.SessionService, user models.User) *http.Cookie {
+	t.Helper()
+	token, err := sessions.Create(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: sessionCookie, Value: token}
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/metrics"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPMetrics записывает длительность и статус каждого запроса в гистограмму по маршрутам.
+// Маршрут берется из шаблона (/products/:id), чтобы число рядов не росло с числом товаров.
+func HTTPMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		started := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequestDuration.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(started).Seconds())
+	}
+}
+
+// MetricsAccess пускает к /metrics адреса из cfg.AllowedNetworks, а при заданных
+// логине и пароле - любого, кто их передал (basic auth).
+// Адрес берется из TCP-соединения, а не из X-Forwarded-For: Prometheus обращается к приложению
+// напрямую, а запросы через nginx к /metrics не допускаются.
+func MetricsAccess(cfg config.MetricsConfig) gin.HandlerFunc {
+	var networks []*net.IPNet
+	for _, value := range cfg.AllowedNetworks {
+		// Значения проверены при загрузке конфигурации
+		if network, err := config.ParseNetwork(value); err == nil {
+			networks = append(networks, network)
+		}
+	}
+
+	return func(c *gin.Context) {
+		if ip := net.ParseIP(c.RemoteIP()); ip != nil {
+			for _, network := range networks {
+				if network.Contains(ip) {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		if !cfg.BasicAuth() {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		user, password, ok := c.Request.BasicAuth()
+		if ok &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(cfg.Username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(cfg.Password.Value())) == 1 {
+			c.Next()
+			return
+		}
+		c.Header("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
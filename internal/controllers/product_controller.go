@@ -1,10 +1,14 @@
 package controllers
 
 import (
-	"digital-marketplace/internal/database"
+	"digital-marketplace/internal/logging"
 	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
 	"digital-marketplace/internal/services"
+	"errors"
+	"fmt"
 	"html"
+	"math"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -18,11 +22,29 @@ var alphanumericRegex = regexp.MustCompile(`^[a-zA-Z0-9_\- ]+$`)
 
 type ProductController struct {
 	validationService *services.ValidationService
+	searchService     *services.ProductSearchService
+	orderService      *services.OrderService
+	reviewService     *services.ReviewService
+	wishlistService   *services.WishlistService
+	tagService        *services.TagService
+	uploads           *UploadController // Сборка архива при публикации новой версии
+	users             repository.UserRepository
+	products          repository.ProductRepository
+	tags              repository.TagRepository
 }
 
-func NewProductController() *ProductController {
+func NewProductController(repos repository.Repositories) *ProductController {
 	return &ProductController{
 		validationService: services.NewValidationService(),
+		searchService:     services.NewProductSearchService(repos.ProductSearch),
+		orderService:      services.NewOrderService(repos.Orders, repos.Carts),
+		reviewService:     services.NewReviewService(repos.Reviews, repos.Products, repos.Orders),
+		wishlistService:   services.NewWishlistService(repos.Wishlist, repos.Products, repos.Users),
+		tagService:        services.NewTagService(repos.Tags),
+		uploads:           NewUploadController(repos),
+		users:             repos.Users,
+		products:          repos.Products,
+		tags:              repos.Tags,
 	}
 }
 
@@ -42,113 +64,181 @@ func sanitizeQueryParam(param string) string {
 	return sanitized
 }
 
-// GetTags godoc
-// @Summary Get all available tags
-// @Description Retrieve a list of all product tags
-// @Tags products
-// @Accept  json
-// @Produce  json
-// @Success 200 {array} models.Tag
-// @Failure 500 {object} gin.H{"error": string}
-// @Router /api/tags [get]
+// GetTags возвращает все теги в алфавитном порядке со слагом, родителем и числом товаров (GET /api/tags).
+// Формат ответа описан в APISpec (операция legacyListTags).
 func (pc *ProductController) GetTags(c *gin.Context) {
-	var tags []models.Tag
-	result := database.DB.Order("name asc").Find(&tags)
-	if result.Error != nil {
+	tags, err := pc.tagService.List()
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
 		return
 	}
 	c.JSON(http.StatusOK, tags)
 }
 
-// GetProductsAPI godoc
-// @Summary Get products, optionally filtered by tags
-// @Description Retrieve a list of products. If 'tags' query parameter is provided (comma-separated), only products matching ALL specified tags are returned.
-// @Tags products
-// @Accept  json
-// @Produce  json
-// @Param   tags query string false "Comma-separated list of tag names to filter by"
-// @Success 200 {array} models.Product
-// @Failure 500 {object} gin.H{"error": string}
-// @Router /api/products [get]
+// GetProductsAPI возвращает страницу товаров (GET /api/products). Тело ответа - массив товаров,
+// курсор следующей страницы передается в заголовках X-Next-Cursor и Link.
+// Параметры и формат ответа описаны в APISpec (операция legacyListProducts).
 func (pc *ProductController) GetProductsAPI(c *gin.Context) {
-	var products []models.Product
-	tagsParam := c.Query("tags")
+	page, err := findProducts(c, pc.validationService, pc.searchService)
+	if err != nil {
+		var paramErr *productQueryError
+		if errors.As(err, &paramErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": paramErr.message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
 
-	// Используем ValidationService для очистки и валидации параметра
-	tagsQuery := pc.validationService.SanitizeQueryParam(tagsParam)
-	isValid, _ := pc.validationService.ValidateQueryParam(tagsQuery)
+	if page.Next != "" {
+		c.Header("X-Next-Cursor", page.Next)
+		c.Header("Link", "<"+nextPageURL(c, page.Next)+`>; rel="next"`)
+	}
+	c.JSON(http.StatusOK, page.Items)
+}
 
-	if !isValid {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Недопустимый формат параметра tags"})
-		return
+// productQueryError - параметр списка товаров не прошел валидацию, message показывается клиенту
+type productQueryError struct {
+	message string
+}
+
+func (e *productQueryError) Error() string {
+	return e.message
+}
+
+// parseProductQuery собирает параметры выдачи товаров из строки запроса:
+// q, tags (через запятую, также повторяющийся tag), tag_mode, min_price, max_price,
+// seller_id, seller (имя пользователя), sort, cursor и limit.
+func parseProductQuery(c *gin.Context, validationService *services.ValidationService) (services.ProductQuery, error) {
+	var query services.ProductQuery
+
+	query.Text = strings.TrimSpace(c.Query("q"))
+	if valid, errMsg := validationService.ValidateSearchQuery(query.Text); !valid {
+		return query, &productQueryError{errMsg}
 	}
 
-	if tagsQuery == "" {
-		// Если теги не указаны, просто получаем все продукты
-		result := database.DB.Find(&products)
-		if result.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
-			return
+	// Используем ValidationService для очистки и валидации параметра
+	tagsParam := c.Query("tags")
+	if extra := c.QueryArray("tag"); len(extra) > 0 {
+		tagsParam = strings.Join(append([]string{tagsParam}, extra...), ",")
+	}
+	tagsQuery := validationService.SanitizeQueryParam(tagsParam)
+
+	// Валидация тегов (каждый тег по отдельности: запятая - разделитель, а не часть имени)
+	for _, tag := range strings.Split(tagsQuery, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
 		}
-	} else {
-		// Если теги указаны, фильтруем
-		tagNames := strings.Split(tagsQuery, ",")
-		if len(tagNames) == 0 {
-			// Если пустой параметр tags=, возвращаем пустой список
-			c.JSON(http.StatusOK, []models.Product{})
-			return
+		// Тег задается именем (на любом языке) или слагом
+		if isValid, _ := validationService.ValidateTagName(tag); !isValid {
+			return query, &productQueryError{"Недопустимый формат параметра tags"}
 		}
+		// Несуществующие теги не отбрасываем: в режиме all с ними выдача пустая, как и раньше
+		query.Tags = append(query.Tags, services.NormalizeTagName(tag))
+	}
 
-		// Валидация тегов
-		var validTagNames []string
-		for _, tag := range tagNames {
-			tag = strings.TrimSpace(tag)
+	switch mode := services.TagMode(c.DefaultQuery("tag_mode", string(services.TagModeAll))); mode {
+	case services.TagModeAll, services.TagModeAny:
+		query.TagMode = mode
+	default:
+		return query, &productQueryError{"Параметр tag_mode должен быть all или any"}
+	}
+
+	var err error
+	if query.MinPrice, err = parsePriceParam(c, "min_price", validationService); err != nil {
+		return query, err
+	}
+	if query.MaxPrice, err = parsePriceParam(c, "max_price", validationService); err != nil {
+		return query, err
+	}
+	if query.MinPrice != nil && query.MaxPrice != nil && *query.MinPrice > *query.MaxPrice {
+		return query, &productQueryError{"min_price не может быть больше max_price"}
+	}
+
+	if sellerID := strings.TrimSpace(c.Query("seller_id")); sellerID != "" {
+		id, err := strconv.ParseUint(sellerID, 10, 64)
+		if err != nil || id == 0 {
+			return query, &productQueryError{"Недопустимый параметр seller_id"}
+		}
+		query.SellerID = uint(id)
+	}
+	if seller := strings.TrimSpace(c.Query("seller")); seller != "" {
+		if valid, _ := validationService.ValidateUsername(seller); !valid {
+			return query, &productQueryError{"Недопустимый параметр seller"}
+		}
+		query.SellerUsername = seller
+	}
 
-			// Используем ValidationService для валидации тега
-			isValid, _ := pc.validationService.ValidateTagName(tag)
-			if isValid {
-				validTagNames = append(validTagNames, tag)
-			}
+	if sort := strings.TrimSpace(c.Query("sort")); sort != "" {
+		if !services.ValidSort(services.ProductSort(sort)) {
+			return query, &productQueryError{"Параметр sort должен быть relevance, newest, price_asc, price_desc или popular"}
 		}
+		query.Sort = services.ProductSort(sort)
+	}
 
-		// Если все теги невалидны, возвращаем пустой список
-		if len(validTagNames) == 0 {
-			c.JSON(http.StatusOK, []models.Product{})
-			return
+	if limit := strings.TrimSpace(c.Query("limit")); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > services.MaxProductPageSize {
+			return query, &productQueryError{fmt.Sprintf("Параметр limit должен быть от 1 до %d", services.MaxProductPageSize)}
 		}
+		query.Limit = n
+	}
+
+	query.Cursor = strings.TrimSpace(c.Query("cursor"))
+	return query, nil
+}
 
-		// Используем параметризованный запрос для безопасности
-		query := `
-			SELECT p.*
-			FROM products p
-			JOIN product_tags pt ON p.id = pt.product_id
-			JOIN tags t ON pt.tag_id = t.id
-			WHERE t.name IN (?)
-			GROUP BY p.id
-			HAVING COUNT(DISTINCT t.id) = ?`
+// parsePriceParam разбирает необязательную границу цены
+func parsePriceParam(c *gin.Context, name string, validationService *services.ValidationService) (*float64, error) {
+	value := strings.TrimSpace(c.Query(name))
+	if value == "" {
+		return nil, nil
+	}
+	price, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(price) || math.IsInf(price, 0) {
+		return nil, &productQueryError{"Недопустимый параметр " + name}
+	}
+	if valid, errMsg := validationService.ValidatePrice(price); !valid {
+		return nil, &productQueryError{errMsg}
+	}
+	return &price, nil
+}
 
-		result := database.DB.Raw(query, validTagNames, len(validTagNames)).Scan(&products)
+// findProducts возвращает страницу товаров по параметрам запроса (см. parseProductQuery).
+// Используется страницей /products, старым /api/products и /api/v1.
+// Ошибки валидации параметров имеют тип *productQueryError.
+func findProducts(c *gin.Context, validationService *services.ValidationService, searchService *services.ProductSearchService) (*services.ProductPage, error) {
+	query, err := parseProductQuery(c, validationService)
+	if err != nil {
+		return nil, err
+	}
 
-		if result.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch filtered products"})
-			return
-		}
-		// Если result.RowsAffected == 0, вернется пустой слайс products, что корректно
+	page, err := searchService.Search(c.Request.Context(), query)
+	if errors.Is(err, services.ErrInvalidCursor) {
+		return nil, &productQueryError{"Недействительный параметр cursor"}
 	}
+	return page, err
+}
 
-	c.JSON(http.StatusOK, products)
+// nextPageURL возвращает текущий URL с курсором следующей страницы
+func nextPageURL(c *gin.Context, cursor string) string {
+	values := c.Request.URL.Query()
+	values.Set("cursor", cursor)
+	return c.Request.URL.Path + "?" + values.Encode()
 }
 
-// ShowProductDetail отображает детальную информацию о продукте по его ID
+// ShowProductDetail отображает страницу товара /products/:id-:slug.
+// Слаг служит только для читаемости: товар ищется по ID, а запросы по старому числовому
+// адресу (/products/42) или с устаревшим слагом перенаправляются (301) на канонический URL.
 func (pc *ProductController) ShowProductDetail(c *gin.Context) {
-	// Получаем ID продукта из параметров URL
-	productIDStr := c.Param("id")
+	// Параметр имеет вид "42-kurs-po-go" или просто "42"
+	idPart, _, _ := strings.Cut(c.Param("id"), "-")
 
 	// Валидация ID
-	productID, err := strconv.ParseUint(productIDStr, 10, 64)
+	productID, err := strconv.ParseUint(idPart, 10, 64)
 	if err != nil {
-		renderTemplate(c, "error.html", gin.H{
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{
 			"Error": "Неверный ID продукта",
 		})
 		return
@@ -157,70 +247,274 @@ func (pc *ProductController) ShowProductDetail(c *gin.Context) {
 	// Проверка ID через ValidationService
 	validID, errMsg := pc.validationService.ValidateProductID(uint(productID))
 	if !validID {
-		renderTemplate(c, "error.html", gin.H{
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{
 			"Error": errMsg,
 		})
 		return
 	}
 
 	// Получаем информацию о продукте
-	var product models.Product
-	if err := database.DB.First(&product, productID).Error; err != nil {
-		renderTemplate(c, "error.html", gin.H{
+	product, err := pc.products.FindByID(uint(productID))
+	if err != nil || !pc.canViewProduct(c, *product) {
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{
 			"Error": "Продукт не найден",
 		})
 		return
 	}
 
+	canonicalPath := services.ProductPath(product.ID, product.Title)
+	if c.Request.URL.Path != canonicalPath {
+		target := canonicalPath
+		if c.Request.URL.RawQuery != "" {
+			target += "?" + c.Request.URL.RawQuery
+		}
+		c.Redirect(http.StatusMovedPermanently, target)
+		return
+	}
+
+	pc.renderProductDetail(c, http.StatusOK, *product, nil)
+}
+
+// canViewProduct сообщает, видна ли страница товара текущему пользователю. Снятый с продажи товар
+// видят только продавец, покупатели (чтобы скачать файл) и модераторы.
+func (pc *ProductController) canViewProduct(c *gin.Context, product models.Product) bool {
+	if product.Listed() {
+		return true
+	}
+	user, loggedIn := getUserFromContext(c)
+	if !loggedIn {
+		return false
+	}
+	return user.ID == product.UserID ||
+		user.Role.Can(models.PermProductsModerate) ||
+		pc.orderService.HasPurchased(c.Request.Context(), user.ID, product.ID)
+}
+
+// renderProductDetail рендерит страницу товара. extra дополняет данные шаблона
+// (например, ошибку формы отзыва, когда страница показывается повторно после POST).
+func (pc *ProductController) renderProductDetail(c *gin.Context, status int, product models.Product, extra gin.H) {
 	// Получаем теги продукта
-	var tags []models.Tag
-	if err := database.DB.Joins("JOIN product_tags pt ON pt.tag_id = tags.id").
-		Where("pt.product_id = ?", productID).
-		Find(&tags).Error; err != nil {
+	tags, err := pc.tags.ListByProduct(product.ID)
+	if err != nil {
 		// Ошибка получения тегов не критична, просто показываем продукт без тегов
 		tags = []models.Tag{}
 	}
 
-	renderTemplate(c, "product_detail.html", gin.H{
-		"Product": product,
-		"Tags":    tags,
-	})
+	// Продавец и число его товаров
+	var seller models.User
+	var sellerProducts int64
+	if found, err := pc.users.FindByID(product.UserID); err == nil {
+		seller = *found
+		sellerProducts, _ = pc.products.CountBySeller(seller.ID)
+	}
+
+	// Владелец и покупатели видят ссылку на файл вместо кнопок покупки
+	user, loggedIn := getUserFromContext(c)
+	isOwner := loggedIn && user.ID == product.UserID
+	purchased := loggedIn && !isOwner && pc.orderService.HasPurchased(c.Request.Context(), user.ID, product.ID)
+	inWishlist := loggedIn && !isOwner && pc.wishlistService.Contains(user.ID, product.ID)
+
+	// Отзывы: покупатель видит форму со своим отзывом, продавец - формы ответа
+	reviews, err := pc.reviewService.ListReviews(product.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки отзывов", logging.ProductID(product.ID), logging.Err(err))
+	}
+	var myReview *models.Review
+	if purchased {
+		myReview, _ = pc.reviewService.GetUserReview(user.ID, product.ID)
+	}
+
+	hasImage := product.ImagePath != "" || product.FilePath != ""
+	imageURL := ""
+	if hasImage {
+		imageURL = services.BaseURL() + "/images/products/" + strconv.FormatUint(uint64(product.ID), 10)
+	}
+
+	canonicalPath := services.ProductPath(product.ID, product.Title)
+	data := gin.H{
+		"Product":         product,
+		"Tags":            tags,
+		"Seller":          seller,
+		"SellerProducts":  sellerProducts,
+		"SellerPath":      services.StorefrontPath(seller.Username),
+		"IsOwner":         isOwner,
+		"Purchased":       purchased,
+		"InWishlist":      inWishlist,
+		"HasImage":        hasImage,
+		"CanonicalPath":   canonicalPath,
+		"CanonicalURL":    services.BaseURL() + canonicalPath,
+		"ImageURL":        imageURL,
+		"MetaDescription": metaDescription(product.Description, product.Title),
+		"Reviews":         reviews,
+		"MyReview":        myReview,
+		"UserID":          user.ID,
+	}
+	for key, value := range extra {
+		data[key] = value
+	}
+	renderTemplateWithStatus(c, status, "product_detail.html", data)
 }
 
-// ShowProducts displays the page with all products
-func (pc *ProductController) ShowProducts(c *gin.Context) {
-	var products []models.Product // Assuming you have a Product model in internal/models
-	result := database.DB.Find(&products)
+// UpdatePrice меняет цену товара (POST /products/:id/price, только владелец).
+// При снижении цены подписчики из избранного получат письмо.
+func (pc *ProductController) UpdatePrice(c *gin.Context) {
+	product, ok := pc.loadOwnProduct(c)
+	if !ok {
+		return
+	}
 
-	if result.Error != nil {
-		// Handle error - maybe show an error page or log it
-		renderTemplate(c, "products.html", gin.H{
-			"Error":       "Не удалось загрузить товары",
-			"AllProducts": []models.Product{}, // Pass empty slice on error
-		})
+	price, err := strconv.ParseFloat(strings.TrimSpace(c.PostForm("price")), 64)
+	if err != nil {
+		pc.renderProductDetail(c, http.StatusBadRequest, product, gin.H{"ManageError": "Неверный формат цены. Введите числовое значение"})
+		return
+	}
+	if valid, errMsg := pc.validationService.ValidatePrice(price); !valid {
+		pc.renderProductDetail(c, http.StatusBadRequest, product, gin.H{"ManageError": errMsg})
 		return
 	}
 
-	renderTemplate(c, "products.html", gin.H{
-		"AllProducts": products,
+	updated := product
+	if err := pc.products.Update(c.Request.Context(), &updated, repository.ProductChanges{Price: &price}); err != nil {
+		requestLogger(c).Error("Ошибка изменения цены", logging.ProductID(product.ID), logging.Err(err))
+		pc.renderProductDetail(c, http.StatusInternalServerError, product, gin.H{"ManageError": "Не удалось сохранить цену"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, services.ProductPath(product.ID, product.Title))
+}
+
+// UpdateWatermark включает или выключает водяные знаки в скачиваемых файлах
+// (POST /products/:id/watermark, только владелец)
+func (pc *ProductController) UpdateWatermark(c *gin.Context) {
+	product, ok := pc.loadOwnProduct(c)
+	if !ok {
+		return
+	}
+
+	enabled := c.PostForm("watermark") == "on"
+	updated := product
+	if err := pc.products.Update(c.Request.Context(), &updated, repository.ProductChanges{Watermark: &enabled}); err != nil {
+		requestLogger(c).Error("Ошибка изменения настройки водяных знаков", logging.ProductID(product.ID), logging.Err(err))
+		pc.renderProductDetail(c, http.StatusInternalServerError, product, gin.H{"ManageError": "Не удалось сохранить настройку водяных знаков"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, services.ProductPath(product.ID, product.Title))
+}
+
+// PublishVersion загружает новую версию файлов товара (POST /products/:id/versions, только владелец).
+// Подписчики из избранного получат письмо о выходе версии.
+func (pc *ProductController) PublishVersion(c *gin.Context) {
+	product, ok := pc.loadOwnProduct(c)
+	if !ok {
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		pc.renderProductDetail(c, http.StatusBadRequest, product, gin.H{"ManageError": "Ошибка при обработке формы"})
+		return
+	}
+
+	updated, errMsg := pc.uploads.publishNewVersion(c, product, form.File["files"])
+	if errMsg != "" {
+		pc.renderProductDetail(c, http.StatusBadRequest, product, gin.H{"ManageError": errMsg})
+		return
+	}
+
+	pc.renderProductDetail(c, http.StatusOK, *updated, gin.H{
+		"ManageSuccess": fmt.Sprintf("Версия %d опубликована", updated.Version),
 	})
 }
 
-// ShowProductsPage рендерит HTML страницу со всеми продуктами
+// loadOwnProduct загружает товар по параметру :id и проверяет, что текущий пользователь - его продавец.
+// При ошибке страница уже отрисована.
+func (pc *ProductController) loadOwnProduct(c *gin.Context) (models.Product, bool) {
+	var product models.Product
+	user, _ := getUserFromContext(c)
+
+	idPart, _, _ := strings.Cut(c.Param("id"), "-")
+	productID, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Неверный ID продукта"})
+		return product, false
+	}
+
+	found, err := pc.products.FindByID(uint(productID))
+	if err != nil {
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Продукт не найден"})
+		return product, false
+	}
+	product = *found
+	if product.UserID != user.ID {
+		renderTemplateWithStatus(c, http.StatusForbidden, "error.html", gin.H{"Error": "Изменять товар может только его продавец"})
+		return product, false
+	}
+	return product, true
+}
+
+// metaDescription - короткое описание для meta description и OpenGraph (до 200 символов, в одну строку)
+func metaDescription(description, fallback string) string {
+	text := strings.Join(strings.Fields(description), " ")
+	if text == "" {
+		return fallback
+	}
+	if runes := []rune(text); len(runes) > 200 {
+		text = strings.TrimSpace(string(runes[:197])) + "..."
+	}
+	return text
+}
+
+// ShowProductsPage рендерит HTML страницу с товарами. Поддерживает те же параметры, что и /api/products:
+// поиск, фильтры, сортировку и переход на следующую страницу по курсору.
 func (pc *ProductController) ShowProductsPage(c *gin.Context) {
-	// Возможно, здесь не нужно загружать все продукты, если фронтенд все равно их запросит через API?
-	// Оставим пока для примера, но для SPA это может быть избыточно.
-	var products []models.Product
-	database.DB.Find(&products) // Просто получаем все для начальной отрисовки
+	var (
+		products []services.ProductHit
+		nextURL  string
+		errMsg   string
+	)
+
+	page, err := findProducts(c, pc.validationService, pc.searchService)
+	if err != nil {
+		var paramErr *productQueryError
+		if errors.As(err, &paramErr) {
+			errMsg = paramErr.message
+		} else {
+			errMsg = "Не удалось загрузить товары"
+		}
+		products = []services.ProductHit{}
+	} else {
+		products = page.Items
+		if page.Next != "" {
+			nextURL = nextPageURL(c, page.Next)
+		}
+	}
 
 	// Получаем все теги для отображения фильтров
-	var tags []models.Tag
-	database.DB.Order("name asc").Find(&tags)
+	tags, err := pc.tags.List()
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки тегов", logging.Err(err))
+	}
+
+	selectedTags := make(map[string]bool)
+	for _, tag := range c.QueryArray("tag") {
+		selectedTags[tag] = true
+	}
+	for _, tag := range strings.Split(c.Query("tags"), ",") {
+		selectedTags[strings.TrimSpace(tag)] = true
+	}
 
 	renderTemplate(c, "products.html", gin.H{
-		"AllProducts": products, // Для начального отображения (может быть пустым)
-		"AllTags":     tags,     // Для рендеринга фильтров
-		// Error handling можно добавить по аналогии с GetProductsAPI
+		"AllProducts":  products,
+		"AllTags":      tags, // Для рендеринга фильтров
+		"SelectedTags": selectedTags,
+		"Query":        strings.TrimSpace(c.Query("q")),
+		"Sort":         c.Query("sort"),
+		"TagMode":      c.Query("tag_mode"),
+		"MinPrice":     c.Query("min_price"),
+		"MaxPrice":     c.Query("max_price"),
+		"NextURL":      nextURL,
+		"Error":        errMsg,
 	})
 }
 
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/services"
+	"net/http"
+	"testing"
+)
+
+func TestShowProductDetailRedirectsToCanonicalPath(t *testing.T) {
+	store, repos := newTestStore()
+	seller := testUser(t, repos, "seller@example.com", models.RoleSeller)
+	product := testProduct(store, seller, "Шаблон резюме", 100)
+	canonical := services.ProductPath(product.ID, product.Title)
+	id := idString(product.ID)
+
+	router := newTestRouter(t)
+	router.GET("/products/:id", NewProductController(repos).ShowProductDetail)
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"без слага", "/products/" + id, canonical},
+		{"старый слаг", "/products/" + id + "-staryi-slag", canonical},
+		// Метки рекламных кампаний и прочие параметры не теряются при перенаправлении
+		{"с параметрами", "/products/" + id + "?utm_source=mail&ref=a%2Fb", canonical + "?utm_source=mail&ref=a%2Fb"},
+		{"пустой запрос", "/products/" + id + "?", canonical},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(router, http.MethodGet, tt.target)
+			if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tt.want {
+				t.Errorf("статус %d, Location %q, ожидалось 301 и %q", w.Code, w.Header().Get("Location"), tt.want)
+			}
+		})
+	}
+
+	if w := serve(router, http.MethodGet, canonical+"?utm_source=mail"); w.Code != http.StatusOK {
+		t.Errorf("канонический адрес: статус %d, ожидался 200", w.Code)
+	}
+}
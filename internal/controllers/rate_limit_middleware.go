@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/services"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitByIP ограничивает частоту запросов с одного IP-адреса.
+// scope разделяет ведра разных групп маршрутов (например, "auth" и "api").
+func RateLimitByIP(limiter *services.RateLimitService, scope string, rule services.RateLimitRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := fmt.Sprintf("%s:ip:%s", scope, c.ClientIP())
+		if allowed, wait := limiter.Allow(c.Request.Context(), key, rule); !allowed {
+			abortTooManyRequests(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RateLimitByAccount ограничивает частоту запросов для одного аккаунта.
+// Для авторизованных пользователей ключом служит ID, для форм входа и регистрации - email из формы.
+func RateLimitByAccount(limiter *services.RateLimitService, scope string, rule services.RateLimitRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		account := ""
+		if user, exists := getUserFromContext(c); exists {
+			account = "user:" + strconv.FormatUint(uint64(user.ID), 10)
+		} else if email := strings.ToLower(strings.TrimSpace(c.PostForm("email"))); email != "" {
+			account = "email:" + email
+		}
+
+		// Без идентификатора аккаунта ограничивать нечего, остается лимит по IP
+		if account == "" {
+			c.Next()
+			return
+		}
+
+		key := fmt.Sprintf("%s:account:%s", scope, account)
+		if allowed, wait := limiter.Allow(c.Request.Context(), key, rule); !allowed {
+			abortTooManyRequests(c, wait)
+			return
+		}
+		c.Next()
+	}
+}
+
+// abortTooManyRequests прерывает запрос с кодом 429 и заголовком Retry-After
+func abortTooManyRequests(c *gin.Context, wait time.Duration) {
+	seconds := retryAfterSeconds(wait)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	requestLogger(c).Warn("Превышен лимит запросов", slog.Int("retry_after_s", seconds))
+
+	message := fmt.Sprintf("Слишком много запросов. Повторите попытку через %d сек.", seconds)
+	if isAPIv1Path(c) {
+		apiError(c, http.StatusTooManyRequests, apiCodeRateLimited, message)
+		return
+	}
+	if strings.HasPrefix(c.Request.URL.Path, "/api") {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": message})
+		return
+	}
+	renderTemplateWithStatus(c, http.StatusTooManyRequests, "error.html", gin.H{"Error": message})
+	c.Abort()
+}
+
+// retryAfterSeconds округляет время ожидания вверх до целых секунд (минимум 1)
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
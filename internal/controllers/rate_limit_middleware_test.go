@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitByIPIgnoresForwardedForFromUntrustedClients(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies([]string{"10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	limiter := services.NewRateLimitService(services.NewMemoryRateLimitStore(), services.DefaultLockoutPolicy)
+	router.GET("/api/ping", RateLimitByIP(limiter, "test", services.RateLimitRule{Limit: 1, Period: time.Hour}),
+		func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		want         int
+	}{
+		{"первый запрос клиента", "203.0.113.5:4000", "1.1.1.1", http.StatusNoContent},
+		// Клиент подставляет другой X-Forwarded-For, но ведро то же - по адресу соединения
+		{"подмена X-Forwarded-For", "203.0.113.5:4001", "2.2.2.2", http.StatusTooManyRequests},
+		// За nginx адрес берется из последнего элемента, который добавил сам nginx
+		{"клиент за прокси", "10.0.0.1:5000", "9.9.9.9, 198.51.100.7", http.StatusNoContent},
+		{"подмена за прокси", "10.0.0.1:5001", "8.8.8.8, 198.51.100.7", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/ping", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: статус %d, ожидался %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	limiter := services.NewRateLimitService(services.NewMemoryRateLimitStore(), services.DefaultLockoutPolicy).
+		WithClock(func() time.Time { return now })
+	router.GET("/api/ping", RateLimitByIP(limiter, "test", services.RateLimitRule{Limit: 1, Period: time.Minute}),
+		func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	tests := []struct {
+		at         time.Duration
+		want       int
+		retryAfter string
+	}{
+		{0, http.StatusNoContent, ""},
+		{0, http.StatusTooManyRequests, "60"},
+		// Дробные секунды округляются вверх
+		{30*time.Second + 200*time.Millisecond, http.StatusTooManyRequests, "30"},
+		{time.Minute, http.StatusNoContent, ""},
+	}
+	for i, tt := range tests {
+		now = start.Add(tt.at)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/ping", nil))
+		if w.Code != tt.want || w.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("запрос %d: статус %d, Retry-After %q, ожидалось %d и %q",
+				i+1, w.Code, w.Header().Get("Retry-After"), tt.want, tt.retryAfter)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"bytes"
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/logging"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestLoggerOmitsDownloadToken(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(logging.NewHandler(config.LogConfig{Level: slog.LevelDebug}, &logs)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	_, repos := newTestStore()
+	router := newTestRouter(t)
+	router.Use(RequestLogger())
+	router.GET("/download/:token", NewDownloadController(repos).HandleDownload)
+
+	const token = "f3c1a9d2e8b74c6a"
+	serve(router, http.MethodGet, "/download/"+token)
+
+	if strings.Contains(logs.String(), token) {
+		t.Fatalf("токен скачивания попал в журнал: %s", logs.String())
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(logs.Bytes()), &entry); err != nil {
+		t.Fatalf("строка журнала %q: %v", logs.String(), err)
+	}
+	if entry["route"] != "/download/:token" {
+		t.Errorf("маршрут в журнале %v, ожидался /download/:token", entry["route"])
+	}
+}
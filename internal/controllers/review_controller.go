@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReviewController обрабатывает формы отзывов на странице товара
+type ReviewController struct {
+	validationService *services.ValidationService
+	reviewService     *services.ReviewService
+	products          *ProductController // Для повторного показа страницы товара с ошибкой
+	productRepo       repository.ProductRepository
+}
+
+func NewReviewController(repos repository.Repositories, products *ProductController) *ReviewController {
+	return &ReviewController{
+		validationService: services.NewValidationService(),
+		reviewService:     services.NewReviewService(repos.Reviews, repos.Products, repos.Orders),
+		products:          products,
+		productRepo:       repos.Products,
+	}
+}
+
+// SaveReview создает или изменяет отзыв текущего пользователя (POST /products/:id/reviews)
+func (rc *ReviewController) SaveReview(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	idPart, _, _ := strings.Cut(c.Param("id"), "-")
+	productID, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Неверный ID продукта"})
+		return
+	}
+
+	found, err := rc.productRepo.FindByID(uint(productID))
+	if err != nil {
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Продукт не найден"})
+		return
+	}
+	product := *found
+
+	rating, _ := strconv.Atoi(c.PostForm("rating"))
+	text := c.PostForm("text")
+	if valid, errMsg := rc.validationService.ValidateReview(rating, text); !valid {
+		rc.products.renderProductDetail(c, http.StatusBadRequest, product, gin.H{"ReviewError": errMsg})
+		return
+	}
+
+	if _, _, err := rc.reviewService.SaveReview(user.ID, product.ID, rating, text); err != nil {
+		if errors.Is(err, services.ErrReviewNotAllowed) {
+			rc.products.renderProductDetail(c, http.StatusForbidden, product, gin.H{"ReviewError": "Отзыв могут оставить только покупатели товара"})
+			return
+		}
+		requestLogger(c).Error("Ошибка сохранения отзыва", logging.ProductID(product.ID), logging.Err(err))
+		rc.products.renderProductDetail(c, http.StatusInternalServerError, product, gin.H{"ReviewError": "Не удалось сохранить отзыв"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, services.ProductPath(product.ID, product.Title)+"#reviews")
+}
+
+// ReplyToReview сохраняет ответ продавца на отзыв (POST /reviews/:reviewID/reply)
+func (rc *ReviewController) ReplyToReview(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	review, ok := rc.loadReviewParam(c)
+	if !ok {
+		return
+	}
+
+	reply := c.PostForm("reply")
+	if valid, errMsg := rc.validationService.ValidateReviewReply(reply); !valid {
+		rc.products.renderProductDetail(c, http.StatusBadRequest, review.Product, gin.H{"ReviewError": errMsg})
+		return
+	}
+
+	if _, err := rc.reviewService.Reply(user.ID, review.ID, reply); err != nil {
+		if errors.Is(err, services.ErrNotProductSeller) {
+			rc.products.renderProductDetail(c, http.StatusForbidden, review.Product, gin.H{"ReviewError": "Ответить на отзыв может только продавец товара"})
+			return
+		}
+		requestLogger(c).Error("Ошибка сохранения ответа на отзыв", slog.Uint64("review_id", uint64(review.ID)), logging.Err(err))
+		rc.products.renderProductDetail(c, http.StatusInternalServerError, review.Product, gin.H{"ReviewError": "Не удалось сохранить ответ"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, services.ProductPath(review.Product.ID, review.Product.Title)+"#reviews")
+}
+
+// FlagReview отправляет отзыв на проверку модератору (POST /reviews/:reviewID/flag)
+func (rc *ReviewController) FlagReview(c *gin.Context) {
+	review, ok := rc.loadReviewParam(c)
+	if !ok {
+		return
+	}
+
+	if err := rc.reviewService.Flag(review.ID); err != nil {
+		requestLogger(c).Error("Ошибка отправки жалобы на отзыв", slog.Uint64("review_id", uint64(review.ID)), logging.Err(err))
+		rc.products.renderProductDetail(c, http.StatusInternalServerError, review.Product, gin.H{"ReviewError": "Не удалось отправить жалобу"})
+		return
+	}
+
+	rc.products.renderProductDetail(c, http.StatusOK, review.Product, gin.H{"ReviewSuccess": "Спасибо, отзыв отправлен на проверку модератору"})
+}
+
+// loadReviewParam загружает видимый отзыв по параметру :reviewID вместе с товаром.
+// При ошибке страница уже отрисована.
+func (rc *ReviewController) loadReviewParam(c *gin.Context) (models.Review, bool) {
+	var review models.Review
+
+	reviewID, err := strconv.ParseUint(c.Param("reviewID"), 10, 64)
+	if err != nil {
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Неверный ID отзыва"})
+		return review, false
+	}
+
+	found, err := rc.reviewService.VisibleReview(uint(reviewID))
+	if errors.Is(err, services.ErrReviewNotFound) {
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Отзыв не найден"})
+		return review, false
+	}
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки отзыва", logging.Err(err))
+		renderTemplateWithStatus(c, http.StatusInternalServerError, "error.html", gin.H{"Error": "Не удалось загрузить отзыв"})
+		return review, false
+	}
+	return *found, true
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/services"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestSaveAndFlagReview(t *testing.T) {
+	store, repos := newTestStore()
+	seller := testUser(t, repos, "seller@example.com", models.RoleSeller)
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	stranger := testUser(t, repos, "stranger@example.com", models.RoleUser)
+	product := testProduct(store, seller, "Шаблон", 100)
+	store.AddOrder(&models.Order{UserID: buyer.ID, Items: []models.OrderItem{{ProductID: product.ID}}})
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+
+	review := NewReviewController(repos, NewProductController(repos))
+	router := newTestRouter(t)
+	authenticated := router.Group("/", AuthRequired(sessions))
+	authenticated.POST("/products/:id/reviews", review.SaveReview)
+	authenticated.POST("/reviews/:reviewID/flag", review.FlagReview)
+
+	target := "/products/" + idString(product.ID) + "/reviews"
+	form := url.Values{"rating": {"4"}, "text": {"Хороший шаблон"}}
+	if w := serveForm(router, target, form, loginCookie(t, sessions, stranger)); w.Code != http.StatusForbidden {
+		t.Errorf("не покупатель: статус %d, ожидался 403", w.Code)
+	}
+	if w := serveForm(router, target, url.Values{"rating": {"9"}}, loginCookie(t, sessions, buyer)); w.Code != http.StatusBadRequest {
+		t.Errorf("оценка вне диапазона: статус %d, ожидался 400", w.Code)
+	}
+
+	cookie := loginCookie(t, sessions, buyer)
+	if w := serveForm(router, target, form, cookie); w.Code != http.StatusFound {
+		t.Fatalf("отзыв покупателя: статус %d", w.Code)
+	}
+	// Повторная отправка меняет существующий отзыв
+	form.Set("rating", "2")
+	if w := serveForm(router, target, form, cookie); w.Code != http.StatusFound {
+		t.Fatalf("изменение отзыва: статус %d", w.Code)
+	}
+	saved, err := repos.Reviews.FindByUser(buyer.ID, product.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Rating != 2 {
+		t.Errorf("оценка %d, ожидалась 2", saved.Rating)
+	}
+	if updated, _ := repos.Products.FindByID(product.ID); updated.ReviewCount != 1 || updated.RatingAvg != 2 {
+		t.Errorf("рейтинг товара %v по %d отзывам, ожидалось 2 по 1", updated.RatingAvg, updated.ReviewCount)
+	}
+
+	flag := "/reviews/" + idString(saved.ID) + "/flag"
+	if w := serve(router, http.MethodPost, flag, loginCookie(t, sessions, stranger)); w.Code != http.StatusOK {
+		t.Fatalf("жалоба: статус %d", w.Code)
+	}
+	if flagged, _ := repos.Reviews.FindByID(saved.ID); !flagged.Flagged {
+		t.Error("отзыв не отмечен жалобой")
+	}
+
+	// Скрытый отзыв недоступен для жалоб
+	if err := repos.Reviews.SetHidden(context.Background(), saved.ID, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(router, http.MethodPost, flag, loginCookie(t, sessions, stranger)); w.Code != http.StatusNotFound {
+		t.Errorf("жалоба на скрытый отзыв: статус %d, ожидался 404", w.Code)
+	}
+}
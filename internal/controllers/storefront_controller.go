@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Каталог для аватаров продавцов
+const avatarUploadDir = "./uploads/avatars"
+
+// StorefrontController обслуживает публичные витрины продавцов /u/:username
+// и форму настроек витрины в профиле
+type StorefrontController struct {
+	validationService *services.ValidationService
+	searchService     *services.ProductSearchService
+	storefrontService *services.StorefrontService
+	auth              *AuthController // Для повторного показа профиля с ошибкой
+	users             repository.UserRepository
+}
+
+func NewStorefrontController(repos repository.Repositories, auth *AuthController) *StorefrontController {
+	return &StorefrontController{
+		validationService: services.NewValidationService(),
+		searchService:     services.NewProductSearchService(repos.ProductSearch),
+		storefrontService: services.NewStorefrontService(repos.Users, repos.Products),
+		auth:              auth,
+		users:             repos.Users,
+	}
+}
+
+// ShowStorefront отображает витрину продавца: описание, аватар, ссылки, статистику
+// и товары продавца с курсорной пагинацией (те же параметры sort, q, limit, cursor, что и у /products).
+// Имя ищется без учета регистра; запрос с другим регистром перенаправляется на канонический адрес.
+func (sc *StorefrontController) ShowStorefront(c *gin.Context) {
+	seller, err := sc.storefrontService.FindSeller(c.Param("username"))
+	if err != nil {
+		if !errors.Is(err, services.ErrStorefrontNotFound) {
+			requestLogger(c).Error("Ошибка загрузки витрины", slog.String("username", c.Param("username")), logging.Err(err))
+		}
+		renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Продавец не найден"})
+		return
+	}
+
+	storefrontPath := services.StorefrontPath(seller.Username)
+	if c.Param("username") != seller.Username {
+		target := storefrontPath
+		if c.Request.URL.RawQuery != "" {
+			target += "?" + c.Request.URL.RawQuery
+		}
+		c.Redirect(http.StatusMovedPermanently, target)
+		return
+	}
+
+	var (
+		products = []services.ProductHit{}
+		nextURL  string
+		errMsg   string
+	)
+	query, err := parseProductQuery(c, sc.validationService)
+	if err == nil {
+		// Витрина всегда показывает только товары этого продавца
+		query.SellerID = seller.ID
+		query.SellerUsername = ""
+
+		var page *services.ProductPage
+		page, err = sc.searchService.Search(c.Request.Context(), query)
+		if errors.Is(err, services.ErrInvalidCursor) {
+			err = &productQueryError{"Недействительный параметр cursor"}
+		}
+		if err == nil {
+			products = page.Items
+			if page.Next != "" {
+				nextURL = nextPageURL(c, page.Next)
+			}
+		}
+	}
+	if err != nil {
+		var paramErr *productQueryError
+		if errors.As(err, &paramErr) {
+			errMsg = paramErr.message
+		} else {
+			requestLogger(c).Error("Ошибка загрузки товаров витрины", slog.Uint64("seller_id", uint64(seller.ID)), logging.Err(err))
+			errMsg = "Не удалось загрузить товары"
+		}
+	}
+
+	stats, err := sc.storefrontService.Stats(seller.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка подсчета статистики продавца", slog.Uint64("seller_id", uint64(seller.ID)), logging.Err(err))
+	}
+
+	user, loggedIn := getUserFromContext(c)
+	renderTemplate(c, "storefront.html", gin.H{
+		"Seller":          seller,
+		"AvatarURL":       avatarURL(*seller),
+		"Links":           services.UserSocialLinks(*seller),
+		"Stats":           stats,
+		"Products":        products,
+		"NextURL":         nextURL,
+		"Sort":            c.Query("sort"),
+		"Query":           strings.TrimSpace(c.Query("q")),
+		"Error":           errMsg,
+		"IsOwner":         loggedIn && user.ID == seller.ID,
+		"StorefrontPath":  storefrontPath,
+		"CanonicalURL":    services.BaseURL() + storefrontPath,
+		"MetaDescription": metaDescription(seller.Bio, "Товары продавца "+seller.Username),
+	})
+}
+
+// SaveSettings сохраняет настройки витрины из профиля (POST /profile/storefront):
+// описание, ссылки на соцсети (по одной на строку) и необязательный новый аватар
+func (sc *StorefrontController) SaveSettings(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	bio := c.PostForm("bio")
+	links := services.ParseSocialLinks(c.PostForm("social_links"))
+	if valid, errMsg := sc.validationService.ValidateBio(bio); !valid {
+		sc.showProfileError(c, errMsg)
+		return
+	}
+	if valid, errMsg := sc.validationService.ValidateSocialLinks(links); !valid {
+		sc.showProfileError(c, errMsg)
+		return
+	}
+
+	if avatar, err := c.FormFile("avatar"); err == nil {
+		if valid, errMsg := sc.validationService.ValidateFile(avatar, false); !valid {
+			sc.showProfileError(c, "Проблема с аватаром: "+errMsg)
+			return
+		}
+		if err := os.MkdirAll(avatarUploadDir, os.ModePerm); err != nil {
+			requestLogger(c).Error("Не удалось создать директорию аватаров", logging.Err(err))
+			sc.showProfileError(c, "Не удалось сохранить аватар")
+			return
+		}
+
+		ext := strings.ToLower(filepath.Ext(avatar.Filename))
+		fileName := fmt.Sprintf("%d_%d%s", user.ID, time.Now().UnixNano(), ext)
+		if err := c.SaveUploadedFile(avatar, filepath.Join(avatarUploadDir, fileName)); err != nil {
+			requestLogger(c).Error("Ошибка сохранения аватара", logging.Err(err))
+			sc.showProfileError(c, "Не удалось сохранить аватар")
+			return
+		}
+		sc.replaceAvatar(c, user.ID, "/uploads/avatars/"+fileName)
+	} else if c.PostForm("remove_avatar") != "" {
+		sc.replaceAvatar(c, user.ID, "")
+	}
+
+	if err := sc.storefrontService.UpdateSettings(user.ID, bio, links); err != nil {
+		requestLogger(c).Error("Ошибка сохранения витрины", logging.Err(err))
+		sc.showProfileError(c, "Не удалось сохранить настройки витрины")
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/profile#storefront")
+}
+
+// replaceAvatar сохраняет новый путь аватара и удаляет файл прежнего
+func (sc *StorefrontController) replaceAvatar(c *gin.Context, userID uint, path string) {
+	oldPath, err := sc.storefrontService.SetAvatar(userID, path)
+	if err != nil {
+		requestLogger(c).Error("Ошибка обновления аватара", logging.Err(err))
+		return
+	}
+	if oldPath != "" && oldPath != path {
+		if err := os.Remove(filepath.Join(".", strings.TrimPrefix(oldPath, "/"))); err != nil && !os.IsNotExist(err) {
+			requestLogger(c).Warn("Не удалось удалить старый аватар", slog.String("path", oldPath), logging.Err(err))
+		}
+	}
+}
+
+func (sc *StorefrontController) showProfileError(c *gin.Context, message string) {
+	c.Set("storefront_error", message)
+	sc.auth.ShowProfile(c)
+}
+
+// ServeAvatar отдает аватар пользователя (GET /images/avatars/:userID)
+func (sc *StorefrontController) ServeAvatar(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("userID"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Некорректный идентификатор пользователя"})
+		return
+	}
+
+	user, err := sc.users.FindByID(uint(userID))
+	if err != nil || user.AvatarPath == "" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Аватар не найден"})
+		return
+	}
+
+	fullPath := filepath.Join(".", strings.TrimPrefix(user.AvatarPath, "/"))
+	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Файл аватара не найден"})
+		return
+	}
+	c.File(fullPath)
+}
+
+// avatarURL возвращает адрес аватара пользователя или пустую строку, если аватара нет.
+// Имя файла в параметре v меняется при загрузке нового аватара и сбрасывает кэш браузера.
+func avatarURL(user models.User) string {
+	if user.AvatarPath == "" {
+		return ""
+	}
+	return "/images/avatars/" + strconv.FormatUint(uint64(user.ID), 10) + "?v=" + strings.TrimSuffix(filepath.Base(user.AvatarPath), filepath.Ext(user.AvatarPath))
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/services"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// TemplateFuncs возвращает дополнительные функции для HTML-шаблонов
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"subtract": func(a, b float64) float64 {
+			return a - b
+		},
+		"stars":       stars,
+		"productPath": services.ProductPath,
+	}
+}
+
+// stars рисует оценку звездочками: 3.6 -> "★★★★☆". Принимает средний рейтинг (float64) и оценку отзыва (int).
+func stars(rating interface{}) string {
+	var filled int
+	switch value := rating.(type) {
+	case int:
+		filled = value
+	case float64:
+		filled = int(math.Round(value))
+	}
+	if filled < 0 {
+		filled = 0
+	}
+	if filled > 5 {
+		filled = 5
+	}
+	return strings.Repeat("★", filled) + strings.Repeat("☆", 5-filled)
+}
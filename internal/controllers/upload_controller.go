@@ -2,11 +2,17 @@ package controllers
 
 import (
 	"archive/zip"
-	"digital-marketplace/internal/database"
+	"context"
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/metrics"
 	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
 	"digital-marketplace/internal/services"
+	"digital-marketplace/internal/tracing"
 	"fmt"
 	"io"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -15,7 +21,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Список разрешенных MIME-типов для изображений
@@ -60,11 +66,17 @@ var allowedProductFileExtensions = map[string]bool{
 
 type UploadController struct {
 	validationService *services.ValidationService
+	tagService        *services.TagService
+	tags              repository.TagRepository
+	products          repository.ProductRepository
 }
 
-func NewUploadController() *UploadController {
+func NewUploadController(repos repository.Repositories) *UploadController {
 	return &UploadController{
 		validationService: services.NewValidationService(),
+		tagService:        services.NewTagService(repos.Tags),
+		tags:              repos.Tags,
+		products:          repos.Products,
 	}
 }
 
@@ -80,12 +92,11 @@ func NewUploadController() *UploadController {
 
 func (uc *UploadController) ShowUploadPage(c *gin.Context) {
 	// Загружаем существующие теги для передачи в шаблон
-	var existingTags []models.Tag
-	dbResult := database.DB.Order("name asc").Find(&existingTags)
-	if dbResult.Error != nil {
+	existingTags, err := uc.tags.List()
+	if err != nil {
 		// Логгируем ошибку, но продолжаем рендерить страницу,
 		// возможно, без списка существующих тегов
-		fmt.Println("Error fetching existing tags:", dbResult.Error)
+		requestLogger(c).Error("Ошибка загрузки тегов", logging.Err(err))
 		renderTemplate(c, "upload.html", gin.H{"Error": "Could not load existing tags."})
 		return
 	}
@@ -110,33 +121,53 @@ func (uc *UploadController) HandleUpload(c *gin.Context) {
 	description := strings.TrimSpace(c.PostForm("description"))
 	priceStr := strings.TrimSpace(c.PostForm("price"))
 
-	// Проверка названия товара
-	if valid, errMsg := uc.validationService.ValidateTitle(title); !valid {
-		renderTemplate(c, "upload.html", gin.H{"Error": errMsg})
+	// Конвертируем цену (остальная валидация - в createProduct)
+	price, err := strconv.ParseFloat(priceStr, 64)
+	if err != nil {
+		renderTemplate(c, "upload.html", gin.H{
+			"Error":       "Неверный формат цены. Введите числовое значение",
+			"Title":       title,
+			"Description": description,
+		})
 		return
 	}
 
-	// Проверка описания товара
-	if valid, errMsg := uc.validationService.ValidateDescription(description); !valid {
+	// Обработка загруженного изображения товара
+	image, err := c.FormFile("image")
+	if err != nil {
 		renderTemplate(c, "upload.html", gin.H{
-			"Error": errMsg,
-			"Title": title,
+			"Error":       "Ошибка при загрузке изображения товара",
+			"Title":       title,
+			"Description": description,
 		})
 		return
 	}
 
-	// Конвертируем и проверяем цену
-	price, err := strconv.ParseFloat(priceStr, 64)
+	// Обработка файлов продукта
+	form, err := c.MultipartForm()
 	if err != nil {
 		renderTemplate(c, "upload.html", gin.H{
-			"Error":       "Неверный формат цены. Введите числовое значение",
+			"Error":       "Ошибка при обработке формы",
 			"Title":       title,
 			"Description": description,
 		})
 		return
 	}
 
-	if valid, errMsg := uc.validationService.ValidatePrice(price); !valid {
+	// Форма загрузки присылает выбранные теги одним списком имен в tags_list
+	newTagNames := append(strings.Split(c.PostForm("new_tags_list"), ","), strings.Split(c.PostForm("tags_list"), ",")...)
+
+	_, errMsg := uc.createProduct(c, user, productUploadInput{
+		Title:          title,
+		Description:    description,
+		Price:          price,
+		ExistingTagIDs: c.PostFormArray("existing_tags"), // Массив строк ID
+		NewTagNames:    newTagNames,
+		Image:          image,
+		Files:          form.File["files"],
+		Watermark:      c.PostForm("watermark") == "on",
+	})
+	if errMsg != "" {
 		renderTemplate(c, "upload.html", gin.H{
 			"Error":       errMsg,
 			"Title":       title,
@@ -145,145 +176,148 @@ func (uc *UploadController) HandleUpload(c *gin.Context) {
 		return
 	}
 
-	// Получаем теги из формы
-	existingTagIDsStr := c.PostFormArray("existing_tags") // Массив строк ID
-	newTagsListStr := c.PostForm("new_tags_list")         // Новый способ: читаем из скрытого поля
+	// Успешное завершение
+	c.Redirect(http.StatusFound, "/profile")
+}
 
-	// --- Обработка тегов ---
-	var tagIDs []int
-	processedTagNames := make(map[string]bool) // Для избежания дубликатов по имени
+// productUploadInput содержит данные нового товара из HTML-формы или JSON API
+type productUploadInput struct {
+	Title          string
+	Description    string
+	Price          float64
+	ExistingTagIDs []string
+	NewTagNames    []string
+	Image          *multipart.FileHeader
+	Files          []*multipart.FileHeader
+	Watermark      bool // Встраивать в скачиваемые файлы данные покупателя
+}
 
-	// 1. Обрабатываем существующие выбранные теги
-	for _, idStr := range existingTagIDsStr {
-		id, err := strconv.Atoi(idStr)
-		if err == nil {
-			tagIDs = append(tagIDs, id)
-			// Можно добавить получение имени тега по ID и добавление в processedTagNames,
-			// чтобы новые теги с таким же именем не дублировались
-		}
+// createProduct валидирует данные, собирает архив файлов и сохраняет товар с тегами.
+// Возвращает созданный товар или сообщение об ошибке для пользователя.
+func (uc *UploadController) createProduct(c *gin.Context, user models.User, input productUploadInput) (*models.Product, string) {
+	// Проверка названия товара
+	if valid, errMsg := uc.validationService.ValidateTitle(input.Title); !valid {
+		return nil, errMsg
 	}
 
-	// 2. Обрабатываем новые теги из newTagsListStr
-	newTagNames := strings.Split(newTagsListStr, ",") // Новый способ
-	for _, name := range newTagNames {
-		trimmedName := strings.TrimSpace(name)
-		if trimmedName == "" {
-			continue // Пропускаем пустые строки
-		}
-
-		// **ВАЛИДАЦИЯ ИМЕНИ ТЕГА (Серверная)** с использованием ValidationService
-		if valid, errMsg := uc.validationService.ValidateTagName(trimmedName); !valid {
-			renderTemplate(c, "upload.html", gin.H{
-				"Error":       errMsg,
-				"Title":       title,
-				"Description": description,
-			})
-			return
-		}
+	// Проверка описания товара
+	if valid, errMsg := uc.validationService.ValidateDescription(input.Description); !valid {
+		return nil, errMsg
+	}
 
-		// Проверяем, не обрабатывали ли уже тег с таким именем (из существующих или новых)
-		lowerCaseName := strings.ToLower(trimmedName)
-		if processedTagNames[lowerCaseName] {
-			continue
-		}
+	if valid, errMsg := uc.validationService.ValidatePrice(input.Price); !valid {
+		return nil, errMsg
+	}
 
-		var tag models.Tag
-		// Ищем или создаем тег (регистронезависимо)
-		err := database.DB.Where("lower(name) = ?", lowerCaseName).FirstOrCreate(&tag, models.Tag{Name: trimmedName}).Error
-		if err != nil {
-			renderTemplate(c, "upload.html", gin.H{
-				"Error":       fmt.Sprintf("Ошибка обработки тега '%s': %v", trimmedName, err),
-				"Title":       title,
-				"Description": description,
-			})
-			return
-		}
+	// --- Обработка тегов ---
+	_, tagSpan := tracing.Start(c.Request.Context(), "upload.resolve_tags",
+		attribute.Int("tags.existing", len(input.ExistingTagIDs)), attribute.Int("tags.new", len(input.NewTagNames)))
+	tagIDs, errMsg := uc.resolveTagIDs(input.ExistingTagIDs, input.NewTagNames)
+	tracing.EndMessage(tagSpan, errMsg)
+	if errMsg != "" {
+		return nil, errMsg
+	}
 
-		tagIDs = append(tagIDs, tag.ID)
-		processedTagNames[lowerCaseName] = true
+	// Валидация изображения
+	if valid, errMsg := uc.validationService.ValidateFile(input.Image, false); !valid {
+		return nil, fmt.Sprintf("Проблема с изображением товара: %s", errMsg)
 	}
 
-	// Удаляем дубликаты ID, если они могли появиться
-	tagIDs = uniqueInts(tagIDs)
-	// --- Конец обработки тегов ---
+	zipFilePath, webZipPath, errMsg := uc.buildProductArchive(c, input.Files)
+	if errMsg != "" {
+		return nil, errMsg
+	}
 
-	// Обеспечим существование директории загрузок
-	uploadDir := "./uploads"
-	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
-		renderTemplate(c, "upload.html", gin.H{
-			"Error":       "Не удалось создать директорию для загрузок: " + err.Error(),
-			"Title":       title,
-			"Description": description,
-		})
-		return
+	// Создаем запись о товаре в БД
+	product := models.Product{
+		Title:       input.Title,
+		Description: input.Description,
+		Price:       input.Price,
+		FilePath:    webZipPath,
+		UserID:      user.ID,
+		CreatedAt:   time.Now(),
+		Watermark:   input.Watermark,
 	}
 
-	// Текущее время для уникальных имен файлов
-	timestamp := time.Now().UnixNano()
+	// --- Сохранение товара с тегами; с первым товаром покупатель становится продавцом ---
+	txCtx, txSpan := tracing.Start(c.Request.Context(), "upload.save_product")
+	err := uc.products.Create(txCtx, &product, tagIDs)
+	tracing.End(txSpan, err)
 
-	// Обработка загруженного изображения товара
-	image, err := c.FormFile("image")
 	if err != nil {
-		renderTemplate(c, "upload.html", gin.H{
-			"Error":       "Ошибка при загрузке изображения товара",
-			"Title":       title,
-			"Description": description,
-		})
-		return
+		// Ошибка транзакции: удаляем созданные файлы и показываем ошибку
+		os.Remove(zipFilePath)
+		return nil, "Ошибка сохранения товара или тегов: " + err.Error()
 	}
 
-	// Валидация изображения
-	if valid, errMsg := uc.validationService.ValidateFile(image, false); !valid {
-		renderTemplate(c, "upload.html", gin.H{
-			"Error":       fmt.Sprintf("Проблема с изображением товара: %s", errMsg),
-			"Title":       title,
-			"Description": description,
-		})
-		return
+	metrics.Uploads.WithLabelValues("product").Inc()
+	return &product, ""
+}
+
+// publishNewVersion заменяет файлы товара новым архивом, увеличивает номер версии
+// и ставит в очередь уведомления для тех, у кого товар в избранном.
+// Возвращает обновленный товар или сообщение об ошибке для пользователя.
+func (uc *UploadController) publishNewVersion(c *gin.Context, product models.Product, files []*multipart.FileHeader) (*models.Product, string) {
+	if len(files) == 0 {
+		return nil, "Выберите файлы новой версии"
 	}
 
-	// Обработка файлов продукта
-	form, err := c.MultipartForm()
+	zipFilePath, webZipPath, errMsg := uc.buildProductArchive(c, files)
+	if errMsg != "" {
+		return nil, errMsg
+	}
+
+	oldFilePath := product.FilePath
+	txCtx, txSpan := tracing.Start(c.Request.Context(), "upload.save_version", attribute.Int("product.id", int(product.ID)))
+	err := uc.products.PublishVersion(txCtx, &product, webZipPath)
+	tracing.End(txSpan, err)
 	if err != nil {
-		renderTemplate(c, "upload.html", gin.H{
-			"Error":       "Ошибка при обработке формы",
-			"Title":       title,
-			"Description": description,
-		})
-		return
+		os.Remove(zipFilePath)
+		return nil, "Ошибка сохранения новой версии: " + err.Error()
+	}
+
+	// Старый архив больше не нужен: скачивание всегда отдает текущую версию
+	if oldFilePath != "" && oldFilePath != webZipPath {
+		if err := os.Remove(filepath.Join(".", strings.TrimPrefix(oldFilePath, "/"))); err != nil && !os.IsNotExist(err) {
+			requestLogger(c).Warn("Не удалось удалить старый архив", logging.ProductID(product.ID), slog.String("path", oldFilePath), logging.Err(err))
+		}
 	}
 
-	files := form.File["files"]
+	metrics.Uploads.WithLabelValues("version").Inc()
+	return &product, ""
+}
+
+// buildProductArchive проверяет файлы товара и упаковывает их в zip-архив в ./uploads.
+// Возвращает путь к архиву на диске, путь для сохранения в Product.FilePath
+// или сообщение об ошибке для пользователя.
+func (uc *UploadController) buildProductArchive(c *gin.Context, files []*multipart.FileHeader) (zipFilePath, webZipPath, errMsg string) {
+	ctx, span := tracing.Start(c.Request.Context(), "upload.build_archive", attribute.Int("files", len(files)))
+	defer func() { tracing.EndMessage(span, errMsg) }()
+
+	// Обеспечим существование директории загрузок
+	uploadDir := "./uploads"
+	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
+		return "", "", "Не удалось создать директорию для загрузок: " + err.Error()
+	}
+
+	// Текущее время для уникальных имен файлов
+	timestamp := time.Now().UnixNano()
+
 	if len(files) > maxProductFiles {
-		renderTemplate(c, "upload.html", gin.H{
-			"Error":       fmt.Sprintf("Превышено максимальное количество файлов (%d)", maxProductFiles),
-			"Title":       title,
-			"Description": description,
-		})
-		return
+		return "", "", fmt.Sprintf("Превышено максимальное количество файлов (%d)", maxProductFiles)
 	}
 
 	// Валидация каждого файла продукта
 	for _, file := range files {
 		if valid, errMsg := uc.validationService.ValidateFile(file, true); !valid {
-			renderTemplate(c, "upload.html", gin.H{
-				"Error":       fmt.Sprintf("Проблема с файлом %s: %s", file.Filename, errMsg),
-				"Title":       title,
-				"Description": description,
-			})
-			return
+			return "", "", fmt.Sprintf("Проблема с файлом %s: %s", file.Filename, errMsg)
 		}
 	}
 
 	// Создаем временную директорию для загружаемых файлов
 	tempDir := filepath.Join(uploadDir, fmt.Sprintf("temp_%d", timestamp))
 	if err := os.MkdirAll(tempDir, os.ModePerm); err != nil {
-		renderTemplate(c, "upload.html", gin.H{
-			"Error":       "Не удалось создать временную директорию: " + err.Error(),
-			"Title":       title,
-			"Description": description,
-		})
-		return
+		return "", "", "Не удалось создать временную директорию: " + err.Error()
 	}
 	defer os.RemoveAll(tempDir) // Удаляем временную директорию после использования
 
@@ -296,80 +330,92 @@ func (uc *UploadController) HandleUpload(c *gin.Context) {
 		tempFilename := filepath.Join(tempDir, safeFilename)
 
 		// Сохраняем файл
-		if err := c.SaveUploadedFile(file, tempFilename); err != nil {
-			renderTemplate(c, "upload.html", gin.H{
-				"Error":       "Не удалось сохранить файл: " + err.Error(),
-				"Title":       title,
-				"Description": description,
-			})
-			return
+		_, saveSpan := tracing.Start(ctx, "storage.save_upload", attribute.Int64("file.size", file.Size))
+		err := c.SaveUploadedFile(file, tempFilename)
+		tracing.End(saveSpan, err)
+		if err != nil {
+			return "", "", "Не удалось сохранить файл: " + err.Error()
 		}
 	}
 
 	// Создаем архив
 	zipFilename := fmt.Sprintf("%d_product_files.zip", timestamp)
-	zipFilePath := filepath.Join(uploadDir, zipFilename)
-	webZipPath := "/uploads/" + zipFilename
+	zipFilePath = filepath.Join(uploadDir, zipFilename)
+	webZipPath = "/uploads/" + zipFilename
 
 	// Создаем архив с файлами
-	if err := createZipArchive(tempDir, zipFilePath); err != nil {
-		renderTemplate(c, "upload.html", gin.H{
-			"Error":       "Не удалось создать архив: " + err.Error(),
-			"Title":       title,
-			"Description": description,
-		})
-		return
+	if err := createZipArchive(ctx, tempDir, zipFilePath); err != nil {
+		return "", "", "Не удалось создать архив: " + err.Error()
 	}
 
-	// Создаем запись о товаре в БД
-	product := models.Product{
-		Title:       title,
-		Description: description,
-		Price:       price,
-		FilePath:    webZipPath,
-		UserID:      user.ID,
-		CreatedAt:   time.Now(),
+	return zipFilePath, webZipPath, ""
+}
+
+// resolveTagIDs превращает выбранные ID существующих тегов и имена новых тегов в список ID,
+// создавая новые теги при необходимости
+func (uc *UploadController) resolveTagIDs(existingTagIDs []string, newTagNames []string) ([]uint, string) {
+	var tagIDs []uint
+	processedTagNames := make(map[string]bool) // Для избежания дубликатов по имени
+
+	// 1. Обрабатываем существующие выбранные теги
+	var selectedIDs []uint
+	for _, idStr := range existingTagIDs {
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err == nil {
+			selectedIDs = append(selectedIDs, uint(id))
+		}
+	}
+	if len(selectedIDs) > 0 {
+		// Несуществующие ID пропускаем: иначе вставка нарушит внешний ключ product_tags.tag_id
+		existing, err := uc.tags.ExistingIDs(selectedIDs)
+		if err != nil {
+			return nil, fmt.Sprintf("Ошибка проверки тегов: %v", err)
+		}
+		tagIDs = append(tagIDs, existing...)
 	}
 
-	// --- Сохранение в БД в транзакции ---
-	err = database.DB.Transaction(func(tx *gorm.DB) error {
-		// 1. Создаем продукт
-		if err := tx.Create(&product).Error; err != nil {
-			return err // Возвращаем ошибку для отката транзакции
+	// 2. Обрабатываем новые теги
+	for _, name := range newTagNames {
+		trimmedName := strings.TrimSpace(name)
+		if trimmedName == "" {
+			continue // Пропускаем пустые строки
 		}
 
-		// 2. Создаем связи с тегами
-		if len(tagIDs) > 0 {
-			var productTags []models.ProductTag
-			for _, tagID := range tagIDs {
-				productTags = append(productTags, models.ProductTag{ProductID: product.ID, TagID: tagID})
-			}
-			if err := tx.Create(&productTags).Error; err != nil {
-				return err // Возвращаем ошибку для отката транзакции
-			}
+		// **ВАЛИДАЦИЯ ИМЕНИ ТЕГА (Серверная)** с использованием ValidationService
+		if valid, errMsg := uc.validationService.ValidateTagName(trimmedName); !valid {
+			return nil, errMsg
 		}
 
-		return nil // Все успешно, коммитим транзакцию
-	})
+		// Проверяем, не обрабатывали ли уже тег с таким именем
+		lowerCaseName := strings.ToLower(services.NormalizeTagName(trimmedName))
+		if processedTagNames[lowerCaseName] {
+			continue
+		}
 
-	if err != nil {
-		// Ошибка транзакции: удаляем созданные файлы и показываем ошибку
-		os.Remove(zipFilePath)
-		renderTemplate(c, "upload.html", gin.H{
-			"Error":       "Ошибка сохранения товара или тегов: " + err.Error(),
-			"Title":       title,
-			"Description": description,
-		})
-		return
+		// Ищем или создаем тег (регистронезависимо, со слагом)
+		tag, err := uc.tagService.FindOrCreate(trimmedName)
+		if err != nil {
+			return nil, fmt.Sprintf("Ошибка обработки тега '%s': %v", trimmedName, err)
+		}
+
+		tagIDs = append(tagIDs, tag.ID)
+		processedTagNames[lowerCaseName] = true
 	}
-	// --- Конец сохранения в БД ---
 
-	// Успешное завершение
-	c.Redirect(http.StatusFound, "/profile")
+	// Удаляем дубликаты ID, если они могли появиться
+	return uniqueIDs(tagIDs), ""
 }
 
 // Функция для создания zip-архива из файлов в директории
-func createZipArchive(sourceDir, destinationPath string) error {
+func createZipArchive(ctx context.Context, sourceDir, destinationPath string) (err error) {
+	_, span := tracing.Start(ctx, "archive.create_zip")
+	var files int
+	var written int64
+	defer func() {
+		span.SetAttributes(attribute.Int("archive.files", files), attribute.Int64("archive.source_bytes", written))
+		tracing.End(span, err)
+	}()
+
 	// Создаем файл архива
 	zipFile, err := os.Create(destinationPath)
 	if err != nil {
@@ -412,18 +458,20 @@ func createZipArchive(sourceDir, destinationPath string) error {
 		}
 
 		// Копируем содержимое файла в архив
-		_, err = io.Copy(zipFileEntry, file)
+		n, err := io.Copy(zipFileEntry, file)
+		files++
+		written += n
 		return err
 	})
 
 	return err
 }
 
-// Вспомогательная функция для удаления дубликатов из слайса int
-func uniqueInts(intSlice []int) []int {
-	keys := make(map[int]bool)
-	list := []int{}
-	for _, entry := range intSlice {
+// Вспомогательная функция для удаления дубликатов из слайса ID
+func uniqueIDs(ids []uint) []uint {
+	keys := make(map[uint]bool)
+	list := []uint{}
+	for _, entry := range ids {
 		if _, value := keys[entry]; !value {
 			keys[entry] = true
 			list = append(list, entry)
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WishlistController struct {
+	wishlistService *services.WishlistService
+}
+
+func NewWishlistController(repos repository.Repositories) *WishlistController {
+	return &WishlistController{
+		wishlistService: services.NewWishlistService(repos.Wishlist, repos.Products, repos.Users),
+	}
+}
+
+// ShowWishlist показывает избранное пользователя
+func (wc *WishlistController) ShowWishlist(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	items, err := wc.wishlistService.List(user.ID)
+	if err != nil {
+		requestLogger(c).Error("Ошибка загрузки избранного", logging.Err(err))
+		renderTemplateWithStatus(c, http.StatusInternalServerError, "wishlist.html", gin.H{
+			"Error": "Не удалось загрузить избранное. Попробуйте снова.",
+		})
+		return
+	}
+
+	renderTemplate(c, "wishlist.html", gin.H{"Items": items})
+}
+
+// AddToWishlist добавляет товар в избранное (POST /wishlist/add/:productID)
+func (wc *WishlistController) AddToWishlist(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	productID, err := strconv.ParseUint(c.Param("productID"), 10, 64)
+	if err != nil {
+		renderTemplateWithStatus(c, http.StatusBadRequest, "error.html", gin.H{"Error": "Неверный ID продукта"})
+		return
+	}
+
+	if _, _, err := wc.wishlistService.Add(user.ID, uint(productID)); err != nil {
+		switch {
+		case errors.Is(err, services.ErrProductNotFound):
+			renderTemplateWithStatus(c, http.StatusNotFound, "error.html", gin.H{"Error": "Продукт не найден"})
+		case errors.Is(err, services.ErrWishlistOwnProduct):
+			renderTemplateWithStatus(c, http.StatusBadRequest, "error.html", gin.H{"Error": "Нельзя добавить в избранное свой собственный товар"})
+		default:
+			requestLogger(c).Error("Ошибка добавления товара в избранное", logging.ProductID(uint(productID)), logging.Err(err))
+			renderTemplateWithStatus(c, http.StatusInternalServerError, "error.html", gin.H{"Error": "Не удалось добавить товар в избранное"})
+		}
+		return
+	}
+
+	redirectBack(c, "/wishlist")
+}
+
+// RemoveFromWishlist удаляет товар из избранного (POST /wishlist/remove/:productID)
+func (wc *WishlistController) RemoveFromWishlist(c *gin.Context) {
+	user, _ := getUserFromContext(c)
+
+	productID, err := strconv.ParseUint(c.Param("productID"), 10, 64)
+	if err != nil {
+		renderTemplateWithStatus(c, http.StatusBadRequest, "error.html", gin.H{"Error": "Неверный ID продукта"})
+		return
+	}
+
+	// Товара уже нет в избранном - результат тот же, ошибку не показываем
+	if err := wc.wishlistService.Remove(user.ID, uint(productID)); err != nil && !errors.Is(err, services.ErrWishlistItemNotFound) {
+		requestLogger(c).Error("Ошибка удаления товара из избранного", logging.ProductID(uint(productID)), logging.Err(err))
+		renderTemplateWithStatus(c, http.StatusInternalServerError, "error.html", gin.H{"Error": "Не удалось удалить товар из избранного"})
+		return
+	}
+
+	redirectBack(c, "/wishlist")
+}
+
+// redirectBack возвращает пользователя на страницу, с которой отправлена форма.
+// Принимаются только локальные пути, чтобы Referer нельзя было использовать для открытого редиректа.
+func redirectBack(c *gin.Context, fallback string) {
+	target := fallback
+	if referer := c.Request.Referer(); referer != "" {
+		if u, err := c.Request.URL.Parse(referer); err == nil && u.Host == c.Request.Host {
+			target = u.RequestURI()
+		}
+	}
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		target = fallback
+	}
+	c.Redirect(http.StatusFound, target)
+}
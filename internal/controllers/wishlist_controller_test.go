@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/services"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestWishlistAddAndRemove(t *testing.T) {
+	store, repos := newTestStore()
+	seller := testUser(t, repos, "seller@example.com", models.RoleSeller)
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	product := testProduct(store, seller, "Шаблон", 100)
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+
+	wishlist := NewWishlistController(repos)
+	router := newTestRouter(t)
+	authenticated := router.Group("/", AuthRequired(sessions))
+	authenticated.POST("/wishlist/add/:productID", wishlist.AddToWishlist)
+	authenticated.POST("/wishlist/remove/:productID", wishlist.RemoveFromWishlist)
+
+	target := "/wishlist/add/" + idString(product.ID)
+	if w := serve(router, http.MethodPost, target, loginCookie(t, sessions, seller)); w.Code != http.StatusBadRequest {
+		t.Errorf("свой товар: статус %d, ожидался 400", w.Code)
+	}
+	if w := serve(router, http.MethodPost, "/wishlist/add/999", loginCookie(t, sessions, buyer)); w.Code != http.StatusNotFound {
+		t.Errorf("несуществующий товар: статус %d, ожидался 404", w.Code)
+	}
+
+	cookie := loginCookie(t, sessions, buyer)
+	for i := 0; i < 2; i++ {
+		if w := serve(router, http.MethodPost, target, cookie); w.Code != http.StatusFound {
+			t.Fatalf("добавление #%d: статус %d", i+1, w.Code)
+		}
+	}
+	items, err := repos.Wishlist.ListByUser(buyer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ProductID != product.ID {
+		t.Fatalf("избранное после повторного добавления: %+v", items)
+	}
+
+	// Удаление отсутствующего товара не считается ошибкой
+	for i := 0; i < 2; i++ {
+		if w := serve(router, http.MethodPost, "/wishlist/remove/"+idString(product.ID), cookie); w.Code != http.StatusFound {
+			t.Fatalf("удаление #%d: статус %d", i+1, w.Code)
+		}
+	}
+	if in, _ := repos.Wishlist.Contains(buyer.ID, product.ID); in {
+		t.Error("товар остался в избранном")
+	}
+}
+
+func TestUpdatePriceNotifiesWishlistOnDrop(t *testing.T) {
+	store, repos := newTestStore()
+	seller := testUser(t, repos, "seller@example.com", models.RoleSeller)
+	buyer := testUser(t, repos, "buyer@example.com", models.RoleUser)
+	product := testProduct(store, seller, "Шаблон", 100)
+	if _, err := repos.Wishlist.Add(&models.WishlistItem{UserID: buyer.ID, ProductID: product.ID}); err != nil {
+		t.Fatal(err)
+	}
+	sessions := services.NewSessionService(repos.Sessions, repos.Users)
+
+	prod := NewProductController(repos)
+	router := newTestRouter(t)
+	router.POST("/products/:id/price", AuthRequired(sessions), prod.UpdatePrice)
+	target := "/products/" + idString(product.ID) + "/price"
+
+	if w := serveForm(router, target, url.Values{"price": {"50"}}, loginCookie(t, sessions, buyer)); w.Code != http.StatusForbidden {
+		t.Errorf("чужой товар: статус %d, ожидался 403", w.Code)
+	}
+	if w := serveForm(router, target, url.Values{"price": {"дешево"}}, loginCookie(t, sessions, seller)); w.Code != http.StatusBadRequest {
+		t.Errorf("неверная цена: статус %d, ожидался 400", w.Code)
+	}
+
+	cookie := loginCookie(t, sessions, seller)
+	if w := serveForm(router, target, url.Values{"price": {"150"}}, cookie); w.Code != http.StatusFound {
+		t.Fatalf("повышение цены: статус %d", w.Code)
+	}
+	if events := store.WishlistNotifications(); len(events) != 0 {
+		t.Fatalf("повышение цены создало уведомления: %+v", events)
+	}
+
+	if w := serveForm(router, target, url.Values{"price": {"80"}}, cookie); w.Code != http.StatusFound {
+		t.Fatalf("снижение цены: статус %d", w.Code)
+	}
+	events := store.WishlistNotifications()
+	if len(events) != 1 {
+		t.Fatalf("ожидалось одно уведомление, получено %d", len(events))
+	}
+	if e := events[0]; e.UserID != buyer.ID || e.Kind != models.WishlistNotificationPriceDrop || e.OldPrice != 150 || e.NewPrice != 80 {
+		t.Errorf("неожиданное уведомление: %+v", e)
+	}
+	if saved, _ := repos.Products.FindByID(product.ID); saved.Price != 80 {
+		t.Errorf("цена в хранилище %v, ожидалась 80", saved.Price)
+	}
+}
@@ -1,11 +1,13 @@
 package database
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 
-	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/database/migrations"
+	"digital-marketplace/internal/tracing"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -13,45 +15,49 @@ import (
 
 var DB *gorm.DB
 
-func InitDB() {
-	host := os.Getenv("DB_HOST")
-	if host == "" {
-		host = "localhost"
+// Connect открывает соединение с PostgreSQL. Запросы с контекстом (DB.WithContext)
+// попадают в трассу этого контекста как спаны gorm.*.
+func Connect(cfg config.DatabaseConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{})
+	if err != nil {
+		return nil, err
 	}
-	port := os.Getenv("DB_PORT")
-	if port == "" {
-		port = "5432"
+	if err := db.Use(tracing.NewPlugin()); err != nil {
+		return nil, err
 	}
-	user := os.Getenv("DB_USER")
-	if user == "" {
-		user = "postgres"
+	return db, nil
+}
+
+// Migrate применяет непримененные миграции схемы (см. пакет migrations)
+func Migrate(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
 	}
-	password := os.Getenv("DB_PASSWORD")
-	if password == "" {
-		password = "postgres"
+	runner, err := migrations.New(sqlDB)
+	if err != nil {
+		return err
 	}
-	dbname := os.Getenv("DB_NAME")
-	if dbname == "" {
-		dbname = "marketplace"
+	applied, err := runner.Up(context.Background())
+	for _, m := range applied {
+		slog.Info("Применена миграция", slog.String("migration", m.String()))
 	}
+	return err
+}
 
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		host, user, password, dbname, port)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// InitDB подключается к базе, применяет миграции и сохраняет подключение в DB
+func InitDB(cfg config.DatabaseConfig) error {
+	db, err := Connect(cfg)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		return fmt.Errorf("подключение к базе данных: %w", err)
 	}
 
-	err = db.AutoMigrate(
-		&models.User{},
-		&models.Product{},
-		&models.CartItem{},
-		&models.Order{},
-		&models.OrderItem{},
-	)
-	if err != nil {
-		log.Fatal("Migration failed:", err)
+	if err := Migrate(db); err != nil {
+		return fmt.Errorf("применение миграций: %w", err)
 	}
 
+	detectTrigramSearch(db)
+
 	DB = db
+	return nil
 }
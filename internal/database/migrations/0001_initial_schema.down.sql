@@ -0,0 +1,16 @@
+-- Удаляет все таблицы приложения вместе с данными
+DROP TABLE IF EXISTS
+	personal_access_tokens,
+	user_identities,
+	login_attempts,
+	rate_limit_buckets,
+	wishlist_notifications,
+	wishlist_items,
+	reviews,
+	order_items,
+	orders,
+	cart_items,
+	product_tags,
+	tags,
+	products,
+	users;
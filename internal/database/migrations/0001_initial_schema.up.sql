@@ -0,0 +1,156 @@
+-- Базовая схема: таблицы, которые раньше создавал AutoMigrate.
+-- IF NOT EXISTS позволяет применить миграцию к базе, созданной AutoMigrate до появления миграций.
+
+CREATE TABLE IF NOT EXISTS users (
+	id bigserial PRIMARY KEY,
+	username varchar(255),
+	email text NOT NULL,
+	password text NOT NULL,
+	balance decimal DEFAULT 0,
+	generated_password boolean DEFAULT false,
+	created_at timestamptz,
+	bio text,
+	avatar_path text,
+	social_links text,
+	CONSTRAINT uni_users_email UNIQUE (email)
+);
+
+CREATE TABLE IF NOT EXISTS products (
+	id bigserial PRIMARY KEY,
+	title text NOT NULL,
+	description text,
+	price decimal NOT NULL DEFAULT 0,
+	file_path text NOT NULL,
+	image_path text,
+	user_id bigint,
+	created_at timestamptz,
+	version bigint NOT NULL DEFAULT 1,
+	rating_avg decimal NOT NULL DEFAULT 0,
+	review_count bigint NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+	id bigserial PRIMARY KEY,
+	name text NOT NULL,
+	slug varchar(80),
+	parent_id bigint,
+	CONSTRAINT fk_tags_parent FOREIGN KEY (parent_id) REFERENCES tags (id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS idx_tags_parent_id ON tags (parent_id);
+
+CREATE TABLE IF NOT EXISTS product_tags (
+	product_id bigint,
+	tag_id bigint,
+	PRIMARY KEY (product_id, tag_id),
+	CONSTRAINT fk_product_tags_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE,
+	CONSTRAINT fk_product_tags_tag FOREIGN KEY (tag_id) REFERENCES tags (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_product_tags_tag_id ON product_tags (tag_id);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	product_id bigint NOT NULL,
+	created_at timestamptz,
+	CONSTRAINT fk_cart_items_user FOREIGN KEY (user_id) REFERENCES users (id),
+	CONSTRAINT fk_cart_items_product FOREIGN KEY (product_id) REFERENCES products (id)
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	created_at timestamptz,
+	CONSTRAINT fk_orders_user FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE TABLE IF NOT EXISTS order_items (
+	id bigserial PRIMARY KEY,
+	order_id bigint NOT NULL,
+	product_id bigint NOT NULL,
+	CONSTRAINT fk_orders_items FOREIGN KEY (order_id) REFERENCES orders (id),
+	CONSTRAINT fk_order_items_product FOREIGN KEY (product_id) REFERENCES products (id)
+);
+
+CREATE TABLE IF NOT EXISTS reviews (
+	id bigserial PRIMARY KEY,
+	product_id bigint NOT NULL,
+	user_id bigint NOT NULL,
+	rating bigint NOT NULL,
+	text text,
+	seller_reply text,
+	seller_reply_at timestamptz,
+	flagged boolean NOT NULL DEFAULT false,
+	hidden boolean NOT NULL DEFAULT false,
+	created_at timestamptz,
+	updated_at timestamptz,
+	CONSTRAINT fk_reviews_user FOREIGN KEY (user_id) REFERENCES users (id),
+	CONSTRAINT fk_reviews_product FOREIGN KEY (product_id) REFERENCES products (id)
+);
+CREATE INDEX IF NOT EXISTS idx_reviews_flagged ON reviews (flagged);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reviews_product_user ON reviews (product_id, user_id);
+
+CREATE TABLE IF NOT EXISTS wishlist_items (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	product_id bigint NOT NULL,
+	created_at timestamptz,
+	CONSTRAINT fk_wishlist_items_user FOREIGN KEY (user_id) REFERENCES users (id),
+	CONSTRAINT fk_wishlist_items_product FOREIGN KEY (product_id) REFERENCES products (id)
+);
+CREATE INDEX IF NOT EXISTS idx_wishlist_items_product_id ON wishlist_items (product_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_wishlist_user_product ON wishlist_items (user_id, product_id);
+
+CREATE TABLE IF NOT EXISTS wishlist_notifications (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	product_id bigint NOT NULL,
+	kind varchar(20) NOT NULL,
+	old_price decimal NOT NULL DEFAULT 0,
+	new_price decimal NOT NULL DEFAULT 0,
+	version bigint NOT NULL DEFAULT 0,
+	created_at timestamptz,
+	sent_at timestamptz,
+	CONSTRAINT fk_wishlist_notifications_product FOREIGN KEY (product_id) REFERENCES products (id)
+);
+CREATE INDEX IF NOT EXISTS idx_wishlist_notifications_sent_at ON wishlist_notifications (sent_at);
+CREATE INDEX IF NOT EXISTS idx_wishlist_notifications_user_id ON wishlist_notifications (user_id);
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+	key varchar(255) PRIMARY KEY,
+	tokens decimal NOT NULL,
+	updated_at timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+	key varchar(255) PRIMARY KEY,
+	failures bigint NOT NULL DEFAULT 0,
+	locked_until timestamptz,
+	updated_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	provider varchar(50) NOT NULL,
+	subject varchar(255) NOT NULL,
+	email varchar(255),
+	created_at timestamptz,
+	CONSTRAINT fk_user_identities_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_identities_provider_subject ON user_identities (provider, subject);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
+
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	name varchar(100) NOT NULL,
+	token_hash varchar(64) NOT NULL,
+	prefix varchar(16) NOT NULL,
+	last_used_at timestamptz,
+	expires_at timestamptz,
+	revoked_at timestamptz,
+	created_at timestamptz,
+	CONSTRAINT fk_personal_access_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_personal_access_tokens_token_hash ON personal_access_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON personal_access_tokens (user_id);
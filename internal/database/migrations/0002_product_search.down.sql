@@ -0,0 +1,13 @@
+-- Расширение pg_trgm не удаляется: им могут пользоваться другие базы и приложения
+DROP INDEX IF EXISTS idx_products_search_text_trgm;
+DROP INDEX IF EXISTS idx_products_search_vector;
+
+DROP TRIGGER IF EXISTS tags_search_touch ON tags;
+DROP FUNCTION IF EXISTS tags_search_touch();
+DROP TRIGGER IF EXISTS product_tags_search_touch ON product_tags;
+DROP FUNCTION IF EXISTS product_tags_search_touch();
+DROP TRIGGER IF EXISTS products_search_refresh ON products;
+DROP FUNCTION IF EXISTS products_search_refresh();
+
+ALTER TABLE products DROP COLUMN IF EXISTS search_text;
+ALTER TABLE products DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,84 @@
+-- Полнотекстовый поиск товаров:
+--   - search_vector - tsvector из названия (вес A), тегов (B) и описания (C);
+--   - search_text - название и теги в нижнем регистре для триграммного поиска опечаток.
+--
+-- Колонки пересчитывает триггер на products. Изменения тегов "трогают" строку товара
+-- (UPDATE ... SET title = title), чтобы сработал тот же триггер.
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_text text NOT NULL DEFAULT '';
+
+CREATE OR REPLACE FUNCTION products_search_refresh() RETURNS trigger AS $$
+DECLARE
+	tag_names text;
+BEGIN
+	SELECT string_agg(t.name, ' ') INTO tag_names
+	FROM product_tags pt JOIN tags t ON t.id = pt.tag_id
+	WHERE pt.product_id = NEW.id;
+
+	NEW.search_vector :=
+		setweight(to_tsvector('simple', coalesce(NEW.title, '')), 'A') ||
+		setweight(to_tsvector('simple', coalesce(tag_names, '')), 'B') ||
+		setweight(to_tsvector('simple', coalesce(NEW.description, '')), 'C');
+	NEW.search_text := lower(coalesce(NEW.title, '') || ' ' || coalesce(tag_names, ''));
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS products_search_refresh ON products;
+
+CREATE TRIGGER products_search_refresh BEFORE INSERT OR UPDATE OF title, description ON products
+	FOR EACH ROW EXECUTE FUNCTION products_search_refresh();
+
+CREATE OR REPLACE FUNCTION product_tags_search_touch() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		UPDATE products SET title = title WHERE id = OLD.product_id;
+	ELSE
+		UPDATE products SET title = title WHERE id = NEW.product_id;
+	END IF;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS product_tags_search_touch ON product_tags;
+
+CREATE TRIGGER product_tags_search_touch AFTER INSERT OR DELETE ON product_tags
+	FOR EACH ROW EXECUTE FUNCTION product_tags_search_touch();
+
+CREATE OR REPLACE FUNCTION tags_search_touch() RETURNS trigger AS $$
+BEGIN
+	UPDATE products SET title = title
+	WHERE id IN (SELECT product_id FROM product_tags WHERE tag_id = NEW.id);
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS tags_search_touch ON tags;
+
+CREATE TRIGGER tags_search_touch AFTER UPDATE OF name ON tags
+	FOR EACH ROW EXECUTE FUNCTION tags_search_touch();
+
+CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector);
+
+-- Заполняем колонки для товаров, созданных до появления поиска
+UPDATE products SET title = title WHERE search_vector IS NULL;
+
+-- pg_trgm может быть недоступно (у пользователя БД нет прав на CREATE EXTENSION).
+-- Тогда поиск работает только по полнотекстовому индексу, без исправления опечаток.
+DO $$
+BEGIN
+	CREATE EXTENSION IF NOT EXISTS pg_trgm;
+EXCEPTION WHEN insufficient_privilege OR feature_not_supported OR undefined_file THEN
+	RAISE NOTICE 'pg_trgm недоступно, поиск с опечатками отключен: %', SQLERRM;
+END
+$$;
+
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm') THEN
+		CREATE INDEX IF NOT EXISTS idx_products_search_text_trgm ON products USING GIN (search_text gin_trgm_ops);
+	END IF;
+END
+$$;
@@ -0,0 +1,4 @@
+-- Снимаются только уникальные индексы. Внешние ключи входят в базовую схему (0001),
+-- а объединенные теги и удаленные привязки восстановить нельзя.
+DROP INDEX IF EXISTS idx_tags_slug_unique;
+DROP INDEX IF EXISTS idx_tags_lower_name;
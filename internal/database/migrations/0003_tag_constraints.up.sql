@@ -0,0 +1,60 @@
+-- Ограничения тегов. На новой базе внешние ключи уже созданы в 0001; на базе, созданной
+-- AutoMigrate до появления иерархии тегов, миграция добавляет недостающие колонки,
+-- чистит данные, которые нарушили бы ограничения, и создает внешние ключи.
+
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS slug varchar(80);
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS parent_id bigint;
+
+-- Тегов без имени быть не должно
+DELETE FROM product_tags WHERE tag_id IN (SELECT id FROM tags WHERE name IS NULL OR btrim(name) = '');
+DELETE FROM tags WHERE name IS NULL OR btrim(name) = '';
+ALTER TABLE tags ALTER COLUMN name SET NOT NULL;
+
+-- Привязки к удаленным товарам и тегам, ссылки на удаленных родителей
+DELETE FROM product_tags pt
+WHERE NOT EXISTS (SELECT 1 FROM products p WHERE p.id = pt.product_id)
+	OR NOT EXISTS (SELECT 1 FROM tags t WHERE t.id = pt.tag_id);
+UPDATE tags c SET parent_id = NULL
+WHERE parent_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM tags t WHERE t.id = c.parent_id);
+
+-- Теги, чьи имена отличаются только регистром, объединяются: остается тег с меньшим ID,
+-- товары и дочерние теги переходят к нему
+CREATE TEMPORARY TABLE tag_duplicates ON COMMIT DROP AS
+	SELECT id, keep_id FROM (
+		SELECT id, min(id) OVER (PARTITION BY lower(name)) AS keep_id FROM tags
+	) d WHERE id <> keep_id;
+INSERT INTO product_tags (product_id, tag_id)
+	SELECT DISTINCT pt.product_id, d.keep_id FROM product_tags pt JOIN tag_duplicates d ON d.id = pt.tag_id
+	WHERE NOT EXISTS (SELECT 1 FROM product_tags e WHERE e.product_id = pt.product_id AND e.tag_id = d.keep_id);
+UPDATE tags t SET parent_id = d.keep_id FROM tag_duplicates d WHERE t.parent_id = d.id;
+UPDATE tags SET parent_id = NULL WHERE parent_id = id;
+DELETE FROM product_tags WHERE tag_id IN (SELECT id FROM tag_duplicates);
+DELETE FROM tags WHERE id IN (SELECT id FROM tag_duplicates);
+
+-- Повторяющиеся слаги сбрасываются, TagService.BackfillSlugs назначит новые при запуске
+UPDATE tags t SET slug = '' FROM (
+	SELECT id, row_number() OVER (PARTITION BY slug ORDER BY id) AS n FROM tags WHERE slug <> ''
+) d WHERE t.id = d.id AND d.n > 1;
+
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_tags_parent') THEN
+		ALTER TABLE tags ADD CONSTRAINT fk_tags_parent
+			FOREIGN KEY (parent_id) REFERENCES tags (id) ON DELETE SET NULL;
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_product_tags_product') THEN
+		ALTER TABLE product_tags ADD CONSTRAINT fk_product_tags_product
+			FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE;
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_product_tags_tag') THEN
+		ALTER TABLE product_tags ADD CONSTRAINT fk_product_tags_tag
+			FOREIGN KEY (tag_id) REFERENCES tags (id) ON DELETE CASCADE;
+	END IF;
+END
+$$;
+
+CREATE INDEX IF NOT EXISTS idx_tags_parent_id ON tags (parent_id);
+CREATE INDEX IF NOT EXISTS idx_product_tags_tag_id ON product_tags (tag_id);
+DROP INDEX IF EXISTS idx_tags_slug;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_lower_name ON tags (lower(name));
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_slug_unique ON tags (slug) WHERE slug <> '';
@@ -0,0 +1,11 @@
+-- Журнал аудита удаляется вместе с записями; роли и блокировки теряются
+DROP TABLE IF EXISTS audit_events;
+
+ALTER TABLE products DROP COLUMN IF EXISTS unlist_reason;
+ALTER TABLE products DROP COLUMN IF EXISTS unlisted_at;
+
+DROP INDEX IF EXISTS idx_users_role;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS chk_users_role;
+ALTER TABLE users DROP COLUMN IF EXISTS ban_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS banned_at;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
@@ -0,0 +1,42 @@
+-- Роли пользователей, блокировка аккаунтов, снятие товаров с продажи и журнал аудита
+-- действий в разделе /admin.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role varchar(20) NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS banned_at timestamptz;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS ban_reason text;
+
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'chk_users_role') THEN
+		ALTER TABLE users ADD CONSTRAINT chk_users_role
+			CHECK (role IN ('user', 'seller', 'moderator', 'admin'));
+	END IF;
+END
+$$;
+
+-- Пользователи, у которых уже есть товары, становятся продавцами
+UPDATE users u SET role = 'seller'
+WHERE role = 'user' AND EXISTS (SELECT 1 FROM products p WHERE p.user_id = u.id);
+
+-- Сотрудников и продавцов немного, а в /admin их часто ищут по роли
+CREATE INDEX IF NOT EXISTS idx_users_role ON users (role) WHERE role <> 'user';
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS unlisted_at timestamptz;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS unlist_reason text;
+
+-- Журнал аудита. Внешних ключей нет: записи должны пережить удаление пользователей и товаров.
+CREATE TABLE IF NOT EXISTS audit_events (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	actor_id bigint,
+	action varchar(64) NOT NULL,
+	target_type varchar(32),
+	target_id bigint,
+	ip varchar(64),
+	user_agent text,
+	details jsonb NOT NULL DEFAULT '{}',
+	note text
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events (target_type, target_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor_id ON audit_events (actor_id, created_at);
@@ -0,0 +1,20 @@
+-- Журнал аудита только дополняется: изменить или удалить записи нельзя даже
+-- через прямой доступ к базе от имени приложения.
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events: % запрещен, журнал только дополняется', TG_OP
+		USING ERRCODE = 'insufficient_privilege';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+	FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+	FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+-- Фильтр по началу названия действия в /admin/audit (например, "auth.")
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events (action varchar_pattern_ops, id);
@@ -0,0 +1,19 @@
+-- Скачивания файлов товаров: лимит на покупку, статистика продавцов и поиск раздачи ссылок
+
+CREATE TABLE IF NOT EXISTS downloads (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	user_id bigint NOT NULL,
+	product_id bigint NOT NULL,
+	version integer NOT NULL,
+	bytes bigint NOT NULL DEFAULT 0,
+	ip varchar(64),
+	via varchar(16) NOT NULL,
+	by_owner boolean NOT NULL DEFAULT false,
+	CONSTRAINT fk_downloads_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+	CONSTRAINT fk_downloads_product FOREIGN KEY (product_id) REFERENCES products (id) ON DELETE CASCADE
+);
+-- Лимит и поиск раздачи считают скачивания одной покупки
+CREATE INDEX IF NOT EXISTS idx_downloads_user_product ON downloads (user_id, product_id, created_at);
+-- Статистика продавца по товарам
+CREATE INDEX IF NOT EXISTS idx_downloads_product ON downloads (product_id, created_at);
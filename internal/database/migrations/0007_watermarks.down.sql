@@ -0,0 +1,6 @@
+-- Коды водяных знаков удаляются: найти покупателя по уже скачанным файлам можно только по журналу аудита
+DROP INDEX IF EXISTS idx_downloads_watermark;
+ALTER TABLE downloads DROP COLUMN IF EXISTS watermark;
+ALTER TABLE downloads DROP COLUMN IF EXISTS order_id;
+
+ALTER TABLE products DROP COLUMN IF EXISTS watermark;
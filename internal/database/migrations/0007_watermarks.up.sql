@@ -0,0 +1,8 @@
+-- Водяные знаки: продавец включает их для товара, а каждое скачивание с водяным знаком
+-- получает уникальный код, по которому находится покупатель
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS watermark boolean NOT NULL DEFAULT false;
+
+ALTER TABLE downloads ADD COLUMN IF NOT EXISTS order_id bigint;
+ALTER TABLE downloads ADD COLUMN IF NOT EXISTS watermark varchar(32);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_downloads_watermark ON downloads (watermark) WHERE watermark IS NOT NULL;
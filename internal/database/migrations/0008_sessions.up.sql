@@ -0,0 +1,15 @@
+-- Сессии входа: cookie содержит случайный токен вместо ID пользователя
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	token_hash varchar(64) NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	last_seen_at timestamptz NOT NULL DEFAULT now(),
+	expires_at timestamptz NOT NULL,
+	CONSTRAINT fk_sessions_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_token_hash ON sessions (token_hash);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+-- Очистка просроченных сессий
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions (expires_at);
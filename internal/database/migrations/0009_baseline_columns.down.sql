@@ -0,0 +1,2 @@
+-- Колонки входят в схему 0001 на новой базе, поэтому откат их не удаляет
+SELECT 1;
@@ -0,0 +1,12 @@
+-- Колонки, добавленные в users и products после появления AutoMigrate.
+-- 0001 создает таблицы через IF NOT EXISTS и не трогает уже существующие, поэтому в базе,
+-- созданной старым AutoMigrate, этих колонок нет. На новой базе команды ничего не меняют.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS generated_password boolean DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bio text;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_path text;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS social_links text;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_avg decimal NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS review_count bigint NOT NULL DEFAULT 0;
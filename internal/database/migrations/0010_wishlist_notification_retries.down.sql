@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_wishlist_notifications_pending;
+
+ALTER TABLE wishlist_notifications DROP COLUMN IF EXISTS failed_at;
+ALTER TABLE wishlist_notifications DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE wishlist_notifications DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,10 @@
+-- Повторные попытки отправки уведомлений избранного: счетчик неудач, время
+-- следующей попытки и отметка об отказе после исчерпания попыток
+
+ALTER TABLE wishlist_notifications ADD COLUMN IF NOT EXISTS attempts bigint NOT NULL DEFAULT 0;
+ALTER TABLE wishlist_notifications ADD COLUMN IF NOT EXISTS next_attempt_at timestamptz;
+ALTER TABLE wishlist_notifications ADD COLUMN IF NOT EXISTS failed_at timestamptz;
+
+-- Очередь рассылки: неотправленные события, по которым еще есть попытки
+CREATE INDEX IF NOT EXISTS idx_wishlist_notifications_pending ON wishlist_notifications (user_id, id)
+	WHERE sent_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1,20 @@
+-- Запросы на привязку внешнего аккаунта к существующему пользователю с тем же email.
+-- Раньше хранились в памяти процесса и пропадали при перезапуске и между экземплярами.
+
+CREATE TABLE IF NOT EXISTS pending_identity_links (
+	id bigserial PRIMARY KEY,
+	token_hash varchar(64) NOT NULL,
+	email_token_hash varchar(64),
+	user_id bigint NOT NULL,
+	email varchar(255) NOT NULL,
+	provider varchar(50) NOT NULL,
+	subject varchar(255) NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	expires_at timestamptz NOT NULL,
+	CONSTRAINT fk_pending_identity_links_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pending_identity_links_token_hash ON pending_identity_links (token_hash);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pending_identity_links_email_token_hash ON pending_identity_links (email_token_hash);
+CREATE INDEX IF NOT EXISTS idx_pending_identity_links_user_id ON pending_identity_links (user_id);
+-- Очистка истекших запросов
+CREATE INDEX IF NOT EXISTS idx_pending_identity_links_expires_at ON pending_identity_links (expires_at);
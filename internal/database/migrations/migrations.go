@@ -0,0 +1,347 @@
+// Package migrations применяет версионированные SQL-миграции схемы базы данных.
+//
+// Миграции лежат в этом каталоге парами файлов NNNN_name.up.sql и NNNN_name.down.sql
+// и встраиваются в бинарник через embed. Примененные версии и контрольные суммы
+// up-скриптов хранятся в таблице schema_migrations. На время работы берется
+// advisory lock PostgreSQL, поэтому одновременный запуск нескольких экземпляров
+// приложения применяет миграции только один раз.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed *.sql
+var embedded embed.FS
+
+// Ключ advisory lock, общий для всех экземпляров приложения
+const lockKey int64 = 0x6d6b74706c616365 // "mktplace"
+
+var fileNameRegex = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Ошибки миграций
+var (
+	ErrChecksumMismatch = errors.New("файл примененной миграции изменен")
+	ErrNoDownScript     = errors.New("у миграции нет down-скрипта")
+)
+
+// Migration - одна версия схемы
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum - SHA-256 up-скрипта. Сохраняется при применении, чтобы заметить
+// правку уже примененной миграции.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Status - состояние миграции для команды migrate status
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time // nil - миграция еще не применена
+	Modified  bool       // Файл изменен после применения
+	Missing   bool       // Версия применена, но файла миграции нет (база новее бинарника)
+}
+
+// Load читает миграции из fsys и проверяет, что версии идут без повторов
+// и у каждой есть up-скрипт
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		match := fileNameRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("неверное имя файла миграции %q (ожидается NNNN_name.up.sql)", entry.Name())
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("версия %d используется миграциями %q и %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	list := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, fmt.Errorf("у миграции %s нет up-скрипта", m)
+		}
+		list = append(list, *m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Runner применяет и откатывает миграции
+type Runner struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New создает Runner для встроенных в бинарник миграций
+func New(db *sql.DB) (*Runner, error) {
+	list, err := Load(embedded)
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{db: db, migrations: list}, nil
+}
+
+// Up применяет все непримененные миграции по порядку, каждую в своей транзакции.
+// Если файл уже примененной миграции изменился, ничего не применяет и возвращает ErrChecksumMismatch.
+func (r *Runner) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := r.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range r.migrations {
+			if record, ok := done[m.Version]; ok && record.checksum != m.Checksum() {
+				return fmt.Errorf("%w: %s", ErrChecksumMismatch, m)
+			}
+		}
+
+		for _, m := range r.migrations {
+			if _, ok := done[m.Version]; ok {
+				continue
+			}
+			if err := runInTx(ctx, conn, m.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx,
+					`INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES ($1, $2, $3, now())`,
+					m.Version, m.Name, m.Checksum())
+				return err
+			}); err != nil {
+				return fmt.Errorf("миграция %s: %w", m, err)
+			}
+			applied = append(applied, m)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down откатывает steps последних примененных миграций, начиная с самой новой
+func (r *Runner) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var rolledBack []Migration
+	err := r.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(r.migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			m := r.migrations[i]
+			if _, ok := done[m.Version]; !ok {
+				continue
+			}
+			if strings.TrimSpace(m.Down) == "" {
+				return fmt.Errorf("%w: %s", ErrNoDownScript, m)
+			}
+			if err := runInTx(ctx, conn, m.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("откат миграции %s: %w", m, err)
+			}
+			rolledBack = append(rolledBack, m)
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Status возвращает состояние всех известных и примененных миграций по возрастанию версий.
+// Только читает schema_migrations и не берет advisory lock, поэтому не ждет идущих миграций
+// и не создает таблицу: на пустой базе все миграции считаются непримененными.
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, err
+	}
+	done := make(map[int64]appliedRecord)
+	if exists {
+		var err error
+		if done, err = appliedMigrations(ctx, r.db); err != nil {
+			return nil, err
+		}
+	}
+
+	var statuses []Status
+	for _, m := range r.migrations {
+		status := Status{Version: m.Version, Name: m.Name}
+		if record, ok := done[m.Version]; ok {
+			appliedAt := record.appliedAt
+			status.AppliedAt = &appliedAt
+			status.Modified = record.checksum != m.Checksum()
+			delete(done, m.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for version, record := range done {
+		appliedAt := record.appliedAt
+		statuses = append(statuses, Status{Version: version, Name: record.name, AppliedAt: &appliedAt, Missing: true})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// withLock выполняет fn на отдельном соединении, удерживая advisory lock.
+// Блокировка сеансовая, поэтому все запросы идут через одно соединение, а не через пул.
+func (r *Runner) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, lockKey).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		slog.InfoContext(ctx, "Миграции выполняет другой процесс, ожидание блокировки")
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		// Контекст мог быть уже отменен, а блокировку нужно снять в любом случае
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey); err != nil {
+			slog.Error("Не удалось снять блокировку миграций", slog.String("error", err.Error()))
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		checksum char(64) NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+type appliedRecord struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// queryer - общее у *sql.DB и *sql.Conn: Status читает через пул, Up и Down - через соединение с блокировкой
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func appliedMigrations(ctx context.Context, conn queryer) (map[int64]appliedRecord, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int64]appliedRecord)
+	for rows.Next() {
+		var version int64
+		var record appliedRecord
+		if err := rows.Scan(&version, &record.name, &record.checksum, &record.appliedAt); err != nil {
+			return nil, err
+		}
+		done[version] = record
+	}
+	return done, rows.Err()
+}
+
+// runInTx выполняет скрипт миграции и запись в schema_migrations в одной транзакции.
+// Скрипт передается без параметров, поэтому может содержать несколько команд.
+func runInTx(ctx context.Context, conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Create создает в dir пустую пару файлов для новой миграции со следующим номером
+// и возвращает их пути
+func Create(dir, name string) (upPath, downPath string, err error) {
+	name = strings.Trim(strings.ToLower(regexp.MustCompile(`[^a-zA-Z0-9]+`).ReplaceAllString(name, "_")), "_")
+	if name == "" {
+		return "", "", errors.New("укажите название миграции, например add_user_roles")
+	}
+
+	list, err := Load(os.DirFS(dir))
+	if err != nil {
+		return "", "", err
+	}
+	var next int64 = 1
+	if len(list) > 0 {
+		next = list[len(list)-1].Version + 1
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%04d_%s", next, name))
+	upPath, downPath = base+".up.sql", base+".down.sql"
+	header := fmt.Sprintf("-- %04d_%s\n", next, name)
+	if err := os.WriteFile(upPath, []byte(header), 0o644); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(downPath, []byte(header), 0o644); err != nil {
+		return "", "", err
+	}
+	return upPath, downPath, nil
+}
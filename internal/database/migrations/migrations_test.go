@@ -0,0 +1,203 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// baselineSchema - таблицы в том виде, в каком их создавал AutoMigrate до появления миграций
+const baselineSchema = `
+CREATE TABLE users (
+	id bigserial PRIMARY KEY,
+	username varchar(255),
+	email text NOT NULL,
+	password text NOT NULL,
+	balance decimal DEFAULT 0,
+	created_at timestamptz,
+	CONSTRAINT uni_users_email UNIQUE (email)
+);
+CREATE TABLE products (
+	id bigserial PRIMARY KEY,
+	title text NOT NULL,
+	description text,
+	price decimal NOT NULL DEFAULT 0,
+	file_path text NOT NULL,
+	image_path text,
+	user_id bigint,
+	created_at timestamptz
+);
+CREATE TABLE cart_items (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	product_id bigint NOT NULL,
+	created_at timestamptz,
+	CONSTRAINT fk_cart_items_user FOREIGN KEY (user_id) REFERENCES users (id),
+	CONSTRAINT fk_cart_items_product FOREIGN KEY (product_id) REFERENCES products (id)
+);
+CREATE TABLE orders (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL,
+	created_at timestamptz,
+	CONSTRAINT fk_orders_user FOREIGN KEY (user_id) REFERENCES users (id)
+);
+CREATE TABLE order_items (
+	id bigserial PRIMARY KEY,
+	order_id bigint NOT NULL,
+	product_id bigint NOT NULL,
+	CONSTRAINT fk_orders_items FOREIGN KEY (order_id) REFERENCES orders (id),
+	CONSTRAINT fk_order_items_product FOREIGN KEY (product_id) REFERENCES products (id)
+);
+INSERT INTO users (email, password, created_at) VALUES ('old@example.com', 'hash', now());
+INSERT INTO products (title, price, file_path, user_id, created_at) VALUES ('Старый товар', 10, 'uploads/old.zip', 1, now());
+`
+
+func TestLoadEmbedded(t *testing.T) {
+	list, err := Load(embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range list {
+		if m.Version != int64(i+1) {
+			t.Errorf("версия %d на позиции %d, ожидалась %d", m.Version, i, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("%s: нет down-скрипта", m)
+		}
+	}
+}
+
+// testDB подключается к базе из TEST_DATABASE_DSN и переключает единственное соединение пула
+// на новую пустую схему, которая удаляется после теста
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN не задан")
+	}
+	gormDB, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := gormDB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	schema := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	if _, err := db.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+	// public остается в пути ради расширения pg_trgm, которое могло быть установлено туда раньше
+	if _, err := db.Exec(`SET search_path TO ` + schema + `, public`); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestUpFromBaselineSchema(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	if _, err := db.Exec(baselineSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	runner, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := runner.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status до первого Up: %v", err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			t.Errorf("%d_%s помечена примененной до Up", s.Version, s.Name)
+		}
+	}
+
+	applied, err := runner.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(runner.migrations) {
+		t.Errorf("применено %d миграций, ожидалось %d", len(applied), len(runner.migrations))
+	}
+
+	columns := map[string][]string{
+		"users":    {"generated_password", "bio", "avatar_path", "social_links", "role", "banned_at"},
+		"products": {"version", "rating_avg", "review_count", "search_vector"},
+	}
+	for table, names := range columns {
+		for _, column := range names {
+			var exists bool
+			err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM information_schema.columns
+				WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2)`, table, column).Scan(&exists)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !exists {
+				t.Errorf("в %s нет колонки %s", table, column)
+			}
+		}
+	}
+
+	// Старые строки получают значения по умолчанию и читаются кодом приложения
+	var version, reviewCount int64
+	if err := db.QueryRow(`SELECT version, review_count FROM products WHERE id = 1`).Scan(&version, &reviewCount); err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 || reviewCount != 0 {
+		t.Errorf("version=%d review_count=%d, ожидалось 1 и 0", version, reviewCount)
+	}
+
+	again, err := runner.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 0 {
+		t.Errorf("повторный Up применил %d миграций", len(again))
+	}
+	statuses, err = runner.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil || s.Modified || s.Missing {
+			t.Errorf("неожиданное состояние %d_%s: %+v", s.Version, s.Name, s)
+		}
+	}
+}
+
+func TestStatusDoesNotWaitForLock(t *testing.T) {
+	db := testDB(t)
+	runner, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Блокировку держит другая сессия, как во время идущего migrate up
+	other, err := sql.Open("pgx", os.Getenv("TEST_DATABASE_DSN"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.Exec(`SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Exec(`SELECT pg_advisory_unlock($1)`, lockKey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := runner.Status(ctx); err != nil {
+		t.Fatalf("Status при занятой блокировке: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package database
+
+import (
+	"log/slog"
+
+	"gorm.io/gorm"
+)
+
+// TrigramSearch сообщает, доступно ли расширение pg_trgm. Без него поиск работает
+// только по полнотекстовому индексу, без исправления опечаток.
+// Колонки, триггеры и индексы поиска создает миграция 0002_product_search.
+var TrigramSearch bool
+
+// detectTrigramSearch проверяет, установлено ли расширение pg_trgm
+func detectTrigramSearch(db *gorm.DB) {
+	if err := db.Raw(`SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm')`).Scan(&TrigramSearch).Error; err != nil {
+		slog.Warn("Не удалось проверить расширение pg_trgm", slog.String("error", err.Error()))
+		TrigramSearch = false
+	}
+	if !TrigramSearch {
+		slog.Warn("Расширение pg_trgm недоступно, поиск с опечатками отключен")
+	}
+}
@@ -0,0 +1,141 @@
+// Package logging настраивает структурированный журнал приложения (log/slog).
+//
+// Логгер запроса (с request_id, а после входа - с user_id) хранится в context.Context:
+// контроллеры и сервисы берут его через FromContext, поэтому все записи одного запроса,
+// включая фоновую отправку письма о заказе, связаны одним request_id.
+// Стандартные поля задаются функциями UserID, OrderID, ProductID и Email, секреты
+// (пароли, токены, cookie) скрываются обработчиком при записи.
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+
+	"digital-marketplace/internal/config"
+)
+
+// Имена стандартных полей журнала
+const (
+	KeyRequestID = "request_id"
+	KeyTraceID   = "trace_id"
+	KeyUserID    = "user_id"
+	KeyOrderID   = "order_id"
+	KeyProductID = "product_id"
+	KeyEmail     = "email"
+	KeyError     = "error"
+)
+
+// redacted - значение, которое пишется вместо секрета
+const redacted = "[скрыто]"
+
+// sensitiveKeys - поля, значения которых никогда не попадают в журнал
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"pass":          true,
+	"secret":        true,
+	"client_secret": true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"csrf_token":    true,
+	"authorization": true,
+	"cookie":        true,
+	"set_cookie":    true,
+	"api_key":       true,
+}
+
+// Setup создает логгер по конфигурации и делает его логгером по умолчанию.
+// Стандартный пакет log после этого тоже пишет через него (с уровнем INFO).
+func Setup(cfg config.LogConfig, w io.Writer) *slog.Logger {
+	logger := slog.New(NewHandler(cfg, w))
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	return logger
+}
+
+// NewHandler создает обработчик: JSON или текст, с заданным уровнем и скрытием секретов
+func NewHandler(cfg config.LogConfig, w io.Writer) slog.Handler {
+	opts := &slog.HandlerOptions{
+		Level:       cfg.Level,
+		ReplaceAttr: redact,
+	}
+	if cfg.Format == "text" {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+// redact скрывает значения полей из sensitiveKeys
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	return a
+}
+
+type contextKey struct{}
+
+// WithContext сохраняет логгер в контексте
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext возвращает логгер из контекста или логгер по умолчанию
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
+
+// With добавляет поля к логгеру из контекста и возвращает новый контекст
+func With(ctx context.Context, args ...any) context.Context {
+	return WithContext(ctx, FromContext(ctx).With(args...))
+}
+
+// UserID - поле с ID пользователя
+func UserID(id uint) slog.Attr {
+	return slog.Uint64(KeyUserID, uint64(id))
+}
+
+// OrderID - поле с ID заказа
+func OrderID(id uint) slog.Attr {
+	return slog.Uint64(KeyOrderID, uint64(id))
+}
+
+// ProductID - поле с ID товара
+func ProductID(id uint) slog.Attr {
+	return slog.Uint64(KeyProductID, uint64(id))
+}
+
+// Err - поле с ошибкой
+func Err(err error) slog.Attr {
+	if err == nil {
+		return slog.String(KeyError, "")
+	}
+	return slog.String(KeyError, err.Error())
+}
+
+// Email - поле с адресом, в котором скрыта локальная часть: "a***@example.com".
+// По домену можно разбирать проблемы доставки, а адрес целиком в журнал не попадает.
+func Email(email string) slog.Attr {
+	return slog.String(KeyEmail, MaskEmail(email))
+}
+
+// MaskEmail оставляет от локальной части адреса первый символ
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		if email == "" {
+			return ""
+		}
+		return redacted
+	}
+	first := []rune(email[:at])[0]
+	return string(first) + "***" + email[at:]
+}
@@ -0,0 +1,186 @@
+// Package metrics описывает метрики приложения для Prometheus.
+//
+// Метрики регистрируются в собственном реестре Registry (а не в глобальном реестре
+// client_golang), который отдается обработчиком Handler на /metrics. Кроме HTTP-метрик
+// здесь же бизнес-счетчики: регистрации, входы, заказы, выручка, загрузки, письма и скачивания.
+// Контроллеры и сервисы увеличивают их напрямую, например metrics.Logins.WithLabelValues("password").Inc().
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace - общий префикс имен метрик
+const namespace = "marketplace"
+
+// Registry - реестр всех метрик приложения
+var Registry = prometheus.NewRegistry()
+
+// Границы гистограммы длительности запросов. Верхние корзины нужны для загрузки
+// и скачивания файлов товаров, которые идут минутами.
+var httpDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+var (
+	// HTTPRequestDuration - длительность обработки запросов по маршруту и статусу.
+	// Число запросов с каждым статусом дает marketplace_http_request_duration_seconds_count.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Длительность обработки HTTP-запросов по маршруту, методу и статусу.",
+		Buckets:   httpDurationBuckets,
+	}, []string{"method", "route", "status"})
+
+	// Downloads - скачивания файлов товаров: source=link (по ссылке из письма или API) или direct
+	Downloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloads_total",
+		Help:      "Число скачиваний файлов товаров.",
+	}, []string{"source"})
+
+	// DownloadBytes - объем отданных файлов товаров
+	DownloadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "download_bytes_total",
+		Help:      "Объем отданных файлов товаров в байтах.",
+	}, []string{"source"})
+
+	// DownloadsRejected - отказы в скачивании из-за исчерпанного лимита покупки
+	DownloadsRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloads_rejected_total",
+		Help:      "Число отказов в скачивании из-за лимита скачиваний покупки.",
+	})
+
+	// DownloadAnomalies - покупки, которые скачивают со слишком многих IP
+	DownloadAnomalies = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "download_anomalies_total",
+		Help:      "Число случаев, когда покупку скачивают с подозрительно многих адресов.",
+	})
+
+	// WatermarkFailures - скачивания, при которых не удалось встроить водяной знак
+	WatermarkFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watermark_failures_total",
+		Help:      "Число скачиваний, при которых не удалось встроить водяной знак.",
+	})
+
+	// Registrations - новые пользователи: method=password или oauth
+	Registrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Число регистраций пользователей.",
+	}, []string{"method"})
+
+	// Logins - успешные входы: method=password, api или oauth
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Число успешных входов.",
+	}, []string{"method"})
+
+	// LoginFailures - неудачные попытки входа по паролю: method=password или api
+	LoginFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_failures_total",
+		Help:      "Число неудачных попыток входа.",
+	}, []string{"method"})
+
+	// Orders - оформленные заказы: source=cart (из корзины) или buy (покупка одного товара)
+	Orders = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_total",
+		Help:      "Число оформленных заказов.",
+	}, []string{"source"})
+
+	// Revenue - сумма оформленных заказов
+	Revenue = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "revenue_total",
+		Help:      "Сумма оформленных заказов.",
+	})
+
+	// Uploads - загрузки продавцов: kind=product (новый товар) или version (новая версия файлов)
+	Uploads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_total",
+		Help:      "Число загруженных товаров и версий.",
+	}, []string{"kind"})
+
+	// MailSent - отправленные письма: kind=order, product или notification
+	MailSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mail_sent_total",
+		Help:      "Число отправленных писем.",
+	}, []string{"kind"})
+
+	// MailFailures - письма, которые не удалось отправить
+	MailFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mail_failures_total",
+		Help:      "Число писем, которые не удалось отправить.",
+	}, []string{"kind"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		Downloads,
+		DownloadBytes,
+		DownloadsRejected,
+		DownloadAnomalies,
+		WatermarkFailures,
+		Registrations,
+		Logins,
+		LoginFailures,
+		Orders,
+		Revenue,
+		Uploads,
+		MailSent,
+		MailFailures,
+	)
+}
+
+// Handler отдает метрики в текстовом формате Prometheus
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// RegisterDBStats добавляет статистику пула соединений с базой (go_sql_*)
+func RegisterDBStats(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterGauge добавляет показатель, значение которого читается при каждом сборе метрик
+func RegisterGauge(name, help string, value func() float64) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, value))
+}
+
+// ObserveMail учитывает результат отправки письма
+func ObserveMail(kind string, err error) {
+	if err != nil {
+		MailFailures.WithLabelValues(kind).Inc()
+		return
+	}
+	MailSent.WithLabelValues(kind).Inc()
+}
+
+// ObserveDownload учитывает отданный файл товара; bytes < 0 означает, что ответ не был записан
+func ObserveDownload(source string, bytes int) {
+	if bytes < 0 {
+		return
+	}
+	Downloads.WithLabelValues(source).Inc()
+	DownloadBytes.WithLabelValues(source).Add(float64(bytes))
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// AuditEvent - запись журнала аудита: кто, откуда и что сделал с каким объектом.
+// Записи только добавляются; ссылок на пользователей нет, чтобы журнал пережил удаление аккаунтов.
+type AuditEvent struct {
+	ID         uint      `gorm:"primaryKey"`
+	CreatedAt  time.Time `gorm:"not null"`
+	ActorID    *uint     // nil - действие системы (например, назначение администраторов при запуске)
+	Action     string    `gorm:"size:64;not null"`
+	TargetType string    `gorm:"size:32"`
+	TargetID   *uint
+	IP         string `gorm:"size:64"`
+	UserAgent  string `gorm:"type:text"`
+	Details    string `gorm:"type:jsonb;not null;default:'{}'"` // JSON-объект с подробностями действия
+	Note       string `gorm:"type:text"`                        // Комментарий сотрудника к действию
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Download - одно скачивание файла товара. По этим записям считаются лимит скачиваний
+// покупки, статистика продавца и число разных IP, с которых скачивают по одной покупке.
+type Download struct {
+	ID        uint      `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"not null"`
+	UserID    uint      `gorm:"not null"` // Покупатель (или владелец товара, см. ByOwner)
+	ProductID uint      `gorm:"not null"`
+	Version   int       `gorm:"not null"` // Версия файлов товара на момент скачивания
+	Bytes     int64     `gorm:"not null;default:0"`
+	IP        string    `gorm:"size:64"`
+	Via       string    `gorm:"size:16;not null"`       // link - по ссылке из письма или API, direct - со страницы товара
+	ByOwner   bool      `gorm:"not null;default:false"` // Продавец скачал свой товар: не входит в лимит и статистику
+	OrderID   *uint     // Заказ, в котором куплен товар; заполняется для скачиваний с водяным знаком
+	Watermark *string   `gorm:"size:32"` // Код водяного знака в отданном файле, nil - файл отдан без изменений
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// PersonalAccessToken - токен доступа к JSON API (/api/v1), который пользователь создает в профиле.
+// В базе хранится только SHA-256 хеш токена; сам токен показывается один раз при создании.
+type PersonalAccessToken struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"size:100;not null"`
+	TokenHash  string `gorm:"size:64;not null;uniqueIndex"`
+	Prefix     string `gorm:"size:16;not null"` // Начало токена для отображения в списке
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
+// IsActive сообщает, можно ли использовать токен в момент now
+func (t PersonalAccessToken) IsActive(now time.Time) bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+	return t.ExpiresAt == nil || now.Before(*t.ExpiresAt)
+}
@@ -11,4 +11,23 @@ type Product struct {
 	ImagePath   string    `json:"imagePath"`
 	UserID      uint      `json:"-"`
 	CreatedAt   time.Time `json:"createdAt"`
+	Version     int       `gorm:"not null;default:1" json:"version"` // Увеличивается при загрузке новых файлов товара
+
+	// Средняя оценка и число видимых отзывов, пересчитываются ReviewService
+	RatingAvg   float64 `gorm:"not null;default:0" json:"ratingAvg"`
+	ReviewCount int     `gorm:"not null;default:0" json:"reviewCount"`
+
+	// Снятый модератором товар не показывается в каталоге и не продается,
+	// но покупатели по-прежнему могут его скачать
+	UnlistedAt   *time.Time `json:"-"`
+	UnlistReason string     `gorm:"type:text" json:"-"`
+
+	// Продавец включил водяные знаки: в файлы, которые скачивает покупатель,
+	// встраиваются его номер, номер заказа и код скачивания (пакет watermark)
+	Watermark bool `gorm:"not null;default:false" json:"watermark"`
+}
+
+// Listed сообщает, продается ли товар (не снят с продажи модератором)
+func (p Product) Listed() bool {
+	return p.UnlistedAt == nil
 }
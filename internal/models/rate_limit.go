@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// RateLimitBucket хранит состояние token bucket для ключа ограничения (например, "auth:ip:1.2.3.4").
+// Используется только Postgres-хранилищем лимитов; in-memory хранилище держит то же состояние в памяти.
+type RateLimitBucket struct {
+	Key       string    `gorm:"primaryKey;size:255"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+// LoginAttempt хранит счетчик неудачных попыток входа и время окончания блокировки для аккаунта.
+type LoginAttempt struct {
+	Key         string `gorm:"primaryKey;size:255"`
+	Failures    int    `gorm:"not null;default:0"`
+	LockedUntil time.Time
+	UpdatedAt   time.Time
+}
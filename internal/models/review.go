@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Review - отзыв покупателя о товаре. Оставить отзыв может только пользователь,
+// купивший товар, и только один на товар; автор может его изменять.
+type Review struct {
+	ID        uint   `gorm:"primaryKey"`
+	ProductID uint   `gorm:"not null;uniqueIndex:idx_reviews_product_user"` // Foreign key to Product
+	UserID    uint   `gorm:"not null;uniqueIndex:idx_reviews_product_user"` // Автор отзыва
+	Rating    int    `gorm:"not null"`                                      // Оценка от 1 до 5
+	Text      string `gorm:"type:text"`
+
+	SellerReply   string `gorm:"type:text"` // Ответ продавца (пустой, если ответа нет)
+	SellerReplyAt *time.Time
+
+	// Модерация: Flagged - на отзыв пожаловались, Hidden - модератор скрыл отзыв.
+	// Скрытые отзывы не показываются и не учитываются в рейтинге товара.
+	Flagged bool `gorm:"not null;default:false;index"`
+	Hidden  bool `gorm:"not null;default:false"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	User    User    `gorm:"foreignKey:UserID"`
+	Product Product `gorm:"foreignKey:ProductID"`
+}
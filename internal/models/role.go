@@ -0,0 +1,77 @@
+package models
+
+// Role - роль пользователя. Роль определяет, какие разделы /admin доступны пользователю.
+type Role string
+
+const (
+	RoleUser      Role = "user"      // Покупатель
+	RoleSeller    Role = "seller"    // Выставил хотя бы один товар; назначается при первой загрузке
+	RoleModerator Role = "moderator" // Модерация пользователей, товаров, отзывов и заказов
+	RoleAdmin     Role = "admin"     // Все права, включая балансы, роли, теги и журнал аудита
+)
+
+// Roles - все роли в порядке возрастания прав
+var Roles = []Role{RoleUser, RoleSeller, RoleModerator, RoleAdmin}
+
+// Permission - право на действие в разделе администратора
+type Permission string
+
+const (
+	PermAdminPanel       Permission = "admin.panel"        // Вход в раздел /admin
+	PermUsersView        Permission = "users.view"         // Поиск и просмотр пользователей
+	PermUsersBan         Permission = "users.ban"          // Блокировка и разблокировка аккаунтов
+	PermUsersBalance     Permission = "users.balance"      // Изменение баланса
+	PermUsersRoles       Permission = "users.roles"        // Назначение ролей
+	PermProductsModerate Permission = "products.moderate"  // Снятие товаров с продажи и возврат
+	PermReviewsModerate  Permission = "reviews.moderate"   // Скрытие отзывов с жалобами
+	PermOrdersView       Permission = "orders.view"        // Просмотр заказов
+	PermOrdersResendMail Permission = "orders.resend_mail" // Повторная отправка письма о заказе
+	PermTagsManage       Permission = "tags.manage"        // Управление тегами
+	PermAuditView        Permission = "audit.view"         // Журнал аудита и его выгрузка
+)
+
+var moderatorPermissions = []Permission{
+	PermAdminPanel,
+	PermUsersView,
+	PermUsersBan,
+	PermProductsModerate,
+	PermReviewsModerate,
+	PermOrdersView,
+	PermOrdersResendMail,
+}
+
+// rolePermissions - права каждой роли. У user и seller прав в разделе администратора нет.
+var rolePermissions = map[Role][]Permission{
+	RoleModerator: moderatorPermissions,
+	RoleAdmin: append(append([]Permission{}, moderatorPermissions...),
+		PermUsersBalance,
+		PermUsersRoles,
+		PermTagsManage,
+		PermAuditView,
+	),
+}
+
+// Valid сообщает, является ли значение одной из известных ролей
+func (r Role) Valid() bool {
+	for _, role := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Can сообщает, дает ли роль право perm
+func (r Role) Can(perm Permission) bool {
+	for _, p := range rolePermissions[r] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStaff сообщает, относится ли роль к модераторам или администраторам
+func (r Role) IsStaff() bool {
+	return r.Can(PermAdminPanel)
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session - сессия входа через браузер. В cookie лежит случайный токен, в базе - только его
+// SHA-256 хеш, поэтому по содержимому cookie нельзя выдать себя за другого пользователя.
+type Session struct {
+	ID         uint      `gorm:"primaryKey"`
+	UserID     uint      `gorm:"not null;index"`
+	TokenHash  string    `gorm:"size:64;not null;uniqueIndex"`
+	CreatedAt  time.Time `gorm:"not null"`
+	LastSeenAt time.Time `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
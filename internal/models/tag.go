@@ -1,14 +1,25 @@
 package models
 
 // Tag represents a tag that can be applied to products.
+// Теги образуют иерархию категорий: у тега может быть родитель (ParentID).
+// Имена уникальны без учета регистра (индекс idx_tags_lower_name), слаги - уникальны
+// (idx_tags_slug_unique); оба индекса создаются миграцией 0003_tag_constraints.
 type Tag struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	Name     string `gorm:"not null" json:"name"`
+	Slug     string `gorm:"size:80" json:"slug"` // Латинский идентификатор для URL
+	ParentID *uint  `gorm:"index" json:"parentId,omitempty"`
+
+	// При удалении родителя дочерние теги становятся тегами верхнего уровня
+	Parent *Tag `gorm:"foreignKey:ParentID;constraint:OnDelete:SET NULL" json:"-"`
 }
 
 // ProductTag represents the many-to-many relationship between products and tags.
-// Пока не используется напрямую в JSON ответах, но нужна для связи в БД.
+// Привязки удаляются вместе с товаром или тегом.
 type ProductTag struct {
 	ProductID uint `gorm:"primaryKey"`
-	TagID     int  `gorm:"primaryKey"`
+	TagID     uint `gorm:"primaryKey;index"`
+
+	Product Product `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE" json:"-"`
+	Tag     Tag     `gorm:"foreignKey:TagID;constraint:OnDelete:CASCADE" json:"-"`
 }
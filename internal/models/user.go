@@ -3,10 +3,27 @@ package models
 import "time"
 
 type User struct {
-	ID        uint    `gorm:"primaryKey"`
-	Username  string  `gorm:"size:255"`
-	Email     string  `gorm:"unique;not null"`
-	Password  string  `gorm:"not null"`
-	Balance   float64 `gorm:"default:0"`
-	CreatedAt time.Time
+	ID                uint    `gorm:"primaryKey"`
+	Username          string  `gorm:"size:255"`
+	Email             string  `gorm:"unique;not null"`
+	Password          string  `gorm:"not null"`
+	Balance           float64 `gorm:"default:0"`
+	GeneratedPassword bool    `gorm:"default:false"` // Аккаунт создан через OAuth, пароль пользователю неизвестен
+	CreatedAt         time.Time
+
+	// Публичная витрина продавца (/u/:username)
+	Bio         string `gorm:"type:text"`
+	AvatarPath  string
+	SocialLinks string `gorm:"type:text"` // Ссылки на соцсети, по одной на строку
+
+	// Роль и блокировка (раздел /admin). Заблокированный пользователь не может войти,
+	// а его действующие сессии и токены API перестают работать.
+	Role      Role `gorm:"size:20;not null;default:user"`
+	BannedAt  *time.Time
+	BanReason string `gorm:"type:text"`
+}
+
+// Banned сообщает, заблокирован ли аккаунт
+func (u User) Banned() bool {
+	return u.BannedAt != nil
 }
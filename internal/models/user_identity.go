@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// UserIdentity связывает аккаунт пользователя с внешним OAuth/OIDC провайдером.
+// Пара (Provider, Subject) уникальна: один внешний аккаунт привязан ровно к одному пользователю.
+type UserIdentity struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	Provider  string `gorm:"size:50;not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Subject   string `gorm:"size:255;not null;uniqueIndex:idx_user_identities_provider_subject"`
+	Email     string `gorm:"size:255"` // Email, который вернул провайдер при привязке (только для отображения)
+	CreatedAt time.Time
+
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
+// PendingIdentityLink - внешний аккаунт, email которого совпал с email существующего пользователя.
+// Привязка ждет подтверждения владельцем аккаунта: паролем, входом через уже привязанный
+// внешний аккаунт или ссылкой из письма. В cookie и в письме лежат случайные токены,
+// в базе - только их SHA-256 хеши, как у Session.
+type PendingIdentityLink struct {
+	ID             uint      `gorm:"primaryKey"`
+	TokenHash      string    `gorm:"size:64;not null;uniqueIndex"`
+	EmailTokenHash *string   `gorm:"size:64;uniqueIndex"` // nil - письмо с подтверждением не отправлялось
+	UserID         uint      `gorm:"not null;index"`
+	Email          string    `gorm:"size:255;not null"` // Email аккаунта, к которому просят привязку
+	Provider       string    `gorm:"size:50;not null"`
+	Subject        string    `gorm:"size:255;not null"`
+	CreatedAt      time.Time `gorm:"not null"`
+	ExpiresAt      time.Time `gorm:"not null;index"`
+
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Виды уведомлений по товарам из избранного
+const (
+	WishlistNotificationPriceDrop  = "price_drop"
+	WishlistNotificationNewVersion = "new_version"
+)
+
+// WishlistItem - товар в избранном пользователя. Пользователь подписан на уведомления
+// о снижении цены и выходе новых версий этого товара.
+type WishlistItem struct {
+	ID        uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"not null;uniqueIndex:idx_wishlist_user_product"`
+	ProductID uint `gorm:"not null;uniqueIndex:idx_wishlist_user_product;index"`
+	CreatedAt time.Time
+
+	User    User    `gorm:"foreignKey:UserID"`
+	Product Product `gorm:"foreignKey:ProductID"`
+}
+
+// WishlistNotification - событие по товару из избранного, которое ждет отправки.
+// Письма отправляются пачками: все события пользователя попадают в одно письмо.
+// Если письмо не ушло, событие откладывается до NextAttemptAt, а после
+// исчерпания попыток помечается FailedAt и больше не отправляется.
+type WishlistNotification struct {
+	ID        uint    `gorm:"primaryKey"`
+	UserID    uint    `gorm:"not null;index"`
+	ProductID uint    `gorm:"not null"`
+	Kind      string  `gorm:"size:20;not null"` // price_drop или new_version
+	OldPrice  float64 `gorm:"not null;default:0"`
+	NewPrice  float64 `gorm:"not null;default:0"`
+	Version   int     `gorm:"not null;default:0"`
+	CreatedAt time.Time
+	SentAt    *time.Time `gorm:"index"` // nil - еще не отправлено
+
+	Attempts      int        `gorm:"not null;default:0"` // Неудачные попытки отправки
+	NextAttemptAt *time.Time // Следующая попытка не раньше этого времени; nil - при ближайшем проходе
+	FailedAt      *time.Time // Попытки исчерпаны, событие больше не отправляется
+
+	Product Product `gorm:"foreignKey:ProductID"`
+}
@@ -0,0 +1,134 @@
+package openapi
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Route - зарегистрированный в роутере маршрут (метод и путь в формате gin)
+type Route struct {
+	Method string
+	Path   string
+}
+
+var openAPIParamRegex = regexp.MustCompile(`\{([^}]+)\}`)
+
+// CheckRoutes сравнивает маршруты роутера с операциями документа и возвращает
+// список расхождений: маршруты без описания и описанные операции без маршрута.
+// Учитываются только маршруты, начинающиеся с prefix.
+func CheckRoutes(d *Document, routes []Route, prefix string) []string {
+	var problems []string
+
+	documented := make(map[string]bool)
+	for _, ref := range d.Operations() {
+		documented[ref.Method+" "+ref.Path] = true
+	}
+
+	registered := make(map[string]bool)
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, prefix) {
+			continue
+		}
+		key := route.Method + " " + PathFromGin(route.Path)
+		registered[key] = true
+		if !documented[key] {
+			problems = append(problems, fmt.Sprintf("маршрут %s не описан в спецификации", key))
+		}
+	}
+
+	for key := range documented {
+		if !registered[key] {
+			problems = append(problems, fmt.Sprintf("операция %s описана, но маршрут не зарегистрирован", key))
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// Validate проверяет внутреннюю согласованность документа: каждая ссылка $ref
+// ведет на существующую схему, параметры пути совпадают с шаблоном пути,
+// у каждой операции есть хотя бы один ответ и уникальный operationId.
+func (d *Document) Validate() []string {
+	var problems []string
+	operationIDs := make(map[string]string)
+
+	for _, ref := range d.Operations() {
+		name := ref.Method + " " + ref.Path
+		op := ref.Operation
+
+		if len(op.Responses) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: не описано ни одного ответа", name))
+		}
+		if op.OperationID == "" {
+			problems = append(problems, fmt.Sprintf("%s: не задан operationId", name))
+		} else if other, exists := operationIDs[op.OperationID]; exists {
+			problems = append(problems, fmt.Sprintf("%s: operationId %q уже используется в %s", name, op.OperationID, other))
+		} else {
+			operationIDs[op.OperationID] = name
+		}
+
+		inTemplate := make(map[string]bool)
+		for _, match := range openAPIParamRegex.FindAllStringSubmatch(ref.Path, -1) {
+			inTemplate[match[1]] = true
+		}
+		declared := make(map[string]bool)
+		for _, param := range op.Parameters {
+			d.checkSchema(param.Schema, name+" параметр "+param.Name, &problems)
+			if param.In != "path" {
+				continue
+			}
+			declared[param.Name] = true
+			if !inTemplate[param.Name] {
+				problems = append(problems, fmt.Sprintf("%s: параметр пути %s отсутствует в шаблоне", name, param.Name))
+			}
+		}
+		for param := range inTemplate {
+			if !declared[param] {
+				problems = append(problems, fmt.Sprintf("%s: параметр пути %s не описан", name, param))
+			}
+		}
+
+		if op.RequestBody != nil {
+			for contentType, media := range op.RequestBody.Content {
+				d.checkSchema(media.Schema, name+" тело "+contentType, &problems)
+			}
+		}
+		for status, response := range op.Responses {
+			for contentType, media := range response.Content {
+				d.checkSchema(media.Schema, name+" ответ "+status+" "+contentType, &problems)
+			}
+		}
+	}
+
+	for schemaName, schema := range d.Components.Schemas {
+		d.checkSchema(schema, "схема "+schemaName, &problems)
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// checkSchema рекурсивно проверяет, что все $ref схемы ведут на существующие компоненты
+func (d *Document) checkSchema(schema *Schema, location string, problems *[]string) {
+	if schema == nil {
+		return
+	}
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		if _, exists := d.Components.Schemas[name]; !exists {
+			*problems = append(*problems, fmt.Sprintf("%s: ссылка на несуществующую схему %s", location, schema.Ref))
+		}
+		return
+	}
+	for _, inner := range schema.AllOf {
+		d.checkSchema(inner, location, problems)
+	}
+	d.checkSchema(schema.Items, location, problems)
+	d.checkSchema(schema.AdditionalProperties, location, problems)
+	for _, prop := range schema.Properties {
+		d.checkSchema(prop, location, problems)
+	}
+}
@@ -0,0 +1,242 @@
+// Package openapi строит спецификацию OpenAPI 3 для JSON API маркетплейса.
+// Схемы запросов и ответов генерируются рефлексией из Go-типов (models.Product,
+// DTO контроллеров), поэтому изменение структуры сразу отражается в документе.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Version - версия формата OpenAPI, в которой выдается документ
+const Version = "3.0.3"
+
+// Document - корневой объект спецификации
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Servers    []Server             `json:"servers,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+	Tags       []Tag                `json:"tags,omitempty"`
+
+	componentTypes map[string]reflect.Type // Go-тип каждой схемы из components, для поиска конфликтов имен
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// PathItem содержит операции одного пути
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Put    *Operation `json:"put,omitempty"`
+	Patch  *Operation `json:"patch,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+}
+
+// Operation описывает один эндпоинт (метод + путь)
+type Operation struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // path, query, header
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// New создает пустой документ
+func New(title, version, description string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: title, Version: version, Description: description},
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			Schemas:         make(map[string]*Schema),
+			SecuritySchemes: make(map[string]*SecurityScheme),
+		},
+		componentTypes: make(map[string]reflect.Type),
+	}
+}
+
+// ginParamRegex находит параметры пути в формате gin (:id, *filepath)
+var ginParamRegex = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+
+// PathFromGin переводит путь gin ("/products/:id") в формат OpenAPI ("/products/{id}")
+func PathFromGin(path string) string {
+	return ginParamRegex.ReplaceAllString(path, "{$1}")
+}
+
+// Add регистрирует операцию для метода и пути в формате gin. Параметры пути,
+// которые не описаны явно, добавляются автоматически (ID - целые числа, остальное - строки).
+func (d *Document) Add(method, ginPath string, op Operation) {
+	path := PathFromGin(ginPath)
+	item, exists := d.Paths[path]
+	if !exists {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+
+	declared := make(map[string]bool)
+	for _, param := range op.Parameters {
+		if param.In == "path" {
+			declared[param.Name] = true
+		}
+	}
+	var pathParams []Parameter
+	for _, match := range ginParamRegex.FindAllStringSubmatch(ginPath, -1) {
+		name := match[1]
+		if declared[name] {
+			continue
+		}
+		schema := &Schema{Type: "string"}
+		if name == "id" || strings.HasSuffix(name, "ID") {
+			schema = &Schema{Type: "integer", Format: "int64", Minimum: Float(1)}
+		}
+		pathParams = append(pathParams, Parameter{Name: name, In: "path", Required: true, Schema: schema})
+	}
+	op.Parameters = append(pathParams, op.Parameters...)
+
+	switch method {
+	case http.MethodGet:
+		item.Get = &op
+	case http.MethodPost:
+		item.Post = &op
+	case http.MethodPut:
+		item.Put = &op
+	case http.MethodPatch:
+		item.Patch = &op
+	case http.MethodDelete:
+		item.Delete = &op
+	default:
+		panic(fmt.Sprintf("openapi: неподдерживаемый метод %s", method))
+	}
+}
+
+// Operations возвращает все операции документа, отсортированные по пути и методу
+func (d *Document) Operations() []OperationRef {
+	var result []OperationRef
+	for path, item := range d.Paths {
+		for _, entry := range []struct {
+			method string
+			op     *Operation
+		}{
+			{http.MethodGet, item.Get},
+			{http.MethodPost, item.Post},
+			{http.MethodPut, item.Put},
+			{http.MethodPatch, item.Patch},
+			{http.MethodDelete, item.Delete},
+		} {
+			if entry.op != nil {
+				result = append(result, OperationRef{Method: entry.method, Path: path, Operation: entry.op})
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Path != result[j].Path {
+			return result[i].Path < result[j].Path
+		}
+		return result[i].Method < result[j].Method
+	})
+	return result
+}
+
+// OperationRef - операция вместе с методом и путем
+type OperationRef struct {
+	Method    string
+	Path      string
+	Operation *Operation
+}
+
+// JSON сериализует документ с отступами
+func (d *Document) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// --- Хелперы для описания операций ---
+
+// JSONContent оборачивает схему в content "application/json"
+func JSONContent(schema *Schema) map[string]*MediaType {
+	return map[string]*MediaType{"application/json": {Schema: schema}}
+}
+
+// JSONBody описывает обязательное JSON-тело запроса
+func JSONBody(schema *Schema) *RequestBody {
+	return &RequestBody{Required: true, Content: JSONContent(schema)}
+}
+
+// JSONResponse описывает ответ с JSON-телом
+func JSONResponse(description string, schema *Schema) *Response {
+	return &Response{Description: description, Content: JSONContent(schema)}
+}
+
+// QueryParam описывает необязательный строковый query-параметр
+func QueryParam(name, description string) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: &Schema{Type: "string"}}
+}
+
+// Float возвращает указатель на число (для Minimum/Maximum)
+func Float(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,183 @@
+package openapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Schema - JSON Schema в диалекте OpenAPI 3.0
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf возвращает схему для значения v. Именованные структуры регистрируются
+// в components/schemas и возвращаются как $ref.
+func (d *Document) SchemaOf(v interface{}) *Schema {
+	return d.schemaForType(reflect.TypeOf(v))
+}
+
+// Ref возвращает ссылку на схему из components/schemas
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// ArrayOf возвращает схему массива элементов item
+func ArrayOf(item *Schema) *Schema {
+	return &Schema{Type: "array", Items: item}
+}
+
+// ObjectOf возвращает схему объекта с обязательными свойствами
+func ObjectOf(properties map[string]*Schema, required ...string) *Schema {
+	return &Schema{Type: "object", Properties: properties, Required: required}
+}
+
+func (d *Document) schemaForType(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+
+	if t.Kind() == reflect.Pointer {
+		inner := d.schemaForType(t.Elem())
+		if inner.Ref != "" {
+			// В OpenAPI 3.0 соседние с $ref ключи игнорируются, поэтому nullable задается через allOf
+			return &Schema{AllOf: []*Schema{inner}, Nullable: true}
+		}
+		inner.Nullable = true
+		return inner
+	}
+
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32", Minimum: Float(0)}
+	case reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64", Minimum: Float(0)}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return ArrayOf(d.schemaForType(t.Elem()))
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaForType(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		name := componentName(t)
+		if registered, exists := d.componentTypes[name]; exists {
+			if registered != t {
+				panic(fmt.Sprintf("openapi: типы %s и %s получают одно имя схемы %s", registered, t, name))
+			}
+			return Ref(name)
+		}
+		// Регистрируем имя до обхода полей, чтобы рекурсивные типы ссылались сами на себя
+		d.componentTypes[name] = t
+		d.Components.Schemas[name] = d.structSchema(t)
+		return Ref(name)
+	}
+
+	panic(fmt.Sprintf("openapi: тип %s не поддерживается", t))
+}
+
+// structSchema строит схему объекта по полям структуры с учетом тегов json
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, omitEmpty, skip := jsonFieldName(field)
+		if skip {
+			continue
+		}
+
+		// Встроенные структуры без тега json раскрываются в родительский объект
+		if field.Anonymous && field.Tag.Get("json") == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				inner := d.structSchema(embedded)
+				for propName, prop := range inner.Properties {
+					schema.Properties[propName] = prop
+				}
+				schema.Required = append(schema.Required, inner.Required...)
+				continue
+			}
+		}
+
+		schema.Properties[name] = d.schemaForType(field.Type)
+		if !omitEmpty && field.Type.Kind() != reflect.Pointer {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// jsonFieldName возвращает имя поля в JSON так же, как encoding/json
+func jsonFieldName(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	if !field.IsExported() && !field.Anonymous {
+		return "", false, true
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, false
+}
+
+// componentName - имя схемы в components. Неэкспортируемые DTO вида apiProduct
+// получают имя APIProduct, остальные типы - имя типа с заглавной буквы.
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	if strings.HasPrefix(name, "api") && len(name) > 3 && unicode.IsUpper(rune(name[3])) {
+		return "API" + name[3:]
+	}
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
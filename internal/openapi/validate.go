@@ -0,0 +1,204 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidateResponse проверяет ответ на запрос method requestPath (путь запроса без query,
+// например /api/v1/products/42) по документу: код status должен быть описан у операции,
+// JSON-тело - соответствовать схеме ответа, а ответ без описанного тела - быть пустым.
+func (d *Document) ValidateResponse(method, requestPath string, status int, body []byte) error {
+	ref, ok := d.findOperation(method, requestPath)
+	if !ok {
+		return fmt.Errorf("операция %s %s не описана", method, requestPath)
+	}
+	name := ref.Method + " " + ref.Path
+
+	response, ok := ref.Operation.Responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = ref.Operation.Responses["default"]
+	}
+	if !ok {
+		return fmt.Errorf("%s: ответ %d не описан", name, status)
+	}
+
+	media := response.Content["application/json"]
+	if media == nil {
+		if len(response.Content) == 0 && len(bytes.TrimSpace(body)) > 0 {
+			return fmt.Errorf("%s: ответ %d описан без тела, а получено %d байт", name, status, len(body))
+		}
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("%s: ответ %d не является JSON: %v", name, status, err)
+	}
+	if err := d.validateValue(media.Schema, value, "$"); err != nil {
+		return fmt.Errorf("%s: ответ %d: %v", name, status, err)
+	}
+	return nil
+}
+
+// findOperation ищет операцию, шаблон пути которой совпадает с requestPath.
+// Если подходят несколько шаблонов, выбирается тот, у которого меньше параметров
+// (/products/mine точнее, чем /products/{id}).
+func (d *Document) findOperation(method, requestPath string) (OperationRef, bool) {
+	segments := strings.Split(requestPath, "/")
+	var best OperationRef
+	bestParams := -1
+	for _, ref := range d.Operations() {
+		template := strings.Split(ref.Path, "/")
+		if ref.Method != method || len(template) != len(segments) {
+			continue
+		}
+		params := 0
+		for i, part := range template {
+			if openAPIParamRegex.MatchString(part) && segments[i] != "" {
+				params++
+			} else if part != segments[i] {
+				params = -1
+				break
+			}
+		}
+		if params >= 0 && (bestParams < 0 || params < bestParams) {
+			best, bestParams = ref, params
+		}
+	}
+	return best, bestParams >= 0
+}
+
+// validateValue проверяет значение, декодированное из JSON с UseNumber, по схеме.
+// at - путь к значению для сообщения об ошибке ($.data.items[0]).
+func (d *Document) validateValue(schema *Schema, value interface{}, at string) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		target, ok := d.Components.Schemas[name]
+		if !ok {
+			return fmt.Errorf("%s: ссылка на несуществующую схему %s", at, schema.Ref)
+		}
+		return d.validateValue(target, value, at)
+	}
+	if value == nil {
+		if schema.Nullable || (schema.Type == "" && len(schema.AllOf) == 0) {
+			return nil
+		}
+		return fmt.Errorf("%s: null, а схема его не допускает", at)
+	}
+	for _, inner := range schema.AllOf {
+		if err := d.validateValue(inner, value, at); err != nil {
+			return err
+		}
+	}
+
+	switch schema.Type {
+	case "":
+		// Схема без типа допускает любое значение
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: ожидался объект, получено %T", at, value)
+		}
+		if err := d.validateObject(schema, object, at); err != nil {
+			return err
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: ожидался массив, получено %T", at, value)
+		}
+		for i, item := range items {
+			if err := d.validateValue(schema.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: ожидалась строка, получено %T", at, value)
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: %q не в формате date-time", at, s)
+			}
+		}
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: ожидалось число, получено %T", at, value)
+		}
+		f, err := number.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: некорректное число %s", at, number)
+		}
+		if _, err := number.Int64(); schema.Type == "integer" && err != nil {
+			return fmt.Errorf("%s: ожидалось целое число, получено %s", at, number)
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			return fmt.Errorf("%s: %s меньше минимума %v", at, number, *schema.Minimum)
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			return fmt.Errorf("%s: %s больше максимума %v", at, number, *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: ожидалось логическое значение, получено %T", at, value)
+		}
+	default:
+		return fmt.Errorf("%s: неизвестный тип схемы %s", at, schema.Type)
+	}
+
+	if len(schema.Enum) > 0 {
+		for _, allowed := range schema.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: значение %v не входит в %v", at, value, schema.Enum)
+	}
+	return nil
+}
+
+// validateObject проверяет обязательные и описанные поля объекта. Схемы строятся из Go-типов,
+// поэтому поле, которого нет в схеме, означает расхождение ответа и документа.
+func (d *Document) validateObject(schema *Schema, object map[string]interface{}, at string) error {
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("%s: нет обязательного поля %s", at, name)
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prop, described := schema.Properties[name]
+		switch {
+		case described:
+		case schema.AdditionalProperties != nil:
+			prop = schema.AdditionalProperties
+		case schema.Properties == nil:
+			// Объект без описанных полей (например, документ OpenAPI) не проверяется
+			continue
+		default:
+			return fmt.Errorf("%s: поле %s не описано в схеме", at, name)
+		}
+		if err := d.validateValue(prop, object[name], at+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
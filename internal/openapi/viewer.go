@@ -0,0 +1,9 @@
+package openapi
+
+import _ "embed"
+
+// ViewerHTML - страница просмотра спецификации. Встроена в бинарник и не загружает
+// ничего со сторонних серверов, поэтому документация доступна и без интернета.
+//
+//go:embed viewer.html
+var ViewerHTML []byte
@@ -0,0 +1,1462 @@
+package repository
+
+import (
+	"context"
+	"digital-marketplace/internal/database"
+	"digital-marketplace/internal/models"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGorm создает репозитории поверх подключения GORM
+func NewGorm(db *gorm.DB) Repositories {
+	return Repositories{
+		Users:    &gormUserRepository{db: db},
+		Products: &gormProductRepository{db: db},
+		Tags:     &gormTagRepository{db: db},
+		Carts:    &gormCartRepository{db: db},
+		Orders:   &gormOrderRepository{db: db},
+		Sessions: &gormSessionRepository{db: db},
+
+		Identities: &gormIdentityRepository{db: db},
+		Tokens:     &gormTokenRepository{db: db},
+		Reviews:    &gormReviewRepository{db: db},
+		Wishlist:   &gormWishlistRepository{db: db},
+		Audit:      &gormAuditRepository{db: db},
+		Downloads:  &gormDownloadRepository{db: db},
+
+		ProductSearch: &gormProductSearchRepository{db: db},
+	}
+}
+
+// notFound заменяет gorm.ErrRecordNotFound на ErrNotFound, чтобы вызывающий код не зависел от GORM
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// --- Пользователи ---
+
+type gormUserRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormUserRepository) FindByID(id uint) (*models.User, error) {
+	var user models.User
+	if err := r.db.First(&user, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepository) FindByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepository) FindByUsername(username string) (*models.User, error) {
+	var user models.User
+	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepository) Create(user *models.User) error {
+	return r.db.Create(user).Error
+}
+
+func (r *gormUserRepository) Save(user *models.User) error {
+	return r.db.Save(user).Error
+}
+
+func (r *gormUserRepository) UpdatePassword(id uint, hash string) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).Update("password", hash).Error
+}
+
+func (r *gormUserRepository) FindByIDs(ids []uint) ([]models.User, error) {
+	var users []models.User
+	if len(ids) == 0 {
+		return users, nil
+	}
+	err := r.db.Where("id IN ?", ids).Find(&users).Error
+	return users, err
+}
+
+func (r *gormUserRepository) FindByUsernameFold(username string) (*models.User, error) {
+	var user models.User
+	if err := r.db.Where("lower(username) = lower(?)", username).Order("id asc").First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepository) UpdateProfile(id uint, bio, socialLinks string) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"bio":          bio,
+		"social_links": socialLinks,
+	}).Error
+}
+
+func (r *gormUserRepository) SetAvatar(id uint, path string) (oldPath string, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Select("id", "avatar_path").First(&user, id).Error; err != nil {
+			return notFound(err)
+		}
+		oldPath = user.AvatarPath
+		return tx.Model(&user).Update("avatar_path", path).Error
+	})
+	return oldPath, err
+}
+
+func (r *gormUserRepository) FindByEmailsFold(emails []string) ([]models.User, error) {
+	var users []models.User
+	if len(emails) == 0 {
+		return users, nil
+	}
+	lowered := make([]string, 0, len(emails))
+	for _, email := range emails {
+		lowered = append(lowered, strings.ToLower(email))
+	}
+	err := r.db.Where("lower(email) IN ?", lowered).Order("id asc").Find(&users).Error
+	return users, err
+}
+
+func (r *gormUserRepository) Search(filter UserFilter, limit int) ([]models.User, error) {
+	db := r.db.Model(&models.User{})
+	if filter.Text != "" {
+		pattern := likePattern(filter.Text)
+		db = db.Where("id = ? OR email ILIKE ? OR username ILIKE ?", filter.ID, pattern, pattern)
+	}
+	if filter.Role != "" {
+		db = db.Where("role = ?", filter.Role)
+	}
+	if filter.BannedOnly {
+		db = db.Where("banned_at IS NOT NULL")
+	}
+
+	var users []models.User
+	err := db.Order("id desc").Limit(limit).Find(&users).Error
+	return users, err
+}
+
+func (r *gormUserRepository) Change(ctx context.Context, id uint, change func(user *models.User) (*models.AuditEvent, error)) (*models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, id).Error; err != nil {
+			return notFound(err)
+		}
+		event, err := change(&user)
+		if err != nil || event == nil {
+			return err
+		}
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(event).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// --- Товары ---
+
+type gormProductRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormProductRepository) FindByID(id uint) (*models.Product, error) {
+	var product models.Product
+	if err := r.db.First(&product, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &product, nil
+}
+
+func (r *gormProductRepository) ListBySeller(userID uint) ([]models.Product, error) {
+	var products []models.Product
+	err := r.db.Where("user_id = ?", userID).Order("created_at desc").Find(&products).Error
+	return products, err
+}
+
+func (r *gormProductRepository) CountBySeller(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.Product{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *gormProductRepository) SellerStats(sellerID uint) (SellerStats, error) {
+	var stats SellerStats
+	err := r.db.Raw(`SELECT
+			(SELECT COUNT(*) FROM products p WHERE p.user_id = @seller AND p.unlisted_at IS NULL) AS product_count,
+			(SELECT COUNT(*) FROM order_items oi JOIN products p ON p.id = oi.product_id WHERE p.user_id = @seller) AS sales_count,
+			COALESCE((SELECT AVG(r.rating) FROM reviews r JOIN products p ON p.id = r.product_id
+				WHERE p.user_id = @seller AND NOT r.hidden), 0)::float8 AS rating_avg,
+			(SELECT COUNT(*) FROM reviews r JOIN products p ON p.id = r.product_id
+				WHERE p.user_id = @seller AND NOT r.hidden) AS review_count`,
+		map[string]interface{}{"seller": sellerID}).Scan(&stats).Error
+	return stats, err
+}
+
+func (r *gormProductRepository) Create(ctx context.Context, product *models.Product, tagIDs []uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(product).Error; err != nil {
+			return err
+		}
+		if err := replaceProductTags(tx, product.ID, tagIDs); err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).
+			Where("id = ? AND role = ?", product.UserID, models.RoleUser).
+			Update("role", models.RoleSeller).Error
+	})
+}
+
+func (r *gormProductRepository) Update(ctx context.Context, product *models.Product, changes ProductChanges) error {
+	var updated models.Product
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, product.ID).Error; err != nil {
+			return notFound(err)
+		}
+
+		updates := make(map[string]interface{})
+		if changes.Title != nil {
+			updates["title"] = *changes.Title
+		}
+		if changes.Description != nil {
+			updates["description"] = *changes.Description
+		}
+		if changes.Price != nil {
+			updates["price"] = *changes.Price
+		}
+		if changes.Watermark != nil {
+			updates["watermark"] = *changes.Watermark
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&current).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if changes.TagIDs != nil {
+			if err := replaceProductTags(tx, product.ID, *changes.TagIDs); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.First(&updated, product.ID).Error; err != nil {
+			return err
+		}
+		if updated.Price >= current.Price {
+			return nil
+		}
+		return enqueueWishlistNotifications(tx, models.WishlistNotification{
+			ProductID: updated.ID,
+			Kind:      models.WishlistNotificationPriceDrop,
+			OldPrice:  current.Price,
+			NewPrice:  updated.Price,
+			Version:   updated.Version,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	*product = updated
+	return nil
+}
+
+func (r *gormProductRepository) PublishVersion(ctx context.Context, product *models.Product, filePath string) error {
+	var updated models.Product
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Product{}).Where("id = ?", product.ID).Updates(map[string]interface{}{
+			"file_path": filePath,
+			"version":   gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		if err := tx.First(&updated, product.ID).Error; err != nil {
+			return err
+		}
+		return enqueueWishlistNotifications(tx, models.WishlistNotification{
+			ProductID: updated.ID,
+			Kind:      models.WishlistNotificationNewVersion,
+			OldPrice:  updated.Price,
+			NewPrice:  updated.Price,
+			Version:   updated.Version,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	*product = updated
+	return nil
+}
+
+func (r *gormProductRepository) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", id).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("product_id = ?", id).Delete(&models.WishlistNotification{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("product_id = ?", id).Delete(&models.WishlistItem{}).Error; err != nil {
+			return err
+		}
+		// Привязки к тегам удаляются каскадно вместе с товаром
+		result := tx.Delete(&models.Product{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (r *gormProductRepository) Search(filter ProductFilter, limit int) ([]ProductWithSales, error) {
+	db := r.db.Table("products p").
+		Select(`p.*, u.email AS seller_email,
+			(SELECT COUNT(*) FROM order_items oi WHERE oi.product_id = p.id) AS sales_count`).
+		Joins("LEFT JOIN users u ON u.id = p.user_id")
+	if filter.Text != "" {
+		pattern := likePattern(filter.Text)
+		db = db.Where("p.id = ? OR p.title ILIKE ? OR u.email ILIKE ?", filter.ID, pattern, pattern)
+	}
+	if filter.UnlistedOnly {
+		db = db.Where("p.unlisted_at IS NOT NULL")
+	}
+
+	var products []ProductWithSales
+	err := db.Order("p.id desc").Limit(limit).Scan(&products).Error
+	return products, err
+}
+
+func (r *gormProductRepository) Change(ctx context.Context, id uint, change func(product *models.Product) (*models.AuditEvent, error)) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, id).Error; err != nil {
+			return notFound(err)
+		}
+		event, err := change(&product)
+		if err != nil || event == nil {
+			return err
+		}
+		if err := tx.Save(&product).Error; err != nil {
+			return err
+		}
+		// Оформить заказ на снятый товар все равно не получится
+		if !product.Listed() {
+			if err := tx.Where("product_id = ?", id).Delete(&models.CartItem{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(event).Error
+	})
+}
+
+// replaceProductTags заменяет набор тегов товара
+func replaceProductTags(tx *gorm.DB, productID uint, tagIDs []uint) error {
+	if err := tx.Where("product_id = ?", productID).Delete(&models.ProductTag{}).Error; err != nil {
+		return err
+	}
+	if len(tagIDs) == 0 {
+		return nil
+	}
+
+	productTags := make([]models.ProductTag, 0, len(tagIDs))
+	for _, tagID := range tagIDs {
+		productTags = append(productTags, models.ProductTag{ProductID: productID, TagID: tagID})
+	}
+	return tx.Create(&productTags).Error
+}
+
+// enqueueWishlistNotifications создает событие для каждого пользователя, у которого товар в избранном
+func enqueueWishlistNotifications(tx *gorm.DB, n models.WishlistNotification) error {
+	return tx.Exec(`INSERT INTO wishlist_notifications (user_id, product_id, kind, old_price, new_price, version, created_at)
+		SELECT w.user_id, ?, ?, ?, ?, ?, ? FROM wishlist_items w WHERE w.product_id = ?`,
+		n.ProductID, n.Kind, n.OldPrice, n.NewPrice, n.Version, time.Now(), n.ProductID).Error
+}
+
+// --- Теги ---
+
+type gormTagRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormTagRepository) List() ([]models.Tag, error) {
+	var tags []models.Tag
+	err := r.db.Order("name asc").Find(&tags).Error
+	return tags, err
+}
+
+func (r *gormTagRepository) ListByProduct(productID uint) ([]models.Tag, error) {
+	var tags []models.Tag
+	err := r.db.Joins("JOIN product_tags pt ON pt.tag_id = tags.id").
+		Where("pt.product_id = ?", productID).
+		Order("tags.name asc").
+		Find(&tags).Error
+	return tags, err
+}
+
+func (r *gormTagRepository) NamesByProducts(productIDs []uint) (map[uint][]string, error) {
+	result := make(map[uint][]string)
+	if len(productIDs) == 0 {
+		return result, nil
+	}
+
+	var rows []struct {
+		ProductID uint
+		Name      string
+	}
+	err := r.db.Table("product_tags").
+		Select("product_tags.product_id, tags.name").
+		Joins("JOIN tags ON tags.id = product_tags.tag_id").
+		Where("product_tags.product_id IN ?", productIDs).
+		Order("tags.name asc").
+		Scan(&rows).Error
+	if err != nil {
+		return result, err
+	}
+
+	for _, row := range rows {
+		result[row.ProductID] = append(result[row.ProductID], row.Name)
+	}
+	return result, nil
+}
+
+func (r *gormTagRepository) ExistingIDs(ids []uint) ([]uint, error) {
+	var existing []uint
+	if len(ids) == 0 {
+		return existing, nil
+	}
+	err := r.db.Model(&models.Tag{}).Where("id IN ?", ids).Pluck("id", &existing).Error
+	return existing, err
+}
+
+func (r *gormTagRepository) ListWithUsage() ([]TagUsage, error) {
+	var tags []TagUsage
+	err := r.db.Table("tags").
+		Select("tags.*, (SELECT COUNT(*) FROM product_tags pt WHERE pt.tag_id = tags.id) AS usage_count").
+		Order("lower(tags.name) asc").
+		Scan(&tags).Error
+	return tags, err
+}
+
+func (r *gormTagRepository) ListWithoutSlug() ([]models.Tag, error) {
+	var tags []models.Tag
+	err := r.db.Where("slug IS NULL OR slug = ''").Order("id asc").Find(&tags).Error
+	return tags, err
+}
+
+func (r *gormTagRepository) FindByID(id uint) (*models.Tag, error) {
+	var tag models.Tag
+	if err := r.db.First(&tag, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &tag, nil
+}
+
+func (r *gormTagRepository) FindByName(name string) (*models.Tag, error) {
+	var tag models.Tag
+	if err := r.db.Where("lower(name) = lower(?)", name).First(&tag).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &tag, nil
+}
+
+func (r *gormTagRepository) NameTaken(name string, excludeID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.Tag{}).Where("lower(name) = lower(?) AND id <> ?", name, excludeID).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *gormTagRepository) SlugTaken(slug string, excludeID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.Tag{}).Where("slug = ? AND id <> ?", slug, excludeID).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *gormTagRepository) IsAncestor(ancestorID, id uint) (bool, error) {
+	return tagIsAncestor(r.db, ancestorID, id)
+}
+
+func (r *gormTagRepository) Create(tag *models.Tag) error {
+	return r.db.Create(tag).Error
+}
+
+func (r *gormTagRepository) Save(tag *models.Tag) error {
+	return r.db.Model(tag).Select("name", "slug", "parent_id").Updates(tag).Error
+}
+
+func (r *gormTagRepository) Merge(sourceID, targetID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var source, target models.Tag
+		if err := tx.First(&source, sourceID).Error; err != nil {
+			return notFound(err)
+		}
+		if err := tx.First(&target, targetID).Error; err != nil {
+			return notFound(err)
+		}
+
+		// Товары, у которых уже есть target, просто теряют source
+		if err := tx.Exec(`INSERT INTO product_tags (product_id, tag_id)
+			SELECT product_id, ? FROM product_tags WHERE tag_id = ?
+			ON CONFLICT DO NOTHING`, targetID, sourceID).Error; err != nil {
+			return err
+		}
+
+		// Если target был потомком source, поднимаем его на место source, чтобы не получить цикл
+		if isAncestor, err := tagIsAncestor(tx, sourceID, targetID); err != nil {
+			return err
+		} else if isAncestor {
+			if err := tx.Model(&target).Update("parent_id", source.ParentID).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&models.Tag{}).Where("parent_id = ? AND id <> ?", sourceID, targetID).
+			Update("parent_id", targetID).Error; err != nil {
+			return err
+		}
+
+		// Привязки source к товарам удаляются каскадно
+		return tx.Delete(&source).Error
+	})
+}
+
+func (r *gormTagRepository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var tag models.Tag
+		if err := tx.First(&tag, id).Error; err != nil {
+			return notFound(err)
+		}
+		if err := tx.Model(&models.Tag{}).Where("parent_id = ?", id).Update("parent_id", tag.ParentID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&tag).Error
+	})
+}
+
+// tagIsAncestor проверяет, является ли ancestorID предком тега id или им самим
+func tagIsAncestor(db *gorm.DB, ancestorID, id uint) (bool, error) {
+	var found int64
+	err := db.Raw(`WITH RECURSIVE chain AS (
+			SELECT id, parent_id FROM tags WHERE id = ?
+			UNION
+			SELECT t.id, t.parent_id FROM tags t JOIN chain c ON t.id = c.parent_id
+		)
+		SELECT COUNT(*) FROM chain WHERE id = ?`, id, ancestorID).Scan(&found).Error
+	return found > 0, err
+}
+
+// --- Корзина ---
+
+type gormCartRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormCartRepository) ListByUser(userID uint) ([]models.CartItem, error) {
+	var items []models.CartItem
+	err := r.db.Preload("Product").Where("user_id = ?", userID).Order("created_at desc").Find(&items).Error
+	return items, err
+}
+
+func (r *gormCartRepository) Find(userID, productID uint) (*models.CartItem, error) {
+	var item models.CartItem
+	if err := r.db.Where("user_id = ? AND product_id = ?", userID, productID).First(&item).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &item, nil
+}
+
+func (r *gormCartRepository) Add(item *models.CartItem) error {
+	return r.db.Create(item).Error
+}
+
+func (r *gormCartRepository) Remove(userID, itemID uint) error {
+	result := r.db.Where("id = ? AND user_id = ?", itemID, userID).Delete(&models.CartItem{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormCartRepository) Checkout(ctx context.Context, userID uint, charge func(user *models.User, items []models.CartItem) (OrderCharge, error)) (*models.Order, error) {
+	var order *models.Order
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Блокируем строку пользователя, чтобы параллельные покупки не списали баланс дважды
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return notFound(err)
+		}
+		var items []models.CartItem
+		if err := tx.Preload("Product").Where("user_id = ?", userID).Order("created_at desc").Find(&items).Error; err != nil {
+			return err
+		}
+		decision, err := charge(&user, items)
+		if err != nil {
+			return err
+		}
+
+		productIDs := make([]uint, 0, len(items))
+		for _, item := range items {
+			productIDs = append(productIDs, item.ProductID)
+		}
+		if order, err = placeOrder(tx, &user, productIDs, decision); err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.CartItem{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// placeOrder создает заказ пользователя с позициями productIDs, списывает charge.Total
+// с баланса и сохраняет запись журнала. Вызывается внутри транзакции.
+func placeOrder(tx *gorm.DB, user *models.User, productIDs []uint, charge OrderCharge) (*models.Order, error) {
+	order := models.Order{UserID: user.ID, CreatedAt: time.Now()}
+	if err := tx.Create(&order).Error; err != nil {
+		return nil, err
+	}
+	items := make([]models.OrderItem, 0, len(productIDs))
+	for _, productID := range productIDs {
+		items = append(items, models.OrderItem{OrderID: order.ID, ProductID: productID})
+	}
+	if err := tx.Create(&items).Error; err != nil {
+		return nil, err
+	}
+	order.Items = items
+
+	if err := tx.Model(&models.User{}).Where("id = ?", user.ID).
+		Update("balance", gorm.Expr("balance - ?", charge.Total)).Error; err != nil {
+		return nil, err
+	}
+	if charge.Event != nil {
+		charge.Event.TargetID = &order.ID
+		if err := tx.Create(charge.Event).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &order, nil
+}
+
+// --- Заказы ---
+
+type gormOrderRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormOrderRepository) ListByUser(userID uint) ([]models.Order, error) {
+	var orders []models.Order
+	err := r.db.Preload("Items").Preload("Items.Product").
+		Where("user_id = ?", userID).Order("created_at desc").Find(&orders).Error
+	return orders, err
+}
+
+func (r *gormOrderRepository) FindForUser(userID, orderID uint) (*models.Order, error) {
+	var order models.Order
+	err := r.db.Preload("Items").Preload("Items.Product").
+		Where("id = ? AND user_id = ?", orderID, userID).First(&order).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &order, nil
+}
+
+func (r *gormOrderRepository) ItemsByOrder(orderID uint) ([]models.OrderItem, error) {
+	var items []models.OrderItem
+	err := r.db.Preload("Product").Where("order_id = ?", orderID).Find(&items).Error
+	return items, err
+}
+
+func (r *gormOrderRepository) HasPurchased(userID, productID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.product_id = ? AND orders.user_id = ?", productID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *gormOrderRepository) CountByProduct(productID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.OrderItem{}).Where("product_id = ?", productID).Count(&count).Error
+	return count, err
+}
+
+func (r *gormOrderRepository) FindByID(id uint) (*models.Order, error) {
+	var order models.Order
+	if err := r.db.Preload("User").Preload("Items.Product").First(&order, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &order, nil
+}
+
+func (r *gormOrderRepository) FirstOrderID(userID, productID uint) (uint, error) {
+	var orderID uint
+	err := r.db.Model(&models.OrderItem{}).
+		Select("COALESCE(MIN(orders.id), 0)").
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("order_items.product_id = ? AND orders.user_id = ?", productID, userID).
+		Scan(&orderID).Error
+	return orderID, err
+}
+
+func (r *gormOrderRepository) Search(id uint, email string, limit int) ([]OrderSummary, error) {
+	db := r.db.Table("orders o").
+		Select(`o.id, o.created_at, o.user_id, u.email,
+			(SELECT COUNT(*) FROM order_items oi WHERE oi.order_id = o.id) AS items_count`).
+		Joins("LEFT JOIN users u ON u.id = o.user_id")
+	switch {
+	case id != 0:
+		db = db.Where("o.id = ?", id)
+	case email != "":
+		db = db.Where("u.email ILIKE ?", likePattern(email))
+	}
+
+	var orders []OrderSummary
+	err := db.Order("o.id desc").Limit(limit).Scan(&orders).Error
+	return orders, err
+}
+
+func (r *gormOrderRepository) Buy(ctx context.Context, userID, productID uint, charge func(user *models.User, product *models.Product, purchased bool) (OrderCharge, error)) (*models.Order, error) {
+	var order *models.Order
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return notFound(err)
+		}
+		var product models.Product
+		if err := tx.First(&product, productID).Error; err != nil {
+			return notFound(err)
+		}
+		purchased, err := (&gormOrderRepository{db: tx}).HasPurchased(userID, productID)
+		if err != nil {
+			return err
+		}
+		decision, err := charge(&user, &product, purchased)
+		if err != nil {
+			return err
+		}
+		order, err = placeOrder(tx, &user, []uint{productID}, decision)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// --- Сессии ---
+
+type gormSessionRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormSessionRepository) Create(session *models.Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *gormSessionRepository) FindByTokenHash(hash string) (*models.Session, error) {
+	var session models.Session
+	if err := r.db.Where("token_hash = ?", hash).First(&session).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &session, nil
+}
+
+func (r *gormSessionRepository) Touch(id uint, at time.Time) error {
+	return r.db.Model(&models.Session{}).Where("id = ?", id).UpdateColumn("last_seen_at", at).Error
+}
+
+func (r *gormSessionRepository) Delete(hash string) error {
+	return r.db.Where("token_hash = ?", hash).Delete(&models.Session{}).Error
+}
+
+func (r *gormSessionRepository) DeleteByUser(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.Session{}).Error
+}
+
+func (r *gormSessionRepository) DeleteExpired(now time.Time) (int64, error) {
+	result := r.db.Where("expires_at < ?", now).Delete(&models.Session{})
+	return result.RowsAffected, result.Error
+}
+
+// --- Внешние аккаунты ---
+
+type gormIdentityRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormIdentityRepository) FindBySubject(provider, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	if err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &identity, nil
+}
+
+func (r *gormIdentityRepository) FindForUser(userID, id uint) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&identity).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &identity, nil
+}
+
+func (r *gormIdentityRepository) ListByUser(userID uint) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := r.db.Where("user_id = ?", userID).Order("provider asc").Find(&identities).Error
+	return identities, err
+}
+
+func (r *gormIdentityRepository) CountByUser(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.UserIdentity{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *gormIdentityRepository) Create(identity *models.UserIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *gormIdentityRepository) CreateWithUser(ctx context.Context, user *models.User, identity *models.UserIdentity) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return tx.Create(identity).Error
+	})
+}
+
+func (r *gormIdentityRepository) Delete(id uint) error {
+	return r.db.Delete(&models.UserIdentity{}, id).Error
+}
+
+func (r *gormIdentityRepository) CreatePending(link *models.PendingIdentityLink) error {
+	return r.db.Create(link).Error
+}
+
+func (r *gormIdentityRepository) FindPending(tokenHash string, now time.Time) (*models.PendingIdentityLink, error) {
+	return r.findPending("token_hash = ?", tokenHash, now)
+}
+
+func (r *gormIdentityRepository) FindPendingByEmailToken(tokenHash string, now time.Time) (*models.PendingIdentityLink, error) {
+	return r.findPending("email_token_hash = ?", tokenHash, now)
+}
+
+func (r *gormIdentityRepository) findPending(query, tokenHash string, now time.Time) (*models.PendingIdentityLink, error) {
+	var link models.PendingIdentityLink
+	if err := r.db.Where(query, tokenHash).Where("expires_at > ?", now).First(&link).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &link, nil
+}
+
+func (r *gormIdentityRepository) SetPendingEmailToken(id uint, tokenHash string, expiresAt time.Time) error {
+	return r.db.Model(&models.PendingIdentityLink{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"email_token_hash": tokenHash, "expires_at": expiresAt}).Error
+}
+
+func (r *gormIdentityRepository) ConfirmPending(ctx context.Context, id uint, identity *models.UserIdentity) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Удаление строки запроса - блокировка: параллельное подтверждение того же запроса
+		// дождется этой транзакции и не найдет строку
+		result := tx.Where("id = ?", id).Delete(&models.PendingIdentityLink{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Create(identity).Error
+	})
+}
+
+func (r *gormIdentityRepository) DeletePending(id uint) error {
+	return r.db.Delete(&models.PendingIdentityLink{}, id).Error
+}
+
+func (r *gormIdentityRepository) DeleteExpiredPending(now time.Time) (int64, error) {
+	result := r.db.Where("expires_at < ?", now).Delete(&models.PendingIdentityLink{})
+	return result.RowsAffected, result.Error
+}
+
+// --- Токены доступа ---
+
+type gormTokenRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormTokenRepository) CountActive(userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (r *gormTokenRepository) Create(token *models.PersonalAccessToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *gormTokenRepository) FindByHash(hash string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	if err := r.db.Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &token, nil
+}
+
+func (r *gormTokenRepository) Touch(id uint, at time.Time) error {
+	return r.db.Model(&models.PersonalAccessToken{}).Where("id = ?", id).UpdateColumn("last_used_at", at).Error
+}
+
+func (r *gormTokenRepository) ListActive(userID uint) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at desc").Find(&tokens).Error
+	return tokens, err
+}
+
+func (r *gormTokenRepository) Revoke(userID, id uint, at time.Time) error {
+	result := r.db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormTokenRepository) DeleteExpired(cutoff time.Time) (int64, error) {
+	result := r.db.Where("(revoked_at IS NOT NULL AND revoked_at < ?) OR (expires_at IS NOT NULL AND expires_at < ?)", cutoff, cutoff).
+		Delete(&models.PersonalAccessToken{})
+	return result.RowsAffected, result.Error
+}
+
+// --- Отзывы ---
+
+type gormReviewRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormReviewRepository) FindByID(id uint) (*models.Review, error) {
+	var review models.Review
+	if err := r.db.First(&review, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &review, nil
+}
+
+func (r *gormReviewRepository) FindVisible(id uint) (*models.Review, error) {
+	var review models.Review
+	if err := r.db.Preload("Product").Where("hidden = ?", false).First(&review, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &review, nil
+}
+
+func (r *gormReviewRepository) FindByUser(userID, productID uint) (*models.Review, error) {
+	var review models.Review
+	if err := r.db.Where("product_id = ? AND user_id = ?", productID, userID).First(&review).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &review, nil
+}
+
+func (r *gormReviewRepository) ListVisible(productID uint) ([]models.Review, error) {
+	reviews := []models.Review{}
+	err := r.db.Preload("User").
+		Where("product_id = ? AND hidden = ?", productID, false).
+		Order("created_at desc, id desc").
+		Find(&reviews).Error
+	return reviews, err
+}
+
+func (r *gormReviewRepository) ListForModeration(limit int) ([]models.Review, error) {
+	var reviews []models.Review
+	err := r.db.Preload("User").Preload("Product").
+		Where("flagged = ? OR hidden = ?", true, true).
+		Order("updated_at desc").Limit(limit).Find(&reviews).Error
+	return reviews, err
+}
+
+func (r *gormReviewRepository) Save(review *models.Review) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if review.ID == 0 {
+			if err := tx.Create(review).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Model(review).Updates(map[string]interface{}{
+			"rating": review.Rating,
+			"text":   review.Text,
+		}).Error; err != nil {
+			return err
+		}
+		return refreshProductRating(tx, review.ProductID)
+	})
+}
+
+func (r *gormReviewRepository) SetReply(id uint, reply string, at time.Time) error {
+	// UpdateColumns не меняет updated_at: он отражает только правки автора отзыва
+	return r.db.Model(&models.Review{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"seller_reply":    reply,
+		"seller_reply_at": at,
+	}).Error
+}
+
+func (r *gormReviewRepository) Flag(id uint) error {
+	return r.db.Model(&models.Review{}).Where("id = ?", id).UpdateColumn("flagged", true).Error
+}
+
+func (r *gormReviewRepository) SetHidden(ctx context.Context, id uint, hidden bool, event *models.AuditEvent) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var review models.Review
+		if err := tx.First(&review, id).Error; err != nil {
+			return notFound(err)
+		}
+		if err := tx.Model(&review).UpdateColumns(map[string]interface{}{"hidden": hidden, "flagged": false}).Error; err != nil {
+			return err
+		}
+		if err := refreshProductRating(tx, review.ProductID); err != nil {
+			return err
+		}
+		if event == nil {
+			return nil
+		}
+		return tx.Create(event).Error
+	})
+}
+
+// refreshProductRating пересчитывает среднюю оценку и число видимых отзывов товара
+func refreshProductRating(tx *gorm.DB, productID uint) error {
+	return tx.Exec(`
+		UPDATE products SET
+			rating_avg = COALESCE((SELECT AVG(r.rating) FROM reviews r WHERE r.product_id = ? AND NOT r.hidden), 0),
+			review_count = (SELECT COUNT(*) FROM reviews r WHERE r.product_id = ? AND NOT r.hidden)
+		WHERE id = ?`, productID, productID, productID).Error
+}
+
+// --- Избранное ---
+
+type gormWishlistRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormWishlistRepository) Add(item *models.WishlistItem) (bool, error) {
+	// Уникальный индекс (user_id, product_id) защищает от дублей при параллельных запросах
+	result := r.db.Where("user_id = ? AND product_id = ?", item.UserID, item.ProductID).FirstOrCreate(item)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *gormWishlistRepository) Remove(userID, productID uint) error {
+	result := r.db.Where("user_id = ? AND product_id = ?", userID, productID).Delete(&models.WishlistItem{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormWishlistRepository) ListByUser(userID uint) ([]models.WishlistItem, error) {
+	var items []models.WishlistItem
+	err := r.db.Preload("Product").Where("user_id = ?", userID).Order("created_at desc").Find(&items).Error
+	return items, err
+}
+
+func (r *gormWishlistRepository) Contains(userID, productID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.WishlistItem{}).Where("user_id = ? AND product_id = ?", userID, productID).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *gormWishlistRepository) PendingNotifications(ctx context.Context, now time.Time, limit int) ([]models.WishlistNotification, error) {
+	var pending []models.WishlistNotification
+	err := r.db.WithContext(ctx).Preload("Product").
+		Where("sent_at IS NULL AND failed_at IS NULL").
+		Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now).
+		Order("user_id, id").
+		Limit(limit).
+		Find(&pending).Error
+	return pending, err
+}
+
+func (r *gormWishlistRepository) MarkSent(ids []uint, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Model(&models.WishlistNotification{}).Where("id IN ?", ids).Update("sent_at", at).Error
+}
+
+func (r *gormWishlistRepository) MarkFailed(ids []uint, attempts int, retryAt *time.Time, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	updates := map[string]interface{}{"attempts": attempts, "next_attempt_at": retryAt}
+	if retryAt == nil {
+		updates["failed_at"] = at
+	}
+	return r.db.Model(&models.WishlistNotification{}).Where("id IN ?", ids).Updates(updates).Error
+}
+
+// --- Журнал аудита ---
+
+type gormAuditRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormAuditRepository) Create(ctx context.Context, event *models.AuditEvent) error {
+	return r.db.WithContext(ctx).Create(event).Error
+}
+
+func (r *gormAuditRepository) Search(filter AuditFilter, limit int) ([]models.AuditEvent, error) {
+	query := r.db.Model(&models.AuditEvent{})
+	if filter.Action != "" {
+		query = query.Where("action LIKE ?", escapeLike(filter.Action)+"%")
+	}
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != 0 {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.IP != "" {
+		query = query.Where("ip = ?", filter.IP)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+
+	var events []models.AuditEvent
+	err := query.Order("id desc").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+func (r *gormAuditRepository) ListForTarget(targetType string, targetID uint, limit int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	err := r.db.Where("target_type = ? AND target_id = ?", targetType, targetID).
+		Order("created_at desc, id desc").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+func (r *gormAuditRepository) FindByDetail(action, key, value string) (*models.AuditEvent, error) {
+	var event models.AuditEvent
+	if err := r.db.Where("action = ? AND details->>? = ?", action, key, value).Order("id").First(&event).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &event, nil
+}
+
+// likePattern превращает строку поиска в шаблон ILIKE "%...%", экранируя спецсимволы
+func likePattern(query string) string {
+	return "%" + escapeLike(query) + "%"
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// --- Скачивания ---
+
+type gormDownloadRepository struct {
+	db *gorm.DB
+}
+
+func (r *gormDownloadRepository) Reserve(ctx context.Context, download *models.Download, limit int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if limit > 0 && !download.ByOwner {
+			// Блокировка строки покупателя выстраивает его параллельные скачивания в очередь,
+			// поэтому подсчет ниже видит все уже зарезервированные скачивания
+			var user models.User
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, download.UserID).Error; err != nil {
+				return notFound(err)
+			}
+			var count int64
+			err := tx.Model(&models.Download{}).
+				Where("user_id = ? AND product_id = ? AND NOT by_owner", download.UserID, download.ProductID).
+				Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count >= int64(limit) {
+				return ErrLimitReached
+			}
+		}
+		return tx.Create(download).Error
+	})
+}
+
+func (r *gormDownloadRepository) Complete(ctx context.Context, download *models.Download) error {
+	result := r.db.WithContext(ctx).Model(download).
+		Select("bytes", "watermark", "order_id").
+		Updates(download)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *gormDownloadRepository) CountByBuyer(userID, productID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.Download{}).
+		Where("user_id = ? AND product_id = ? AND NOT by_owner", userID, productID).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *gormDownloadRepository) CountIPs(ctx context.Context, userID, productID uint, since time.Time, ip string) (ips, fromIP int64, err error) {
+	var stats struct {
+		IPs    int64 `gorm:"column:ips"`
+		FromIP int64 `gorm:"column:from_ip"`
+	}
+	err = r.db.WithContext(ctx).Model(&models.Download{}).
+		Select("COUNT(DISTINCT ip) AS ips, COUNT(*) FILTER (WHERE ip = ?) AS from_ip", ip).
+		Where("user_id = ? AND product_id = ? AND NOT by_owner AND created_at >= ?", userID, productID, since).
+		Scan(&stats).Error
+	return stats.IPs, stats.FromIP, err
+}
+
+func (r *gormDownloadRepository) Stats(productIDs []uint, period StatsPeriod) (map[uint]DownloadStats, error) {
+	result := make(map[uint]DownloadStats)
+	if len(productIDs) == 0 {
+		return result, nil
+	}
+
+	var rows []DownloadStats
+	err := r.db.Model(&models.Download{}).
+		Select(`product_id,
+			COUNT(*) AS downloads,
+			COUNT(*) FILTER (WHERE created_at >= ?) AS recent_downloads,
+			COUNT(DISTINCT user_id) AS buyers,
+			COALESCE(SUM(bytes), 0) AS bytes,
+			MAX(created_at) AS last_download_at`, period.RecentSince).
+		Where("product_id IN ? AND NOT by_owner", productIDs).
+		Group("product_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.ProductID] = row
+	}
+
+	if period.AnomalyIPs == 0 {
+		return result, nil
+	}
+	var suspicious []struct {
+		ProductID uint
+		Buyers    int64
+	}
+	err = r.db.Raw(`
+		SELECT product_id, COUNT(*) AS buyers FROM (
+			SELECT product_id, user_id FROM downloads
+			WHERE product_id IN ? AND NOT by_owner AND created_at >= ?
+			GROUP BY product_id, user_id
+			HAVING COUNT(DISTINCT ip) >= ?
+		) per_buyer
+		GROUP BY product_id`,
+		productIDs, period.AnomalySince, period.AnomalyIPs).
+		Scan(&suspicious).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range suspicious {
+		stats := result[row.ProductID]
+		stats.SuspiciousBuyers = row.Buyers
+		result[row.ProductID] = stats
+	}
+	return result, nil
+}
+
+func (r *gormDownloadRepository) FindByWatermark(code string) (*models.Download, error) {
+	var download models.Download
+	if err := r.db.Where("watermark = ?", code).First(&download).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &download, nil
+}
+
+// --- Поиск товаров ---
+
+type gormProductSearchRepository struct {
+	db *gorm.DB
+}
+
+// Search строит запрос по полнотекстовому индексу search_vector (миграция 0002_product_search)
+// с префиксным поиском слов, а при доступном pg_trgm находит и слова с опечатками.
+func (r *gormProductSearchRepository) Search(ctx context.Context, search ProductSearch) ([]ProductSearchHit, error) {
+	var (
+		selects     = []string{"p.*"}
+		selectArgs  []interface{}
+		conditions  []string
+		whereArgs   []interface{}
+		textQuery   = prefixTSQuery(search.Terms)
+		searchWords = strings.Join(search.Terms, " ")
+		rank        = "0"
+		rankArgs    []interface{}
+	)
+
+	if textQuery != "" {
+		rank = "ts_rank_cd(p.search_vector, to_tsquery('simple', ?))"
+		rankArgs = []interface{}{textQuery}
+		match := "p.search_vector @@ to_tsquery('simple', ?)"
+		matchArgs := []interface{}{textQuery}
+
+		if database.TrigramSearch {
+			// word_similarity находит слова с опечатками ("fotoshop" -> "photoshop")
+			rank += " + word_similarity(?, p.search_text) * 0.5"
+			rankArgs = append(rankArgs, searchWords)
+			match = "(" + match + " OR ? <% p.search_text)"
+			matchArgs = append(matchArgs, searchWords)
+		}
+
+		conditions = append(conditions, match)
+		whereArgs = append(whereArgs, matchArgs...)
+	}
+	selects = append(selects, "("+rank+")::float8 AS rank")
+	selectArgs = append(selectArgs, rankArgs...)
+
+	// Ключ сортировки всегда float8: время переводится в микросекунды, чтобы позиция была одного вида
+	switch search.Order {
+	case OrderByRank:
+		selects = append(selects, "("+rank+")::float8 AS sort_key")
+		selectArgs = append(selectArgs, rankArgs...)
+	case OrderByPrice:
+		selects = append(selects, "p.price::float8 AS sort_key")
+	case OrderBySales:
+		selects = append(selects, "(SELECT COUNT(*) FROM order_items oi WHERE oi.product_id = p.id)::float8 AS sort_key")
+	default:
+		selects = append(selects, "floor(extract(epoch from p.created_at) * 1000000)::float8 AS sort_key")
+	}
+
+	if len(search.Tags) > 0 {
+		// Для AnyTag товару достаточно одного тега, иначе нужны все.
+		// Тег из запроса (по имени или слагу) совпадает также со всеми своими дочерними тегами,
+		// поэтому совпадения считаются по строкам запроса (term), а не по ID тегов.
+		required := len(search.Tags)
+		if search.AnyTag {
+			required = 1
+		}
+		conditions = append(conditions, `p.id IN (
+			WITH RECURSIVE tag_tree AS (
+				SELECT t.id, CASE WHEN lower(t.name) IN (?) THEN lower(t.name) ELSE t.slug END AS term
+				FROM tags t WHERE lower(t.name) IN (?) OR t.slug IN (?)
+				UNION
+				SELECT c.id, tt.term FROM tags c JOIN tag_tree tt ON c.parent_id = tt.id
+			)
+			SELECT pt.product_id
+			FROM product_tags pt
+			JOIN tag_tree tt ON pt.tag_id = tt.id
+			GROUP BY pt.product_id
+			HAVING COUNT(DISTINCT tt.term) >= ?)`)
+		whereArgs = append(whereArgs, search.Tags, search.Tags, search.Tags, required)
+	}
+	if search.MinPrice != nil {
+		conditions = append(conditions, "p.price >= ?")
+		whereArgs = append(whereArgs, *search.MinPrice)
+	}
+	if search.MaxPrice != nil {
+		conditions = append(conditions, "p.price <= ?")
+		whereArgs = append(whereArgs, *search.MaxPrice)
+	}
+	if search.SellerID != 0 {
+		conditions = append(conditions, "p.user_id = ?")
+		whereArgs = append(whereArgs, search.SellerID)
+	}
+	if search.SellerUsername != "" {
+		conditions = append(conditions, "p.user_id IN (SELECT u.id FROM users u WHERE lower(u.username) = lower(?))")
+		whereArgs = append(whereArgs, search.SellerUsername)
+	}
+	// Товары, снятые модератором с продажи, в каталог и на витрины не попадают
+	conditions = append(conditions, "p.unlisted_at IS NULL")
+
+	inner := "SELECT " + strings.Join(selects, ", ") + " FROM products p WHERE " + strings.Join(conditions, " AND ")
+	args := append(selectArgs, whereArgs...)
+
+	// Фрагмент описания считается во внешнем запросе, то есть только для строк текущей страницы
+	snippet := "''"
+	var snippetArgs []interface{}
+	if textQuery != "" {
+		snippet = "ts_headline('simple', translate(coalesce(s.description, ''), ?, ''), to_tsquery('simple', ?), ?)"
+		snippetArgs = []interface{}{SnippetStart + SnippetStop, textQuery,
+			"StartSel=" + SnippetStart + ", StopSel=" + SnippetStop + `, MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=" … "`}
+	}
+
+	sql := "SELECT s.*, " + snippet + " AS snippet FROM (" + inner + ") s"
+	args = append(snippetArgs, args...)
+	page, pageArgs := keysetPage(search)
+	sql += page
+	args = append(args, pageArgs...)
+
+	hits := []ProductSearchHit{}
+	err := r.db.WithContext(ctx).Raw(sql, args...).Scan(&hits).Error
+	return hits, err
+}
+
+// keysetPage строит окончание запроса выдачи: условие на позицию после search.After,
+// порядок и лимит. Сравнение пар (ключ, ID) не пропускает и не повторяет товары
+// с одинаковым ключом, а направление сравнения совпадает с направлением сортировки.
+func keysetPage(search ProductSearch) (string, []interface{}) {
+	direction, compare := "DESC", "<"
+	if search.Ascending {
+		direction, compare = "ASC", ">"
+	}
+	var sql string
+	var args []interface{}
+	if search.After != nil {
+		sql = " WHERE (s.sort_key, s.id) " + compare + " (?, ?)"
+		args = append(args, search.After.Key, search.After.ID)
+	}
+	sql += " ORDER BY s.sort_key " + direction + ", s.id " + direction + " LIMIT ?"
+	return sql, append(args, search.Limit)
+}
+
+// prefixTSQuery строит tsquery, в котором каждое слово ищется по префиксу: photo, edit -> "photo:* & edit:*"
+func prefixTSQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = term + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeysetPage(t *testing.T) {
+	after := &ProductPosition{Key: 19.99, ID: 7}
+	tests := []struct {
+		name     string
+		search   ProductSearch
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{"первая страница по убыванию", ProductSearch{Limit: 21},
+			" ORDER BY s.sort_key DESC, s.id DESC LIMIT ?", []interface{}{21}},
+		{"первая страница по возрастанию", ProductSearch{Ascending: true, Limit: 21},
+			" ORDER BY s.sort_key ASC, s.id ASC LIMIT ?", []interface{}{21}},
+		{"после позиции по убыванию", ProductSearch{After: after, Limit: 11},
+			" WHERE (s.sort_key, s.id) < (?, ?) ORDER BY s.sort_key DESC, s.id DESC LIMIT ?", []interface{}{19.99, uint(7), 11}},
+		{"после позиции по возрастанию", ProductSearch{After: after, Ascending: true, Limit: 11},
+			" WHERE (s.sort_key, s.id) > (?, ?) ORDER BY s.sort_key ASC, s.id ASC LIMIT ?", []interface{}{19.99, uint(7), 11}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args := keysetPage(tt.search)
+			if sql != tt.wantSQL {
+				t.Errorf("SQL %q, ожидался %q", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("аргументы %v, ожидались %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestPrefixTSQuery(t *testing.T) {
+	if got := prefixTSQuery([]string{"photo", "edit"}); got != "photo:* & edit:*" {
+		t.Errorf("prefixTSQuery = %q", got)
+	}
+}
@@ -0,0 +1,2150 @@
+// Package memory - реализация репозиториев в памяти для тестов контроллеров без базы данных.
+//
+//	store := memory.New()
+//	repos := store.Repositories()
+//	repos.Users.Create(&models.User{Email: "buyer@example.com"})
+//	store.AddProduct(&models.Product{Title: "Шрифт", UserID: sellerID})
+//	controller := controllers.NewCartController(repos)
+//
+// Товары с заранее заданными полями, теги вместе с привязками к товарам и заказы без списания
+// баланса (для них в интерфейсах нет подходящих методов записи) добавляются методами
+// AddProduct, AddTag и AddOrder.
+// Методы, которые в PostgreSQL выполняются в транзакции, здесь выполняются под одной
+// блокировкой хранилища, но при ошибке не откатывают уже сделанные изменения.
+package memory
+
+import (
+	"context"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Ошибки, которые повторяют уникальные индексы базы
+var (
+	ErrDuplicateEmail    = errors.New("пользователь с таким email уже существует")
+	ErrDuplicateTag      = errors.New("тег с таким именем или слагом уже существует")
+	ErrDuplicateIdentity = errors.New("внешний аккаунт уже привязан")
+	ErrDuplicateReview   = errors.New("отзыв пользователя о товаре уже существует")
+)
+
+// Store хранит все данные фейковых репозиториев. Репозитории из Repositories
+// работают с общим Store, поэтому, например, корзина видит добавленные товары.
+type Store struct {
+	mu          sync.Mutex
+	nextID      uint
+	users       map[uint]models.User
+	products    map[uint]models.Product
+	tags        map[uint]models.Tag
+	productTags map[uint][]uint // ID товара -> ID тегов
+	cartItems   map[uint]models.CartItem
+	orders      map[uint]models.Order // Без Items, позиции лежат в orderItems
+	orderItems  map[uint]models.OrderItem
+	sessions    map[uint]models.Session
+	identities  map[uint]models.UserIdentity
+	pending     map[uint]models.PendingIdentityLink // Запросы на привязку внешних аккаунтов
+	tokens      map[uint]models.PersonalAccessToken
+	reviews     map[uint]models.Review
+	wishlist    map[uint]models.WishlistItem
+	wishlistOut map[uint]models.WishlistNotification // Очередь уведомлений подписчикам
+	auditEvents []models.AuditEvent
+	downloads   map[uint]models.Download
+}
+
+// New создает пустое хранилище
+func New() *Store {
+	return &Store{
+		users:       make(map[uint]models.User),
+		products:    make(map[uint]models.Product),
+		tags:        make(map[uint]models.Tag),
+		productTags: make(map[uint][]uint),
+		cartItems:   make(map[uint]models.CartItem),
+		orders:      make(map[uint]models.Order),
+		orderItems:  make(map[uint]models.OrderItem),
+		sessions:    make(map[uint]models.Session),
+		identities:  make(map[uint]models.UserIdentity),
+		pending:     make(map[uint]models.PendingIdentityLink),
+		tokens:      make(map[uint]models.PersonalAccessToken),
+		reviews:     make(map[uint]models.Review),
+		wishlist:    make(map[uint]models.WishlistItem),
+		wishlistOut: make(map[uint]models.WishlistNotification),
+		downloads:   make(map[uint]models.Download),
+	}
+}
+
+// Repositories возвращает набор репозиториев поверх хранилища
+func (s *Store) Repositories() repository.Repositories {
+	return repository.Repositories{
+		Users:    userRepository{s},
+		Products: productRepository{s},
+		Tags:     tagRepository{s},
+		Carts:    cartRepository{s},
+		Orders:   orderRepository{s},
+		Sessions: sessionRepository{s},
+
+		Identities: identityRepository{s},
+		Tokens:     tokenRepository{s},
+		Reviews:    reviewRepository{s},
+		Wishlist:   wishlistRepository{s},
+		Audit:      auditRepository{s},
+		Downloads:  downloadRepository{s},
+
+		ProductSearch: productSearchRepository{s},
+	}
+}
+
+// WishlistNotifications возвращает все события очереди уведомлений избранного по возрастанию ID
+func (s *Store) WishlistNotifications() []models.WishlistNotification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]models.WishlistNotification, 0, len(s.wishlistOut))
+	for _, n := range s.wishlistOut {
+		events = append(events, n)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events
+}
+
+// AddWishlistNotification ставит событие в очередь уведомлений избранного
+func (s *Store) AddWishlistNotification(n *models.WishlistNotification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.assignID(&n.ID)
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = time.Now()
+	}
+	s.wishlistOut[n.ID] = *n
+}
+
+// AuditEvents возвращает записи журнала аудита в порядке добавления
+func (s *Store) AuditEvents() []models.AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]models.AuditEvent(nil), s.auditEvents...)
+}
+
+// AddProduct сохраняет товар, присваивая ему ID, если он не задан
+func (s *Store) AddProduct(product *models.Product) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.assignID(&product.ID)
+	if product.CreatedAt.IsZero() {
+		product.CreatedAt = time.Now()
+	}
+	if product.Version == 0 {
+		product.Version = 1
+	}
+	s.products[product.ID] = *product
+}
+
+// AddTag сохраняет тег и привязывает его к товарам productIDs
+func (s *Store) AddTag(tag *models.Tag, productIDs ...uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.assignID(&tag.ID)
+	s.tags[tag.ID] = *tag
+	for _, productID := range productIDs {
+		s.productTags[productID] = append(s.productTags[productID], tag.ID)
+	}
+}
+
+// AddOrder сохраняет заказ вместе с позициями (у позиций достаточно ProductID)
+func (s *Store) AddOrder(order *models.Order) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.assignID(&order.ID)
+	if order.CreatedAt.IsZero() {
+		order.CreatedAt = time.Now()
+	}
+	for i := range order.Items {
+		item := &order.Items[i]
+		s.assignID(&item.ID)
+		item.OrderID = order.ID
+		item.Product = s.products[item.ProductID]
+		s.orderItems[item.ID] = *item
+	}
+
+	stored := *order
+	stored.Items = nil
+	s.orders[order.ID] = stored
+}
+
+// assignID выдает следующий ID, если он не задан. Вызывается под s.mu.
+func (s *Store) assignID(id *uint) {
+	if *id == 0 {
+		s.nextID++
+		*id = s.nextID
+	} else if *id > s.nextID {
+		s.nextID = *id
+	}
+}
+
+// orderWithItems собирает заказ с позициями и актуальными товарами. Вызывается под s.mu.
+func (s *Store) orderWithItems(order models.Order) models.Order {
+	order.Items = s.itemsByOrder(order.ID)
+	return order
+}
+
+func (s *Store) itemsByOrder(orderID uint) []models.OrderItem {
+	items := []models.OrderItem{}
+	for _, item := range s.orderItems {
+		if item.OrderID == orderID {
+			item.Product = s.products[item.ProductID]
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+// --- Пользователи ---
+
+type userRepository struct{ s *Store }
+
+func (r userRepository) FindByID(id uint) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r userRepository) FindByEmail(email string) (*models.User, error) {
+	return r.findBy(func(user models.User) bool { return user.Email == email })
+}
+
+func (r userRepository) FindByUsername(username string) (*models.User, error) {
+	return r.findBy(func(user models.User) bool { return user.Username == username })
+}
+
+func (r userRepository) findBy(match func(models.User) bool) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, user := range r.s.users {
+		if match(user) {
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r userRepository) Create(user *models.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, existing := range r.s.users {
+		if existing.Email == user.Email {
+			return ErrDuplicateEmail
+		}
+	}
+	r.s.assignID(&user.ID)
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	r.s.users[user.ID] = *user
+	return nil
+}
+
+func (r userRepository) Save(user *models.User) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.assignID(&user.ID)
+	r.s.users[user.ID] = *user
+	return nil
+}
+
+func (r userRepository) UpdatePassword(id uint, hash string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	user.Password = hash
+	r.s.users[id] = user
+	return nil
+}
+
+func (r userRepository) FindByIDs(ids []uint) ([]models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	users := []models.User{}
+	for _, id := range ids {
+		if user, ok := r.s.users[id]; ok {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (r userRepository) FindByUsernameFold(username string) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var found *models.User
+	for _, user := range r.s.users {
+		if strings.EqualFold(user.Username, username) && (found == nil || user.ID < found.ID) {
+			user := user
+			found = &user
+		}
+	}
+	if found == nil {
+		return nil, repository.ErrNotFound
+	}
+	return found, nil
+}
+
+func (r userRepository) UpdateProfile(id uint, bio, socialLinks string) error {
+	return r.update(id, func(user *models.User) {
+		user.Bio = bio
+		user.SocialLinks = socialLinks
+	})
+}
+
+func (r userRepository) SetAvatar(id uint, path string) (oldPath string, err error) {
+	err = r.update(id, func(user *models.User) {
+		oldPath = user.AvatarPath
+		user.AvatarPath = path
+	})
+	return oldPath, err
+}
+
+func (r userRepository) update(id uint, change func(*models.User)) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	change(&user)
+	r.s.users[id] = user
+	return nil
+}
+
+func (r userRepository) FindByEmailsFold(emails []string) ([]models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	users := []models.User{}
+	for _, user := range r.s.users {
+		for _, email := range emails {
+			if strings.EqualFold(user.Email, email) {
+				users = append(users, user)
+				break
+			}
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (r userRepository) Search(filter repository.UserFilter, limit int) ([]models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	users := []models.User{}
+	for _, user := range r.s.users {
+		if filter.Text != "" && user.ID != filter.ID &&
+			!containsFold(user.Email, filter.Text) && !containsFold(user.Username, filter.Text) {
+			continue
+		}
+		if filter.Role != "" && user.Role != filter.Role {
+			continue
+		}
+		if filter.BannedOnly && !user.Banned() {
+			continue
+		}
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID > users[j].ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (r userRepository) Change(_ context.Context, id uint, change func(user *models.User) (*models.AuditEvent, error)) (*models.User, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	event, err := change(&user)
+	if err != nil {
+		return nil, err
+	}
+	if event != nil {
+		r.s.users[id] = user
+		r.s.addAuditEvent(event)
+	}
+	return &user, nil
+}
+
+// --- Товары ---
+
+type productRepository struct{ s *Store }
+
+func (r productRepository) FindByID(id uint) (*models.Product, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	product, ok := r.s.products[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &product, nil
+}
+
+func (r productRepository) ListBySeller(userID uint) ([]models.Product, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	products := []models.Product{}
+	for _, product := range r.s.products {
+		if product.UserID == userID {
+			products = append(products, product)
+		}
+	}
+	sort.Slice(products, func(i, j int) bool {
+		if !products[i].CreatedAt.Equal(products[j].CreatedAt) {
+			return products[i].CreatedAt.After(products[j].CreatedAt)
+		}
+		return products[i].ID > products[j].ID
+	})
+	return products, nil
+}
+
+func (r productRepository) CountBySeller(userID uint) (int64, error) {
+	products, err := r.ListBySeller(userID)
+	return int64(len(products)), err
+}
+
+func (r productRepository) SellerStats(sellerID uint) (repository.SellerStats, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var stats repository.SellerStats
+	for _, product := range r.s.products {
+		if product.UserID == sellerID && product.Listed() {
+			stats.ProductCount++
+		}
+	}
+	for _, item := range r.s.orderItems {
+		if r.s.products[item.ProductID].UserID == sellerID {
+			stats.SalesCount++
+		}
+	}
+	var ratingSum int
+	for _, review := range r.s.reviews {
+		if !review.Hidden && r.s.products[review.ProductID].UserID == sellerID {
+			stats.ReviewCount++
+			ratingSum += review.Rating
+		}
+	}
+	if stats.ReviewCount > 0 {
+		stats.RatingAvg = float64(ratingSum) / float64(stats.ReviewCount)
+	}
+	return stats, nil
+}
+
+func (r productRepository) Create(_ context.Context, product *models.Product, tagIDs []uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.assignID(&product.ID)
+	if product.CreatedAt.IsZero() {
+		product.CreatedAt = time.Now()
+	}
+	if product.Version == 0 {
+		product.Version = 1
+	}
+	r.s.products[product.ID] = *product
+	r.s.productTags[product.ID] = append([]uint(nil), tagIDs...)
+
+	if user, ok := r.s.users[product.UserID]; ok && user.Role == models.RoleUser {
+		user.Role = models.RoleSeller
+		r.s.users[user.ID] = user
+	}
+	return nil
+}
+
+func (r productRepository) Update(_ context.Context, product *models.Product, changes repository.ProductChanges) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	current, ok := r.s.products[product.ID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	updated := current
+	if changes.Title != nil {
+		updated.Title = *changes.Title
+	}
+	if changes.Description != nil {
+		updated.Description = *changes.Description
+	}
+	if changes.Price != nil {
+		updated.Price = *changes.Price
+	}
+	if changes.Watermark != nil {
+		updated.Watermark = *changes.Watermark
+	}
+	if changes.TagIDs != nil {
+		r.s.productTags[product.ID] = append([]uint(nil), *changes.TagIDs...)
+	}
+	r.s.products[product.ID] = updated
+
+	if updated.Price < current.Price {
+		r.s.enqueueWishlistNotifications(models.WishlistNotification{
+			ProductID: updated.ID,
+			Kind:      models.WishlistNotificationPriceDrop,
+			OldPrice:  current.Price,
+			NewPrice:  updated.Price,
+			Version:   updated.Version,
+		})
+	}
+	*product = updated
+	return nil
+}
+
+func (r productRepository) PublishVersion(_ context.Context, product *models.Product, filePath string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	updated, ok := r.s.products[product.ID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	updated.FilePath = filePath
+	updated.Version++
+	r.s.products[product.ID] = updated
+
+	r.s.enqueueWishlistNotifications(models.WishlistNotification{
+		ProductID: updated.ID,
+		Kind:      models.WishlistNotificationNewVersion,
+		OldPrice:  updated.Price,
+		NewPrice:  updated.Price,
+		Version:   updated.Version,
+	})
+	*product = updated
+	return nil
+}
+
+func (r productRepository) Delete(_ context.Context, id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.products[id]; !ok {
+		return repository.ErrNotFound
+	}
+	for itemID, item := range r.s.cartItems {
+		if item.ProductID == id {
+			delete(r.s.cartItems, itemID)
+		}
+	}
+	for itemID, item := range r.s.wishlist {
+		if item.ProductID == id {
+			delete(r.s.wishlist, itemID)
+		}
+	}
+	for eventID, n := range r.s.wishlistOut {
+		if n.ProductID == id {
+			delete(r.s.wishlistOut, eventID)
+		}
+	}
+	delete(r.s.productTags, id)
+	delete(r.s.products, id)
+	return nil
+}
+
+func (r productRepository) Search(filter repository.ProductFilter, limit int) ([]repository.ProductWithSales, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	products := []repository.ProductWithSales{}
+	for _, product := range r.s.products {
+		seller := r.s.users[product.UserID]
+		if filter.Text != "" && product.ID != filter.ID &&
+			!containsFold(product.Title, filter.Text) && !containsFold(seller.Email, filter.Text) {
+			continue
+		}
+		if filter.UnlistedOnly && product.Listed() {
+			continue
+		}
+		row := repository.ProductWithSales{Product: product, SellerEmail: seller.Email}
+		for _, item := range r.s.orderItems {
+			if item.ProductID == product.ID {
+				row.SalesCount++
+			}
+		}
+		products = append(products, row)
+	}
+	sort.Slice(products, func(i, j int) bool { return products[i].ID > products[j].ID })
+	if len(products) > limit {
+		products = products[:limit]
+	}
+	return products, nil
+}
+
+func (r productRepository) Change(_ context.Context, id uint, change func(product *models.Product) (*models.AuditEvent, error)) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	product, ok := r.s.products[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	event, err := change(&product)
+	if err != nil || event == nil {
+		return err
+	}
+	r.s.products[id] = product
+	if !product.Listed() {
+		for itemID, item := range r.s.cartItems {
+			if item.ProductID == id {
+				delete(r.s.cartItems, itemID)
+			}
+		}
+	}
+	r.s.addAuditEvent(event)
+	return nil
+}
+
+// enqueueWishlistNotifications создает событие для каждого подписчика товара. Вызывается под s.mu.
+func (s *Store) enqueueWishlistNotifications(n models.WishlistNotification) {
+	for _, item := range s.wishlist {
+		if item.ProductID != n.ProductID {
+			continue
+		}
+		event := n
+		event.ID = 0
+		event.UserID = item.UserID
+		event.CreatedAt = time.Now()
+		s.assignID(&event.ID)
+		s.wishlistOut[event.ID] = event
+	}
+}
+
+// --- Теги ---
+
+type tagRepository struct{ s *Store }
+
+func (r tagRepository) List() ([]models.Tag, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	tags := make([]models.Tag, 0, len(r.s.tags))
+	for _, tag := range r.s.tags {
+		tags = append(tags, tag)
+	}
+	sortTags(tags)
+	return tags, nil
+}
+
+func (r tagRepository) ListByProduct(productID uint) ([]models.Tag, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	tags := []models.Tag{}
+	for _, id := range r.s.productTags[productID] {
+		if tag, ok := r.s.tags[id]; ok {
+			tags = append(tags, tag)
+		}
+	}
+	sortTags(tags)
+	return tags, nil
+}
+
+func (r tagRepository) NamesByProducts(productIDs []uint) (map[uint][]string, error) {
+	result := make(map[uint][]string)
+	for _, productID := range productIDs {
+		tags, _ := r.ListByProduct(productID)
+		for _, tag := range tags {
+			result[productID] = append(result[productID], tag.Name)
+		}
+	}
+	return result, nil
+}
+
+func (r tagRepository) ExistingIDs(ids []uint) ([]uint, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	existing := []uint{}
+	for _, id := range ids {
+		if _, ok := r.s.tags[id]; ok {
+			existing = append(existing, id)
+		}
+	}
+	return existing, nil
+}
+
+func (r tagRepository) ListWithUsage() ([]repository.TagUsage, error) {
+	tags, _ := r.List()
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	usage := make(map[uint]int64)
+	for _, tagIDs := range r.s.productTags {
+		for _, id := range tagIDs {
+			usage[id]++
+		}
+	}
+	result := make([]repository.TagUsage, 0, len(tags))
+	for _, tag := range tags {
+		result = append(result, repository.TagUsage{Tag: tag, UsageCount: usage[tag.ID]})
+	}
+	return result, nil
+}
+
+func (r tagRepository) ListWithoutSlug() ([]models.Tag, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	tags := []models.Tag{}
+	for _, tag := range r.s.tags {
+		if tag.Slug == "" {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].ID < tags[j].ID })
+	return tags, nil
+}
+
+func (r tagRepository) FindByID(id uint) (*models.Tag, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	tag, ok := r.s.tags[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &tag, nil
+}
+
+func (r tagRepository) FindByName(name string) (*models.Tag, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, tag := range r.s.tags {
+		if strings.EqualFold(tag.Name, name) {
+			return &tag, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r tagRepository) NameTaken(name string, excludeID uint) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.s.tagTaken(func(tag models.Tag) bool { return strings.EqualFold(tag.Name, name) }, excludeID), nil
+}
+
+func (r tagRepository) SlugTaken(slug string, excludeID uint) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.s.tagTaken(func(tag models.Tag) bool { return tag.Slug == slug }, excludeID), nil
+}
+
+func (r tagRepository) IsAncestor(ancestorID, id uint) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.s.tagIsAncestor(ancestorID, id), nil
+}
+
+func (r tagRepository) Create(tag *models.Tag) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if r.s.tagConflicts(*tag) {
+		return ErrDuplicateTag
+	}
+	r.s.assignID(&tag.ID)
+	r.s.tags[tag.ID] = *tag
+	return nil
+}
+
+func (r tagRepository) Save(tag *models.Tag) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.tags[tag.ID]; !ok {
+		return repository.ErrNotFound
+	}
+	if r.s.tagConflicts(*tag) {
+		return ErrDuplicateTag
+	}
+	r.s.tags[tag.ID] = *tag
+	return nil
+}
+
+func (r tagRepository) Merge(sourceID, targetID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	source, ok := r.s.tags[sourceID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	target, ok := r.s.tags[targetID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+
+	for productID, tagIDs := range r.s.productTags {
+		hasSource, hasTarget := false, false
+		kept := []uint{}
+		for _, id := range tagIDs {
+			hasSource = hasSource || id == sourceID
+			hasTarget = hasTarget || id == targetID
+			if id != sourceID {
+				kept = append(kept, id)
+			}
+		}
+		if hasSource && !hasTarget {
+			kept = append(kept, targetID)
+		}
+		r.s.productTags[productID] = kept
+	}
+
+	if r.s.tagIsAncestor(sourceID, targetID) {
+		target.ParentID = source.ParentID
+		r.s.tags[targetID] = target
+	}
+	for id, tag := range r.s.tags {
+		if tag.ParentID != nil && *tag.ParentID == sourceID && id != targetID {
+			parentID := targetID
+			tag.ParentID = &parentID
+			r.s.tags[id] = tag
+		}
+	}
+	delete(r.s.tags, sourceID)
+	return nil
+}
+
+func (r tagRepository) Delete(id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	tag, ok := r.s.tags[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	for childID, child := range r.s.tags {
+		if child.ParentID != nil && *child.ParentID == id {
+			child.ParentID = tag.ParentID
+			r.s.tags[childID] = child
+		}
+	}
+	for productID, tagIDs := range r.s.productTags {
+		kept := []uint{}
+		for _, tagID := range tagIDs {
+			if tagID != id {
+				kept = append(kept, tagID)
+			}
+		}
+		r.s.productTags[productID] = kept
+	}
+	delete(r.s.tags, id)
+	return nil
+}
+
+// tagTaken ищет тег, кроме excludeID, подходящий под match. Вызывается под s.mu.
+func (s *Store) tagTaken(match func(models.Tag) bool, excludeID uint) bool {
+	for id, tag := range s.tags {
+		if id != excludeID && match(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// tagConflicts повторяет уникальные индексы по lower(name) и slug. Вызывается под s.mu.
+func (s *Store) tagConflicts(tag models.Tag) bool {
+	return s.tagTaken(func(other models.Tag) bool {
+		return strings.EqualFold(other.Name, tag.Name) || (tag.Slug != "" && other.Slug == tag.Slug)
+	}, tag.ID)
+}
+
+// tagIsAncestor поднимается от тега id по родителям. Вызывается под s.mu.
+func (s *Store) tagIsAncestor(ancestorID, id uint) bool {
+	seen := make(map[uint]bool)
+	for current, ok := s.tags[id]; ok && !seen[current.ID]; {
+		if current.ID == ancestorID {
+			return true
+		}
+		seen[current.ID] = true
+		if current.ParentID == nil {
+			return false
+		}
+		current, ok = s.tags[*current.ParentID]
+	}
+	return false
+}
+
+func sortTags(tags []models.Tag) {
+	sort.Slice(tags, func(i, j int) bool { return strings.ToLower(tags[i].Name) < strings.ToLower(tags[j].Name) })
+}
+
+// --- Корзина ---
+
+type cartRepository struct{ s *Store }
+
+func (r cartRepository) ListByUser(userID uint) ([]models.CartItem, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.s.cartItemsOf(userID), nil
+}
+
+// cartItemsOf возвращает корзину пользователя с товарами, новые позиции первыми. Вызывается под s.mu.
+func (s *Store) cartItemsOf(userID uint) []models.CartItem {
+	items := []models.CartItem{}
+	for _, item := range s.cartItems {
+		if item.UserID == userID {
+			item.Product = s.products[item.ProductID]
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].CreatedAt.Equal(items[j].CreatedAt) {
+			return items[i].CreatedAt.After(items[j].CreatedAt)
+		}
+		return items[i].ID > items[j].ID
+	})
+	return items
+}
+
+func (r cartRepository) Find(userID, productID uint) (*models.CartItem, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, item := range r.s.cartItems {
+		if item.UserID == userID && item.ProductID == productID {
+			return &item, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r cartRepository) Add(item *models.CartItem) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.assignID(&item.ID)
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = time.Now()
+	}
+	r.s.cartItems[item.ID] = *item
+	return nil
+}
+
+func (r cartRepository) Remove(userID, itemID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	item, ok := r.s.cartItems[itemID]
+	if !ok || item.UserID != userID {
+		return repository.ErrNotFound
+	}
+	delete(r.s.cartItems, itemID)
+	return nil
+}
+
+func (r cartRepository) Checkout(_ context.Context, userID uint, charge func(user *models.User, items []models.CartItem) (repository.OrderCharge, error)) (*models.Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[userID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	items := r.s.cartItemsOf(userID)
+	decision, err := charge(&user, items)
+	if err != nil {
+		return nil, err
+	}
+	productIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+		delete(r.s.cartItems, item.ID)
+	}
+	return r.s.placeOrder(userID, productIDs, decision), nil
+}
+
+// placeOrder создает заказ с позициями productIDs, списывает charge.Total с баланса
+// и добавляет запись журнала. Вызывается под s.mu.
+func (s *Store) placeOrder(userID uint, productIDs []uint, charge repository.OrderCharge) *models.Order {
+	order := models.Order{UserID: userID, CreatedAt: time.Now()}
+	s.assignID(&order.ID)
+	s.orders[order.ID] = order
+	for _, productID := range productIDs {
+		item := models.OrderItem{OrderID: order.ID, ProductID: productID}
+		s.assignID(&item.ID)
+		s.orderItems[item.ID] = item
+		order.Items = append(order.Items, item)
+	}
+
+	user := s.users[userID]
+	user.Balance -= charge.Total
+	s.users[userID] = user
+	if charge.Event != nil {
+		charge.Event.TargetID = &order.ID
+		s.addAuditEvent(charge.Event)
+	}
+	return &order
+}
+
+// --- Заказы ---
+
+type orderRepository struct{ s *Store }
+
+func (r orderRepository) ListByUser(userID uint) ([]models.Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	orders := []models.Order{}
+	for _, order := range r.s.orders {
+		if order.UserID == userID {
+			orders = append(orders, r.s.orderWithItems(order))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+			return orders[i].CreatedAt.After(orders[j].CreatedAt)
+		}
+		return orders[i].ID > orders[j].ID
+	})
+	return orders, nil
+}
+
+func (r orderRepository) FindForUser(userID, orderID uint) (*models.Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	order, ok := r.s.orders[orderID]
+	if !ok || order.UserID != userID {
+		return nil, repository.ErrNotFound
+	}
+	order = r.s.orderWithItems(order)
+	return &order, nil
+}
+
+func (r orderRepository) ItemsByOrder(orderID uint) ([]models.OrderItem, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.s.itemsByOrder(orderID), nil
+}
+
+func (r orderRepository) HasPurchased(userID, productID uint) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.s.hasPurchased(userID, productID), nil
+}
+
+// hasPurchased сообщает, покупал ли пользователь товар. Вызывается под s.mu.
+func (s *Store) hasPurchased(userID, productID uint) bool {
+	for _, item := range s.orderItems {
+		if item.ProductID == productID && s.orders[item.OrderID].UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r orderRepository) CountByProduct(productID uint) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var count int64
+	for _, item := range r.s.orderItems {
+		if item.ProductID == productID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r orderRepository) FindByID(id uint) (*models.Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	order, ok := r.s.orders[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	order = r.s.orderWithItems(order)
+	order.User = r.s.users[order.UserID]
+	return &order, nil
+}
+
+func (r orderRepository) FirstOrderID(userID, productID uint) (uint, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var first uint
+	for _, item := range r.s.orderItems {
+		if item.ProductID == productID && r.s.orders[item.OrderID].UserID == userID && (first == 0 || item.OrderID < first) {
+			first = item.OrderID
+		}
+	}
+	return first, nil
+}
+
+func (r orderRepository) Search(id uint, email string, limit int) ([]repository.OrderSummary, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	orders := []repository.OrderSummary{}
+	for _, order := range r.s.orders {
+		buyer := r.s.users[order.UserID]
+		if id != 0 && order.ID != id || id == 0 && email != "" && !containsFold(buyer.Email, email) {
+			continue
+		}
+		orders = append(orders, repository.OrderSummary{
+			ID:         order.ID,
+			CreatedAt:  order.CreatedAt,
+			UserID:     order.UserID,
+			Email:      buyer.Email,
+			ItemsCount: int64(len(r.s.itemsByOrder(order.ID))),
+		})
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID > orders[j].ID })
+	if len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
+
+func (r orderRepository) Buy(_ context.Context, userID, productID uint, charge func(user *models.User, product *models.Product, purchased bool) (repository.OrderCharge, error)) (*models.Order, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	user, ok := r.s.users[userID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	product, ok := r.s.products[productID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	decision, err := charge(&user, &product, r.s.hasPurchased(userID, productID))
+	if err != nil {
+		return nil, err
+	}
+	return r.s.placeOrder(userID, []uint{productID}, decision), nil
+}
+
+// --- Сессии ---
+
+type sessionRepository struct{ s *Store }
+
+func (r sessionRepository) Create(session *models.Session) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.assignID(&session.ID)
+	r.s.sessions[session.ID] = *session
+	return nil
+}
+
+func (r sessionRepository) FindByTokenHash(hash string) (*models.Session, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, session := range r.s.sessions {
+		if session.TokenHash == hash {
+			return &session, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r sessionRepository) Touch(id uint, at time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if session, ok := r.s.sessions[id]; ok {
+		session.LastSeenAt = at
+		r.s.sessions[id] = session
+	}
+	return nil
+}
+
+func (r sessionRepository) Delete(hash string) error {
+	return r.deleteWhere(func(session models.Session) bool { return session.TokenHash == hash })
+}
+
+func (r sessionRepository) DeleteByUser(userID uint) error {
+	return r.deleteWhere(func(session models.Session) bool { return session.UserID == userID })
+}
+
+func (r sessionRepository) DeleteExpired(now time.Time) (int64, error) {
+	var removed int64
+	err := r.deleteWhere(func(session models.Session) bool {
+		if session.ExpiresAt.Before(now) {
+			removed++
+			return true
+		}
+		return false
+	})
+	return removed, err
+}
+
+func (r sessionRepository) deleteWhere(match func(models.Session) bool) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for id, session := range r.s.sessions {
+		if match(session) {
+			delete(r.s.sessions, id)
+		}
+	}
+	return nil
+}
+
+// --- Внешние аккаунты ---
+
+type identityRepository struct{ s *Store }
+
+func (r identityRepository) FindBySubject(provider, subject string) (*models.UserIdentity, error) {
+	return r.findBy(func(identity models.UserIdentity) bool {
+		return identity.Provider == provider && identity.Subject == subject
+	})
+}
+
+func (r identityRepository) FindForUser(userID, id uint) (*models.UserIdentity, error) {
+	return r.findBy(func(identity models.UserIdentity) bool { return identity.ID == id && identity.UserID == userID })
+}
+
+func (r identityRepository) findBy(match func(models.UserIdentity) bool) (*models.UserIdentity, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, identity := range r.s.identities {
+		if match(identity) {
+			return &identity, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r identityRepository) ListByUser(userID uint) ([]models.UserIdentity, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	identities := []models.UserIdentity{}
+	for _, identity := range r.s.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Provider < identities[j].Provider })
+	return identities, nil
+}
+
+func (r identityRepository) CountByUser(userID uint) (int64, error) {
+	identities, err := r.ListByUser(userID)
+	return int64(len(identities)), err
+}
+
+func (r identityRepository) Create(identity *models.UserIdentity) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	return r.s.createIdentity(identity)
+}
+
+func (r identityRepository) CreateWithUser(_ context.Context, user *models.User, identity *models.UserIdentity) error {
+	if err := (userRepository{r.s}).Create(user); err != nil {
+		return err
+	}
+	identity.UserID = user.ID
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if err := r.s.createIdentity(identity); err != nil {
+		delete(r.s.users, user.ID)
+		return err
+	}
+	return nil
+}
+
+func (r identityRepository) Delete(id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.identities, id)
+	return nil
+}
+
+func (r identityRepository) CreatePending(link *models.PendingIdentityLink) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.assignID(&link.ID)
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+	r.s.pending[link.ID] = *link
+	return nil
+}
+
+func (r identityRepository) FindPending(tokenHash string, now time.Time) (*models.PendingIdentityLink, error) {
+	return r.findPending(func(link models.PendingIdentityLink) bool { return link.TokenHash == tokenHash }, now)
+}
+
+func (r identityRepository) FindPendingByEmailToken(tokenHash string, now time.Time) (*models.PendingIdentityLink, error) {
+	return r.findPending(func(link models.PendingIdentityLink) bool {
+		return link.EmailTokenHash != nil && *link.EmailTokenHash == tokenHash
+	}, now)
+}
+
+func (r identityRepository) findPending(match func(models.PendingIdentityLink) bool, now time.Time) (*models.PendingIdentityLink, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, link := range r.s.pending {
+		if match(link) && link.ExpiresAt.After(now) {
+			return &link, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r identityRepository) SetPendingEmailToken(id uint, tokenHash string, expiresAt time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if link, ok := r.s.pending[id]; ok {
+		link.EmailTokenHash = &tokenHash
+		link.ExpiresAt = expiresAt
+		r.s.pending[id] = link
+	}
+	return nil
+}
+
+func (r identityRepository) ConfirmPending(_ context.Context, id uint, identity *models.UserIdentity) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	link, ok := r.s.pending[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	delete(r.s.pending, id)
+	if err := r.s.createIdentity(identity); err != nil {
+		r.s.pending[id] = link
+		return err
+	}
+	return nil
+}
+
+func (r identityRepository) DeletePending(id uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.pending, id)
+	return nil
+}
+
+func (r identityRepository) DeleteExpiredPending(now time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var removed int64
+	for id, link := range r.s.pending {
+		if link.ExpiresAt.Before(now) {
+			delete(r.s.pending, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// createIdentity повторяет уникальный индекс (provider, subject). Вызывается под s.mu.
+func (s *Store) createIdentity(identity *models.UserIdentity) error {
+	for _, existing := range s.identities {
+		if existing.Provider == identity.Provider && existing.Subject == identity.Subject {
+			return ErrDuplicateIdentity
+		}
+	}
+	s.assignID(&identity.ID)
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = time.Now()
+	}
+	s.identities[identity.ID] = *identity
+	return nil
+}
+
+// --- Токены доступа ---
+
+type tokenRepository struct{ s *Store }
+
+func (r tokenRepository) CountActive(userID uint) (int64, error) {
+	tokens, err := r.ListActive(userID)
+	return int64(len(tokens)), err
+}
+
+func (r tokenRepository) Create(token *models.PersonalAccessToken) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.assignID(&token.ID)
+	r.s.tokens[token.ID] = *token
+	return nil
+}
+
+func (r tokenRepository) FindByHash(hash string) (*models.PersonalAccessToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, token := range r.s.tokens {
+		if token.TokenHash == hash {
+			return &token, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r tokenRepository) Touch(id uint, at time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if token, ok := r.s.tokens[id]; ok {
+		token.LastUsedAt = &at
+		r.s.tokens[id] = token
+	}
+	return nil
+}
+
+func (r tokenRepository) ListActive(userID uint) ([]models.PersonalAccessToken, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	tokens := []models.PersonalAccessToken{}
+	for _, token := range r.s.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID > tokens[j].ID
+	})
+	return tokens, nil
+}
+
+func (r tokenRepository) Revoke(userID, id uint, at time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	token, ok := r.s.tokens[id]
+	if !ok || token.UserID != userID || token.RevokedAt != nil {
+		return repository.ErrNotFound
+	}
+	token.RevokedAt = &at
+	r.s.tokens[id] = token
+	return nil
+}
+
+func (r tokenRepository) DeleteExpired(cutoff time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var removed int64
+	for id, token := range r.s.tokens {
+		if (token.RevokedAt != nil && token.RevokedAt.Before(cutoff)) || (token.ExpiresAt != nil && token.ExpiresAt.Before(cutoff)) {
+			delete(r.s.tokens, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// --- Отзывы ---
+
+type reviewRepository struct{ s *Store }
+
+func (r reviewRepository) FindByID(id uint) (*models.Review, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	review, ok := r.s.reviews[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return &review, nil
+}
+
+func (r reviewRepository) FindVisible(id uint) (*models.Review, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	review, ok := r.s.reviews[id]
+	if !ok || review.Hidden {
+		return nil, repository.ErrNotFound
+	}
+	review.Product = r.s.products[review.ProductID]
+	return &review, nil
+}
+
+func (r reviewRepository) FindByUser(userID, productID uint) (*models.Review, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, review := range r.s.reviews {
+		if review.UserID == userID && review.ProductID == productID {
+			return &review, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r reviewRepository) ListVisible(productID uint) ([]models.Review, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	reviews := []models.Review{}
+	for _, review := range r.s.reviews {
+		if review.ProductID == productID && !review.Hidden {
+			review.User = r.s.users[review.UserID]
+			reviews = append(reviews, review)
+		}
+	}
+	sort.Slice(reviews, func(i, j int) bool {
+		if !reviews[i].CreatedAt.Equal(reviews[j].CreatedAt) {
+			return reviews[i].CreatedAt.After(reviews[j].CreatedAt)
+		}
+		return reviews[i].ID > reviews[j].ID
+	})
+	return reviews, nil
+}
+
+func (r reviewRepository) ListForModeration(limit int) ([]models.Review, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	reviews := []models.Review{}
+	for _, review := range r.s.reviews {
+		if review.Flagged || review.Hidden {
+			review.User = r.s.users[review.UserID]
+			review.Product = r.s.products[review.ProductID]
+			reviews = append(reviews, review)
+		}
+	}
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].UpdatedAt.After(reviews[j].UpdatedAt) })
+	if len(reviews) > limit {
+		reviews = reviews[:limit]
+	}
+	return reviews, nil
+}
+
+func (r reviewRepository) Save(review *models.Review) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	now := time.Now()
+	if review.ID == 0 {
+		for _, existing := range r.s.reviews {
+			if existing.UserID == review.UserID && existing.ProductID == review.ProductID {
+				return ErrDuplicateReview
+			}
+		}
+		r.s.assignID(&review.ID)
+		review.CreatedAt = now
+		review.UpdatedAt = now
+		stored := *review
+		stored.User, stored.Product = models.User{}, models.Product{}
+		r.s.reviews[review.ID] = stored
+	} else {
+		stored, ok := r.s.reviews[review.ID]
+		if !ok {
+			return repository.ErrNotFound
+		}
+		stored.Rating = review.Rating
+		stored.Text = review.Text
+		stored.UpdatedAt = now
+		review.UpdatedAt = now
+		r.s.reviews[review.ID] = stored
+	}
+	r.s.refreshProductRating(review.ProductID)
+	return nil
+}
+
+func (r reviewRepository) SetReply(id uint, reply string, at time.Time) error {
+	return r.update(id, func(review *models.Review) {
+		review.SellerReply = reply
+		review.SellerReplyAt = &at
+	})
+}
+
+func (r reviewRepository) Flag(id uint) error {
+	return r.update(id, func(review *models.Review) { review.Flagged = true })
+}
+
+func (r reviewRepository) SetHidden(_ context.Context, id uint, hidden bool, event *models.AuditEvent) error {
+	err := r.update(id, func(review *models.Review) {
+		review.Hidden = hidden
+		review.Flagged = false
+	})
+	if err != nil {
+		return err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.refreshProductRating(r.s.reviews[id].ProductID)
+	if event != nil {
+		r.s.addAuditEvent(event)
+	}
+	return nil
+}
+
+func (r reviewRepository) update(id uint, change func(*models.Review)) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	review, ok := r.s.reviews[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	change(&review)
+	r.s.reviews[id] = review
+	return nil
+}
+
+// refreshProductRating пересчитывает рейтинг товара по видимым отзывам. Вызывается под s.mu.
+func (s *Store) refreshProductRating(productID uint) {
+	product, ok := s.products[productID]
+	if !ok {
+		return
+	}
+	var sum, count int
+	for _, review := range s.reviews {
+		if review.ProductID == productID && !review.Hidden {
+			sum += review.Rating
+			count++
+		}
+	}
+	product.ReviewCount = count
+	product.RatingAvg = 0
+	if count > 0 {
+		product.RatingAvg = float64(sum) / float64(count)
+	}
+	s.products[productID] = product
+}
+
+// addAuditEvent добавляет запись в журнал аудита. Вызывается под s.mu.
+func (s *Store) addAuditEvent(event *models.AuditEvent) {
+	s.assignID(&event.ID)
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	s.auditEvents = append(s.auditEvents, *event)
+}
+
+// --- Избранное ---
+
+type wishlistRepository struct{ s *Store }
+
+func (r wishlistRepository) Add(item *models.WishlistItem) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, existing := range r.s.wishlist {
+		if existing.UserID == item.UserID && existing.ProductID == item.ProductID {
+			*item = existing
+			return false, nil
+		}
+	}
+	r.s.assignID(&item.ID)
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = time.Now()
+	}
+	r.s.wishlist[item.ID] = *item
+	return true, nil
+}
+
+func (r wishlistRepository) Remove(userID, productID uint) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for id, item := range r.s.wishlist {
+		if item.UserID == userID && item.ProductID == productID {
+			delete(r.s.wishlist, id)
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r wishlistRepository) ListByUser(userID uint) ([]models.WishlistItem, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	items := []models.WishlistItem{}
+	for _, item := range r.s.wishlist {
+		if item.UserID == userID {
+			item.Product = r.s.products[item.ProductID]
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].CreatedAt.Equal(items[j].CreatedAt) {
+			return items[i].CreatedAt.After(items[j].CreatedAt)
+		}
+		return items[i].ID > items[j].ID
+	})
+	return items, nil
+}
+
+func (r wishlistRepository) Contains(userID, productID uint) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, item := range r.s.wishlist {
+		if item.UserID == userID && item.ProductID == productID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r wishlistRepository) PendingNotifications(_ context.Context, now time.Time, limit int) ([]models.WishlistNotification, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	pending := []models.WishlistNotification{}
+	for _, n := range r.s.wishlistOut {
+		if n.SentAt == nil && n.FailedAt == nil && (n.NextAttemptAt == nil || !n.NextAttemptAt.After(now)) {
+			n.Product = r.s.products[n.ProductID]
+			pending = append(pending, n)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].UserID != pending[j].UserID {
+			return pending[i].UserID < pending[j].UserID
+		}
+		return pending[i].ID < pending[j].ID
+	})
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+	return pending, nil
+}
+
+func (r wishlistRepository) MarkSent(ids []uint, at time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, id := range ids {
+		if n, ok := r.s.wishlistOut[id]; ok {
+			n.SentAt = &at
+			r.s.wishlistOut[id] = n
+		}
+	}
+	return nil
+}
+
+func (r wishlistRepository) MarkFailed(ids []uint, attempts int, retryAt *time.Time, at time.Time) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, id := range ids {
+		if n, ok := r.s.wishlistOut[id]; ok {
+			n.Attempts = attempts
+			n.NextAttemptAt = retryAt
+			if retryAt == nil {
+				n.FailedAt = &at
+			}
+			r.s.wishlistOut[id] = n
+		}
+	}
+	return nil
+}
+
+// --- Журнал аудита ---
+
+type auditRepository struct{ s *Store }
+
+func (r auditRepository) Create(_ context.Context, event *models.AuditEvent) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.s.addAuditEvent(event)
+	return nil
+}
+
+func (r auditRepository) Search(filter repository.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	return r.list(limit, func(event models.AuditEvent) bool {
+		return strings.HasPrefix(event.Action, filter.Action) &&
+			(filter.ActorID == 0 || event.ActorID != nil && *event.ActorID == filter.ActorID) &&
+			(filter.TargetType == "" || event.TargetType == filter.TargetType) &&
+			(filter.TargetID == 0 || event.TargetID != nil && *event.TargetID == filter.TargetID) &&
+			(filter.IP == "" || event.IP == filter.IP) &&
+			(filter.From.IsZero() || !event.CreatedAt.Before(filter.From)) &&
+			(filter.To.IsZero() || event.CreatedAt.Before(filter.To)) &&
+			(filter.BeforeID == 0 || event.ID < filter.BeforeID)
+	})
+}
+
+func (r auditRepository) ListForTarget(targetType string, targetID uint, limit int) ([]models.AuditEvent, error) {
+	return r.list(limit, func(event models.AuditEvent) bool {
+		return event.TargetType == targetType && event.TargetID != nil && *event.TargetID == targetID
+	})
+}
+
+func (r auditRepository) FindByDetail(action, key, value string) (*models.AuditEvent, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, event := range r.s.auditEvents {
+		if event.Action != action {
+			continue
+		}
+		var details map[string]interface{}
+		if err := json.Unmarshal([]byte(event.Details), &details); err != nil {
+			return nil, err
+		}
+		if detail, ok := details[key].(string); ok && detail == value {
+			return &event, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// list возвращает до limit подходящих записей, новые первыми
+func (r auditRepository) list(limit int, match func(models.AuditEvent) bool) ([]models.AuditEvent, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	events := []models.AuditEvent{}
+	for i := len(r.s.auditEvents) - 1; i >= 0 && len(events) < limit; i-- {
+		if match(r.s.auditEvents[i]) {
+			events = append(events, r.s.auditEvents[i])
+		}
+	}
+	return events, nil
+}
+
+// --- Скачивания ---
+
+type downloadRepository struct{ s *Store }
+
+func (r downloadRepository) Reserve(_ context.Context, download *models.Download, limit int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if limit > 0 && !download.ByOwner {
+		if _, ok := r.s.users[download.UserID]; !ok {
+			return repository.ErrNotFound
+		}
+		count := 0
+		for _, d := range r.s.downloads {
+			if d.UserID == download.UserID && d.ProductID == download.ProductID && !d.ByOwner {
+				count++
+			}
+		}
+		if count >= limit {
+			return repository.ErrLimitReached
+		}
+	}
+	r.s.assignID(&download.ID)
+	if download.CreatedAt.IsZero() {
+		download.CreatedAt = time.Now()
+	}
+	r.s.downloads[download.ID] = *download
+	return nil
+}
+
+func (r downloadRepository) Complete(_ context.Context, download *models.Download) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored, ok := r.s.downloads[download.ID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	stored.Bytes = download.Bytes
+	stored.Watermark = download.Watermark
+	stored.OrderID = download.OrderID
+	r.s.downloads[download.ID] = stored
+	return nil
+}
+
+func (r downloadRepository) CountByBuyer(userID, productID uint) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var count int64
+	for _, download := range r.s.downloads {
+		if download.UserID == userID && download.ProductID == productID && !download.ByOwner {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r downloadRepository) CountIPs(_ context.Context, userID, productID uint, since time.Time, ip string) (ips, fromIP int64, err error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, download := range r.s.downloads {
+		if download.UserID != userID || download.ProductID != productID || download.ByOwner || download.CreatedAt.Before(since) {
+			continue
+		}
+		seen[download.IP] = true
+		if download.IP == ip {
+			fromIP++
+		}
+	}
+	return int64(len(seen)), fromIP, nil
+}
+
+func (r downloadRepository) Stats(productIDs []uint, period repository.StatsPeriod) (map[uint]repository.DownloadStats, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	wanted := make(map[uint]bool, len(productIDs))
+	for _, id := range productIDs {
+		wanted[id] = true
+	}
+	buyers := make(map[uint]map[uint]bool)               // товар -> покупатели
+	recentIPs := make(map[uint]map[uint]map[string]bool) // товар -> покупатель -> адреса
+	result := make(map[uint]repository.DownloadStats)
+	for _, download := range r.s.downloads {
+		if !wanted[download.ProductID] || download.ByOwner {
+			continue
+		}
+		stats := result[download.ProductID]
+		stats.ProductID = download.ProductID
+		stats.Downloads++
+		if !download.CreatedAt.Before(period.RecentSince) {
+			stats.RecentDownloads++
+		}
+		stats.Bytes += download.Bytes
+		if stats.LastDownloadAt == nil || download.CreatedAt.After(*stats.LastDownloadAt) {
+			at := download.CreatedAt
+			stats.LastDownloadAt = &at
+		}
+		result[download.ProductID] = stats
+
+		if buyers[download.ProductID] == nil {
+			buyers[download.ProductID] = make(map[uint]bool)
+			recentIPs[download.ProductID] = make(map[uint]map[string]bool)
+		}
+		buyers[download.ProductID][download.UserID] = true
+		if !download.CreatedAt.Before(period.AnomalySince) {
+			if recentIPs[download.ProductID][download.UserID] == nil {
+				recentIPs[download.ProductID][download.UserID] = make(map[string]bool)
+			}
+			recentIPs[download.ProductID][download.UserID][download.IP] = true
+		}
+	}
+	for productID, stats := range result {
+		stats.Buyers = int64(len(buyers[productID]))
+		if period.AnomalyIPs > 0 {
+			for _, ips := range recentIPs[productID] {
+				if len(ips) >= period.AnomalyIPs {
+					stats.SuspiciousBuyers++
+				}
+			}
+		}
+		result[productID] = stats
+	}
+	return result, nil
+}
+
+func (r downloadRepository) FindByWatermark(code string) (*models.Download, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, download := range r.s.downloads {
+		if download.Watermark != nil && *download.Watermark == code {
+			return &download, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// --- Поиск товаров ---
+
+type productSearchRepository struct{ s *Store }
+
+// Search отбирает и упорядочивает товары так же, как PostgreSQL. Слово поиска совпадает
+// с началом слова в названии, тегах или описании; релевантность - сумма весов полей,
+// в которых нашлось слово, как у ts_rank: 1 - название, 0.4 - теги, 0.2 - описание.
+// Опечатки не исправляются, фрагмент описания не строится.
+func (r productSearchRepository) Search(_ context.Context, search repository.ProductSearch) ([]repository.ProductSearchHit, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	hits := []repository.ProductSearchHit{}
+	for _, product := range r.s.products {
+		if !product.Listed() || !r.s.matchesProductSearch(product, search) {
+			continue
+		}
+		rank, ok := r.s.searchRank(product, search.Terms)
+		if !ok {
+			continue
+		}
+		hit := repository.ProductSearchHit{Product: product, Rank: rank}
+		switch search.Order {
+		case repository.OrderByRank:
+			hit.SortKey = rank
+		case repository.OrderByPrice:
+			hit.SortKey = product.Price
+		case repository.OrderBySales:
+			for _, item := range r.s.orderItems {
+				if item.ProductID == product.ID {
+					hit.SortKey++
+				}
+			}
+		default:
+			hit.SortKey = float64(product.CreatedAt.UnixMicro())
+		}
+		position := repository.ProductPosition{Key: hit.SortKey, ID: hit.ID}
+		if search.After != nil && !positionBefore(*search.After, position, search.Ascending) {
+			continue
+		}
+		hits = append(hits, hit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return positionBefore(repository.ProductPosition{Key: hits[i].SortKey, ID: hits[i].ID},
+			repository.ProductPosition{Key: hits[j].SortKey, ID: hits[j].ID}, search.Ascending)
+	})
+	if len(hits) > search.Limit {
+		hits = hits[:search.Limit]
+	}
+	return hits, nil
+}
+
+// positionBefore сообщает, идет ли позиция a в выдаче раньше b
+func positionBefore(a, b repository.ProductPosition, ascending bool) bool {
+	if !ascending {
+		a, b = b, a
+	}
+	if a.Key != b.Key {
+		return a.Key < b.Key
+	}
+	return a.ID < b.ID
+}
+
+// matchesProductSearch проверяет фильтры по тегам, цене и продавцу. Вызывается под s.mu.
+func (s *Store) matchesProductSearch(product models.Product, search repository.ProductSearch) bool {
+	if search.MinPrice != nil && product.Price < *search.MinPrice {
+		return false
+	}
+	if search.MaxPrice != nil && product.Price > *search.MaxPrice {
+		return false
+	}
+	if search.SellerID != 0 && product.UserID != search.SellerID {
+		return false
+	}
+	if search.SellerUsername != "" && !strings.EqualFold(s.users[product.UserID].Username, search.SellerUsername) {
+		return false
+	}
+	if len(search.Tags) == 0 {
+		return true
+	}
+
+	// Тег из запроса совпадает с тегом товара, если это он сам или его предок
+	matched := 0
+	for _, term := range search.Tags {
+		found := false
+		for _, tagID := range s.productTags[product.ID] {
+			for _, tag := range s.tags {
+				if (strings.ToLower(tag.Name) == term || tag.Slug == term) && s.tagIsAncestor(tag.ID, tagID) {
+					found = true
+				}
+			}
+		}
+		if found {
+			matched++
+		}
+	}
+	if search.AnyTag {
+		return matched > 0
+	}
+	return matched == len(search.Tags)
+}
+
+// searchRank считает релевантность товара; ok == false, если какое-то слово не найдено.
+// Вызывается под s.mu.
+func (s *Store) searchRank(product models.Product, terms []string) (rank float64, ok bool) {
+	var tagNames []string
+	for _, tagID := range s.productTags[product.ID] {
+		tagNames = append(tagNames, s.tags[tagID].Name)
+	}
+	fields := []struct {
+		words  []string
+		weight float64
+	}{
+		{searchWords(product.Title), 1},
+		{searchWords(strings.Join(tagNames, " ")), 0.4},
+		{searchWords(product.Description), 0.2},
+	}
+
+	for _, term := range terms {
+		found := false
+		for _, field := range fields {
+			for _, word := range field.words {
+				if strings.HasPrefix(word, term) {
+					rank += field.weight
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return rank, true
+}
+
+// searchWords разбивает текст на слова из букв и цифр в нижнем регистре
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsFold сообщает, содержит ли s подстроку substr без учета регистра
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
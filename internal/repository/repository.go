@@ -0,0 +1,452 @@
+// Package repository описывает доступ к данным, которым пользуются контроллеры и сервисы.
+//
+// Реализация на GORM (NewGorm) работает с PostgreSQL, реализация из пакета
+// repository/memory хранит данные в памяти и нужна для тестов контроллеров без базы.
+// Операции, которые меняют несколько таблиц (создание товара с тегами, удаление товара,
+// объединение тегов, оформление заказа, действия сотрудников вместе с записью в журнал
+// аудита), выполняются одним методом репозитория в одной транзакции.
+package repository
+
+import (
+	"context"
+	"digital-marketplace/internal/models"
+	"errors"
+	"time"
+)
+
+// ErrNotFound возвращается, когда запись не найдена
+var ErrNotFound = errors.New("запись не найдена")
+
+// ErrLimitReached возвращается, когда запись не добавлена из-за исчерпанного лимита
+var ErrLimitReached = errors.New("лимит исчерпан")
+
+// UserRepository - пользователи
+type UserRepository interface {
+	FindByID(id uint) (*models.User, error)
+	FindByEmail(email string) (*models.User, error)
+	FindByUsername(username string) (*models.User, error)
+	Create(user *models.User) error
+	Save(user *models.User) error
+	UpdatePassword(id uint, hash string) error
+	// FindByIDs возвращает существующих пользователей из ids в любом порядке
+	FindByIDs(ids []uint) ([]models.User, error)
+	// FindByUsernameFold ищет пользователя по имени без учета регистра. Если имя совпадает
+	// у нескольких пользователей (возможно у аккаунтов из OAuth), возвращает самого раннего.
+	FindByUsernameFold(username string) (*models.User, error)
+	// UpdateProfile сохраняет описание витрины и ссылки на соцсети (по одной на строку)
+	UpdateProfile(id uint, bio, socialLinks string) error
+	// SetAvatar сохраняет путь к новому аватару и возвращает путь к прежнему
+	SetAvatar(id uint, path string) (oldPath string, err error)
+	// FindByEmailsFold возвращает пользователей с адресами из emails без учета регистра
+	FindByEmailsFold(emails []string) ([]models.User, error)
+	// Search возвращает до limit пользователей по фильтру, новые первыми
+	Search(filter UserFilter, limit int) ([]models.User, error)
+	// Change загружает пользователя с блокировкой строки и передает его в change. Если change
+	// вернул запись журнала, пользователь сохраняется и запись добавляется в той же транзакции;
+	// nil означает, что менять нечего. Ошибка change откатывает транзакцию.
+	Change(ctx context.Context, id uint, change func(user *models.User) (*models.AuditEvent, error)) (*models.User, error)
+}
+
+// UserFilter - условия поиска пользователей в разделе /admin. Пустые поля не ограничивают выборку.
+type UserFilter struct {
+	Text       string // Часть email или имени; если задан ID, подходит и пользователь с этим ID
+	ID         uint
+	Role       models.Role
+	BannedOnly bool
+}
+
+// ProductChanges - изменения товара для ProductRepository.Update; nil - поле не меняется
+type ProductChanges struct {
+	Title       *string
+	Description *string
+	Price       *float64
+	Watermark   *bool
+	TagIDs      *[]uint // Новый набор тегов; пустой срез снимает все теги
+}
+
+// SellerStats - сводные показатели продавца для витрины
+type SellerStats struct {
+	ProductCount int64   `json:"productCount"`
+	SalesCount   int64   `json:"salesCount"`  // Сколько раз покупали товары продавца
+	RatingAvg    float64 `json:"ratingAvg"`   // Средняя оценка по видимым отзывам на все товары
+	ReviewCount  int64   `json:"reviewCount"` // Число видимых отзывов
+}
+
+// ProductRepository - товары. Методы записи ставят уведомления подписчикам избранного
+// (wishlist_notifications) в той же транзакции, что и изменение товара.
+type ProductRepository interface {
+	FindByID(id uint) (*models.Product, error)
+	// ListBySeller возвращает товары продавца
+	ListBySeller(userID uint) ([]models.Product, error)
+	CountBySeller(userID uint) (int64, error)
+	// SellerStats считает товары в продаже, продажи и рейтинг продавца
+	SellerStats(sellerID uint) (SellerStats, error)
+	// Create сохраняет новый товар с тегами. Покупатель, выставивший первый товар, становится продавцом.
+	Create(ctx context.Context, product *models.Product, tagIDs []uint) error
+	// Update применяет изменения и перечитывает товар в product.
+	// При снижении цены подписчикам ставится уведомление.
+	Update(ctx context.Context, product *models.Product, changes ProductChanges) error
+	// PublishVersion заменяет архив товара, увеличивает версию, перечитывает товар в product
+	// и ставит подписчикам уведомление о новой версии
+	PublishVersion(ctx context.Context, product *models.Product, filePath string) error
+	// Delete удаляет товар вместе с привязками к тегам, позициями корзин и избранного
+	Delete(ctx context.Context, id uint) error
+	// Search возвращает до limit товаров по фильтру вместе с продавцом и числом продаж, новые первыми
+	Search(filter ProductFilter, limit int) ([]ProductWithSales, error)
+	// Change загружает товар с блокировкой строки и передает его в change - так же, как
+	// UserRepository.Change. Снятый с продажи товар удаляется из корзин.
+	Change(ctx context.Context, id uint, change func(product *models.Product) (*models.AuditEvent, error)) error
+}
+
+// ProductFilter - условия поиска товаров в разделе /admin
+type ProductFilter struct {
+	Text         string // Часть названия или email продавца; если задан ID, подходит и товар с этим ID
+	ID           uint
+	UnlistedOnly bool
+}
+
+// ProductWithSales - товар вместе с email продавца и числом продаж
+type ProductWithSales struct {
+	models.Product
+	SellerEmail string
+	SalesCount  int64
+}
+
+// Маркеры, которыми ProductSearchRepository выделяет совпадения во фрагменте описания:
+// символы из области частного использования Unicode. Перед построением фрагмента они
+// удаляются из описания, поэтому во фрагменте встречаются только маркеры совпадений.
+const (
+	SnippetStart = "\uE000"
+	SnippetStop  = "\uE001"
+)
+
+// ProductOrder - ключ сортировки выдачи ProductSearchRepository
+type ProductOrder int
+
+const (
+	OrderByCreated ProductOrder = iota // По времени создания
+	OrderByRank                        // По релевантности поиска по тексту
+	OrderByPrice                       // По цене
+	OrderBySales                       // По числу продаж
+)
+
+// ProductSearch - запрос к ProductSearchRepository. Пустые поля не ограничивают выборку.
+type ProductSearch struct {
+	Terms          []string // Слова поиска из букв и цифр в нижнем регистре, каждое ищется по префиксу
+	Tags           []string // Имена или слаги тегов в нижнем регистре без повторов; тег включает дочерние
+	AnyTag         bool     // Достаточно одного тега из Tags, иначе нужны все
+	MinPrice       *float64 // Включительно
+	MaxPrice       *float64 // Включительно
+	SellerID       uint
+	SellerUsername string // Без учета регистра
+	Order          ProductOrder
+	Ascending      bool
+	After          *ProductPosition // Выдача начинается строго после этой позиции
+	Limit          int
+}
+
+// ProductPosition - позиция товара в выдаче: ключ сортировки и ID, который упорядочивает
+// товары с равным ключом
+type ProductPosition struct {
+	Key float64
+	ID  uint
+}
+
+// ProductSearchHit - найденный товар
+type ProductSearchHit struct {
+	models.Product `gorm:"embedded"`
+
+	Rank    float64 // Релевантность; 0 без поиска по тексту
+	Snippet string  // Фрагмент описания с SnippetStart и SnippetStop вокруг совпадений
+	SortKey float64 // Ключ сортировки (время создания - в микросекундах) для ProductPosition
+}
+
+// ProductSearchRepository - поиск товаров для каталога и витрин. Товары, снятые с продажи,
+// не возвращаются.
+type ProductSearchRepository interface {
+	// Search возвращает до Limit товаров, упорядоченных по паре (ключ Order, ID)
+	// в направлении Ascending
+	Search(ctx context.Context, search ProductSearch) ([]ProductSearchHit, error)
+}
+
+// TagUsage - тег с числом товаров, к которым он привязан
+type TagUsage struct {
+	models.Tag
+	UsageCount int64 `json:"usageCount"`
+}
+
+// TagRepository - теги и их привязки к товарам. Имена тегов уникальны без учета регистра,
+// слаги уникальны; нарушение уникальности возвращается ошибкой базы.
+type TagRepository interface {
+	// List возвращает все теги по алфавиту
+	List() ([]models.Tag, error)
+	// ListWithUsage возвращает все теги по алфавиту (без учета регистра) с числом товаров
+	ListWithUsage() ([]TagUsage, error)
+	// ListWithoutSlug возвращает теги без слага по возрастанию ID
+	ListWithoutSlug() ([]models.Tag, error)
+	FindByID(id uint) (*models.Tag, error)
+	// FindByName ищет тег по имени без учета регистра
+	FindByName(name string) (*models.Tag, error)
+	// NameTaken сообщает, занято ли имя (без учета регистра) тегом, кроме excludeID
+	NameTaken(name string, excludeID uint) (bool, error)
+	// SlugTaken сообщает, занят ли слаг тегом, кроме excludeID
+	SlugTaken(slug string, excludeID uint) (bool, error)
+	// IsAncestor сообщает, является ли ancestorID предком тега id или им самим
+	IsAncestor(ancestorID, id uint) (bool, error)
+	Create(tag *models.Tag) error
+	// Save сохраняет имя, слаг и родителя тега
+	Save(tag *models.Tag) error
+	// Merge переносит товары тега sourceID на targetID и удаляет source. Дочерние теги source
+	// переходят к target; если target был потомком source, он поднимается на место source.
+	Merge(sourceID, targetID uint) error
+	// Delete удаляет тег вместе с привязками к товарам; дочерние теги переходят к его родителю
+	Delete(id uint) error
+	// ListByProduct возвращает теги товара по алфавиту
+	ListByProduct(productID uint) ([]models.Tag, error)
+	// NamesByProducts возвращает имена тегов для нескольких товаров одним запросом
+	NamesByProducts(productIDs []uint) (map[uint][]string, error)
+	// ExistingIDs оставляет из ids только существующие теги
+	ExistingIDs(ids []uint) ([]uint, error)
+}
+
+// CartRepository - корзины пользователей
+type CartRepository interface {
+	// ListByUser возвращает позиции корзины вместе с товарами, новые первыми
+	ListByUser(userID uint) ([]models.CartItem, error)
+	// Find ищет товар в корзине пользователя
+	Find(userID, productID uint) (*models.CartItem, error)
+	Add(item *models.CartItem) error
+	// Remove удаляет позицию из корзины пользователя. Чужую или несуществующую
+	// позицию не трогает и возвращает ErrNotFound.
+	Remove(userID, itemID uint) error
+	// Checkout оформляет заказ из корзины пользователя userID в одной транзакции: блокирует
+	// строку пользователя и передает его в charge вместе с позициями корзины (с товарами).
+	// Затем создает заказ из этих позиций, списывает с баланса OrderCharge.Total, очищает
+	// корзину и сохраняет запись журнала. Ошибка charge откатывает транзакцию.
+	Checkout(ctx context.Context, userID uint, charge func(user *models.User, items []models.CartItem) (OrderCharge, error)) (*models.Order, error)
+}
+
+// OrderCharge - решение сервиса об оформлении заказа: сумма, которая списывается с баланса
+// покупателя, и запись журнала аудита. TargetID записи репозиторий заполняет ID созданного заказа.
+type OrderCharge struct {
+	Total float64
+	Event *models.AuditEvent
+}
+
+// OrderRepository - заказы
+type OrderRepository interface {
+	// ListByUser возвращает заказы пользователя вместе с товарами, новые первыми
+	ListByUser(userID uint) ([]models.Order, error)
+	// FindForUser возвращает заказ пользователя вместе с товарами
+	FindForUser(userID, orderID uint) (*models.Order, error)
+	// ItemsByOrder возвращает позиции заказа вместе с товарами
+	ItemsByOrder(orderID uint) ([]models.OrderItem, error)
+	HasPurchased(userID, productID uint) (bool, error)
+	// CountByProduct возвращает, сколько раз товар покупали
+	CountByProduct(productID uint) (int64, error)
+	// FindByID возвращает заказ вместе с покупателем и товарами
+	FindByID(id uint) (*models.Order, error)
+	// FirstOrderID возвращает первый заказ, в котором пользователь купил товар, или 0
+	FirstOrderID(userID, productID uint) (uint, error)
+	// Search возвращает до limit последних заказов. Ненулевой ID ищет заказ по номеру,
+	// иначе непустой email - по части адреса покупателя.
+	Search(id uint, email string, limit int) ([]OrderSummary, error)
+	// Buy оформляет заказ на один товар так же, как CartRepository.Checkout, но без корзины:
+	// в charge передаются покупатель, товар и то, покупал ли он этот товар раньше.
+	// Если нет пользователя или товара - ErrNotFound.
+	Buy(ctx context.Context, userID, productID uint, charge func(user *models.User, product *models.Product, purchased bool) (OrderCharge, error)) (*models.Order, error)
+}
+
+// OrderSummary - строка списка заказов в разделе /admin
+type OrderSummary struct {
+	ID         uint
+	CreatedAt  time.Time
+	UserID     uint
+	Email      string
+	ItemsCount int64
+}
+
+// SessionRepository - сессии входа через браузер
+type SessionRepository interface {
+	Create(session *models.Session) error
+	FindByTokenHash(hash string) (*models.Session, error)
+	// Touch обновляет время последнего запроса в сессии
+	Touch(id uint, at time.Time) error
+	// Delete удаляет сессию по хешу токена; удаленная или несуществующая сессия - не ошибка
+	Delete(hash string) error
+	// DeleteByUser завершает все сессии пользователя
+	DeleteByUser(userID uint) error
+	// DeleteExpired удаляет сессии, истекшие до now, и возвращает их число
+	DeleteExpired(now time.Time) (int64, error)
+}
+
+// IdentityRepository - внешние аккаунты (OAuth/OIDC), привязанные к пользователям
+type IdentityRepository interface {
+	// FindBySubject ищет привязку внешнего аккаунта provider/subject
+	FindBySubject(provider, subject string) (*models.UserIdentity, error)
+	// FindForUser возвращает привязку пользователя; чужая привязка - ErrNotFound
+	FindForUser(userID, id uint) (*models.UserIdentity, error)
+	// ListByUser возвращает привязки пользователя по имени провайдера
+	ListByUser(userID uint) ([]models.UserIdentity, error)
+	CountByUser(userID uint) (int64, error)
+	Create(identity *models.UserIdentity) error
+	// CreateWithUser создает пользователя и привязку к нему в одной транзакции
+	CreateWithUser(ctx context.Context, user *models.User, identity *models.UserIdentity) error
+	Delete(id uint) error
+
+	// CreatePending сохраняет запрос на привязку, ожидающий подтверждения владельцем аккаунта
+	CreatePending(link *models.PendingIdentityLink) error
+	// FindPending ищет запрос по хешу токена из cookie; истекший до now запрос - ErrNotFound
+	FindPending(tokenHash string, now time.Time) (*models.PendingIdentityLink, error)
+	// FindPendingByEmailToken ищет запрос по хешу токена из письма; истекший до now - ErrNotFound
+	FindPendingByEmailToken(tokenHash string, now time.Time) (*models.PendingIdentityLink, error)
+	// SetPendingEmailToken сохраняет хеш токена из письма и продлевает запрос до expiresAt
+	SetPendingEmailToken(id uint, tokenHash string, expiresAt time.Time) error
+	// ConfirmPending удаляет запрос id и создает привязку identity в одной транзакции.
+	// ErrNotFound, если запрос уже подтвержден или отменен: подтвердить его дважды нельзя.
+	ConfirmPending(ctx context.Context, id uint, identity *models.UserIdentity) error
+	// DeletePending удаляет запрос; отсутствующий запрос - не ошибка
+	DeletePending(id uint) error
+	// DeleteExpiredPending удаляет запросы, истекшие до now, и возвращает их число
+	DeleteExpiredPending(now time.Time) (int64, error)
+}
+
+// TokenRepository - персональные токены доступа к API
+type TokenRepository interface {
+	// CountActive считает неотозванные токены пользователя
+	CountActive(userID uint) (int64, error)
+	Create(token *models.PersonalAccessToken) error
+	FindByHash(hash string) (*models.PersonalAccessToken, error)
+	// Touch обновляет время последнего использования токена
+	Touch(id uint, at time.Time) error
+	// ListActive возвращает неотозванные токены пользователя, новые первыми
+	ListActive(userID uint) ([]models.PersonalAccessToken, error)
+	// Revoke отзывает токен пользователя; отозванный, чужой или несуществующий - ErrNotFound
+	Revoke(userID, id uint, at time.Time) error
+	// DeleteExpired удаляет токены, отозванные или истекшие до cutoff
+	DeleteExpired(cutoff time.Time) (int64, error)
+}
+
+// ReviewRepository - отзывы о товарах. Методы, которые меняют оценку или видимость отзыва,
+// пересчитывают рейтинг товара (Product.RatingAvg и ReviewCount).
+type ReviewRepository interface {
+	// FindByID возвращает отзыв, в том числе скрытый
+	FindByID(id uint) (*models.Review, error)
+	// FindVisible возвращает видимый отзыв вместе с товаром
+	FindVisible(id uint) (*models.Review, error)
+	// FindByUser возвращает отзыв пользователя о товаре
+	FindByUser(userID, productID uint) (*models.Review, error)
+	// ListVisible возвращает видимые отзывы о товаре вместе с авторами, новые первыми
+	ListVisible(productID uint) ([]models.Review, error)
+	// ListForModeration возвращает отзывы с жалобами и скрытые вместе с авторами и товарами,
+	// недавно измененные первыми
+	ListForModeration(limit int) ([]models.Review, error)
+	// Save создает отзыв (ID == 0) или сохраняет оценку и текст существующего
+	Save(review *models.Review) error
+	// SetReply сохраняет ответ продавца, не меняя updated_at
+	SetReply(id uint, reply string, at time.Time) error
+	// Flag отмечает отзыв жалобой
+	Flag(id uint) error
+	// SetHidden скрывает или показывает отзыв, снимает жалобу и записывает event
+	// в журнал аудита в той же транзакции
+	SetHidden(ctx context.Context, id uint, hidden bool, event *models.AuditEvent) error
+}
+
+// WishlistRepository - избранное и очередь уведомлений подписчикам
+type WishlistRepository interface {
+	// Add добавляет товар в избранное; created == false, если он уже был в избранном
+	Add(item *models.WishlistItem) (created bool, err error)
+	// Remove удаляет товар из избранного пользователя; ErrNotFound, если его там нет
+	Remove(userID, productID uint) error
+	// ListByUser возвращает избранное вместе с товарами, последние добавленные первыми
+	ListByUser(userID uint) ([]models.WishlistItem, error)
+	Contains(userID, productID uint) (bool, error)
+	// PendingNotifications возвращает до limit неотправленных событий вместе с товарами,
+	// упорядоченных по пользователю. События, отложенные после неудачи на время
+	// позже now, и события с исчерпанными попытками не возвращаются.
+	PendingNotifications(ctx context.Context, now time.Time, limit int) ([]models.WishlistNotification, error)
+	// MarkSent отмечает события отправленными
+	MarkSent(ids []uint, at time.Time) error
+	// MarkFailed записывает неудачную попытку отправки: событиям присваивается счетчик
+	// attempts и время следующей попытки retryAt. retryAt == nil - попытки исчерпаны,
+	// события помечаются failed_at = at.
+	MarkFailed(ids []uint, attempts int, retryAt *time.Time, at time.Time) error
+}
+
+// AuditFilter - условия поиска по журналу аудита. Пустые поля не ограничивают выборку.
+type AuditFilter struct {
+	Action     string // Начало названия действия: "auth." найдет все события входа
+	ActorID    uint
+	TargetType string
+	TargetID   uint
+	IP         string
+	From       time.Time // Включительно
+	To         time.Time // Не включительно
+	BeforeID   uint      // Постраничный просмотр: только записи старше этой
+}
+
+// AuditRepository - журнал аудита. Записи только добавляются.
+type AuditRepository interface {
+	Create(ctx context.Context, event *models.AuditEvent) error
+	// Search возвращает до limit записей по фильтру, новые первыми
+	Search(filter AuditFilter, limit int) ([]models.AuditEvent, error)
+	// ListForTarget возвращает до limit последних записей об объекте, новые первыми
+	ListForTarget(targetType string, targetID uint, limit int) ([]models.AuditEvent, error)
+	// FindByDetail возвращает самую раннюю запись action, у которой в подробностях key равен value
+	FindByDetail(action, key, value string) (*models.AuditEvent, error)
+}
+
+// DownloadStats - статистика скачиваний товара покупателями (скачивания владельца не учитываются)
+type DownloadStats struct {
+	ProductID        uint
+	Downloads        int64      // Всего скачиваний
+	RecentDownloads  int64      // С момента StatsPeriod.RecentSince
+	Buyers           int64      // Разных покупателей, скачавших товар
+	Bytes            int64      // Отдано байт
+	LastDownloadAt   *time.Time // nil - товар еще не скачивали
+	SuspiciousBuyers int64      // Покупатели, скачивавшие с AnomalyIPs и более адресов с AnomalySince
+}
+
+// StatsPeriod - границы, по которым DownloadRepository.Stats считает недавние и подозрительные скачивания
+type StatsPeriod struct {
+	RecentSince  time.Time
+	AnomalySince time.Time
+	AnomalyIPs   int // 0 - подозрительных покупателей не искать
+}
+
+// DownloadRepository - скачивания файлов товаров
+type DownloadRepository interface {
+	// Reserve сохраняет скачивание до отдачи файла. Если limit > 0 и скачивание не владельца,
+	// сначала проверяет, что у покупателя меньше limit скачиваний товара, иначе возвращает
+	// ErrLimitReached. Проверка и вставка атомарны: параллельные запросы лимит не превысят.
+	Reserve(ctx context.Context, download *models.Download, limit int) error
+	// Complete сохраняет размер отданного файла и водяной знак зарезервированного скачивания
+	Complete(ctx context.Context, download *models.Download) error
+	// CountByBuyer считает скачивания товара покупателем без скачиваний владельца
+	CountByBuyer(userID, productID uint) (int64, error)
+	// CountIPs возвращает, со скольких разных адресов покупатель скачивал товар начиная с since
+	// и сколько из этих скачиваний было с адреса ip
+	CountIPs(ctx context.Context, userID, productID uint, since time.Time, ip string) (ips, fromIP int64, err error)
+	// Stats возвращает статистику по товарам productIDs; товары без скачиваний в результат не попадают
+	Stats(productIDs []uint, period StatsPeriod) (map[uint]DownloadStats, error)
+	// FindByWatermark ищет скачивание по коду водяного знака
+	FindByWatermark(code string) (*models.Download, error)
+}
+
+// Repositories - набор репозиториев, который передается в конструкторы контроллеров
+type Repositories struct {
+	Users      UserRepository
+	Products   ProductRepository
+	Tags       TagRepository
+	Carts      CartRepository
+	Orders     OrderRepository
+	Sessions   SessionRepository
+	Identities IdentityRepository
+	Tokens     TokenRepository
+	Reviews    ReviewRepository
+	Wishlist   WishlistRepository
+	Audit      AuditRepository
+	Downloads  DownloadRepository
+
+	ProductSearch ProductSearchRepository
+}
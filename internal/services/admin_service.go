@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ошибки действий в разделе администратора
+var (
+	ErrUserNotFound      = errors.New("пользователь не найден")
+	ErrOrderNotFound     = errors.New("заказ не найден")
+	ErrAuditNoteRequired = errors.New("укажите причину действия")
+	ErrZeroAmount        = errors.New("сумма изменения баланса не может быть нулевой")
+	ErrNegativeBalance   = errors.New("баланс не может стать отрицательным")
+	ErrSelfModeration    = errors.New("это действие нельзя применить к своему аккаунту")
+	ErrStaffProtected    = errors.New("блокировать сотрудников может только администратор")
+	ErrInvalidRole       = errors.New("неизвестная роль")
+)
+
+// adminListLimit - сколько строк показывают списки раздела администратора
+const adminListLimit = 100
+
+// AdminProduct - товар в списке модерации вместе с продавцом и числом продаж
+type AdminProduct = repository.ProductWithSales
+
+// AdminOrder - строка списка заказов
+type AdminOrder = repository.OrderSummary
+
+// AdminService выполняет действия сотрудников: поиск пользователей, изменение баланса,
+// блокировку, роли, снятие товаров с продажи и просмотр заказов.
+// Каждое изменение записывается в журнал аудита в той же транзакции.
+// Блокировка и смена роли завершают все сессии пользователя.
+type AdminService struct {
+	users    repository.UserRepository
+	products repository.ProductRepository
+	orders   repository.OrderRepository
+	audit    repository.AuditRepository
+	sessions repository.SessionRepository
+}
+
+// NewAdminService создает сервис администратора поверх репозиториев
+func NewAdminService(users repository.UserRepository, products repository.ProductRepository, orders repository.OrderRepository, audit repository.AuditRepository, sessions repository.SessionRepository) *AdminService {
+	return &AdminService{users: users, products: products, orders: orders, audit: audit, sessions: sessions}
+}
+
+// SearchUsers ищет пользователей по части email или имени либо по точному ID.
+// Пустой role - любая роль; bannedOnly оставляет только заблокированных.
+func (s *AdminService) SearchUsers(query string, role models.Role, bannedOnly bool) ([]models.User, error) {
+	filter := repository.UserFilter{Text: strings.TrimSpace(query), Role: role, BannedOnly: bannedOnly}
+	if id, err := strconv.ParseUint(filter.Text, 10, 64); err == nil {
+		filter.ID = uint(id)
+	}
+	return s.users.Search(filter, adminListLimit)
+}
+
+// GetUser возвращает пользователя по ID (ErrUserNotFound, если его нет)
+func (s *AdminService) GetUser(userID uint) (*models.User, error) {
+	user, err := s.users.FindByID(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// AdjustBalance изменяет баланс на amount (положительный - начисление, отрицательный - списание).
+// Причина обязательна и сохраняется в журнале вместе с балансом до и после.
+func (s *AdminService) AdjustBalance(ctx context.Context, actor AuditActor, userID uint, amount float64, note string) (*models.User, error) {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return nil, ErrAuditNoteRequired
+	}
+	if amount == 0 {
+		return nil, ErrZeroAmount
+	}
+
+	// Строка пользователя блокируется, как при покупке: списание не пересечется с оформлением заказа
+	user, err := s.changeUser(ctx, userID, func(user *models.User) (*models.AuditEvent, error) {
+		before := user.Balance
+		if before+amount < 0 {
+			return nil, ErrNegativeBalance
+		}
+		user.Balance = before + amount
+		return newAuditEvent(actor, AuditEntry{
+			Action:     AuditUserBalanceAdjust,
+			TargetType: AuditTargetUser,
+			TargetID:   userID,
+			Details:    map[string]interface{}{"amount": amount, "balance_before": before, "balance_after": user.Balance},
+			Note:       note,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).Info("Баланс изменен сотрудником", logging.UserID(userID), slog.Float64("amount", amount))
+	return user, nil
+}
+
+// Ban блокирует аккаунт. Модератор не может заблокировать модератора или администратора,
+// никто не может заблокировать себя.
+func (s *AdminService) Ban(ctx context.Context, actor AuditActor, userID uint, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return ErrAuditNoteRequired
+	}
+	if userID == actor.UserID {
+		return ErrSelfModeration
+	}
+
+	return s.changeUserAccess(ctx, userID, func(user *models.User) (*models.AuditEvent, error) {
+		if user.Role.IsStaff() && !actor.Role.Can(models.PermUsersRoles) {
+			return nil, ErrStaffProtected
+		}
+		if user.Banned() {
+			return nil, nil
+		}
+		now := time.Now()
+		user.BannedAt = &now
+		user.BanReason = reason
+		return newAuditEvent(actor, AuditEntry{
+			Action:     AuditUserBan,
+			TargetType: AuditTargetUser,
+			TargetID:   userID,
+			Details:    map[string]interface{}{"email": user.Email},
+			Note:       reason,
+		})
+	})
+}
+
+// Unban снимает блокировку аккаунта
+func (s *AdminService) Unban(ctx context.Context, actor AuditActor, userID uint, note string) error {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return ErrAuditNoteRequired
+	}
+
+	_, err := s.changeUser(ctx, userID, func(user *models.User) (*models.AuditEvent, error) {
+		if !user.Banned() {
+			return nil, nil
+		}
+		banReason := user.BanReason
+		user.BannedAt = nil
+		user.BanReason = ""
+		return newAuditEvent(actor, AuditEntry{
+			Action:     AuditUserUnban,
+			TargetType: AuditTargetUser,
+			TargetID:   userID,
+			Details:    map[string]interface{}{"email": user.Email, "ban_reason": banReason},
+			Note:       note,
+		})
+	})
+	return err
+}
+
+// SetRole назначает пользователю роль. Свою роль изменить нельзя, чтобы не потерять доступ к /admin.
+func (s *AdminService) SetRole(ctx context.Context, actor AuditActor, userID uint, role models.Role, note string) error {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return ErrAuditNoteRequired
+	}
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+	if userID == actor.UserID {
+		return ErrSelfModeration
+	}
+
+	return s.changeUserAccess(ctx, userID, setRole(actor, role, note))
+}
+
+// PromoteAdmins назначает администраторами пользователей с адресами из adminEmails
+// (ADMIN_EMAILS в конфигурации). Вызывается при запуске; повторный вызов ничего не меняет.
+func (s *AdminService) PromoteAdmins(ctx context.Context, adminEmails []string) (int, error) {
+	users, err := s.users.FindByEmailsFold(adminEmails)
+	if err != nil {
+		return 0, err
+	}
+
+	promoted := 0
+	for _, user := range users {
+		if user.Role == models.RoleAdmin {
+			continue
+		}
+		if err := s.changeUserAccess(ctx, user.ID, setRole(AuditActor{}, models.RoleAdmin, "ADMIN_EMAILS")); err != nil {
+			return promoted, err
+		}
+		promoted++
+	}
+	return promoted, nil
+}
+
+// setRole возвращает изменение для UserRepository.Change, которое назначает роль role
+func setRole(actor AuditActor, role models.Role, note string) func(*models.User) (*models.AuditEvent, error) {
+	return func(user *models.User) (*models.AuditEvent, error) {
+		if user.Role == role {
+			return nil, nil
+		}
+		from := user.Role
+		user.Role = role
+		return newAuditEvent(actor, AuditEntry{
+			Action:     AuditUserRoleChange,
+			TargetType: AuditTargetUser,
+			TargetID:   user.ID,
+			Details:    map[string]interface{}{"from": from, "to": role},
+			Note:       note,
+		})
+	}
+}
+
+// SearchProducts ищет товары по части названия, email продавца или по точному ID.
+// unlistedOnly оставляет только снятые с продажи.
+func (s *AdminService) SearchProducts(query string, unlistedOnly bool) ([]AdminProduct, error) {
+	filter := repository.ProductFilter{Text: strings.TrimSpace(query), UnlistedOnly: unlistedOnly}
+	if id, err := strconv.ParseUint(filter.Text, 10, 64); err == nil {
+		filter.ID = uint(id)
+	}
+	return s.products.Search(filter, adminListLimit)
+}
+
+// Unlist снимает товар с продажи: он пропадает из каталога и витрин, купить его нельзя,
+// но покупатели сохраняют доступ к файлам. Из корзин товар убирается.
+func (s *AdminService) Unlist(ctx context.Context, actor AuditActor, productID uint, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return ErrAuditNoteRequired
+	}
+
+	return s.changeProduct(ctx, productID, func(product *models.Product) (*models.AuditEvent, error) {
+		if !product.Listed() {
+			return nil, nil
+		}
+		now := time.Now()
+		product.UnlistedAt = &now
+		product.UnlistReason = reason
+		return newAuditEvent(actor, AuditEntry{
+			Action:     AuditProductUnlist,
+			TargetType: AuditTargetProduct,
+			TargetID:   productID,
+			Details:    map[string]interface{}{"title": product.Title, "seller_id": product.UserID},
+			Note:       reason,
+		})
+	})
+}
+
+// Relist возвращает снятый товар в продажу
+func (s *AdminService) Relist(ctx context.Context, actor AuditActor, productID uint, note string) error {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return ErrAuditNoteRequired
+	}
+
+	return s.changeProduct(ctx, productID, func(product *models.Product) (*models.AuditEvent, error) {
+		if product.Listed() {
+			return nil, nil
+		}
+		unlistReason := product.UnlistReason
+		product.UnlistedAt = nil
+		product.UnlistReason = ""
+		return newAuditEvent(actor, AuditEntry{
+			Action:     AuditProductRelist,
+			TargetType: AuditTargetProduct,
+			TargetID:   productID,
+			Details:    map[string]interface{}{"title": product.Title, "unlist_reason": unlistReason},
+			Note:       note,
+		})
+	})
+}
+
+// ListOrders возвращает последние заказы. query - точный ID заказа или часть email покупателя.
+func (s *AdminService) ListOrders(query string) ([]AdminOrder, error) {
+	query = strings.TrimSpace(query)
+	if id, err := strconv.ParseUint(strings.TrimPrefix(query, "#"), 10, 64); err == nil {
+		return s.orders.Search(uint(id), "", adminListLimit)
+	}
+	return s.orders.Search(0, query, adminListLimit)
+}
+
+// GetOrder возвращает заказ вместе с покупателем и товарами
+func (s *AdminService) GetOrder(orderID uint) (*models.Order, error) {
+	order, err := s.orders.FindByID(orderID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrOrderNotFound
+	}
+	return order, err
+}
+
+// RecordMailResend записывает в журнал повторную отправку письма о заказе.
+// Само письмо отправляется в фоне, поэтому запись делается при постановке в очередь.
+func (s *AdminService) RecordMailResend(ctx context.Context, actor AuditActor, order models.Order, note string) error {
+	event, err := newAuditEvent(actor, AuditEntry{
+		Action:     AuditOrderMailResend,
+		TargetType: AuditTargetOrder,
+		TargetID:   order.ID,
+		Details:    map[string]interface{}{"email": order.User.Email},
+		Note:       strings.TrimSpace(note),
+	})
+	if err != nil {
+		return err
+	}
+	return s.audit.Create(ctx, event)
+}
+
+// changeUser применяет change к заблокированной строке пользователя (ErrUserNotFound, если его нет)
+func (s *AdminService) changeUser(ctx context.Context, userID uint, change func(*models.User) (*models.AuditEvent, error)) (*models.User, error) {
+	user, err := s.users.Change(ctx, userID, change)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// changeUserAccess применяет change так же, как changeUser, и, если пользователь изменился,
+// завершает все его сессии: блокировка или новая роль действуют во всех браузерах сразу,
+// а вошедший снова получает сессию с актуальными правами. Ошибка завершения сессий только
+// пишется в журнал: изменение уже сохранено, а AuthRequired все равно читает роль
+// и блокировку из базы.
+func (s *AdminService) changeUserAccess(ctx context.Context, userID uint, change func(*models.User) (*models.AuditEvent, error)) error {
+	changed := false
+	_, err := s.changeUser(ctx, userID, func(user *models.User) (*models.AuditEvent, error) {
+		event, err := change(user)
+		changed = event != nil
+		return event, err
+	})
+	if err != nil || !changed {
+		return err
+	}
+	if err := s.sessions.DeleteByUser(userID); err != nil {
+		logging.FromContext(ctx).Error("Ошибка завершения сессий пользователя", logging.UserID(userID), logging.Err(err))
+	}
+	return nil
+}
+
+// changeProduct применяет change к заблокированной строке товара (ErrProductNotFound, если его нет)
+func (s *AdminService) changeProduct(ctx context.Context, productID uint, change func(*models.Product) (*models.AuditEvent, error)) error {
+	err := s.products.Change(ctx, productID, change)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrProductNotFound
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"encoding/json"
+	"time"
+)
+
+// Объекты, к которым относятся записи журнала аудита (поле target_type)
+const (
+	AuditTargetUser    = "user"
+	AuditTargetProduct = "product"
+	AuditTargetOrder   = "order"
+	AuditTargetReview  = "review"
+	AuditTargetTag     = "tag"
+	AuditTargetToken   = "token"
+)
+
+// AuditTargetTypes - все типы объектов, по ним фильтруется журнал в /admin/audit
+var AuditTargetTypes = []string{
+	AuditTargetUser, AuditTargetProduct, AuditTargetOrder, AuditTargetReview, AuditTargetTag, AuditTargetToken,
+}
+
+// Действия пользователей: вход, смена пароля, привязка аккаунтов, покупки и скачивания
+const (
+	AuditAuthRegister       = "auth.register"
+	AuditAuthLogin          = "auth.login"
+	AuditAuthLoginFailed    = "auth.login_failed"
+	AuditAuthLogout         = "auth.logout"
+	AuditAuthPasswordChange = "auth.password_change"
+	AuditAuthPasswordFailed = "auth.password_change_failed"
+	AuditAuthIdentityLink   = "auth.identity_link"
+	AuditAuthIdentityUnlink = "auth.identity_unlink"
+	AuditAuthTokenCreate    = "auth.token_create"
+	AuditAuthTokenRevoke    = "auth.token_revoke"
+	AuditOrderCheckout      = "order.checkout"
+	AuditOrderBuy           = "order.buy"
+	AuditUserBalanceEarn    = "user.balance_earn"
+	AuditFileDownload       = "file.download"
+	AuditDownloadAnomaly    = "download.anomaly" // Покупку скачивают со слишком многих IP
+)
+
+// Действия сотрудников в разделе /admin (поле action)
+const (
+	AuditUserBalanceAdjust = "user.balance_adjust"
+	AuditUserBan           = "user.ban"
+	AuditUserUnban         = "user.unban"
+	AuditUserRoleChange    = "user.role_change"
+	AuditProductUnlist     = "product.unlist"
+	AuditProductRelist     = "product.relist"
+	AuditOrderMailResend   = "order.mail_resend"
+	AuditReviewHide        = "review.hide"
+	AuditReviewRestore     = "review.restore"
+	AuditTagRename         = "tag.rename"
+	AuditTagSetParent      = "tag.set_parent"
+	AuditTagMerge          = "tag.merge"
+	AuditTagDelete         = "tag.delete"
+	AuditLogExport         = "audit.export"
+)
+
+// AuditActor - кто и откуда выполняет действие. Контроллеры заполняют его из запроса.
+type AuditActor struct {
+	UserID    uint        // 0 - действие системы, а не пользователя
+	Role      models.Role // Роль на момент действия, по ней сервисы проверяют права
+	IP        string
+	UserAgent string
+}
+
+// AuditEntry - действие, которое нужно записать в журнал
+type AuditEntry struct {
+	Action     string
+	TargetType string
+	TargetID   uint
+	Details    map[string]interface{} // Сохраняется как JSON-объект
+	Note       string
+}
+
+// AuditService пишет и читает журнал аудита (таблица audit_events)
+type AuditService struct {
+	events repository.AuditRepository
+}
+
+// NewAuditService создает сервис журнала аудита поверх репозитория
+func NewAuditService(events repository.AuditRepository) *AuditService {
+	return &AuditService{events: events}
+}
+
+// Record записывает действие, которое выполнено вне транзакции сервиса (например, операции с тегами)
+func (s *AuditService) Record(ctx context.Context, actor AuditActor, entry AuditEntry) error {
+	event, err := newAuditEvent(actor, entry)
+	if err != nil {
+		return err
+	}
+	return s.events.Create(ctx, event)
+}
+
+// AuditFilter - условия поиска по журналу. Пустые поля не ограничивают выборку.
+type AuditFilter = repository.AuditFilter
+
+// auditExportBatch - сколько записей читается за один запрос при выгрузке журнала
+const auditExportBatch = 500
+
+// Search возвращает записи журнала по фильтру, новые первыми
+func (s *AuditService) Search(filter AuditFilter, limit int) ([]models.AuditEvent, error) {
+	return s.events.Search(filter, limit)
+}
+
+// Export передает в fn все записи по фильтру, новые первыми. Записи читаются порциями,
+// поэтому выгрузка большого журнала не держит его целиком в памяти.
+func (s *AuditService) Export(ctx context.Context, filter AuditFilter, fn func(models.AuditEvent) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		events, err := s.Search(filter, auditExportBatch)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		if len(events) < auditExportBatch {
+			return nil
+		}
+		filter.BeforeID = events[len(events)-1].ID
+	}
+}
+
+// ListForTarget возвращает последние записи об объекте, новые первыми
+func (s *AuditService) ListForTarget(targetType string, targetID uint, limit int) ([]models.AuditEvent, error) {
+	return s.events.ListForTarget(targetType, targetID, limit)
+}
+
+// newAuditEvent собирает запись журнала для репозитория, который сохранит ее вместе с действием
+func newAuditEvent(actor AuditActor, entry AuditEntry) (*models.AuditEvent, error) {
+	details := []byte("{}")
+	if len(entry.Details) > 0 {
+		encoded, err := json.Marshal(entry.Details)
+		if err != nil {
+			return nil, err
+		}
+		details = encoded
+	}
+
+	event := models.AuditEvent{
+		CreatedAt:  time.Now(),
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		IP:         actor.IP,
+		UserAgent:  actor.UserAgent,
+		Details:    string(details),
+		Note:       entry.Note,
+	}
+	if actor.UserID != 0 {
+		actorID := actor.UserID
+		event.ActorID = &actorID
+	}
+	if entry.TargetID != 0 {
+		targetID := entry.TargetID
+		event.TargetID = &targetID
+	}
+	return &event, nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"errors"
+	"log/slog"
+)
+
+// BalanceService начисляет кредиты пользователю по его собственному запросу.
+// Изменения баланса сотрудниками выполняет AdminService.AdjustBalance.
+type BalanceService struct {
+	users repository.UserRepository
+}
+
+// NewBalanceService создает сервис баланса поверх репозитория пользователей
+func NewBalanceService(users repository.UserRepository) *BalanceService {
+	return &BalanceService{users: users}
+}
+
+// Earn начисляет amount на баланс пользователя actor. Начисление и запись
+// в журнале аудита сохраняются в одной транзакции.
+func (s *BalanceService) Earn(ctx context.Context, actor AuditActor, amount float64) (*models.User, error) {
+	user, err := s.users.Change(ctx, actor.UserID, func(user *models.User) (*models.AuditEvent, error) {
+		before := user.Balance
+		user.Balance += amount
+		return newAuditEvent(actor, AuditEntry{
+			Action:     AuditUserBalanceEarn,
+			TargetType: AuditTargetUser,
+			TargetID:   actor.UserID,
+			Details:    map[string]interface{}{"amount": amount, "balance_before": before, "balance_after": user.Balance},
+		})
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).Info("Баланс пополнен", slog.Float64("amount", amount))
+	return user, nil
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/metrics"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/watermark"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrDownloadLimitReached - покупатель исчерпал лимит скачиваний товара
+var ErrDownloadLimitReached = errors.New("лимит скачиваний этого товара исчерпан")
+
+// recentDownloadsPeriod - за какой период статистика продавца показывает недавние скачивания
+const recentDownloadsPeriod = 30 * 24 * time.Hour
+
+// downloadsConfig - ограничения, заданные при запуске через ConfigureDownloads
+var downloadsConfig = config.Defaults().Downloads
+
+// ConfigureDownloads задает лимит скачиваний и порог поиска раздачи ссылок
+func ConfigureDownloads(cfg config.DownloadsConfig) {
+	downloadsConfig = cfg
+}
+
+// DownloadStats - статистика скачиваний товара покупателями (скачивания владельца не учитываются).
+// RecentDownloads считаются за последние 30 дней, SuspiciousBuyers - за последний AnomalyWindow.
+type DownloadStats = repository.DownloadStats
+
+// DownloadService учитывает скачивания файлов товаров, следит за лимитом
+// скачиваний покупки и считает статистику для продавцов
+type DownloadService struct {
+	downloads repository.DownloadRepository
+	audit     repository.AuditRepository
+}
+
+// NewDownloadService создает сервис скачиваний поверх репозиториев
+func NewDownloadService(downloads repository.DownloadRepository, audit repository.AuditRepository) *DownloadService {
+	return &DownloadService{downloads: downloads, audit: audit}
+}
+
+// Limit возвращает лимит скачиваний одной покупки; 0 - без ограничения
+func (s *DownloadService) Limit() int {
+	return downloadsConfig.PerPurchaseLimit
+}
+
+// CheckLimit возвращает ErrDownloadLimitReached, если userID больше не может скачать товар.
+// Владельца товара лимит не ограничивает. Проверка предварительная (например, перед выдачей
+// ссылки): само скачивание учитывается через Reserve.
+func (s *DownloadService) CheckLimit(userID uint, product models.Product) error {
+	limit := downloadsConfig.PerPurchaseLimit
+	if limit == 0 || product.UserID == userID {
+		return nil
+	}
+	count, err := s.downloads.CountByBuyer(userID, product.ID)
+	if err != nil {
+		return err
+	}
+	if count >= int64(limit) {
+		return ErrDownloadLimitReached
+	}
+	return nil
+}
+
+// HasDownloaded сообщает, учтено ли уже скачивание товара пользователем userID, то есть
+// можно ли докачать файл без нового резервирования. Владелец товара лимитом не ограничен.
+func (s *DownloadService) HasDownloaded(userID uint, product models.Product) (bool, error) {
+	if product.UserID == userID {
+		return true, nil
+	}
+	count, err := s.downloads.CountByBuyer(userID, product.ID)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Reserve учитывает скачивание товара покупателем actor.UserID до отдачи файла и возвращает
+// ErrDownloadLimitReached, если лимит покупки уже исчерпан. Проверка лимита и запись скачивания
+// атомарны, поэтому параллельные запросы не скачают файл сверх лимита.
+// via - способ скачивания (link или direct).
+func (s *DownloadService) Reserve(ctx context.Context, actor AuditActor, product models.Product, via string) (*models.Download, error) {
+	download := models.Download{
+		CreatedAt: time.Now(),
+		UserID:    actor.UserID,
+		ProductID: product.ID,
+		Version:   product.Version,
+		IP:        actor.IP,
+		Via:       via,
+		ByOwner:   product.UserID == actor.UserID,
+	}
+	err := s.downloads.Reserve(ctx, &download, downloadsConfig.PerPurchaseLimit)
+	if errors.Is(err, repository.ErrLimitReached) {
+		return nil, ErrDownloadLimitReached
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &download, nil
+}
+
+// Complete сохраняет размер отданного файла и водяной знак (mark, nil - файл без знака)
+// скачивания из Reserve и проверяет, не скачивают ли эту покупку со слишком многих адресов
+func (s *DownloadService) Complete(ctx context.Context, actor AuditActor, download *models.Download, bytes int64, mark *watermark.Mark) error {
+	download.Bytes = bytes
+	if mark != nil {
+		download.Watermark = &mark.Code
+		if mark.OrderID != 0 {
+			download.OrderID = &mark.OrderID
+		}
+	}
+	if err := s.downloads.Complete(ctx, download); err != nil {
+		return err
+	}
+	if download.ByOwner || downloadsConfig.AnomalyIPs == 0 {
+		return nil
+	}
+	return s.checkAnomaly(ctx, actor, *download)
+}
+
+// checkAnomaly записывает в журнал аудита событие download.anomaly, когда скачивание
+// с нового адреса доводит число разных IP покупки за AnomalyWindow до порога или выше.
+// Повторные скачивания с уже известных адресов событий не создают.
+func (s *DownloadService) checkAnomaly(ctx context.Context, actor AuditActor, download models.Download) error {
+	since := download.CreatedAt.Add(-downloadsConfig.AnomalyWindow)
+	ips, fromIP, err := s.downloads.CountIPs(ctx, download.UserID, download.ProductID, since, download.IP)
+	if err != nil {
+		return err
+	}
+	if fromIP > 1 || ips < int64(downloadsConfig.AnomalyIPs) {
+		return nil
+	}
+
+	logging.FromContext(ctx).Warn("Покупку скачивают с подозрительно многих адресов",
+		logging.ProductID(download.ProductID), slog.Int64("ips", ips))
+	metrics.DownloadAnomalies.Inc()
+	event, err := newAuditEvent(actor, AuditEntry{
+		Action:     AuditDownloadAnomaly,
+		TargetType: AuditTargetUser,
+		TargetID:   download.UserID,
+		Details: map[string]interface{}{
+			"product_id": download.ProductID,
+			"ips":        ips,
+			"window":     downloadsConfig.AnomalyWindow.String(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return s.audit.Create(ctx, event)
+}
+
+// Stats возвращает статистику скачиваний по товарам productIDs. Товары без скачиваний
+// в результат не попадают.
+func (s *DownloadService) Stats(productIDs []uint) (map[uint]DownloadStats, error) {
+	now := time.Now()
+	return s.downloads.Stats(productIDs, repository.StatsPeriod{
+		RecentSince:  now.Add(-recentDownloadsPeriod),
+		AnomalySince: now.Add(-downloadsConfig.AnomalyWindow),
+		AnomalyIPs:   downloadsConfig.AnomalyIPs,
+	})
+}
@@ -2,8 +2,7 @@ package services
 
 import (
 	"crypto/rand"
-	"digital-marketplace/internal/database"
-	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
@@ -11,32 +10,42 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
 // FileService предоставляет методы для работы с файлами
-type FileService struct{}
+type FileService struct {
+	products repository.ProductRepository
+}
 
 // NewFileService создает новый экземпляр сервиса файлов
-func NewFileService() *FileService {
-	return &FileService{}
+func NewFileService(products repository.ProductRepository) *FileService {
+	return &FileService{products: products}
 }
 
 // DownloadInfo содержит информацию для безопасного скачивания файла
 type DownloadInfo struct {
+	ProductID   uint
+	UserID      uint // Кому выдана ссылка: скачивание по ней учитывается в лимите этого покупателя
 	FileName    string
 	ContentType string
 	FilePath    string
 	ExpireTime  time.Time
 }
 
-var activeDownloads = make(map[string]DownloadInfo)
+// activeDownloads - выданные токены скачивания. Токены создаются и в обработчиках запросов,
+// и в фоновых задачах отправки писем, поэтому доступ идет под activeDownloadsMu.
+var (
+	activeDownloadsMu sync.Mutex
+	activeDownloads   = make(map[string]DownloadInfo)
+)
 
-// GenerateDownloadToken создает временный токен для скачивания файла
-func (fs *FileService) GenerateDownloadToken(productID uint) (string, error) {
+// GenerateDownloadToken создает временный токен для скачивания файла товара пользователем userID
+func (fs *FileService) GenerateDownloadToken(productID, userID uint) (string, error) {
 	// Получаем информацию о продукте
-	var product models.Product
-	if err := database.DB.First(&product, productID).Error; err != nil {
+	product, err := fs.products.FindByID(productID)
+	if err != nil {
 		return "", errors.New("продукт не найден")
 	}
 
@@ -60,18 +69,25 @@ func (fs *FileService) GenerateDownloadToken(productID uint) (string, error) {
 
 	// Сохраняем информацию о скачивании
 	downloadInfo := DownloadInfo{
+		ProductID:   product.ID,
+		UserID:      userID,
 		FileName:    filepath.Base(product.FilePath),
 		ContentType: fs.GuessContentType(filePath),
 		FilePath:    fullPath,
 		ExpireTime:  time.Now().Add(24 * time.Hour), // Токен действителен 24 часа
 	}
 
+	activeDownloadsMu.Lock()
 	activeDownloads[token] = downloadInfo
+	activeDownloadsMu.Unlock()
 	return token, nil
 }
 
 // HasValidToken проверяет действительность токена скачивания
 func (fs *FileService) HasValidToken(token string) bool {
+	activeDownloadsMu.Lock()
+	defer activeDownloadsMu.Unlock()
+
 	info, exists := activeDownloads[token]
 	if !exists {
 		return false
@@ -88,6 +104,9 @@ func (fs *FileService) HasValidToken(token string) bool {
 
 // GetDownloadInfo возвращает информацию о скачивании по токену
 func (fs *FileService) GetDownloadInfo(token string) (DownloadInfo, error) {
+	activeDownloadsMu.Lock()
+	defer activeDownloadsMu.Unlock()
+
 	info, exists := activeDownloads[token]
 	if !exists {
 		return DownloadInfo{}, errors.New("недействительный токен скачивания")
@@ -109,14 +128,45 @@ func (fs *FileService) GenerateDownloadURL(token string, baseURL string) string
 
 // DeleteToken удаляет токен после использования
 func (fs *FileService) DeleteToken(token string) {
+	activeDownloadsMu.Lock()
 	delete(activeDownloads, token)
+	activeDownloadsMu.Unlock()
+}
+
+// SweepExpiredDownloadTokens удаляет просроченные токены скачивания, по которым так и не скачали файл
+func SweepExpiredDownloadTokens(now time.Time) int {
+	activeDownloadsMu.Lock()
+	defer activeDownloadsMu.Unlock()
+
+	removed := 0
+	for token, info := range activeDownloads {
+		if now.After(info.ExpireTime) {
+			delete(activeDownloads, token)
+			removed++
+		}
+	}
+	return removed
+}
+
+// ActiveDownloadTokens возвращает число действующих (не просроченных) токенов скачивания
+func ActiveDownloadTokens(now time.Time) int {
+	activeDownloadsMu.Lock()
+	defer activeDownloadsMu.Unlock()
+
+	active := 0
+	for _, info := range activeDownloads {
+		if !now.After(info.ExpireTime) {
+			active++
+		}
+	}
+	return active
 }
 
 // GetProductFileInfo возвращает путь и имя файла для указанного продукта
 func (fs *FileService) GetProductFileInfo(productID uint) (filePath string, fileName string, err error) {
 	// Получаем информацию о продукте
-	var product models.Product
-	if err := database.DB.First(&product, productID).Error; err != nil {
+	product, err := fs.products.FindByID(productID)
+	if err != nil {
 		return "", "", errors.New("продукт не найден")
 	}
 
@@ -2,52 +2,124 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/metrics"
 	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/tracing"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
+	"mime"
 	"mime/multipart"
+	"net"
 	"net/smtp"
-	"os"
+	"strconv"
 	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
-func SendProductToEmail(to string, product models.Product) error {
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
-	fromEmail := os.Getenv("SMTP_FROM_EMAIL")
-	// baseURL := os.Getenv("BASE_URL") // Больше не нужен для ссылки
+// smtpConfig - настройки SMTP, заданные при запуске через ConfigureMail
+var smtpConfig config.SMTPConfig
 
-	if smtpHost == "" || smtpPort == "" {
-		fmt.Println("SMTP_HOST или SMTP_PORT не установлены. Пропускаем отправку email.")
-		return nil
+// ConfigureMail задает настройки SMTP для всех писем приложения
+func ConfigureMail(cfg config.SMTPConfig) {
+	smtpConfig = cfg
+}
+
+// MailConfig возвращает настройки SMTP, заданные через ConfigureMail
+func MailConfig() config.SMTPConfig {
+	return smtpConfig
+}
+
+// smtpSettings - параметры подключения к SMTP-серверу
+type smtpSettings struct {
+	Host, Port, User, Pass, From string
+}
+
+// loadSMTPSettings возвращает настройки SMTP. ok == false, если отправка почты не настроена:
+// в этом случае письма пропускаются без ошибки.
+func loadSMTPSettings(ctx context.Context) (settings smtpSettings, ok bool) {
+	settings = smtpSettings{
+		Host: smtpConfig.Host,
+		Port: strconv.Itoa(smtpConfig.Port),
+		User: smtpConfig.User,
+		Pass: smtpConfig.Password.Value(),
+		From: smtpConfig.From,
+	}
+
+	if !smtpConfig.Enabled() {
+		logging.FromContext(ctx).Debug("SMTP не настроен, письмо не отправлено")
+		return settings, false
 	}
 
 	// Для Mailhog не требуются учетные данные
-	if smtpHost != "mailhog" && (smtpUser == "" || smtpPass == "") {
-		fmt.Println("SMTP_USER или SMTP_PASS не установлены. Пропускаем отправку email.")
-		return nil
+	if settings.Host != "mailhog" && (settings.User == "" || settings.Pass == "") {
+		logging.FromContext(ctx).Warn("SMTP_USER или SMTP_PASS не установлены, письмо не отправлено")
+		return settings, false
 	}
 
-	if fromEmail == "" {
-		fromEmail = "marketplace@example.com"
-		if smtpUser != "" {
-			fromEmail = smtpUser
+	if settings.From == "" {
+		settings.From = "marketplace@example.com"
+		if settings.User != "" {
+			settings.From = settings.User
 		}
 	}
+	return settings, true
+}
+
+// SendTextEmail отправляет простое текстовое письмо (UTF-8) без вложений
+func SendTextEmail(ctx context.Context, to, subject, body string) error {
+	settings, ok := loadSMTPSettings(ctx)
+	if !ok {
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(fmt.Sprintf("From: %s\r\n", settings.From))
+	buf.WriteString(fmt.Sprintf("To: %s\r\n", to))
+	buf.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject)))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	// base64 с переносом строк по 76 символов, как требует RFC 2045
+	encoded := base64.StdEncoding.EncodeToString([]byte(body))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+
+	if err := deliverMail(ctx, "notification", settings, to, buf.Bytes()); err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("Письмо отправлено", slog.String("subject", subject), logging.Email(to),
+		slog.String("smtp_host", settings.Host))
+	return nil
+}
+
+func SendProductToEmail(ctx context.Context, fileService *FileService, to string, product models.Product) error {
+	settings, ok := loadSMTPSettings(ctx)
+	if !ok {
+		return nil
+	}
 
 	// Получаем путь к файлу продукта
-	fileService := NewFileService()
 	productFilePath, productFileName, err := fileService.GetProductFileInfo(product.ID)
 	if err != nil {
 		return fmt.Errorf("ошибка получения информации о файле продукта: %v", err)
 	}
 
 	// Читаем файл
+	_, readSpan := tracing.Start(ctx, "storage.read_file", attribute.Int("product.id", int(product.ID)))
 	fileBytes, err := ioutil.ReadFile(productFilePath)
+	tracing.End(readSpan, err)
 	if err != nil {
 		return fmt.Errorf("ошибка чтения файла продукта '%s': %v", productFilePath, err)
 	}
@@ -70,7 +142,7 @@ func SendProductToEmail(to string, product models.Product) error {
 
 	// Заголовки письма
 	headers := make(map[string]string)
-	headers["From"] = fromEmail
+	headers["From"] = settings.From
 	headers["To"] = to
 	headers["Subject"] = fmt.Sprintf("Ваш цифровой товар: %s", product.Title)
 	headers["MIME-Version"] = "1.0"
@@ -113,67 +185,109 @@ func SendProductToEmail(to string, product models.Product) error {
 	// Отправляем сообщение
 	msgBytes := buf.Bytes()
 
-	// Проверяем, используем ли MailHog (без TLS) или реальный SMTP-сервер (с TLS)
-	if strings.ToLower(smtpHost) == "mailhog" {
-		// Для MailHog используем простое SMTP без TLS и аутентификации
-		err = smtp.SendMail(
-			smtpHost+":"+smtpPort,
-			nil, // для mailhog аутентификация не нужна
-			fromEmail,
-			[]string{to},
-			msgBytes,
-		)
-		if err != nil {
-			return fmt.Errorf("Ошибка отправки через MailHog: %v", err)
-		}
-		fmt.Printf("Письмо с вложением %s отправлено на %s через MailHog\n", productFileName, to)
-		return nil
+	if err := deliverMail(ctx, "product", settings, to, msgBytes); err != nil {
+		return err
 	}
+	logging.FromContext(ctx).Info("Письмо с товаром отправлено", logging.ProductID(product.ID),
+		slog.String("file", productFileName), logging.Email(to), slog.String("smtp_host", settings.Host))
+	return nil
+}
 
-	// Для реальных SMTP-серверов используем TLS
-	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: true, // В реальном приложении должно быть false и настроен CA
-		ServerName:         smtpHost,
-	}
+// deliverMail отправляет готовое письмо вида kind (order, product, notification)
+// и учитывает его в трассе (спан smtp.send) и в метриках писем
+func deliverMail(ctx context.Context, kind string, settings smtpSettings, to string, msgBytes []byte) error {
+	_, span := tracing.Start(ctx, "smtp.send",
+		attribute.String("mail.kind", kind),
+		attribute.Int("mail.size", len(msgBytes)),
+		semconv.ServerAddress(settings.Host),
+	)
+	err := sendSMTP(ctx, settings, to, msgBytes)
+	tracing.End(span, err)
+	metrics.ObserveMail(kind, err)
+	return err
+}
 
-	conn, err := tls.Dial("tcp", smtpHost+":"+smtpPort, tlsconfig)
+// sendSMTP отправляет сообщение: MailHog - без TLS и аутентификации,
+// реальные SMTP-серверы - через TLS. Отмена ctx закрывает соединение,
+// поэтому зависший сервер не задерживает остановку приложения.
+func sendSMTP(ctx context.Context, settings smtpSettings, to string, msgBytes []byte) error {
+	smtpHost, smtpPort := settings.Host, settings.Port
+	fromEmail := settings.From
+	addr := net.JoinHostPort(smtpHost, smtpPort)
+
+	// Проверяем, используем ли MailHog (без TLS) или реальный SMTP-сервер (с TLS)
+	mailhog := strings.ToLower(smtpHost) == "mailhog"
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if mailhog {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		tlsDialer := &tls.Dialer{
+			NetDialer: dialer,
+			Config: &tls.Config{
+				InsecureSkipVerify: true, // В реальном приложении должно быть false и настроен CA
+				ServerName:         smtpHost,
+			},
+		}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	}
 	if err != nil {
-		return fmt.Errorf("ошибка TLS подключения: %v", err)
+		return smtpError(ctx, "ошибка подключения к SMTP-серверу", err)
 	}
 	defer conn.Close()
 
+	// Команды SMTP не принимают ctx: при отмене закрываем соединение,
+	// и ожидающая команда сразу завершается ошибкой
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
 	client, err := smtp.NewClient(conn, smtpHost)
 	if err != nil {
-		return fmt.Errorf("ошибка создания SMTP клиента: %v", err)
+		return smtpError(ctx, "ошибка создания SMTP клиента", err)
 	}
 	defer client.Close()
 
-	if err = client.Auth(auth); err != nil {
-		return fmt.Errorf("ошибка аутентификации: %v", err)
+	// Для MailHog аутентификация не нужна
+	if !mailhog {
+		auth := smtp.PlainAuth("", settings.User, settings.Pass, smtpHost)
+		if err = client.Auth(auth); err != nil {
+			return smtpError(ctx, "ошибка аутентификации", err)
+		}
 	}
 
 	if err = client.Mail(fromEmail); err != nil {
-		return fmt.Errorf("ошибка команды MAIL FROM: %v", err)
+		return smtpError(ctx, "ошибка команды MAIL FROM", err)
 	}
 	if err = client.Rcpt(to); err != nil {
-		return fmt.Errorf("ошибка команды RCPT TO: %v", err)
+		return smtpError(ctx, "ошибка команды RCPT TO", err)
 	}
 
 	w, err := client.Data()
 	if err != nil {
-		return fmt.Errorf("ошибка команды DATA: %v", err)
+		return smtpError(ctx, "ошибка команды DATA", err)
 	}
 
 	_, err = w.Write(msgBytes)
 	if err != nil {
-		return fmt.Errorf("ошибка записи тела письма: %v", err)
+		return smtpError(ctx, "ошибка записи тела письма", err)
 	}
 	err = w.Close()
 	if err != nil {
-		return fmt.Errorf("ошибка закрытия DATA writer: %v", err)
+		return smtpError(ctx, "ошибка закрытия DATA writer", err)
 	}
 
-	fmt.Printf("Письмо с вложением %s отправлено на %s через %s\n", productFileName, to, smtpHost)
-	return client.Quit()
+	if err = client.Quit(); err != nil {
+		return smtpError(ctx, "ошибка команды QUIT", err)
+	}
+	return nil
+}
+
+// smtpError описывает ошибку шага отправки. Если ctx отменен, ошибка соединения -
+// лишь следствие отмены, и возвращается ошибка ctx.
+func smtpError(ctx context.Context, step string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("отправка письма прервана: %w", ctxErr)
+	}
+	return fmt.Errorf("%s: %v", step, err)
 }
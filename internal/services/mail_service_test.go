@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSendSMTPStopsOnCancel(t *testing.T) {
+	// Сервер принимает TLS-соединение, но не присылает приветствие SMTP
+	certs := httptest.NewTLSServer(nil)
+	config := &tls.Config{Certificates: certs.TLS.Certificates}
+	certs.Close()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+		// Ждем, пока клиент закроет соединение
+		_, _ = conn.Read(make([]byte, 1))
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	settings := smtpSettings{Host: host, Port: port, User: "user", Pass: "pass", From: "marketplace@example.com"}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sendSMTP(ctx, settings, "buyer@example.com", []byte("Subject: тест\r\n\r\nтест"))
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("ошибка %v, ожидалась context.DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sendSMTP не завершилась после отмены ctx")
+	}
+}
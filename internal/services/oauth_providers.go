@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+// --- GitHub ---
+
+// GithubProvider implements OAuthProvider for GitHub (plain OAuth 2.0 + REST API for the profile)
+type GithubProvider struct {
+	config *oauth2.Config
+	apiURL string
+}
+
+// NewGithubProvider creates the GitHub provider
+func NewGithubProvider(clientID, clientSecret, redirectURL string) *GithubProvider {
+	return &GithubProvider{
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"user:email"},
+			Endpoint:     github.Endpoint,
+		},
+		apiURL: "https://api.github.com",
+	}
+}
+
+func (p *GithubProvider) Name() string        { return "github" }
+func (p *GithubProvider) DisplayName() string { return "GitHub" }
+
+func (p *GithubProvider) AuthCodeURL(state, verifier string) string {
+	return p.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+}
+
+// GithubUser represents GitHub user information
+type GithubUser struct {
+	ID        int    `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+func (p *GithubProvider) Exchange(ctx context.Context, code, verifier string) (*OAuthProfile, error) {
+	// Exchange code for token
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("GitHub code exchange failed: %v", err)
+	}
+
+	// Get user info
+	client := p.config.Client(ctx, token)
+	var githubUser GithubUser
+	if err := getJSON(client, p.apiURL+"/user", &githubUser); err != nil {
+		return nil, fmt.Errorf("Failed to get user info from GitHub: %v", err)
+	}
+
+	// The public profile email may be unverified, so always take the primary verified one
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	email, verified := "", false
+	if err := getJSON(client, p.apiURL+"/user/emails", &emails); err == nil {
+		for _, e := range emails {
+			if e.Primary && e.Verified {
+				email, verified = e.Email, true
+				break
+			}
+		}
+	}
+	if email == "" {
+		email = githubUser.Email
+	}
+
+	// Use login as username if name is empty
+	username := githubUser.Name
+	if username == "" {
+		username = githubUser.Login
+	}
+
+	return &OAuthProfile{
+		Provider:      p.Name(),
+		Subject:       strconv.Itoa(githubUser.ID),
+		Email:         email,
+		EmailVerified: verified,
+		Username:      username,
+	}, nil
+}
+
+// --- Generic OpenID Connect (also used for Google and GitLab) ---
+
+// OIDCProviderConfig configures an OpenID Connect provider discovered from its issuer URL
+type OIDCProviderConfig struct {
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // defaults to openid, email, profile
+	HTTPClient   *http.Client
+}
+
+// OIDCProvider implements OAuthProvider for any OpenID Connect issuer with PKCE.
+// Endpoints are read from /.well-known/openid-configuration on first use.
+type OIDCProvider struct {
+	cfg OIDCProviderConfig
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// NewOIDCProvider creates an OpenID Connect provider
+func NewOIDCProvider(cfg OIDCProviderConfig) *OIDCProvider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &OIDCProvider{cfg: cfg}
+}
+
+func (p *OIDCProvider) Name() string        { return p.cfg.Name }
+func (p *OIDCProvider) DisplayName() string { return p.cfg.DisplayName }
+
+// discover loads and caches the provider metadata
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var doc oidcDiscovery
+	if err := doJSON(p.cfg.HTTPClient, req, &doc); err != nil {
+		return nil, fmt.Errorf("OIDC discovery for %s failed: %v", p.cfg.Issuer, err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("OIDC issuer mismatch: expected %s, got %s", p.cfg.Issuer, doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("OIDC discovery for %s is missing required endpoints", p.cfg.Issuer)
+	}
+
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+func (p *OIDCProvider) oauthConfig(doc *oidcDiscovery) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Scopes:       p.cfg.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  doc.AuthorizationEndpoint,
+			TokenURL: doc.TokenEndpoint,
+		},
+	}
+}
+
+func (p *OIDCProvider) AuthCodeURL(state, verifier string) string {
+	doc, err := p.discover(context.Background())
+	if err != nil {
+		// Without discovery there is nowhere to redirect; the callback will report the error
+		return "/login"
+	}
+	return p.oauthConfig(doc).AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier string) (*OAuthProfile, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.cfg.HTTPClient)
+	config := p.oauthConfig(doc)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("%s code exchange failed: %v", p.cfg.DisplayName, err)
+	}
+
+	// The userinfo endpoint is authenticated by the access token we just received over TLS
+	var claims struct {
+		Subject           string `json:"sub"`
+		Email             string `json:"email"`
+		EmailVerified     *bool  `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+		Nickname          string `json:"nickname"`
+		Name              string `json:"name"`
+	}
+	if err := getJSON(config.Client(ctx, token), doc.UserinfoEndpoint, &claims); err != nil {
+		return nil, fmt.Errorf("Failed to get user info from %s: %v", p.cfg.DisplayName, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%s returned no subject", p.cfg.DisplayName)
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Nickname
+	}
+	if username == "" {
+		username = claims.Name
+	}
+
+	return &OAuthProfile{
+		Provider:      p.Name(),
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified != nil && *claims.EmailVerified,
+		Username:      username,
+	}, nil
+}
+
+// --- HTTP helpers ---
+
+func getJSON(client *http.Client, url string, target interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return doJSON(client, req, target)
+}
+
+func doJSON(client *http.Client, req *http.Request, target interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL)
+	}
+	return json.Unmarshal(body, target)
+}
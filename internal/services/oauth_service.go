@@ -3,41 +3,118 @@ package services
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"digital-marketplace/internal/metrics"
 	"encoding/base64"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
-	"os"
+	"sort"
+	"strings"
 	"time"
 
-	"digital-marketplace/internal/database"
+	"digital-marketplace/internal/config"
 	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
 
 	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/github"
-	"gorm.io/gorm"
 )
 
-// OAuthService manages OAuth authentication
+// OAuthProfile is the normalized user information returned by any OAuth provider
+type OAuthProfile struct {
+	Provider      string
+	Subject       string // Stable user ID at the provider
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+// OAuthProvider is implemented by every supported login provider (GitHub, Google, GitLab, OIDC)
+type OAuthProvider interface {
+	// Name is the provider key used in /auth/:provider routes
+	Name() string
+	// DisplayName is shown on the login button
+	DisplayName() string
+	// AuthCodeURL returns the authorization URL with state and PKCE challenge for verifier
+	AuthCodeURL(state, verifier string) string
+	// Exchange trades the authorization code for a token and fetches the user profile
+	Exchange(ctx context.Context, code, verifier string) (*OAuthProfile, error)
+}
+
+// OAuthService manages OAuth authentication through a registry of providers.
+// Users and their linked identities are stored through repositories.
 type OAuthService struct {
-	githubConfig *oauth2.Config
+	providers  map[string]OAuthProvider
+	users      repository.UserRepository
+	identities repository.IdentityRepository
+	send       func(ctx context.Context, to, subject, body string) error
 }
 
-// NewOAuthService creates a new OAuth service with Github and Google configs
-func NewOAuthService() *OAuthService {
-	// GitHub OAuth config
-	githubConfig := &oauth2.Config{
-		ClientID:     os.Getenv("GITHUB_CLIENT_ID"),
-		ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
-		RedirectURL:  os.Getenv("OAUTH_REDIRECT_BASE") + "/auth/github/callback",
-		Scopes:       []string{"user:email"},
-		Endpoint:     github.Endpoint,
+// NewOAuthService creates a new OAuth service with all providers enabled in the configuration
+func NewOAuthService(users repository.UserRepository, identities repository.IdentityRepository, cfg config.OAuthConfig) *OAuthService {
+	s := &OAuthService{providers: make(map[string]OAuthProvider), users: users, identities: identities, send: SendTextEmail}
+
+	redirectBase := cfg.RedirectBase
+
+	if cfg.GitHub.ClientID != "" {
+		s.Register(NewGithubProvider(cfg.GitHub.ClientID, cfg.GitHub.ClientSecret.Value(), redirectBase+"/auth/github/callback"))
+	}
+
+	if cfg.Google.ClientID != "" {
+		s.Register(NewOIDCProvider(OIDCProviderConfig{
+			Name:         "google",
+			DisplayName:  "Google",
+			Issuer:       "https://accounts.google.com",
+			ClientID:     cfg.Google.ClientID,
+			ClientSecret: cfg.Google.ClientSecret.Value(),
+			RedirectURL:  redirectBase + "/auth/google/callback",
+		}))
 	}
 
-	return &OAuthService{
-		githubConfig: githubConfig,
+	if cfg.GitLab.ClientID != "" {
+		s.Register(NewOIDCProvider(OIDCProviderConfig{
+			Name:         "gitlab",
+			DisplayName:  "GitLab",
+			Issuer:       cfg.GitLab.BaseURL,
+			ClientID:     cfg.GitLab.ClientID,
+			ClientSecret: cfg.GitLab.ClientSecret.Value(),
+			RedirectURL:  redirectBase + "/auth/gitlab/callback",
+		}))
+	}
+
+	if cfg.OIDC.Issuer != "" {
+		s.Register(NewOIDCProvider(OIDCProviderConfig{
+			Name:         "oidc",
+			DisplayName:  cfg.OIDC.DisplayName,
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret.Value(),
+			RedirectURL:  redirectBase + "/auth/oidc/callback",
+		}))
+	}
+
+	return s
+}
+
+// Register adds a provider to the registry (replacing one with the same name)
+func (s *OAuthService) Register(provider OAuthProvider) {
+	s.providers[provider.Name()] = provider
+}
+
+// Provider returns the provider registered under name
+func (s *OAuthService) Provider(name string) (OAuthProvider, bool) {
+	provider, ok := s.providers[strings.ToLower(name)]
+	return provider, ok
+}
+
+// Providers returns all registered providers sorted by name (for rendering login buttons)
+func (s *OAuthService) Providers() []OAuthProvider {
+	providers := make([]OAuthProvider, 0, len(s.providers))
+	for _, provider := range s.providers {
+		providers = append(providers, provider)
 	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name() < providers[j].Name() })
+	return providers
 }
 
 // GenerateState generates a random state string for OAuth
@@ -47,127 +124,372 @@ func (s *OAuthService) GenerateState() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return base64.StdEncoding.EncodeToString(b), nil
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-// GetGithubAuthURL returns the GitHub authorization URL
-func (s *OAuthService) GetGithubAuthURL(state string) string {
-	return s.githubConfig.AuthCodeURL(state)
+// Errors returned by the identity linking flow
+var (
+	ErrEmailNotVerified      = errors.New("provider did not confirm the email address")
+	ErrIdentityOwnedByOther  = errors.New("this external account is already linked to another user")
+	ErrPendingLinkNotFound   = errors.New("link confirmation expired or not found")
+	ErrLinkConfirmationWrong = errors.New("invalid password for link confirmation")
+	ErrLinkIdentityMismatch  = errors.New("the external account is not linked to the account being confirmed")
+	ErrLinkEmailAlreadySent  = errors.New("link confirmation email was already sent")
+	ErrLastLoginMethod       = errors.New("cannot unlink the only way to sign in")
+)
+
+// OAuthLoginResult is the outcome of an OAuth callback: either the user is signed in,
+// or the login matched an existing account by email and needs explicit confirmation.
+type OAuthLoginResult struct {
+	User         *models.User
+	PendingToken string
+	PendingLink  *models.PendingIdentityLink
+	Linked       bool // The identity was linked to the current user during this callback
+	Created      bool // A new account was created for the identity
 }
 
-// GithubUser represents GitHub user information
-type GithubUser struct {
-	ID        int    `json:"id"`
-	Login     string `json:"login"`
-	Name      string `json:"name"`
-	Email     string `json:"email"`
-	AvatarURL string `json:"avatar_url"`
+// Pending link lifetimes: the confirmation page is short-lived, a link sent by email lasts longer
+const (
+	pendingLinkTTL      = 10 * time.Minute
+	pendingLinkEmailTTL = time.Hour
+)
+
+// HandleCallback exchanges the code with the given provider and resolves the local user.
+// If currentUser is set, the identity is linked to that user instead of signing in.
+func (s *OAuthService) HandleCallback(ctx context.Context, providerName, code, verifier string, currentUser *models.User) (*OAuthLoginResult, error) {
+	provider, ok := s.Provider(providerName)
+	if !ok {
+		return nil, fmt.Errorf("Unknown OAuth provider: %s", providerName)
+	}
+
+	profile, err := s.exchange(ctx, provider, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. The identity is already linked: sign in as its owner (or report a conflict when linking)
+	identity, err := s.identities.FindBySubject(profile.Provider, profile.Subject)
+	if err == nil {
+		if currentUser != nil && currentUser.ID != identity.UserID {
+			return nil, ErrIdentityOwnedByOther
+		}
+		user, err := s.users.FindByID(identity.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("Database error: %v", err)
+		}
+		return &OAuthLoginResult{User: user}, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("Database error: %v", err)
+	}
+
+	// 2. Explicit linking from the profile page
+	if currentUser != nil {
+		if err := s.linkIdentity(currentUser.ID, profile); err != nil {
+			return nil, err
+		}
+		return &OAuthLoginResult{User: currentUser, Linked: true}, nil
+	}
+
+	if profile.Email == "" {
+		return nil, fmt.Errorf("Email not provided by %s", profile.Provider)
+	}
+	if !profile.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	// 3. An account with the same email exists: never sign in automatically, ask the owner to confirm
+	existing, err := s.users.FindByEmail(profile.Email)
+	if err == nil {
+		token, pending, err := s.createPendingLink(*existing, profile)
+		if err != nil {
+			return nil, err
+		}
+		return &OAuthLoginResult{PendingToken: token, PendingLink: pending}, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("Database error: %v", err)
+	}
+
+	// 4. New user
+	user, err := s.createUserWithIdentity(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+	return &OAuthLoginResult{User: user, Created: true}, nil
 }
 
-// HandleGithubCallback processes GitHub OAuth callback
-func (s *OAuthService) HandleGithubCallback(code string) (*models.User, error) {
-	// Exchange code for token
-	token, err := s.githubConfig.Exchange(context.Background(), code)
+// exchange trades the code for the provider profile
+func (s *OAuthService) exchange(ctx context.Context, provider OAuthProvider, code, verifier string) (*OAuthProfile, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	profile, err := provider.Exchange(ctx, code, verifier)
 	if err != nil {
-		return nil, fmt.Errorf("GitHub code exchange failed: %v", err)
+		return nil, err
 	}
+	if profile.Subject == "" {
+		return nil, fmt.Errorf("Subject not provided by %s", provider.Name())
+	}
+	return profile, nil
+}
 
-	// Get user info
-	client := s.githubConfig.Client(context.Background(), token)
-	resp, err := client.Get("https://api.github.com/user")
+// PendingLink returns a pending link confirmation by the token from the confirmation cookie
+func (s *OAuthService) PendingLink(token string) (*models.PendingIdentityLink, bool) {
+	if token == "" {
+		return nil, false
+	}
+	pending, err := s.identities.FindPending(hashPendingLinkToken(token), time.Now())
 	if err != nil {
-		return nil, fmt.Errorf("Failed to get user info from GitHub: %v", err)
+		return nil, false
 	}
-	defer resp.Body.Close()
+	return pending, true
+}
 
-	// Parse user data
-	var githubUser GithubUser
-	body, err := io.ReadAll(resp.Body)
+// PendingLinkByEmailToken returns a pending link confirmation by the token from the confirmation email
+func (s *OAuthService) PendingLinkByEmailToken(token string) (*models.PendingIdentityLink, bool) {
+	if token == "" {
+		return nil, false
+	}
+	pending, err := s.identities.FindPendingByEmailToken(hashPendingLinkToken(token), time.Now())
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read GitHub response: %v", err)
-	}
-	if err := json.Unmarshal(body, &githubUser); err != nil {
-		return nil, fmt.Errorf("Failed to parse GitHub user data: %v", err)
-	}
-
-	// If email is not provided, get it from emails endpoint
-	if githubUser.Email == "" {
-		emailsResp, err := client.Get("https://api.github.com/user/emails")
-		if err == nil {
-			defer emailsResp.Body.Close()
-			var emails []struct {
-				Email    string `json:"email"`
-				Primary  bool   `json:"primary"`
-				Verified bool   `json:"verified"`
-			}
-
-			emailsBody, _ := io.ReadAll(emailsResp.Body)
-			json.Unmarshal(emailsBody, &emails)
-
-			// Find primary email
-			for _, email := range emails {
-				if email.Primary && email.Verified {
-					githubUser.Email = email.Email
-					break
-				}
-			}
-		}
+		return nil, false
 	}
+	return pending, true
+}
 
-	// Use login as username if name is empty
-	username := githubUser.Name
-	if username == "" {
-		username = githubUser.Login
+// ConfirmPendingLink links the pending identity after the account owner re-entered their password
+func (s *OAuthService) ConfirmPendingLink(ctx context.Context, token, password string) (*models.User, error) {
+	pending, ok := s.PendingLink(token)
+	if !ok {
+		return nil, ErrPendingLinkNotFound
 	}
 
-	return s.findOrCreateUser(githubUser.Email, username, "github")
+	user, err := s.users.FindByID(pending.UserID)
+	if err != nil {
+		return nil, ErrPendingLinkNotFound
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return nil, ErrLinkConfirmationWrong
+	}
+	return s.confirmPending(ctx, pending)
 }
 
-// findOrCreateUser finds existing user or creates a new one
-func (s *OAuthService) findOrCreateUser(email, username, provider string) (*models.User, error) {
-	if email == "" {
-		return nil, fmt.Errorf("Email not provided by %s", provider)
+// ConfirmPendingLinkWithProvider links the pending identity after the account owner signed in
+// with an external account that is already linked to the same user. Nothing is created
+// or linked when the external account belongs to someone else or to nobody.
+func (s *OAuthService) ConfirmPendingLinkWithProvider(ctx context.Context, token, providerName, code, verifier string) (*models.PendingIdentityLink, *models.User, error) {
+	pending, ok := s.PendingLink(token)
+	if !ok {
+		return nil, nil, ErrPendingLinkNotFound
+	}
+	provider, ok := s.Provider(providerName)
+	if !ok {
+		return nil, nil, fmt.Errorf("Unknown OAuth provider: %s", providerName)
 	}
 
-	var user models.User
-	result := database.DB.Where("email = ?", email).First(&user)
+	profile, err := s.exchange(ctx, provider, code, verifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	identity, err := s.identities.FindBySubject(profile.Provider, profile.Subject)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && identity.UserID != pending.UserID) {
+		return pending, nil, ErrLinkIdentityMismatch
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Database error: %v", err)
+	}
+
+	user, err := s.confirmPending(ctx, pending)
+	return pending, user, err
+}
+
+// SendPendingLinkEmail emails the account owner a link that confirms the pending identity without
+// a password. The link is sent once per pending link and extends its lifetime to pendingLinkEmailTTL.
+func (s *OAuthService) SendPendingLinkEmail(ctx context.Context, token string) error {
+	pending, ok := s.PendingLink(token)
+	if !ok {
+		return ErrPendingLinkNotFound
+	}
+	if pending.EmailTokenHash != nil {
+		return ErrLinkEmailAlreadySent
+	}
+
+	emailToken, err := s.GenerateState()
+	if err != nil {
+		return err
+	}
+	if err := s.identities.SetPendingEmailToken(pending.ID, hashPendingLinkToken(emailToken), time.Now().Add(pendingLinkEmailTTL)); err != nil {
+		return err
+	}
+
+	providerName := pending.Provider
+	if provider, ok := s.Provider(pending.Provider); ok {
+		providerName = provider.DisplayName()
+	}
+	body := fmt.Sprintf(`Здравствуйте!
+
+Кто-то пытается войти в ваш аккаунт Digital Marketplace через %s.
+Если это вы, откройте ссылку, чтобы привязать этот способ входа к аккаунту:
+
+%s/auth-link/email?token=%s
+
+Ссылка действует %d мин. Если вы не входили через %s, просто проигнорируйте это письмо.
 
-	if result.Error != nil {
-		if result.Error == gorm.ErrRecordNotFound {
-			// Create a new user with a random password
-			randomBytes := make([]byte, 32)
-			_, err := rand.Read(randomBytes)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to generate random password: %v", err)
-			}
-			randomPassword := base64.StdEncoding.EncodeToString(randomBytes)
+С уважением,
+Команда Digital Marketplace`, providerName, BaseURL(), emailToken, int(pendingLinkEmailTTL.Minutes()), providerName)
+	return s.send(ctx, pending.Email, "Подтверждение привязки аккаунта "+providerName, body)
+}
 
-			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
-			if err != nil {
-				return nil, fmt.Errorf("Failed to hash password: %v", err)
-			}
+// ConfirmPendingLinkByEmail links the pending identity by the token from the confirmation email
+func (s *OAuthService) ConfirmPendingLinkByEmail(ctx context.Context, emailToken string) (*models.PendingIdentityLink, *models.User, error) {
+	pending, ok := s.PendingLinkByEmailToken(emailToken)
+	if !ok {
+		return nil, nil, ErrPendingLinkNotFound
+	}
+	user, err := s.confirmPending(ctx, pending)
+	return pending, user, err
+}
 
-			newUser := models.User{
-				Email:     email,
-				Username:  username,
-				Password:  string(hashedPassword),
-				CreatedAt: time.Now(),
-			}
+// CancelPendingLink discards a pending link confirmation
+func (s *OAuthService) CancelPendingLink(token string) error {
+	pending, ok := s.PendingLink(token)
+	if !ok {
+		return nil
+	}
+	return s.identities.DeletePending(pending.ID)
+}
 
-			if err := database.DB.Create(&newUser).Error; err != nil {
-				return nil, fmt.Errorf("Failed to create user: %v", err)
-			}
+// DeleteExpiredPendingLinks removes pending link confirmations that have expired
+func (s *OAuthService) DeleteExpiredPendingLinks() (int64, error) {
+	return s.identities.DeleteExpiredPending(time.Now())
+}
 
-			return &newUser, nil
+// confirmPending consumes the pending link and links its identity to the account owner
+func (s *OAuthService) confirmPending(ctx context.Context, pending *models.PendingIdentityLink) (*models.User, error) {
+	user, err := s.users.FindByID(pending.UserID)
+	if err != nil {
+		return nil, ErrPendingLinkNotFound
+	}
+
+	identity := models.UserIdentity{
+		UserID:    user.ID,
+		Provider:  pending.Provider,
+		Subject:   pending.Subject,
+		Email:     pending.Email,
+		CreatedAt: time.Now(),
+	}
+	if err := s.identities.ConfirmPending(ctx, pending.ID, &identity); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, ErrPendingLinkNotFound
 		}
-		return nil, fmt.Errorf("Database error: %v", result.Error)
+		return nil, fmt.Errorf("Failed to link %s identity: %v", pending.Provider, err)
 	}
+	return user, nil
+}
 
-	// Update username if it's empty
-	if user.Username == "" && username != "" {
-		user.Username = username
-		database.DB.Save(&user)
+// Identities returns the external identities linked to the user
+func (s *OAuthService) Identities(userID uint) ([]models.UserIdentity, error) {
+	return s.identities.ListByUser(userID)
+}
+
+// UnlinkIdentity removes a linked identity and returns it. The last identity of an account without
+// a known password cannot be removed, otherwise the user would be locked out.
+func (s *OAuthService) UnlinkIdentity(user models.User, identityID uint) (*models.UserIdentity, error) {
+	identity, err := s.identities.FindForUser(user.ID, identityID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.GeneratedPassword {
+		count, err := s.identities.CountByUser(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if count <= 1 {
+			return nil, ErrLastLoginMethod
+		}
+	}
+
+	if err := s.identities.Delete(identity.ID); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// linkIdentity records the (provider, subject) pair for the user
+func (s *OAuthService) linkIdentity(userID uint, profile *OAuthProfile) error {
+	identity := models.UserIdentity{
+		UserID:    userID,
+		Provider:  profile.Provider,
+		Subject:   profile.Subject,
+		Email:     profile.Email,
+		CreatedAt: time.Now(),
+	}
+	if err := s.identities.Create(&identity); err != nil {
+		return fmt.Errorf("Failed to link %s identity: %v", profile.Provider, err)
+	}
+	return nil
+}
+
+// createPendingLink stores a pending link for the existing account; the returned token goes to a cookie
+func (s *OAuthService) createPendingLink(user models.User, profile *OAuthProfile) (string, *models.PendingIdentityLink, error) {
+	token, err := s.GenerateState()
+	if err != nil {
+		return "", nil, err
+	}
+
+	pending := models.PendingIdentityLink{
+		TokenHash: hashPendingLinkToken(token),
+		UserID:    user.ID,
+		Email:     user.Email,
+		Provider:  profile.Provider,
+		Subject:   profile.Subject,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(pendingLinkTTL),
+	}
+	if err := s.identities.CreatePending(&pending); err != nil {
+		return "", nil, fmt.Errorf("Failed to store pending link: %v", err)
+	}
+	return token, &pending, nil
+}
+
+func hashPendingLinkToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// createUserWithIdentity creates a new user with a random password and links the identity
+func (s *OAuthService) createUserWithIdentity(ctx context.Context, profile *OAuthProfile) (*models.User, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, fmt.Errorf("Failed to generate random password: %v", err)
+	}
+	randomPassword := base64.StdEncoding.EncodeToString(randomBytes)
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to hash password: %v", err)
+	}
+
+	newUser := models.User{
+		Email:             profile.Email,
+		Username:          profile.Username,
+		Password:          string(hashedPassword),
+		GeneratedPassword: true,
+		CreatedAt:         time.Now(),
+	}
+
+	err = s.identities.CreateWithUser(ctx, &newUser, &models.UserIdentity{
+		Provider:  profile.Provider,
+		Subject:   profile.Subject,
+		Email:     profile.Email,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create user: %v", err)
 	}
+	metrics.Registrations.WithLabelValues("oauth").Inc()
 
-	return &user, nil
+	return &newUser, nil
 }
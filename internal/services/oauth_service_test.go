@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/config"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository/memory"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestPendingLinkConfirmedByEmail(t *testing.T) {
+	repos := memory.New().Repositories()
+	owner := models.User{Email: "owner@example.com", Username: "owner", Password: "-"}
+	if err := repos.Users.Create(&owner); err != nil {
+		t.Fatal(err)
+	}
+	s := NewOAuthService(repos.Users, repos.Identities, config.OAuthConfig{})
+	var to, body string
+	s.send = func(_ context.Context, recipient, _, text string) error {
+		to, body = recipient, text
+		return nil
+	}
+
+	token, _, err := s.createPendingLink(owner, &OAuthProfile{Provider: "oidc", Subject: "sub-owner", Email: owner.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendPendingLinkEmail(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	if to != owner.Email {
+		t.Errorf("письмо отправлено на %q, ожидалось %q", to, owner.Email)
+	}
+	match := regexp.MustCompile(`/auth-link/email\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("в письме нет ссылки: %s", body)
+	}
+	emailToken := match[1]
+
+	// Письмо отправляется один раз, а запрос с ним живет дольше страницы подтверждения
+	if err := s.SendPendingLinkEmail(context.Background(), token); !errors.Is(err, ErrLinkEmailAlreadySent) {
+		t.Errorf("повторная отправка: ошибка %v, ожидалась ErrLinkEmailAlreadySent", err)
+	}
+	pending, ok := s.PendingLinkByEmailToken(emailToken)
+	if !ok || pending.ExpiresAt.Before(time.Now().Add(pendingLinkEmailTTL-time.Minute)) {
+		t.Fatalf("запрос по токену из письма: %+v", pending)
+	}
+	if _, ok := s.PendingLinkByEmailToken(token); ok {
+		t.Error("токен из cookie принят как токен из письма")
+	}
+
+	_, user, err := s.ConfirmPendingLinkByEmail(context.Background(), emailToken)
+	if err != nil || user.ID != owner.ID {
+		t.Fatalf("подтверждение: %+v, %v", user, err)
+	}
+	if identity, err := repos.Identities.FindBySubject("oidc", "sub-owner"); err != nil || identity.UserID != owner.ID {
+		t.Errorf("привязка: %+v, %v", identity, err)
+	}
+	if _, _, err := s.ConfirmPendingLinkByEmail(context.Background(), emailToken); !errors.Is(err, ErrPendingLinkNotFound) {
+		t.Errorf("повторное подтверждение: ошибка %v, ожидалась ErrPendingLinkNotFound", err)
+	}
+	if _, ok := s.PendingLink(token); ok {
+		t.Error("запрос остался доступен по cookie после подтверждения")
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/metrics"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"errors"
+	"log/slog"
+)
+
+// Ошибки оформления заказа
+var (
+	ErrCartEmpty         = errors.New("корзина пуста")
+	ErrInsufficientFunds = errors.New("недостаточно средств на балансе")
+	ErrProductNotFound   = errors.New("товар не найден")
+	ErrOwnProduct        = errors.New("нельзя купить свой собственный товар")
+	ErrAlreadyPurchased  = errors.New("товар уже приобретен")
+	ErrProductUnlisted   = errors.New("товар снят с продажи")
+)
+
+// OrderService оформляет заказы из корзины и покупки отдельных товаров.
+// Используется и HTML-контроллерами, и JSON API. Покупка, списание баланса и запись
+// в журнале аудита выполняются репозиторием в одной транзакции.
+type OrderService struct {
+	orders repository.OrderRepository
+	carts  repository.CartRepository
+}
+
+// NewOrderService создает новый экземпляр сервиса заказов
+func NewOrderService(orders repository.OrderRepository, carts repository.CartRepository) *OrderService {
+	return &OrderService{orders: orders, carts: carts}
+}
+
+// Checkout оформляет заказ из всех товаров корзины покупателя actor, списывает баланс и очищает корзину.
+// Заказ и запись в журнале аудита сохраняются в одной транзакции.
+func (s *OrderService) Checkout(ctx context.Context, actor AuditActor) (*models.Order, error) {
+	var totalPrice float64
+	order, err := s.carts.Checkout(ctx, actor.UserID, func(user *models.User, items []models.CartItem) (repository.OrderCharge, error) {
+		if len(items) == 0 {
+			return repository.OrderCharge{}, ErrCartEmpty
+		}
+
+		totalPrice = 0
+		productIDs := make([]uint, 0, len(items))
+		for _, item := range items {
+			if !item.Product.Listed() {
+				return repository.OrderCharge{}, ErrProductUnlisted
+			}
+			totalPrice += item.Product.Price
+			productIDs = append(productIDs, item.ProductID)
+		}
+		if user.Balance < totalPrice {
+			return repository.OrderCharge{}, ErrInsufficientFunds
+		}
+
+		event, err := newAuditEvent(actor, AuditEntry{
+			Action:     AuditOrderCheckout,
+			TargetType: AuditTargetOrder,
+			Details: map[string]interface{}{
+				"product_ids":    productIDs,
+				"total":          totalPrice,
+				"balance_before": user.Balance,
+				"balance_after":  user.Balance - totalPrice,
+			},
+		})
+		return repository.OrderCharge{Total: totalPrice, Event: event}, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).Info("Заказ оформлен из корзины", logging.OrderID(order.ID),
+		slog.Int("items", len(order.Items)), slog.Float64("total", totalPrice))
+	metrics.Orders.WithLabelValues("cart").Inc()
+	metrics.Revenue.Add(totalPrice)
+	return order, nil
+}
+
+// BuyProduct покупает один товар без корзины от имени покупателя actor
+func (s *OrderService) BuyProduct(ctx context.Context, actor AuditActor, productID uint) (*models.Order, error) {
+	var price float64
+	order, err := s.orders.Buy(ctx, actor.UserID, productID, func(user *models.User, product *models.Product, purchased bool) (repository.OrderCharge, error) {
+		switch {
+		case !product.Listed():
+			return repository.OrderCharge{}, ErrProductUnlisted
+		case product.UserID == user.ID:
+			return repository.OrderCharge{}, ErrOwnProduct
+		case purchased:
+			return repository.OrderCharge{}, ErrAlreadyPurchased
+		case user.Balance < product.Price:
+			return repository.OrderCharge{}, ErrInsufficientFunds
+		}
+
+		price = product.Price
+		event, err := newAuditEvent(actor, AuditEntry{
+			Action:     AuditOrderBuy,
+			TargetType: AuditTargetOrder,
+			Details: map[string]interface{}{
+				"product_id":     product.ID,
+				"total":          product.Price,
+				"balance_before": user.Balance,
+				"balance_after":  user.Balance - product.Price,
+			},
+		})
+		return repository.OrderCharge{Total: product.Price, Event: event}, err
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	logging.FromContext(ctx).Info("Товар куплен", logging.OrderID(order.ID), logging.ProductID(productID),
+		slog.Float64("total", price))
+	metrics.Orders.WithLabelValues("buy").Inc()
+	metrics.Revenue.Add(price)
+	return order, nil
+}
+
+// HasPurchased проверяет, покупал ли пользователь товар
+func (s *OrderService) HasPurchased(ctx context.Context, userID, productID uint) bool {
+	purchased, err := s.orders.HasPurchased(userID, productID)
+	if err != nil {
+		logging.FromContext(ctx).Error("Ошибка проверки покупки товара", logging.ProductID(productID), logging.Err(err))
+	}
+	return purchased
+}
+
+// ListOrders возвращает заказы пользователя вместе с товарами, новые первыми
+func (s *OrderService) ListOrders(userID uint) ([]models.Order, error) {
+	return s.orders.ListByUser(userID)
+}
+
+// GetOrder возвращает заказ пользователя по ID (repository.ErrNotFound, если его нет)
+func (s *OrderService) GetOrder(userID, orderID uint) (*models.Order, error) {
+	return s.orders.FindForUser(userID, orderID)
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/repository/memory"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestOrders создает хранилище с продавцом и покупателем, у которого на балансе balance
+func newTestOrders(t *testing.T, balance float64) (*memory.Store, repository.Repositories, *OrderService, models.User, models.User) {
+	t.Helper()
+	store := memory.New()
+	repos := store.Repositories()
+	seller := models.User{Email: "seller@example.com", Username: "seller", Role: models.RoleSeller}
+	buyer := models.User{Email: "buyer@example.com", Username: "buyer", Role: models.RoleUser, Balance: balance}
+	for _, u := range []*models.User{&seller, &buyer} {
+		if err := repos.Users.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, repos, NewOrderService(repos.Orders, repos.Carts), seller, buyer
+}
+
+func TestCheckout(t *testing.T) {
+	store, repos, s, seller, buyer := newTestOrders(t, 200)
+	template := models.Product{Title: "Шаблон", Price: 120, UserID: seller.ID}
+	font := models.Product{Title: "Шрифт", Price: 50, UserID: seller.ID}
+	store.AddProduct(&template)
+	store.AddProduct(&font)
+	actor := AuditActor{UserID: buyer.ID}
+	ctx := context.Background()
+
+	if _, err := s.Checkout(ctx, actor); !errors.Is(err, ErrCartEmpty) {
+		t.Fatalf("пустая корзина: ошибка %v, ожидалась ErrCartEmpty", err)
+	}
+
+	for _, product := range []models.Product{template, font} {
+		if err := repos.Carts.Add(&models.CartItem{UserID: buyer.ID, ProductID: product.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	order, err := s.Checkout(ctx, actor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Items) != 2 || order.UserID != buyer.ID {
+		t.Errorf("заказ: %+v", order)
+	}
+	if saved, _ := repos.Users.FindByID(buyer.ID); saved.Balance != 30 {
+		t.Errorf("баланс после покупки %v, ожидалось 30", saved.Balance)
+	}
+	if items, _ := repos.Carts.ListByUser(buyer.ID); len(items) != 0 {
+		t.Errorf("корзина не очищена: %+v", items)
+	}
+	for _, product := range []models.Product{template, font} {
+		if purchased, _ := repos.Orders.HasPurchased(buyer.ID, product.ID); !purchased {
+			t.Errorf("товар %q не куплен", product.Title)
+		}
+	}
+
+	events := store.AuditEvents()
+	if len(events) != 1 {
+		t.Fatalf("журнал: %+v", events)
+	}
+	event := events[0]
+	if event.Action != AuditOrderCheckout || event.TargetID == nil || *event.TargetID != order.ID {
+		t.Errorf("запись журнала: %+v", event)
+	}
+	var details map[string]interface{}
+	if err := json.Unmarshal([]byte(event.Details), &details); err != nil {
+		t.Fatal(err)
+	}
+	if details["total"] != 170.0 || details["balance_before"] != 200.0 || details["balance_after"] != 30.0 {
+		t.Errorf("подробности записи: %v", details)
+	}
+}
+
+func TestCheckoutRejectedLeavesCart(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		product models.Product
+		want    error
+	}{
+		{"не хватает баланса", models.Product{Title: "Курс", Price: 500}, ErrInsufficientFunds},
+		{"товар снят с продажи", models.Product{Title: "Снятый", Price: 10, UnlistedAt: &now}, ErrProductUnlisted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, repos, s, seller, buyer := newTestOrders(t, 100)
+			product := tt.product
+			product.UserID = seller.ID
+			store.AddProduct(&product)
+			if err := repos.Carts.Add(&models.CartItem{UserID: buyer.ID, ProductID: product.ID}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := s.Checkout(context.Background(), AuditActor{UserID: buyer.ID}); !errors.Is(err, tt.want) {
+				t.Fatalf("ошибка %v, ожидалась %v", err, tt.want)
+			}
+			if saved, _ := repos.Users.FindByID(buyer.ID); saved.Balance != 100 {
+				t.Errorf("баланс изменился: %v", saved.Balance)
+			}
+			if items, _ := repos.Carts.ListByUser(buyer.ID); len(items) != 1 {
+				t.Errorf("корзина изменилась: %+v", items)
+			}
+			if orders, _ := repos.Orders.ListByUser(buyer.ID); len(orders) != 0 {
+				t.Errorf("создан заказ: %+v", orders)
+			}
+			if events := store.AuditEvents(); len(events) != 0 {
+				t.Errorf("запись в журнале: %+v", events)
+			}
+		})
+	}
+}
+
+func TestBuyProduct(t *testing.T) {
+	store, repos, s, seller, buyer := newTestOrders(t, 100)
+	now := time.Now()
+	template := models.Product{Title: "Шаблон", Price: 60, UserID: seller.ID}
+	course := models.Product{Title: "Курс", Price: 150, UserID: seller.ID}
+	unlisted := models.Product{Title: "Снятый", Price: 10, UserID: seller.ID, UnlistedAt: &now}
+	for _, product := range []*models.Product{&template, &course, &unlisted} {
+		store.AddProduct(product)
+	}
+	ctx := context.Background()
+	actor := AuditActor{UserID: buyer.ID}
+
+	order, err := s.BuyProduct(ctx, actor, template.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Items) != 1 || order.Items[0].ProductID != template.ID {
+		t.Errorf("заказ: %+v", order)
+	}
+	if saved, _ := repos.Users.FindByID(buyer.ID); saved.Balance != 40 {
+		t.Errorf("баланс после покупки %v, ожидалось 40", saved.Balance)
+	}
+	events := store.AuditEvents()
+	if len(events) != 1 || events[0].Action != AuditOrderBuy || events[0].TargetID == nil || *events[0].TargetID != order.ID {
+		t.Fatalf("журнал: %+v", events)
+	}
+
+	tests := []struct {
+		name      string
+		actor     AuditActor
+		productID uint
+		want      error
+	}{
+		{"повторная покупка", actor, template.ID, ErrAlreadyPurchased},
+		{"не хватает баланса", actor, course.ID, ErrInsufficientFunds},
+		{"товар снят с продажи", actor, unlisted.ID, ErrProductUnlisted},
+		{"нет товара", actor, 999, ErrProductNotFound},
+		{"свой товар", AuditActor{UserID: seller.ID}, course.ID, ErrOwnProduct},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.BuyProduct(ctx, tt.actor, tt.productID); !errors.Is(err, tt.want) {
+				t.Errorf("ошибка %v, ожидалась %v", err, tt.want)
+			}
+		})
+	}
+	if saved, _ := repos.Users.FindByID(buyer.ID); saved.Balance != 40 {
+		t.Errorf("баланс после отказов %v, ожидалось 40", saved.Balance)
+	}
+	if orders, _ := repos.Orders.ListByUser(buyer.ID); len(orders) != 1 {
+		t.Errorf("заказов %d, ожидался один", len(orders))
+	}
+	if events := store.AuditEvents(); len(events) != 1 {
+		t.Errorf("записи журнала после отказов: %+v", events)
+	}
+}
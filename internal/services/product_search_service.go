@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html"
+	"html/template"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ограничения поискового запроса
+const (
+	MaxSearchQueryLen = 200 // символов во всей строке q
+	maxSearchTerms    = 8   // слов, которые попадают в tsquery
+	maxSearchTermLen  = 50  // символов в одном слове
+)
+
+// Маркеры совпадений во фрагменте описания (см. repository.SnippetStart)
+const (
+	highlightStart = repository.SnippetStart
+	highlightStop  = repository.SnippetStop
+)
+
+// ProductSort - порядок товаров в выдаче
+type ProductSort string
+
+const (
+	SortRelevance ProductSort = "relevance"  // По релевантности, только вместе с текстом поиска
+	SortNewest    ProductSort = "newest"     // Сначала новые
+	SortPriceAsc  ProductSort = "price_asc"  // Сначала дешевые
+	SortPriceDesc ProductSort = "price_desc" // Сначала дорогие
+	SortPopular   ProductSort = "popular"    // По числу продаж
+)
+
+// TagMode - как применяется фильтр по тегам
+type TagMode string
+
+const (
+	TagModeAll TagMode = "all" // Товар должен иметь все теги
+	TagModeAny TagMode = "any" // Достаточно одного из тегов
+)
+
+// Размер страницы выдачи
+const (
+	DefaultProductPageSize = 20
+	MaxProductPageSize     = 100
+)
+
+// ErrInvalidCursor - курсор поврежден или получен для другого порядка сортировки
+var ErrInvalidCursor = errors.New("недействительный курсор страницы")
+
+// ProductQuery - параметры поиска товаров
+type ProductQuery struct {
+	Text           string      // Строка поиска (q). Пустая строка - без полнотекстового поиска
+	Tags           []string    // Фильтр по тегам
+	TagMode        TagMode     // all (по умолчанию) или any
+	MinPrice       *float64    // Нижняя граница цены (включительно)
+	MaxPrice       *float64    // Верхняя граница цены (включительно)
+	SellerID       uint        // Только товары этого продавца
+	SellerUsername string      // Только товары продавца с этим именем (без учета регистра)
+	Sort           ProductSort // Пусто: relevance при поиске по тексту, иначе newest
+	Cursor         string      // Курсор из ProductPage.Next для следующей страницы
+	Limit          int         // Размер страницы, по умолчанию DefaultProductPageSize
+}
+
+// ProductHit - товар в результатах поиска вместе с релевантностью и фрагментом описания
+type ProductHit struct {
+	models.Product
+
+	Rank    float64       `json:"rank,omitempty"`
+	Snippet template.HTML `json:"snippet,omitempty"` // Экранированный текст с <mark> вокруг совпадений
+	URL     string        `json:"url"`               // Путь страницы товара (см. ProductPath)
+}
+
+// ProductPage - одна страница выдачи
+type ProductPage struct {
+	Items []ProductHit
+	Next  string      // Курсор следующей страницы, пустой на последней странице
+	Limit int         // Фактический размер страницы
+	Sort  ProductSort // Фактический порядок сортировки
+}
+
+// productCursor - позиция последнего товара страницы. Сортировка идет по паре (ключ, id),
+// поэтому следующая страница начинается строго после этой пары и не зависит от вставок и удалений.
+type productCursor struct {
+	Sort ProductSort `json:"s"`
+	Key  float64     `json:"k"`
+	ID   uint        `json:"id"`
+}
+
+func encodeProductCursor(cursor productCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeProductCursor(value string, sort ProductSort) (productCursor, error) {
+	var cursor productCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(data, &cursor) != nil || cursor.Sort != sort {
+		return productCursor{}, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// ProductSearchService ищет товары по названию, описанию и тегам
+type ProductSearchService struct {
+	products repository.ProductSearchRepository
+}
+
+// NewProductSearchService создает новый экземпляр сервиса поиска
+func NewProductSearchService(products repository.ProductSearchRepository) *ProductSearchService {
+	return &ProductSearchService{products: products}
+}
+
+// ValidSort сообщает, поддерживается ли порядок сортировки
+func ValidSort(sort ProductSort) bool {
+	switch sort {
+	case SortRelevance, SortNewest, SortPriceAsc, SortPriceDesc, SortPopular:
+		return true
+	}
+	return false
+}
+
+// Search возвращает страницу товаров, подходящих под запрос. При непустом Text используется
+// полнотекстовый индекс с префиксным поиском (и триграммы для опечаток, если доступно pg_trgm).
+// Пагинация курсорная: ProductPage.Next передается в следующий запрос как Cursor.
+func (s *ProductSearchService) Search(ctx context.Context, query ProductQuery) (*ProductPage, error) {
+	terms := SearchTerms(query.Text)
+
+	sort := query.Sort
+	if sort == "" || (sort == SortRelevance && len(terms) == 0) {
+		if len(terms) > 0 {
+			sort = SortRelevance
+		} else {
+			sort = SortNewest
+		}
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultProductPageSize
+	}
+	if limit > MaxProductPageSize {
+		limit = MaxProductPageSize
+	}
+
+	search := repository.ProductSearch{
+		Terms:          terms,
+		AnyTag:         query.TagMode == TagModeAny,
+		MinPrice:       query.MinPrice,
+		MaxPrice:       query.MaxPrice,
+		SellerID:       query.SellerID,
+		SellerUsername: query.SellerUsername,
+		// Берем на одну запись больше, чтобы узнать, есть ли следующая страница
+		Limit: limit + 1,
+	}
+	switch sort {
+	case SortRelevance:
+		search.Order = repository.OrderByRank
+	case SortPriceAsc:
+		search.Order, search.Ascending = repository.OrderByPrice, true
+	case SortPriceDesc:
+		search.Order = repository.OrderByPrice
+	case SortPopular:
+		search.Order = repository.OrderBySales
+	default:
+		search.Order = repository.OrderByCreated
+	}
+
+	// Теги сравниваются без учета регистра; одинаковые теги в запросе считаются одним
+	seen := make(map[string]bool, len(query.Tags))
+	for _, tag := range query.Tags {
+		if tag = strings.ToLower(tag); !seen[tag] {
+			seen[tag] = true
+			search.Tags = append(search.Tags, tag)
+		}
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeProductCursor(query.Cursor, sort)
+		if err != nil {
+			return nil, err
+		}
+		search.After = &repository.ProductPosition{Key: cursor.Key, ID: cursor.ID}
+	}
+
+	found, err := s.products.Search(ctx, search)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ProductPage{Limit: limit, Sort: sort}
+	if len(found) > limit {
+		found = found[:limit]
+		last := found[len(found)-1]
+		page.Next = encodeProductCursor(productCursor{Sort: sort, Key: last.SortKey, ID: last.ID})
+	}
+	hits := make([]ProductHit, 0, len(found))
+	for _, hit := range found {
+		hits = append(hits, ProductHit{
+			Product: hit.Product,
+			Rank:    hit.Rank,
+			Snippet: highlightSnippet(hit.Snippet),
+			URL:     ProductPath(hit.ID, hit.Title),
+		})
+	}
+	page.Items = hits
+	return page, nil
+}
+
+// SearchTerms разбивает строку поиска на слова из букв и цифр (в нижнем регистре).
+// Все остальные символы считаются разделителями, поэтому слова безопасно подставлять в tsquery
+// (см. repository.ProductSearch.Terms).
+func SearchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var terms []string
+	seen := make(map[string]bool)
+	for _, word := range words {
+		if runes := []rune(word); len(runes) > maxSearchTermLen {
+			word = string(runes[:maxSearchTermLen])
+		}
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+		if len(terms) == maxSearchTerms {
+			break
+		}
+	}
+	return terms
+}
+
+// highlightSnippet собирает HTML фрагмента описания: текст между маркерами ts_headline
+// экранируется по частям, а сами маркеры становятся парными <mark> и </mark>.
+// Непарный маркер отбрасывается, поэтому теги всегда сбалансированы.
+func highlightSnippet(raw string) template.HTML {
+	if raw == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	open := false
+	for raw != "" {
+		i := strings.IndexAny(raw, highlightStart+highlightStop)
+		if i < 0 {
+			b.WriteString(html.EscapeString(raw))
+			break
+		}
+		b.WriteString(html.EscapeString(raw[:i]))
+		marker, size := utf8.DecodeRuneInString(raw[i:])
+		switch {
+		case string(marker) == highlightStart && !open:
+			b.WriteString("<mark>")
+			open = true
+		case string(marker) == highlightStop && open:
+			b.WriteString("</mark>")
+			open = false
+		}
+		raw = raw[i+size:]
+	}
+	if open {
+		b.WriteString("</mark>")
+	}
+	return template.HTML(b.String())
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository/memory"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"пусто", "", ""},
+		{"без совпадений", "Набор кистей", "Набор кистей"},
+		{"совпадение", "Набор " + highlightStart + "кистей" + highlightStop + " для Procreate",
+			"Набор <mark>кистей</mark> для Procreate"},
+		{"разметка в описании экранируется", "<b>" + highlightStart + "x" + highlightStop + "</b>",
+			"&lt;b&gt;<mark>x</mark>&lt;/b&gt;"},
+		{"старые маркеры остаются текстом", "⟦" + highlightStart + "шрифт" + highlightStop + "⟧",
+			"⟦<mark>шрифт</mark>⟧"},
+		{"непарный конец отбрасывается", "a" + highlightStop + "b", "ab"},
+		{"незакрытый mark закрывается", highlightStart + "a" + highlightStart + "b", "<mark>ab</mark>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(highlightSnippet(tt.raw)); got != tt.want {
+				t.Errorf("highlightSnippet(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProductCursorRoundTrip(t *testing.T) {
+	cursors := []productCursor{
+		{Sort: SortNewest, Key: float64(time.Date(2026, 1, 1, 12, 0, 0, 123456000, time.UTC).UnixMicro()), ID: 42},
+		{Sort: SortPriceAsc, Key: 19.99, ID: 7},
+		{Sort: SortRelevance, Key: 0.0607927, ID: 1},
+		{Sort: SortPopular, Key: 0, ID: 3},
+	}
+	for _, cursor := range cursors {
+		got, err := decodeProductCursor(encodeProductCursor(cursor), cursor.Sort)
+		if err != nil || got != cursor {
+			t.Errorf("курсор %+v после кодирования: %+v, %v", cursor, got, err)
+		}
+	}
+}
+
+func TestDecodeProductCursorRejects(t *testing.T) {
+	valid := encodeProductCursor(productCursor{Sort: SortPriceAsc, Key: 10, ID: 5})
+	tampered := []byte(valid)
+	tampered[len(tampered)/2] ^= 1
+	tests := []struct {
+		name  string
+		value string
+		sort  ProductSort
+	}{
+		{"другая сортировка", valid, SortPriceDesc},
+		{"сортировка не указана", valid, ""},
+		{"не base64", "%%%", SortPriceAsc},
+		{"стандартный base64 с паддингом", base64.StdEncoding.EncodeToString([]byte(`{"s":"price_asc","k":10,"id":5}`)), SortPriceAsc},
+		{"не JSON", base64.RawURLEncoding.EncodeToString([]byte("price_asc:10:5")), SortPriceAsc},
+		{"поля другого типа", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"price_asc","k":"10","id":5}`)), SortPriceAsc},
+		{"отрицательный id", base64.RawURLEncoding.EncodeToString([]byte(`{"s":"price_asc","k":10,"id":-1}`)), SortPriceAsc},
+		{"измененный байт", string(tampered), SortPriceAsc},
+		{"обрезанный", valid[:len(valid)-4], SortPriceAsc},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeProductCursor(tt.value, tt.sort); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("ошибка %v, ожидалась ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestSearchCursorPagesThroughTies(t *testing.T) {
+	store := memory.New()
+	seller := models.User{Email: "seller@example.com", Username: "seller", Role: models.RoleSeller}
+	if err := store.Repositories().Users.Create(&seller); err != nil {
+		t.Fatal(err)
+	}
+	// Три товара с одной ценой: порядок между ними задает id
+	ids := make([]uint, 0, 5)
+	for _, price := range []float64{20, 10, 20, 30, 20} {
+		product := models.Product{Title: "Товар", Price: price, UserID: seller.ID}
+		store.AddProduct(&product)
+		ids = append(ids, product.ID)
+	}
+	s := NewProductSearchService(store.Repositories().ProductSearch)
+
+	tests := []struct {
+		sort ProductSort
+		want []uint
+	}{
+		{SortPriceAsc, []uint{ids[1], ids[0], ids[2], ids[4], ids[3]}},
+		{SortPriceDesc, []uint{ids[3], ids[4], ids[2], ids[0], ids[1]}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.sort), func(t *testing.T) {
+			var got []uint
+			cursor := ""
+			for pages := 0; pages < 10; pages++ {
+				page, err := s.Search(context.Background(), ProductQuery{Sort: tt.sort, Limit: 2, Cursor: cursor})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, hit := range page.Items {
+					got = append(got, hit.ID)
+				}
+				if cursor = page.Next; cursor == "" {
+					break
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("порядок %v, ожидался %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("порядок %v, ожидался %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	// Курсор одной сортировки не подходит к другой
+	page, err := s.Search(context.Background(), ProductQuery{Sort: SortPriceAsc, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Search(context.Background(), ProductQuery{Sort: SortNewest, Cursor: page.Next}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("курсор с другой сортировкой: ошибка %v, ожидалась ErrInvalidCursor", err)
+	}
+}
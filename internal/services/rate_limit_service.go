@@ -0,0 +1,387 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RateLimitRule описывает лимит token bucket: не более Limit запросов за Period.
+// Limit одновременно является емкостью ведра (burst).
+type RateLimitRule struct {
+	Limit  int
+	Period time.Duration
+}
+
+// refillRate возвращает скорость пополнения ведра в токенах в секунду
+func (r RateLimitRule) refillRate() float64 {
+	if r.Period <= 0 {
+		return 0
+	}
+	return float64(r.Limit) / r.Period.Seconds()
+}
+
+// String возвращает правило в формате "10/1m0s" для логов
+func (r RateLimitRule) String() string {
+	return fmt.Sprintf("%d/%s", r.Limit, r.Period)
+}
+
+// LockoutPolicy описывает экспоненциальную блокировку после неудачных попыток входа:
+// после Threshold неудач аккаунт блокируется на BaseDelay, и каждая следующая неудача
+// удваивает время блокировки, но не более MaxDelay.
+type LockoutPolicy struct {
+	Threshold   int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	ResetWindow time.Duration // через сколько времени без неудач счетчик обнуляется
+}
+
+// DefaultLockoutPolicy - политика блокировки по умолчанию
+var DefaultLockoutPolicy = LockoutPolicy{
+	Threshold:   5,
+	BaseDelay:   30 * time.Second,
+	MaxDelay:    time.Hour,
+	ResetWindow: 24 * time.Hour,
+}
+
+// lockDuration возвращает длительность блокировки для заданного количества неудач
+func (p LockoutPolicy) lockDuration(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	exponent := failures - p.Threshold
+	if exponent > 30 {
+		return p.MaxDelay
+	}
+	delay := time.Duration(float64(p.BaseDelay) * math.Pow(2, float64(exponent)))
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// RateLimitStore хранит состояние ведер и счетчиков неудачных входов
+type RateLimitStore interface {
+	// Take пытается забрать один токен из ведра key. Возвращает false и время ожидания,
+	// если токенов не осталось.
+	Take(key string, rule RateLimitRule, now time.Time) (bool, time.Duration, error)
+	// LockedUntil возвращает время окончания блокировки ключа (нулевое, если блокировки нет)
+	LockedUntil(key string) (time.Time, error)
+	// RegisterFailure увеличивает счетчик неудач и возвращает новое время окончания блокировки
+	RegisterFailure(key string, policy LockoutPolicy, now time.Time) (time.Time, error)
+	// ResetFailures сбрасывает счетчик неудач после успешного входа
+	ResetFailures(key string) error
+}
+
+// takeToken применяет алгоритм token bucket к сохраненному состоянию ведра
+func takeToken(tokens float64, updatedAt time.Time, rule RateLimitRule, now time.Time) (float64, bool, time.Duration) {
+	rate := rule.refillRate()
+	elapsed := now.Sub(updatedAt).Seconds()
+	if elapsed > 0 {
+		tokens = math.Min(float64(rule.Limit), tokens+elapsed*rate)
+	}
+
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+
+	if rate <= 0 {
+		return tokens, false, rule.Period
+	}
+	wait := time.Duration((1 - tokens) / rate * float64(time.Second))
+	return tokens, false, wait
+}
+
+// --- In-memory хранилище ---
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type memoryAttempt struct {
+	failures    int
+	lockedUntil time.Time
+	updatedAt   time.Time
+}
+
+// MemoryRateLimitStore хранит лимиты в памяти процесса.
+// Подходит для одного экземпляра приложения; при нескольких экземплярах используйте Postgres.
+type MemoryRateLimitStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*memoryBucket
+	attempts map[string]*memoryAttempt
+	takes    int
+}
+
+// NewMemoryRateLimitStore создает новое in-memory хранилище лимитов
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:  make(map[string]*memoryBucket),
+		attempts: make(map[string]*memoryAttempt),
+	}
+}
+
+// memorySweepEvery - как часто (в вызовах Take) удалять давно неиспользуемые ведра
+const memorySweepEvery = 1000
+
+func (s *MemoryRateLimitStore) Take(key string, rule RateLimitRule, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.takes++
+	if s.takes%memorySweepEvery == 0 {
+		s.sweep(now)
+	}
+
+	bucket, exists := s.buckets[key]
+	if !exists {
+		bucket = &memoryBucket{tokens: float64(rule.Limit), updatedAt: now}
+		s.buckets[key] = bucket
+	}
+
+	tokens, allowed, wait := takeToken(bucket.tokens, bucket.updatedAt, rule, now)
+	bucket.tokens = tokens
+	bucket.updatedAt = now
+	return allowed, wait, nil
+}
+
+// sweep удаляет ведра и счетчики, которые не обновлялись больше суток
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.updatedAt) > 24*time.Hour {
+			delete(s.buckets, key)
+		}
+	}
+	for key, attempt := range s.attempts {
+		if now.After(attempt.lockedUntil) && now.Sub(attempt.updatedAt) > 24*time.Hour {
+			delete(s.attempts, key)
+		}
+	}
+}
+
+func (s *MemoryRateLimitStore) LockedUntil(key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, exists := s.attempts[key]; exists {
+		return attempt.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (s *MemoryRateLimitStore) RegisterFailure(key string, policy LockoutPolicy, now time.Time) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, exists := s.attempts[key]
+	if !exists {
+		attempt = &memoryAttempt{}
+		s.attempts[key] = attempt
+	}
+	if policy.ResetWindow > 0 && now.Sub(attempt.updatedAt) > policy.ResetWindow && now.After(attempt.lockedUntil) {
+		attempt.failures = 0
+	}
+
+	attempt.failures++
+	attempt.updatedAt = now
+	if delay := policy.lockDuration(attempt.failures); delay > 0 {
+		attempt.lockedUntil = now.Add(delay)
+	}
+	return attempt.lockedUntil, nil
+}
+
+func (s *MemoryRateLimitStore) ResetFailures(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// --- Postgres хранилище ---
+
+// PostgresRateLimitStore хранит лимиты в таблицах rate_limit_buckets и login_attempts,
+// чтобы ограничения разделялись между несколькими экземплярами приложения.
+type PostgresRateLimitStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresRateLimitStore создает хранилище лимитов поверх переданного подключения
+func NewPostgresRateLimitStore(db *gorm.DB) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{db: db}
+}
+
+func (s *PostgresRateLimitStore) Take(key string, rule RateLimitRule, now time.Time) (bool, time.Duration, error) {
+	var allowed bool
+	var wait time.Duration
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Создаем ведро, если его еще нет, и блокируем строку до конца транзакции
+		initial := models.RateLimitBucket{Key: key, Tokens: float64(rule.Limit), UpdatedAt: now}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&initial).Error; err != nil {
+			return err
+		}
+
+		var bucket models.RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&bucket).Error; err != nil {
+			return err
+		}
+
+		var tokens float64
+		tokens, allowed, wait = takeToken(bucket.Tokens, bucket.UpdatedAt, rule, now)
+		return tx.Model(&models.RateLimitBucket{}).Where("key = ?", key).
+			Updates(map[string]interface{}{"tokens": tokens, "updated_at": now}).Error
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	return allowed, wait, nil
+}
+
+func (s *PostgresRateLimitStore) LockedUntil(key string) (time.Time, error) {
+	var attempt models.LoginAttempt
+	err := s.db.Where("key = ?", key).First(&attempt).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return attempt.LockedUntil, nil
+}
+
+func (s *PostgresRateLimitStore) RegisterFailure(key string, policy LockoutPolicy, now time.Time) (time.Time, error) {
+	var lockedUntil time.Time
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		initial := models.LoginAttempt{Key: key, UpdatedAt: now}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&initial).Error; err != nil {
+			return err
+		}
+
+		var attempt models.LoginAttempt
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&attempt).Error; err != nil {
+			return err
+		}
+
+		if policy.ResetWindow > 0 && now.Sub(attempt.UpdatedAt) > policy.ResetWindow && now.After(attempt.LockedUntil) {
+			attempt.Failures = 0
+		}
+		attempt.Failures++
+		attempt.UpdatedAt = now
+		if delay := policy.lockDuration(attempt.Failures); delay > 0 {
+			attempt.LockedUntil = now.Add(delay)
+		}
+		lockedUntil = attempt.LockedUntil
+
+		return tx.Save(&attempt).Error
+	})
+	return lockedUntil, err
+}
+
+func (s *PostgresRateLimitStore) ResetFailures(key string) error {
+	return s.db.Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+// --- Сервис ---
+
+// RateLimitService применяет лимиты и блокировки поверх выбранного хранилища
+type RateLimitService struct {
+	store   RateLimitStore
+	lockout LockoutPolicy
+	now     func() time.Time
+}
+
+// NewRateLimitService создает сервис ограничения частоты запросов
+func NewRateLimitService(store RateLimitStore, lockout LockoutPolicy) *RateLimitService {
+	return &RateLimitService{
+		store:   store,
+		lockout: lockout,
+		now:     time.Now,
+	}
+}
+
+// WithClock подменяет источник текущего времени (для тестов) и возвращает сервис
+func (s *RateLimitService) WithClock(now func() time.Time) *RateLimitService {
+	s.now = now
+	return s
+}
+
+// NewRateLimitStore создает хранилище лимитов по названию из конфигурации (memory или postgres)
+func NewRateLimitStore(kind string, db *gorm.DB) RateLimitStore {
+	if kind == "postgres" {
+		return NewPostgresRateLimitStore(db)
+	}
+	return NewMemoryRateLimitStore()
+}
+
+// Allow проверяет, можно ли выполнить еще один запрос для ключа.
+// При ошибке хранилища запрос пропускается, чтобы сбой БД не блокировал весь сайт.
+func (s *RateLimitService) Allow(ctx context.Context, key string, rule RateLimitRule) (bool, time.Duration) {
+	allowed, wait, err := s.store.Take(key, rule, s.now())
+	if err != nil {
+		logging.FromContext(ctx).Error("Ошибка хранилища лимитов", slog.String("key", rateLimitKeyForLog(key)), logging.Err(err))
+		return true, 0
+	}
+	return allowed, wait
+}
+
+// LoginLockedFor возвращает, сколько еще длится блокировка входа для аккаунта
+func (s *RateLimitService) LoginLockedFor(ctx context.Context, account string) time.Duration {
+	lockedUntil, err := s.store.LockedUntil(loginAttemptKey(account))
+	if err != nil {
+		logging.FromContext(ctx).Error("Ошибка чтения блокировки входа", logging.Email(account), logging.Err(err))
+		return 0
+	}
+	if remaining := lockedUntil.Sub(s.now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// RegisterLoginFailure фиксирует неудачную попытку входа и возвращает длительность блокировки (0, если ее нет)
+func (s *RateLimitService) RegisterLoginFailure(ctx context.Context, account string) time.Duration {
+	now := s.now()
+	lockedUntil, err := s.store.RegisterFailure(loginAttemptKey(account), s.lockout, now)
+	if err != nil {
+		logging.FromContext(ctx).Error("Ошибка записи неудачного входа", logging.Email(account), logging.Err(err))
+		return 0
+	}
+	if remaining := lockedUntil.Sub(now); remaining > 0 {
+		logging.FromContext(ctx).Warn("Вход в аккаунт заблокирован после неудачных попыток",
+			logging.Email(account), slog.Duration("locked_for", remaining))
+		return remaining
+	}
+	return 0
+}
+
+// ResetLoginFailures сбрасывает счетчик неудачных входов после успешной аутентификации
+func (s *RateLimitService) ResetLoginFailures(ctx context.Context, account string) {
+	if err := s.store.ResetFailures(loginAttemptKey(account)); err != nil {
+		logging.FromContext(ctx).Error("Ошибка сброса счетчика входов", logging.Email(account), logging.Err(err))
+	}
+}
+
+// rateLimitKeyForLog скрывает email в ключе лимита ("auth:account:email:a***@example.com")
+func rateLimitKeyForLog(key string) string {
+	if i := strings.Index(key, "email:"); i >= 0 {
+		return key[:i+len("email:")] + logging.MaskEmail(key[i+len("email:"):])
+	}
+	return key
+}
+
+func loginAttemptKey(account string) string {
+	return "login:" + strings.ToLower(strings.TrimSpace(account))
+}
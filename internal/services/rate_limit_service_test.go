@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestRateLimitService создает сервис поверх хранилища в памяти с часами, которыми управляет тест
+func newTestRateLimitService(now *time.Time, policy LockoutPolicy) *RateLimitService {
+	return NewRateLimitService(NewMemoryRateLimitStore(), policy).WithClock(func() time.Time { return *now })
+}
+
+func TestRateLimitTokenBucket(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	s := newTestRateLimitService(&now, DefaultLockoutPolicy)
+	// Три запроса сразу, дальше по одному в секунду
+	rule := RateLimitRule{Limit: 3, Period: 3 * time.Second}
+
+	steps := []struct {
+		name    string
+		at      time.Duration
+		allowed bool
+		wait    time.Duration
+	}{
+		{"первый запрос", 0, true, 0},
+		{"второй запрос", 0, true, 0},
+		{"третий запрос исчерпывает ведро", 0, true, 0},
+		{"пустое ведро", 0, false, time.Second},
+		{"ожидание уменьшается со временем", 500 * time.Millisecond, false, 500 * time.Millisecond},
+		{"токен пополнился", time.Second, true, 0},
+		{"снова пусто", time.Second, false, time.Second},
+		// За минуту ведро наполняется только до емкости
+		{"после паузы", time.Minute, true, 0},
+		{"после паузы", time.Minute, true, 0},
+		{"после паузы", time.Minute, true, 0},
+		{"емкость не больше Limit", time.Minute, false, time.Second},
+	}
+	for i, step := range steps {
+		now = start.Add(step.at)
+		allowed, wait := s.Allow(context.Background(), "api:ip:203.0.113.5", rule)
+		if allowed != step.allowed || wait != step.wait {
+			t.Errorf("шаг %d (%s): allowed=%v wait=%s, ожидалось allowed=%v wait=%s",
+				i+1, step.name, allowed, wait, step.allowed, step.wait)
+		}
+	}
+
+	// У другого ключа свое ведро
+	if allowed, _ := s.Allow(context.Background(), "api:ip:198.51.100.7", rule); !allowed {
+		t.Error("лимит одного ключа применился к другому")
+	}
+}
+
+func TestRateLimitZeroPeriodWaitsWholePeriod(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := newTestRateLimitService(&now, DefaultLockoutPolicy)
+	rule := RateLimitRule{Limit: 0, Period: time.Minute}
+	if allowed, wait := s.Allow(context.Background(), "key", rule); allowed || wait != time.Minute {
+		t.Errorf("allowed=%v wait=%s, ожидался отказ на %s", allowed, wait, time.Minute)
+	}
+}
+
+func TestLockoutPolicyDuration(t *testing.T) {
+	policy := LockoutPolicy{Threshold: 3, BaseDelay: 10 * time.Second, MaxDelay: time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, 10 * time.Second},
+		{4, 20 * time.Second},
+		{5, 40 * time.Second},
+		{6, time.Minute},
+		{7, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.lockDuration(tt.failures); got != tt.want {
+			t.Errorf("%d неудач: блокировка %s, ожидалась %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	policy := LockoutPolicy{Threshold: 3, BaseDelay: 10 * time.Second, MaxDelay: time.Minute, ResetWindow: time.Hour}
+	s := newTestRateLimitService(&now, policy)
+	ctx := context.Background()
+	const account = "Buyer@Example.com"
+
+	for i, want := range []time.Duration{0, 0, 10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		if got := s.RegisterLoginFailure(ctx, account); got != want {
+			t.Errorf("неудача %d: блокировка %s, ожидалась %s", i+1, got, want)
+		}
+	}
+	// Ключ не зависит от регистра и пробелов в email
+	if got := s.LoginLockedFor(ctx, " buyer@example.com "); got != time.Minute {
+		t.Errorf("блокировка %s, ожидалась %s", got, time.Minute)
+	}
+	now = start.Add(45 * time.Second)
+	if got := s.LoginLockedFor(ctx, account); got != 15*time.Second {
+		t.Errorf("через 45 секунд осталось %s, ожидалось 15s", got)
+	}
+	now = start.Add(time.Minute)
+	if got := s.LoginLockedFor(ctx, account); got != 0 {
+		t.Errorf("блокировка не истекла: %s", got)
+	}
+
+	// Успешный вход сбрасывает счетчик: до блокировки снова Threshold неудач
+	s.ResetLoginFailures(ctx, account)
+	for i, want := range []time.Duration{0, 0, 10 * time.Second} {
+		if got := s.RegisterLoginFailure(ctx, account); got != want {
+			t.Errorf("неудача %d после сброса: блокировка %s, ожидалась %s", i+1, got, want)
+		}
+	}
+
+	// Без неудач дольше ResetWindow счетчик обнуляется сам
+	now = now.Add(2 * time.Hour)
+	if got := s.RegisterLoginFailure(ctx, account); got != 0 {
+		t.Errorf("после ResetWindow: блокировка %s, ожидалось 0", got)
+	}
+}
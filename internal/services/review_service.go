@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Ошибки работы с отзывами
+var (
+	ErrReviewNotAllowed = errors.New("отзыв могут оставить только покупатели товара")
+	ErrReviewNotFound   = errors.New("отзыв не найден")
+	ErrNotProductSeller = errors.New("ответить на отзыв может только продавец товара")
+)
+
+// ReviewService управляет отзывами покупателей, ответами продавцов и модерацией.
+// Рейтинг товара (Product.RatingAvg и ReviewCount) пересчитывает репозиторий отзывов.
+type ReviewService struct {
+	reviews  repository.ReviewRepository
+	products repository.ProductRepository
+	orders   repository.OrderRepository
+}
+
+// NewReviewService создает сервис отзывов поверх репозиториев
+func NewReviewService(reviews repository.ReviewRepository, products repository.ProductRepository, orders repository.OrderRepository) *ReviewService {
+	return &ReviewService{reviews: reviews, products: products, orders: orders}
+}
+
+// SaveReview создает отзыв пользователя о товаре или изменяет уже существующий.
+// created сообщает, был ли отзыв создан. Оценка и текст должны быть проверены ValidationService.
+func (s *ReviewService) SaveReview(userID, productID uint, rating int, text string) (review *models.Review, created bool, err error) {
+	if _, err := s.products.FindByID(productID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, false, ErrProductNotFound
+		}
+		return nil, false, err
+	}
+	purchased, err := s.orders.HasPurchased(userID, productID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !purchased {
+		return nil, false, ErrReviewNotAllowed
+	}
+
+	review, err = s.reviews.FindByUser(userID, productID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		review = &models.Review{ProductID: productID, UserID: userID}
+		created = true
+	case err != nil:
+		return nil, false, err
+	}
+	review.Rating = rating
+	review.Text = strings.TrimSpace(text)
+	if err := s.reviews.Save(review); err != nil {
+		return nil, false, err
+	}
+	return review, created, nil
+}
+
+// GetUserReview возвращает отзыв пользователя о товаре (ErrReviewNotFound, если его нет)
+func (s *ReviewService) GetUserReview(userID, productID uint) (*models.Review, error) {
+	review, err := s.reviews.FindByUser(userID, productID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrReviewNotFound
+	}
+	return review, err
+}
+
+// ListReviews возвращает видимые отзывы о товаре вместе с авторами, новые первыми
+func (s *ReviewService) ListReviews(productID uint) ([]models.Review, error) {
+	return s.reviews.ListVisible(productID)
+}
+
+// VisibleReview возвращает видимый отзыв вместе с товаром; скрытые отзывы считаются несуществующими
+func (s *ReviewService) VisibleReview(reviewID uint) (*models.Review, error) {
+	review, err := s.reviews.FindVisible(reviewID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrReviewNotFound
+	}
+	return review, err
+}
+
+// Reply сохраняет ответ продавца на отзыв. Повторный вызов заменяет ответ.
+func (s *ReviewService) Reply(sellerID, reviewID uint, reply string) (*models.Review, error) {
+	review, err := s.VisibleReview(reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if review.Product.UserID != sellerID {
+		return nil, ErrNotProductSeller
+	}
+
+	now := time.Now()
+	review.SellerReply = strings.TrimSpace(reply)
+	review.SellerReplyAt = &now
+	if err := s.reviews.SetReply(review.ID, review.SellerReply, now); err != nil {
+		return nil, err
+	}
+	return review, nil
+}
+
+// Flag отмечает отзыв как требующий проверки модератором (жалоба пользователя)
+func (s *ReviewService) Flag(reviewID uint) error {
+	review, err := s.VisibleReview(reviewID)
+	if err != nil {
+		return err
+	}
+	return s.reviews.Flag(review.ID)
+}
+
+// ListForModeration возвращает отзывы с жалобами и скрытые отзывы вместе с авторами и товарами,
+// недавно измененные первыми
+func (s *ReviewService) ListForModeration(limit int) ([]models.Review, error) {
+	return s.reviews.ListForModeration(limit)
+}
+
+// Moderate скрывает или снова показывает отзыв и снимает отметку о жалобе.
+// Решение записывается в журнал аудита в той же транзакции.
+func (s *ReviewService) Moderate(ctx context.Context, actor AuditActor, reviewID uint, hidden bool, note string) error {
+	review, err := s.reviews.FindByID(reviewID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrReviewNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	action := AuditReviewRestore
+	if hidden {
+		action = AuditReviewHide
+	}
+	event, err := newAuditEvent(actor, AuditEntry{
+		Action:     action,
+		TargetType: AuditTargetReview,
+		TargetID:   review.ID,
+		Details:    map[string]interface{}{"product_id": review.ProductID, "author_id": review.UserID, "flagged": review.Flagged},
+		Note:       strings.TrimSpace(note),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.reviews.SetHidden(ctx, review.ID, hidden, event)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrReviewNotFound
+	}
+	return err
+}
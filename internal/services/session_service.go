@@ -0,0 +1,110 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// SessionTTL - срок жизни сессии входа (и cookie с ее токеном)
+const SessionTTL = 7 * 24 * time.Hour
+
+// ErrInvalidSession - сессия не найдена, истекла или ее пользователь удален
+var ErrInvalidSession = errors.New("сессия недействительна")
+
+// SessionService выдает и проверяет сессии входа через браузер. Токен сессии - 32 случайных
+// байта; в базе хранится только его хеш, поэтому утечка таблицы не дает войти под пользователем.
+// Пользователь (с ролью и блокировкой) загружается из базы при каждой проверке.
+type SessionService struct {
+	sessions repository.SessionRepository
+	users    repository.UserRepository
+}
+
+// NewSessionService создает сервис сессий поверх репозиториев
+func NewSessionService(sessions repository.SessionRepository, users repository.UserRepository) *SessionService {
+	return &SessionService{sessions: sessions, users: users}
+}
+
+// Create начинает сессию пользователя и возвращает токен для cookie
+func (s *SessionService) Create(userID uint) (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	plain := base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	now := time.Now()
+	session := models.Session{
+		UserID:     userID,
+		TokenHash:  hashSessionToken(plain),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(SessionTTL),
+	}
+	if err := s.sessions.Create(&session); err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// Authenticate возвращает пользователя действующей сессии. Заблокированный пользователь
+// возвращается как есть - решение о доступе принимает вызывающий код.
+func (s *SessionService) Authenticate(plain string) (*models.User, error) {
+	if plain == "" {
+		return nil, ErrInvalidSession
+	}
+	session, err := s.sessions.FindByTokenHash(hashSessionToken(plain))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !now.Before(session.ExpiresAt) {
+		return nil, ErrInvalidSession
+	}
+
+	user, err := s.users.FindByID(session.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Время последнего запроса обновляем не чаще раза в минуту, чтобы не писать в БД на каждый запрос
+	if now.Sub(session.LastSeenAt) > time.Minute {
+		s.sessions.Touch(session.ID, now)
+	}
+	return user, nil
+}
+
+// Revoke завершает сессию с токеном plain (выход)
+func (s *SessionService) Revoke(plain string) error {
+	if plain == "" {
+		return nil
+	}
+	return s.sessions.Delete(hashSessionToken(plain))
+}
+
+// RevokeAll завершает все сессии пользователя
+func (s *SessionService) RevokeAll(userID uint) error {
+	return s.sessions.DeleteByUser(userID)
+}
+
+// DeleteExpired удаляет истекшие сессии
+func (s *SessionService) DeleteExpired() (int64, error) {
+	return s.sessions.DeleteExpired(time.Now())
+}
+
+func hashSessionToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
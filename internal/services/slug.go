@@ -0,0 +1,90 @@
+package services
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxSlugLen - максимальная длина слага в URL
+const MaxSlugLen = 60
+
+// cyrillicTranslit - транслитерация кириллицы для слагов (упрощенная, без апострофов)
+var cyrillicTranslit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "h", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g",
+}
+
+// Slugify превращает строку в часть URL из латинских букв, цифр и дефисов:
+// "Курс по Go: основы!" -> "kurs-po-go-osnovy". Диакритика отбрасывается ("Café" -> "cafe"),
+// остальные символы считаются разделителями. Если ничего не осталось, возвращается пустая строка.
+func Slugify(s string) string {
+	var b strings.Builder
+	pendingDash := false
+
+	write := func(part string) {
+		if part == "" {
+			return
+		}
+		if pendingDash && b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		pendingDash = false
+		b.WriteString(part)
+	}
+
+	// NFD раскладывает "é" на "e" и комбинирующий знак, который затем пропускается
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			write(string(r))
+		case cyrillicTranslit[r] != "":
+			write(cyrillicTranslit[r])
+		case r == 'ъ' || r == 'ь':
+			continue
+		default:
+			pendingDash = true
+		}
+	}
+
+	slug := b.String()
+	if len(slug) > MaxSlugLen {
+		slug = slug[:MaxSlugLen]
+		// Не обрываем слово посередине, если есть более ранний дефис
+		if i := strings.LastIndexByte(slug, '-'); i > MaxSlugLen/2 {
+			slug = slug[:i]
+		}
+		slug = strings.TrimRight(slug, "-")
+	}
+	return slug
+}
+
+// ProductPath возвращает канонический путь страницы товара: /products/42-kurs-po-go.
+// Если из названия не получилось слага, путь состоит только из ID.
+func ProductPath(id uint, title string) string {
+	path := "/products/" + strconv.FormatUint(uint64(id), 10)
+	if slug := Slugify(title); slug != "" {
+		path += "-" + slug
+	}
+	return path
+}
+
+// baseURL - публичный адрес сайта, задается при запуске через SetBaseURL
+var baseURL = "http://localhost:8080"
+
+// SetBaseURL задает публичный адрес сайта (BASE_URL)
+func SetBaseURL(url string) {
+	baseURL = strings.TrimSuffix(url, "/")
+}
+
+// BaseURL возвращает публичный адрес сайта для абсолютных ссылок
+func BaseURL() string {
+	return baseURL
+}
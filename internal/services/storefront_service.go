@@ -0,0 +1,116 @@
+package services
+
+import (
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// ErrStorefrontNotFound - продавца с таким именем нет
+var ErrStorefrontNotFound = errors.New("витрина не найдена")
+
+// StorefrontStats - сводные показатели продавца для витрины
+type StorefrontStats = repository.SellerStats
+
+// SocialLink - ссылка на соцсеть с подписью для витрины
+type SocialLink struct {
+	URL   string `json:"url"`
+	Label string `json:"label"`
+}
+
+// Подписи для известных соцсетей; для остальных сайтов показывается домен
+var socialLinkLabels = map[string]string{
+	"github.com":    "GitHub",
+	"gitlab.com":    "GitLab",
+	"t.me":          "Telegram",
+	"twitter.com":   "Twitter",
+	"x.com":         "X",
+	"youtube.com":   "YouTube",
+	"vk.com":        "VK",
+	"instagram.com": "Instagram",
+	"linkedin.com":  "LinkedIn",
+	"behance.net":   "Behance",
+	"dribbble.com":  "Dribbble",
+}
+
+// StorefrontService отвечает за публичные витрины продавцов (/u/:username):
+// поиск продавца по имени, сводную статистику и настройки витрины.
+type StorefrontService struct {
+	users    repository.UserRepository
+	products repository.ProductRepository
+}
+
+// NewStorefrontService создает сервис витрин поверх репозиториев
+func NewStorefrontService(users repository.UserRepository, products repository.ProductRepository) *StorefrontService {
+	return &StorefrontService{users: users, products: products}
+}
+
+// FindSeller ищет пользователя по имени без учета регистра.
+// Если имя совпадает у нескольких пользователей (возможно у аккаунтов из OAuth), берется самый ранний.
+func (s *StorefrontService) FindSeller(username string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, ErrStorefrontNotFound
+	}
+
+	user, err := s.users.FindByUsernameFold(username)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrStorefrontNotFound
+	}
+	return user, err
+}
+
+// Stats считает число товаров, продаж и средний рейтинг продавца
+func (s *StorefrontService) Stats(sellerID uint) (StorefrontStats, error) {
+	return s.products.SellerStats(sellerID)
+}
+
+// UpdateSettings сохраняет описание и ссылки витрины.
+// Значения должны быть проверены ValidationService (ValidateBio, ValidateSocialLinks).
+func (s *StorefrontService) UpdateSettings(userID uint, bio string, links []string) error {
+	return s.users.UpdateProfile(userID, strings.TrimSpace(bio), strings.Join(links, "\n"))
+}
+
+// SetAvatar сохраняет путь к новому аватару и возвращает путь к прежнему, чтобы удалить старый файл
+func (s *StorefrontService) SetAvatar(userID uint, path string) (oldPath string, err error) {
+	return s.users.SetAvatar(userID, path)
+}
+
+// ParseSocialLinks разбирает ссылки из формы (по одной на строку), пропуская пустые строки
+func ParseSocialLinks(text string) []string {
+	var links []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			links = append(links, line)
+		}
+	}
+	return links
+}
+
+// UserSocialLinks возвращает ссылки пользователя с подписями для показа на витрине
+func UserSocialLinks(user models.User) []SocialLink {
+	links := []SocialLink{}
+	for _, link := range ParseSocialLinks(user.SocialLinks) {
+		label := link
+		if u, err := url.Parse(link); err == nil && u.Host != "" {
+			host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+			label = host
+			if known, ok := socialLinkLabels[host]; ok {
+				label = known
+			}
+		}
+		links = append(links, SocialLink{URL: link, Label: label})
+	}
+	return links
+}
+
+// StorefrontPath возвращает путь витрины продавца: /u/username.
+// Для пользователей без имени витрины нет - возвращается пустая строка.
+func StorefrontPath(username string) string {
+	if username == "" {
+		return ""
+	}
+	return "/u/" + url.PathEscape(username)
+}
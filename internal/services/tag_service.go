@@ -0,0 +1,201 @@
+package services
+
+import (
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Ошибки управления тегами
+var (
+	ErrTagNotFound   = errors.New("тег не найден")
+	ErrTagExists     = errors.New("тег с таким именем уже существует")
+	ErrTagCycle      = errors.New("тег не может быть вложен в самого себя или в своего потомка")
+	ErrTagMergeSelf  = errors.New("нельзя объединить тег с самим собой")
+	ErrTagBadParent  = errors.New("родительский тег не найден")
+	errTagSlugExists = errors.New("слаг занят")
+)
+
+// TagUsage - тег с числом товаров, к которым он привязан
+type TagUsage = repository.TagUsage
+
+// TagService отвечает за создание, нормализацию и администрирование тегов.
+// Имена тегов уникальны без учета регистра, у каждого тега есть уникальный слаг.
+type TagService struct {
+	tags repository.TagRepository
+}
+
+// NewTagService создает сервис тегов поверх репозитория
+func NewTagService(tags repository.TagRepository) *TagService {
+	return &TagService{tags: tags}
+}
+
+// NormalizeTagName приводит имя тега к каноническому виду: NFC,
+// без пробелов по краям и с одиночными пробелами между словами
+func NormalizeTagName(name string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(name)), " ")
+}
+
+// FindOrCreate возвращает тег с таким именем (без учета регистра) или создает новый.
+// Имя должно быть проверено ValidationService.ValidateTagName.
+func (s *TagService) FindOrCreate(name string) (*models.Tag, error) {
+	name = NormalizeTagName(name)
+
+	tag, err := s.tags.FindByName(name)
+	if err == nil {
+		return tag, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	slug, err := s.uniqueSlug(name, 0)
+	if err != nil {
+		return nil, err
+	}
+	tag = &models.Tag{Name: name, Slug: slug}
+	if err := s.tags.Create(tag); err != nil {
+		// Тот же тег мог одновременно создать другой запрос (уникальный индекс по lower(name))
+		if existing, lookupErr := s.tags.FindByName(name); lookupErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return tag, nil
+}
+
+// List возвращает все теги по алфавиту с числом товаров
+func (s *TagService) List() ([]TagUsage, error) {
+	return s.tags.ListWithUsage()
+}
+
+// Rename меняет имя тега и пересчитывает его слаг. Если имя занято другим тегом,
+// возвращает ErrTagExists - такие теги нужно объединять через Merge.
+func (s *TagService) Rename(id uint, name string) (*models.Tag, error) {
+	name = NormalizeTagName(name)
+
+	tag, err := s.findTag(id)
+	if err != nil {
+		return nil, err
+	}
+	taken, err := s.tags.NameTaken(name, id)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrTagExists
+	}
+
+	slug, err := s.uniqueSlug(name, id)
+	if err != nil {
+		return nil, err
+	}
+	tag.Name, tag.Slug = name, slug
+	if err := s.tags.Save(tag); err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+// SetParent делает тег дочерним для parentID (nil - тег верхнего уровня)
+func (s *TagService) SetParent(id uint, parentID *uint) error {
+	tag, err := s.findTag(id)
+	if err != nil {
+		return err
+	}
+	if parentID != nil {
+		if _, err := s.findTag(*parentID); err != nil {
+			if errors.Is(err, ErrTagNotFound) {
+				return ErrTagBadParent
+			}
+			return err
+		}
+		if isAncestor, err := s.tags.IsAncestor(id, *parentID); err != nil {
+			return err
+		} else if isAncestor {
+			return ErrTagCycle
+		}
+	}
+	tag.ParentID = parentID
+	return s.tags.Save(tag)
+}
+
+// Merge переносит товары тега sourceID на тег targetID и удаляет sourceID.
+// Дочерние теги source становятся дочерними для target.
+func (s *TagService) Merge(sourceID, targetID uint) error {
+	if sourceID == targetID {
+		return ErrTagMergeSelf
+	}
+	err := s.tags.Merge(sourceID, targetID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrTagNotFound
+	}
+	return err
+}
+
+// Delete удаляет тег (привязки к товарам удаляются каскадно).
+// Дочерние теги переходят к родителю удаленного.
+func (s *TagService) Delete(id uint) error {
+	err := s.tags.Delete(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrTagNotFound
+	}
+	return err
+}
+
+// BackfillSlugs заполняет слаги тегов, созданных до появления слагов.
+// Вызывается при запуске приложения.
+func (s *TagService) BackfillSlugs() error {
+	tags, err := s.tags.ListWithoutSlug()
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		slug, err := s.uniqueSlug(tag.Name, tag.ID)
+		if err == nil {
+			tag.Slug = slug
+			err = s.tags.Save(&tag)
+		}
+		if err != nil {
+			return fmt.Errorf("тег %d: %w", tag.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *TagService) findTag(id uint) (*models.Tag, error) {
+	tag, err := s.tags.FindByID(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrTagNotFound
+	}
+	return tag, err
+}
+
+// uniqueSlug строит слаг из имени и добавляет к нему -2, -3... если он занят другим тегом.
+// Для имен без латиницы и кириллицы (например, "日本") используется "tag".
+func (s *TagService) uniqueSlug(name string, excludeID uint) (string, error) {
+	base := Slugify(name)
+	if base == "" {
+		base = "tag"
+	}
+
+	for n := 1; n < 1000; n++ {
+		slug := base
+		if n > 1 {
+			slug = fmt.Sprintf("%s-%d", base, n)
+		}
+		taken, err := s.tags.SlugTaken(slug, excludeID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return slug, nil
+		}
+	}
+	return "", errTagSlugExists
+}
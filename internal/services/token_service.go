@@ -0,0 +1,126 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Префикс, по которому токены маркетплейса легко найти в логах и сканерах секретов
+const accessTokenPrefix = "dmp_"
+
+// Максимальное количество активных токенов у одного пользователя
+const MaxAccessTokensPerUser = 20
+
+// Ошибки сервиса токенов
+var (
+	ErrInvalidAccessToken = errors.New("недействительный или отозванный токен доступа")
+	ErrTooManyTokens      = errors.New("превышено максимальное количество токенов доступа")
+	ErrTokenNotFound      = errors.New("токен не найден")
+)
+
+// TokenService управляет персональными токенами доступа к API
+type TokenService struct {
+	tokens repository.TokenRepository
+	users  repository.UserRepository
+}
+
+// NewTokenService создает сервис токенов поверх репозиториев
+func NewTokenService(tokens repository.TokenRepository, users repository.UserRepository) *TokenService {
+	return &TokenService{tokens: tokens, users: users}
+}
+
+// CreateToken создает новый токен для пользователя. Возвращает сам токен (показывается один раз)
+// и сохраненную запись. ttl = 0 означает бессрочный токен.
+func (ts *TokenService) CreateToken(userID uint, name string, ttl time.Duration) (string, *models.PersonalAccessToken, error) {
+	active, err := ts.tokens.CountActive(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if active >= MaxAccessTokensPerUser {
+		return "", nil, ErrTooManyTokens
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", nil, err
+	}
+	plain := accessTokenPrefix + base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	token := models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(name),
+		TokenHash: hashAccessToken(plain),
+		Prefix:    plain[:len(accessTokenPrefix)+6],
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := ts.tokens.Create(&token); err != nil {
+		return "", nil, err
+	}
+	return plain, &token, nil
+}
+
+// Authenticate находит пользователя по токену из заголовка Authorization
+func (ts *TokenService) Authenticate(plain string) (models.User, models.PersonalAccessToken, error) {
+	if !strings.HasPrefix(plain, accessTokenPrefix) {
+		return models.User{}, models.PersonalAccessToken{}, ErrInvalidAccessToken
+	}
+
+	token, err := ts.tokens.FindByHash(hashAccessToken(plain))
+	if err != nil {
+		return models.User{}, models.PersonalAccessToken{}, ErrInvalidAccessToken
+	}
+
+	now := time.Now()
+	if !token.IsActive(now) {
+		return models.User{}, *token, ErrInvalidAccessToken
+	}
+
+	user, err := ts.users.FindByID(token.UserID)
+	if err != nil {
+		return models.User{}, *token, ErrInvalidAccessToken
+	}
+
+	// Время последнего использования обновляем не чаще раза в минуту, чтобы не писать в БД на каждый запрос
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		ts.tokens.Touch(token.ID, now)
+		token.LastUsedAt = &now
+	}
+
+	return *user, *token, nil
+}
+
+// ListTokens возвращает неотозванные токены пользователя (без самих значений)
+func (ts *TokenService) ListTokens(userID uint) ([]models.PersonalAccessToken, error) {
+	return ts.tokens.ListActive(userID)
+}
+
+// RevokeToken отзывает токен пользователя
+func (ts *TokenService) RevokeToken(userID, tokenID uint) error {
+	err := ts.tokens.Revoke(userID, tokenID, time.Now())
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrTokenNotFound
+	}
+	return err
+}
+
+// DeleteExpiredTokens удаляет отозванные и истекшие токены старше retention
+func (ts *TokenService) DeleteExpiredTokens(retention time.Duration) (int64, error) {
+	return ts.tokens.DeleteExpired(time.Now().Add(-retention))
+}
+
+func hashAccessToken(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
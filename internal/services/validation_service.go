@@ -3,12 +3,15 @@ package services
 import (
 	"fmt"
 	"io"
+	"math"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // ValidationService предоставляет функции для валидации пользовательского ввода
@@ -27,8 +30,8 @@ var (
 	// Имя пользователя должно содержать только буквы, цифры и знак подчеркивания
 	usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
 
-	// Проверка допустимых символов в имени тега (буквы, цифры, пробелы, дефисы)
-	tagNameRegex = regexp.MustCompile(`^[a-zA-Z0-9\s\-]+$`)
+	// Имя тега - слова из букв и цифр любого алфавита, разделенные одиночным пробелом или дефисом
+	tagNameRegex = regexp.MustCompile(`^[\p{L}\p{M}\p{N}]+(?:[ \-][\p{L}\p{M}\p{N}]+)*$`)
 
 	// Параметры запроса должны содержать только буквы, цифры, пробелы, знаки подчеркивания и дефисы
 	queryParamRegex = regexp.MustCompile(`^[a-zA-Z0-9_\-\s]+$`)
@@ -91,6 +94,22 @@ const (
 
 	// Максимальная длина имени тега
 	MaxTagNameLen = 30
+
+	// Допустимые оценки в отзывах
+	MinReviewRating = 1
+	MaxReviewRating = 5
+
+	// Максимальная длина текста отзыва и ответа продавца
+	MaxReviewTextLen = 2000
+
+	// Ограничения витрины продавца: длина описания, число и длина ссылок на соцсети
+	MaxBioLen        = 1000
+	MaxSocialLinks   = 5
+	MaxSocialLinkLen = 200
+
+	// Раздел администратора: длина причины действия и предел одного изменения баланса
+	MaxAuditNoteLen      = 500
+	MaxBalanceAdjustment = 1000000
 )
 
 // ValidateEmail проверяет корректность email адреса
@@ -170,17 +189,17 @@ func (vs *ValidationService) ValidatePrice(price float64) (bool, string) {
 
 // ValidateTagName проверяет корректность имени тега
 func (vs *ValidationService) ValidateTagName(name string) (bool, string) {
-	name = strings.TrimSpace(name)
+	name = NormalizeTagName(name)
 	if name == "" {
 		return false, "Имя тега не может быть пустым"
 	}
 
-	if len(name) > MaxTagNameLen {
+	if utf8.RuneCountInString(name) > MaxTagNameLen {
 		return false, fmt.Sprintf("Имя тега слишком длинное (максимум %d символов)", MaxTagNameLen)
 	}
 
 	if !tagNameRegex.MatchString(name) {
-		return false, "Имя тега должно содержать только буквы, цифры, пробелы и дефисы"
+		return false, "Имя тега должно содержать только буквы, цифры, пробелы и дефисы и не может начинаться или заканчиваться пробелом или дефисом"
 	}
 
 	return true, ""
@@ -284,9 +303,9 @@ func (vs *ValidationService) SanitizeQueryParam(param string) string {
 	// Обрезаем пробелы
 	trimmed := strings.TrimSpace(param)
 
-	// Ограничиваем длину
-	if len(trimmed) > 100 {
-		trimmed = trimmed[:100]
+	// Ограничиваем длину (по символам, чтобы не разрезать многобайтовую букву)
+	if runes := []rune(trimmed); len(runes) > 100 {
+		trimmed = string(runes[:100])
 	}
 
 	return trimmed
@@ -307,3 +326,92 @@ func (vs *ValidationService) ValidateQueryParam(param string) (bool, string) {
 
 	return true, ""
 }
+
+// ValidateSearchQuery проверяет строку полнотекстового поиска (параметр q)
+func (vs *ValidationService) ValidateSearchQuery(query string) (bool, string) {
+	if utf8.RuneCountInString(query) > MaxSearchQueryLen {
+		return false, fmt.Sprintf("Поисковый запрос не должен превышать %d символов", MaxSearchQueryLen)
+	}
+
+	for _, r := range query {
+		if unicode.IsControl(r) {
+			return false, "Поисковый запрос содержит недопустимые символы"
+		}
+	}
+
+	return true, ""
+}
+
+// ValidateReview проверяет оценку и текст отзыва
+func (vs *ValidationService) ValidateReview(rating int, text string) (bool, string) {
+	if rating < MinReviewRating || rating > MaxReviewRating {
+		return false, fmt.Sprintf("Оценка должна быть от %d до %d", MinReviewRating, MaxReviewRating)
+	}
+
+	if utf8.RuneCountInString(strings.TrimSpace(text)) > MaxReviewTextLen {
+		return false, fmt.Sprintf("Текст отзыва слишком длинный (максимум %d символов)", MaxReviewTextLen)
+	}
+
+	return true, ""
+}
+
+// ValidateReviewReply проверяет ответ продавца на отзыв
+func (vs *ValidationService) ValidateReviewReply(reply string) (bool, string) {
+	reply = strings.TrimSpace(reply)
+	if reply == "" {
+		return false, "Ответ не может быть пустым"
+	}
+
+	if utf8.RuneCountInString(reply) > MaxReviewTextLen {
+		return false, fmt.Sprintf("Ответ слишком длинный (максимум %d символов)", MaxReviewTextLen)
+	}
+
+	return true, ""
+}
+
+// ValidateBio проверяет описание витрины продавца
+func (vs *ValidationService) ValidateBio(bio string) (bool, string) {
+	if utf8.RuneCountInString(strings.TrimSpace(bio)) > MaxBioLen {
+		return false, fmt.Sprintf("Описание слишком длинное (максимум %d символов)", MaxBioLen)
+	}
+	return true, ""
+}
+
+// ValidateSocialLinks проверяет ссылки на соцсети: не больше MaxSocialLinks абсолютных http(s)-адресов
+func (vs *ValidationService) ValidateSocialLinks(links []string) (bool, string) {
+	if len(links) > MaxSocialLinks {
+		return false, fmt.Sprintf("Можно указать не больше %d ссылок", MaxSocialLinks)
+	}
+
+	for _, link := range links {
+		if len(link) > MaxSocialLinkLen {
+			return false, fmt.Sprintf("Ссылка слишком длинная (максимум %d символов)", MaxSocialLinkLen)
+		}
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return false, fmt.Sprintf("Некорректная ссылка: %s. Укажите адрес, начинающийся с https://", link)
+		}
+	}
+	return true, ""
+}
+
+// ValidateAuditNote проверяет причину действия сотрудника (обязательна для записи в журнал аудита)
+func (vs *ValidationService) ValidateAuditNote(note string) (bool, string) {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return false, "Укажите причину действия"
+	}
+	if utf8.RuneCountInString(note) > MaxAuditNoteLen {
+		return false, fmt.Sprintf("Причина слишком длинная (максимум %d символов)", MaxAuditNoteLen)
+	}
+	return true, ""
+}
+
+// ValidateBalanceAdjustment проверяет сумму изменения баланса: ненулевое конечное число
+// не больше MaxBalanceAdjustment по модулю
+func (vs *ValidationService) ValidateBalanceAdjustment(amount float64) (bool, string) {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) || amount == 0 || math.Abs(amount) > MaxBalanceAdjustment {
+		return false, fmt.Sprintf("Сумма должна быть ненулевым числом от -%d до %d", MaxBalanceAdjustment, MaxBalanceAdjustment)
+	}
+	return true, ""
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"digital-marketplace/internal/watermark"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrWatermarkNotFound - код не найден ни среди скачиваний, ни в журнале аудита
+var ErrWatermarkNotFound = errors.New("водяной знак не найден")
+
+// WatermarkMatch - скачивание, в файл которого встроен найденный водяной знак
+type WatermarkMatch struct {
+	Code         string
+	DownloadedAt time.Time
+	BuyerID      uint
+	Buyer        *models.User // nil, если аккаунт удален
+	OrderID      uint         // 0, если заказ не найден
+	ProductID    uint
+	Product      *models.Product // nil, если товар удален
+	Version      int
+	IP           string
+	Via          string
+	FromAudit    bool // Запись о скачивании удалена вместе с аккаунтом, данные взяты из журнала аудита
+}
+
+// WatermarkService выдает водяные знаки для скачиваний и находит покупателя по коду из файла
+type WatermarkService struct {
+	downloads repository.DownloadRepository
+	audit     repository.AuditRepository
+	orders    repository.OrderRepository
+	users     repository.UserRepository
+	products  repository.ProductRepository
+}
+
+// NewWatermarkService создает сервис водяных знаков поверх репозиториев
+func NewWatermarkService(downloads repository.DownloadRepository, audit repository.AuditRepository, orders repository.OrderRepository,
+	users repository.UserRepository, products repository.ProductRepository) *WatermarkService {
+	return &WatermarkService{downloads: downloads, audit: audit, orders: orders, users: users, products: products}
+}
+
+// Enabled сообщает, нужен ли водяной знак, когда userID скачивает товар.
+// Продавец всегда получает свои файлы без изменений.
+func (s *WatermarkService) Enabled(product models.Product, userID uint) bool {
+	return product.Watermark && product.UserID != userID
+}
+
+// Issue создает водяной знак со случайным кодом для скачивания товара покупателем userID.
+// Код сохраняется вместе со скачиванием (DownloadService.Record).
+func (s *WatermarkService) Issue(ctx context.Context, userID uint, product models.Product) (watermark.Mark, error) {
+	code, err := watermark.NewCode()
+	if err != nil {
+		return watermark.Mark{}, err
+	}
+
+	// Если товар покупали несколько раз, в файл попадает первый заказ
+	orderID, err := s.orders.FirstOrderID(userID, product.ID)
+	if err != nil {
+		return watermark.Mark{}, err
+	}
+
+	return watermark.Mark{
+		Code:         code,
+		BuyerID:      userID,
+		OrderID:      orderID,
+		ProductID:    product.ID,
+		ProductTitle: product.Title,
+		Version:      product.Version,
+		IssuedAt:     time.Now(),
+	}, nil
+}
+
+// Lookup находит скачивание по коду водяного знака. Если запись о скачивании удалена
+// вместе с аккаунтом покупателя, данные берутся из события file.download журнала аудита.
+func (s *WatermarkService) Lookup(ctx context.Context, code string) (*WatermarkMatch, error) {
+	var match *WatermarkMatch
+	download, err := s.downloads.FindByWatermark(code)
+	switch {
+	case err == nil:
+		match = &WatermarkMatch{
+			Code:         code,
+			DownloadedAt: download.CreatedAt,
+			BuyerID:      download.UserID,
+			ProductID:    download.ProductID,
+			Version:      download.Version,
+			IP:           download.IP,
+			Via:          download.Via,
+		}
+		if download.OrderID != nil {
+			match.OrderID = *download.OrderID
+		}
+	case errors.Is(err, repository.ErrNotFound):
+		if match, err = s.lookupAudit(code); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if buyer, err := s.users.FindByID(match.BuyerID); err == nil {
+		match.Buyer = buyer
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	if product, err := s.products.FindByID(match.ProductID); err == nil {
+		match.Product = product
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	return match, nil
+}
+
+// lookupAudit ищет код в подробностях событий file.download
+func (s *WatermarkService) lookupAudit(code string) (*WatermarkMatch, error) {
+	event, err := s.audit.FindByDetail(AuditFileDownload, "watermark", code)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrWatermarkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var details struct {
+		OrderID uint   `json:"order_id"`
+		Version int    `json:"version"`
+		Via     string `json:"via"`
+	}
+	if err := json.Unmarshal([]byte(event.Details), &details); err != nil {
+		return nil, err
+	}
+	match := &WatermarkMatch{
+		Code:         code,
+		DownloadedAt: event.CreatedAt,
+		OrderID:      details.OrderID,
+		Version:      details.Version,
+		IP:           event.IP,
+		Via:          details.Via,
+		FromAudit:    true,
+	}
+	if event.ActorID != nil {
+		match.BuyerID = *event.ActorID
+	}
+	if event.TargetID != nil {
+		match.ProductID = *event.TargetID
+	}
+	return match, nil
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/logging"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ошибки работы с избранным
+var (
+	ErrWishlistOwnProduct   = errors.New("нельзя добавить в избранное свой собственный товар")
+	ErrWishlistItemNotFound = errors.New("товара нет в избранном")
+)
+
+// Сколько событий обрабатывается за один проход рассылки
+const wishlistNotificationBatchSize = 500
+
+// Повторные попытки отправки: после каждой неудачи задержка удваивается,
+// начиная с wishlistNotificationRetryDelay; после wishlistNotificationMaxAttempts
+// неудач события больше не отправляются
+const (
+	wishlistNotificationMaxAttempts = 6
+	wishlistNotificationRetryDelay  = 5 * time.Minute
+)
+
+// WishlistService управляет избранным и уведомлениями подписчиков.
+// События (снижение цены, новая версия) записываются в wishlist_notifications
+// репозиторием товаров в той же транзакции, что и изменение товара, а затем
+// периодически рассылаются одним письмом на пользователя (см. SendPendingNotifications).
+type WishlistService struct {
+	wishlist repository.WishlistRepository
+	products repository.ProductRepository
+	users    repository.UserRepository
+	send     func(ctx context.Context, to, subject, body string) error
+	now      func() time.Time
+}
+
+// NewWishlistService создает сервис избранного поверх репозиториев
+func NewWishlistService(wishlist repository.WishlistRepository, products repository.ProductRepository, users repository.UserRepository) *WishlistService {
+	return &WishlistService{
+		wishlist: wishlist,
+		products: products,
+		users:    users,
+		send:     SendTextEmail,
+		now:      time.Now,
+	}
+}
+
+// Add добавляет товар в избранное. created == false, если товар уже был в избранном.
+func (s *WishlistService) Add(userID, productID uint) (item *models.WishlistItem, created bool, err error) {
+	product, err := s.products.FindByID(productID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, false, ErrProductNotFound
+		}
+		return nil, false, err
+	}
+	if product.UserID == userID {
+		return nil, false, ErrWishlistOwnProduct
+	}
+
+	item = &models.WishlistItem{UserID: userID, ProductID: productID}
+	created, err = s.wishlist.Add(item)
+	if err != nil {
+		return nil, false, err
+	}
+	item.Product = *product
+	return item, created, nil
+}
+
+// Remove удаляет товар из избранного
+func (s *WishlistService) Remove(userID, productID uint) error {
+	err := s.wishlist.Remove(userID, productID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrWishlistItemNotFound
+	}
+	return err
+}
+
+// List возвращает избранное пользователя вместе с товарами, последние добавленные первыми
+func (s *WishlistService) List(userID uint) ([]models.WishlistItem, error) {
+	return s.wishlist.ListByUser(userID)
+}
+
+// Contains проверяет, есть ли товар в избранном пользователя
+func (s *WishlistService) Contains(userID, productID uint) bool {
+	contains, _ := s.wishlist.Contains(userID, productID)
+	return contains
+}
+
+// SendPendingNotifications рассылает накопившиеся события: одно письмо на пользователя
+// со всеми изменениями. Отправленные события помечаются sent_at; если письмо
+// не ушло, события пользователя откладываются (см. markFailed), чтобы они не занимали
+// каждый проход, а после исчерпания попыток помечаются failed_at.
+// Отмена ctx прерывает рассылку без учета попытки. Возвращает число отправленных писем.
+func (s *WishlistService) SendPendingNotifications(ctx context.Context) (int, error) {
+	now := s.now()
+	pending, err := s.wishlist.PendingNotifications(ctx, now, wishlistNotificationBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	byUser := make(map[uint][]models.WishlistNotification)
+	var userIDs []uint
+	for _, n := range pending {
+		if _, ok := byUser[n.UserID]; !ok {
+			userIDs = append(userIDs, n.UserID)
+		}
+		byUser[n.UserID] = append(byUser[n.UserID], n)
+	}
+
+	users, err := s.users.FindByIDs(userIDs)
+	if err != nil {
+		return 0, err
+	}
+	emails := make(map[uint]string, len(users))
+	for _, u := range users {
+		emails[u.ID] = u.Email
+	}
+
+	sent := 0
+	for _, userID := range userIDs {
+		events := byUser[userID]
+		ids := make([]uint, 0, len(events))
+		for _, n := range events {
+			ids = append(ids, n.ID)
+		}
+
+		// Пользователь удален или событие потеряло смысл - просто закрываем события
+		if body := wishlistDigest(events); body != "" && emails[userID] != "" {
+			if err := s.send(ctx, emails[userID], "Новости товаров из вашего избранного", body); err != nil {
+				if ctx.Err() != nil {
+					return sent, ctx.Err()
+				}
+				logging.FromContext(ctx).Error("Ошибка отправки уведомлений избранного", logging.UserID(userID), logging.Err(err))
+				if err := s.markFailed(ctx, userID, events, ids, now); err != nil {
+					return sent, err
+				}
+				continue
+			}
+			sent++
+		}
+
+		if err := s.wishlist.MarkSent(ids, time.Now()); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// markFailed засчитывает неудачную попытку отправки письма с событиями events.
+// События одного письма делят счетчик попыток: берется наибольший из них.
+func (s *WishlistService) markFailed(ctx context.Context, userID uint, events []models.WishlistNotification, ids []uint, now time.Time) error {
+	attempts := 0
+	for _, n := range events {
+		if n.Attempts > attempts {
+			attempts = n.Attempts
+		}
+	}
+	attempts++
+
+	if attempts >= wishlistNotificationMaxAttempts {
+		logging.FromContext(ctx).Warn("Попытки отправки уведомлений избранного исчерпаны",
+			logging.UserID(userID), slog.Int("attempts", attempts), slog.Int("events", len(ids)))
+		return s.wishlist.MarkFailed(ids, attempts, nil, now)
+	}
+	retryAt := now.Add(wishlistNotificationRetryDelay << (attempts - 1))
+	return s.wishlist.MarkFailed(ids, attempts, &retryAt, now)
+}
+
+// RunNotifier периодически вызывает SendPendingNotifications, пока не отменен ctx
+func (s *WishlistService) RunNotifier(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sent, err := s.SendPendingNotifications(ctx)
+			if err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx).Error("Ошибка рассылки уведомлений избранного", logging.Err(err))
+			}
+			if sent > 0 {
+				logging.FromContext(ctx).Info("Отправлены письма об избранном", slog.Int("sent", sent))
+			}
+		}
+	}
+}
+
+// wishlistDigest собирает текст письма из событий одного пользователя.
+// Несколько событий по одному товару сворачиваются в одно: старая цена берется
+// из первого события, новая - текущая цена товара, из версий - последняя.
+// Снижение цены не упоминается, если товар с тех пор снова подорожал.
+func wishlistDigest(events []models.WishlistNotification) string {
+	type productChange struct {
+		product    models.Product
+		oldPrice   float64
+		priceDrop  bool
+		newVersion int
+	}
+
+	changes := make(map[uint]*productChange)
+	var order []uint
+	for _, n := range events {
+		if n.Product.ID == 0 {
+			continue // Товар удален
+		}
+		ch, ok := changes[n.ProductID]
+		if !ok {
+			ch = &productChange{product: n.Product}
+			changes[n.ProductID] = ch
+			order = append(order, n.ProductID)
+		}
+		switch n.Kind {
+		case models.WishlistNotificationPriceDrop:
+			if !ch.priceDrop {
+				ch.oldPrice = n.OldPrice
+				ch.priceDrop = true
+			}
+		case models.WishlistNotificationNewVersion:
+			if n.Version > ch.newVersion {
+				ch.newVersion = n.Version
+			}
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return changes[order[i]].product.Title < changes[order[j]].product.Title
+	})
+
+	var lines []string
+	for _, id := range order {
+		ch := changes[id]
+		var parts []string
+		if ch.priceDrop && ch.product.Price < ch.oldPrice {
+			parts = append(parts, fmt.Sprintf("цена снижена с %.2f до %.2f", ch.oldPrice, ch.product.Price))
+		}
+		if ch.newVersion > 0 {
+			parts = append(parts, fmt.Sprintf("вышла версия %d", ch.newVersion))
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %s\n  %s%s", ch.product.Title, strings.Join(parts, ", "),
+			BaseURL(), ProductPath(ch.product.ID, ch.product.Title)))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(`Здравствуйте!
+
+Изменения в товарах из вашего избранного:
+
+%s
+
+Управлять избранным: %s/wishlist
+
+С уважением,
+Команда Digital Marketplace`, strings.Join(lines, "\n\n"), BaseURL())
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"digital-marketplace/internal/models"
+	"digital-marketplace/internal/repository/memory"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newTestWishlistService создает сервис поверх хранилища в памяти с часами и отправкой писем,
+// которыми управляет тест
+func newTestWishlistService(store *memory.Store, now *time.Time, send func(to string) error) *WishlistService {
+	repos := store.Repositories()
+	s := NewWishlistService(repos.Wishlist, repos.Products, repos.Users)
+	s.now = func() time.Time { return *now }
+	s.send = func(_ context.Context, to, _, _ string) error { return send(to) }
+	return s
+}
+
+func TestSendPendingNotificationsRetriesWithBackoff(t *testing.T) {
+	store := memory.New()
+	repos := store.Repositories()
+	seller := models.User{Email: "seller@example.com", Username: "seller", Role: models.RoleSeller}
+	broken := models.User{Email: "broken@example.com", Username: "broken", Role: models.RoleUser}
+	buyer := models.User{Email: "buyer@example.com", Username: "buyer", Role: models.RoleUser}
+	for _, u := range []*models.User{&seller, &broken, &buyer} {
+		if err := repos.Users.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	product := models.Product{Title: "Шаблон", Price: 50, FilePath: "/uploads/test.zip", UserID: seller.ID}
+	store.AddProduct(&product)
+	for _, userID := range []uint{broken.ID, buyer.ID} {
+		store.AddWishlistNotification(&models.WishlistNotification{UserID: userID, ProductID: product.ID,
+			Kind: models.WishlistNotificationPriceDrop, OldPrice: 100, NewPrice: 50})
+	}
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var delivered []string
+	s := newTestWishlistService(store, &now, func(to string) error {
+		if to == broken.Email {
+			return errors.New("почтовый ящик недоступен")
+		}
+		delivered = append(delivered, to)
+		return nil
+	})
+
+	sent, err := s.SendPendingNotifications(context.Background())
+	if err != nil || sent != 1 {
+		t.Fatalf("первый проход: отправлено %d, ошибка %v", sent, err)
+	}
+	failed := store.WishlistNotifications()[0]
+	if failed.Attempts != 1 || failed.NextAttemptAt == nil || !failed.NextAttemptAt.Equal(now.Add(wishlistNotificationRetryDelay)) {
+		t.Fatalf("после первой неудачи: %+v", failed)
+	}
+
+	// Отложенное событие не попадает в проход до срока повторной попытки
+	if pending, _ := repos.Wishlist.PendingNotifications(context.Background(), now, wishlistNotificationBatchSize); len(pending) != 0 {
+		t.Errorf("отложенное событие в очереди: %+v", pending)
+	}
+
+	// Задержка удваивается, пока попытки не исчерпаны
+	for attempt := 2; attempt <= wishlistNotificationMaxAttempts; attempt++ {
+		now = *store.WishlistNotifications()[0].NextAttemptAt
+		if _, err := s.SendPendingNotifications(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		failed = store.WishlistNotifications()[0]
+		if failed.Attempts != attempt {
+			t.Fatalf("попытка %d: счетчик %d", attempt, failed.Attempts)
+		}
+		if attempt < wishlistNotificationMaxAttempts {
+			want := now.Add(wishlistNotificationRetryDelay << (attempt - 1))
+			if failed.NextAttemptAt == nil || !failed.NextAttemptAt.Equal(want) {
+				t.Fatalf("попытка %d: следующая попытка %v, ожидалась %v", attempt, failed.NextAttemptAt, want)
+			}
+		}
+	}
+	if failed.FailedAt == nil || failed.SentAt != nil || failed.NextAttemptAt != nil {
+		t.Fatalf("событие не помечено отказом: %+v", failed)
+	}
+
+	now = now.Add(24 * time.Hour)
+	if pending, _ := repos.Wishlist.PendingNotifications(context.Background(), now, wishlistNotificationBatchSize); len(pending) != 0 {
+		t.Errorf("событие с исчерпанными попытками в очереди: %+v", pending)
+	}
+	if len(delivered) != 1 || delivered[0] != buyer.Email {
+		t.Errorf("доставлены письма %v, ожидалось одно покупателю", delivered)
+	}
+}
+
+func TestSendPendingNotificationsStopsOnCancel(t *testing.T) {
+	store := memory.New()
+	repos := store.Repositories()
+	seller := models.User{Email: "seller@example.com", Username: "seller", Role: models.RoleSeller}
+	buyer := models.User{Email: "buyer@example.com", Username: "buyer", Role: models.RoleUser}
+	for _, u := range []*models.User{&seller, &buyer} {
+		if err := repos.Users.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	product := models.Product{Title: "Шаблон", Price: 50, FilePath: "/uploads/test.zip", UserID: seller.ID}
+	store.AddProduct(&product)
+	store.AddWishlistNotification(&models.WishlistNotification{UserID: buyer.ID, ProductID: product.ID,
+		Kind: models.WishlistNotificationNewVersion, Version: 2})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	s := newTestWishlistService(store, &now, func(string) error {
+		// Остановка приложения во время отправки письма
+		cancel()
+		return ctx.Err()
+	})
+
+	if _, err := s.SendPendingNotifications(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ошибка %v, ожидалась context.Canceled", err)
+	}
+	// Прерванная отправка не считается неудачной попыткой
+	if n := store.WishlistNotifications()[0]; n.Attempts != 0 || n.NextAttemptAt != nil || n.SentAt != nil {
+		t.Errorf("событие после отмены: %+v", n)
+	}
+}
@@ -0,0 +1,131 @@
+// Package tracing настраивает трассировку OpenTelemetry.
+//
+// Спаны создаются для HTTP-запросов (middleware controllers.Tracing), запросов GORM (Plugin),
+// работы с файлами, сборки zip-архивов и отправки писем. Контекст спана передается дальше
+// через context.Context, поэтому сервисы, получившие ctx запроса, попадают в его трассу.
+// Экспортер выбирается в конфигурации: none, stdout, file (JSON в файл) или otlp (OTLP/HTTP).
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"digital-marketplace/internal/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName - имя, под которым приложение создает спаны
+const instrumentationName = "digital-marketplace"
+
+// Setup создает провайдер трассировки по конфигурации и делает его глобальным.
+// Возвращает функцию, которая отправляет накопленные спаны и закрывает экспортер при остановке.
+// При exporter=none спаны не записываются, но заголовок traceparent по-прежнему передается дальше.
+func Setup(ctx context.Context, cfg config.TracingConfig) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter, closeOutput, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("описание сервиса для трассировки: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Решение о записи принимает первый сервис в цепочке; для новых трасс - доля SampleRatio
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeOutput != nil {
+			err = errors.Join(err, closeOutput.Close())
+		}
+		return err
+	}, nil
+}
+
+// newExporter создает экспортер; nil означает, что трассировка выключена.
+// closeOutput - файл, который нужно закрыть после экспортера (для exporter=file).
+func newExporter(ctx context.Context, cfg config.TracingConfig) (exporter sdktrace.SpanExporter, closeOutput io.Closer, err error) {
+	switch cfg.Exporter {
+	case "none":
+		return nil, nil, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "file":
+		file, openErr := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if openErr != nil {
+			return nil, nil, fmt.Errorf("файл трассировки: %w", openErr)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		closeOutput = file
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+	default:
+		return nil, nil, fmt.Errorf("неизвестный экспортер трассировки %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("экспортер трассировки %s: %w", cfg.Exporter, err)
+	}
+	return exporter, closeOutput, nil
+}
+
+// Tracer возвращает трассировщик приложения
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start начинает дочерний спан операции внутри приложения
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End записывает ошибку операции (если есть) и завершает спан
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// EndMessage завершает спан операции, которая сообщает об ошибке текстом для пользователя
+func EndMessage(span trace.Span, errMsg string) {
+	if errMsg != "" {
+		span.SetStatus(codes.Error, errMsg)
+	}
+	span.End()
+}
+
+// TraceID возвращает ID трассы из контекста или пустую строку, если трасса не записывается
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
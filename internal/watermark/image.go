@@ -0,0 +1,178 @@
+package watermark
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	// jpegQuality - качество при повторном сжатии JPEG с надписью
+	jpegQuality = 92
+	// maxImagePixels - изображения крупнее отдаются без надписи: копия для рисования
+	// занимает 4 байта на пиксель (64 МБ при таком лимите)
+	maxImagePixels = 16 << 20
+	// jpegHeaderSize - в скольких первых байтах JPEG ищутся сегменты APPn для переноса
+	jpegHeaderSize = 1 << 20
+	// pngHeaderSize - сигнатура (8 байт) и IHDR: длина, тип, 13 байт данных и CRC
+	pngHeaderSize = 8 + 4 + 4 + 13 + 4
+)
+
+var (
+	labelBackground = image.NewUniform(color.NRGBA{0, 0, 0, 140})
+	labelInk        = image.NewUniform(color.NRGBA{255, 255, 255, 210})
+)
+
+// markImage рисует надпись с покупателем в правом нижнем углу изображения src размером size
+// и возвращает функцию, которая пишет результат в dst с Summary в текстовых метаданных:
+// чанк tEXt для PNG, сегмент COM для JPEG. Размер изображения проверяется по заголовку
+// до распаковки: слишком большое изображение отклоняется с ErrUnsupported.
+func markImage(k kind, src io.ReaderAt, size int64, mark Mark) (writeFunc, error) {
+	config, _, err := image.DecodeConfig(io.NewSectionReader(src, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, fmt.Errorf("%w: изображение %dx%d больше %d мегапикселей",
+			ErrUnsupported, config.Width, config.Height, maxImagePixels>>20)
+	}
+
+	decoded, _, err := image.Decode(io.NewSectionReader(src, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	bounds := decoded.Bounds()
+	img := image.NewRGBA(bounds)
+	draw.Draw(img, bounds, decoded, bounds.Min, draw.Src)
+
+	// На маленьком изображении полная надпись не помещается - тогда рисуется только код
+	for _, text := range []string{mark.Label(), mark.Code} {
+		if drawLabel(img, text) {
+			break
+		}
+	}
+
+	// Метаданные вставляются в поток кодировщика сразу за заголовком файла
+	if k == kindPNG {
+		chunk := pngTextChunk("Comment", mark.Summary())
+		return func(dst io.Writer) error {
+			return png.Encode(&insertWriter{w: dst, n: pngHeaderSize, extra: chunk}, img)
+		}, nil
+	}
+	segments, err := jpegSegments(src, size, mark.Summary())
+	if err != nil {
+		return nil, err
+	}
+	return func(dst io.Writer) error {
+		// Сегменты идут сразу после маркера SOI (2 байта)
+		return jpeg.Encode(&insertWriter{w: dst, n: 2, extra: segments}, img, &jpeg.Options{Quality: jpegQuality})
+	}, nil
+}
+
+// drawLabel рисует text на полупрозрачной плашке. Надпись занимает около трети ширины
+// изображения; false - не помещается даже в минимальном масштабе.
+func drawLabel(img *image.RGBA, text string) bool {
+	bounds := img.Bounds()
+	textWidth := len(text)*(glyphWidth+glyphGap) - glyphGap
+	scale := max(1, bounds.Dx()/(textWidth*3))
+	pad := 2 * scale
+	width := textWidth*scale + 2*pad
+	height := glyphHeight*scale + 2*pad
+	if width > bounds.Dx() || height > bounds.Dy() {
+		return false
+	}
+
+	plate := image.Rect(bounds.Max.X-width, bounds.Max.Y-height, bounds.Max.X, bounds.Max.Y)
+	draw.Draw(img, plate, labelBackground, image.Point{}, draw.Over)
+	x := plate.Min.X + pad
+	for _, r := range text {
+		glyph := glyphs[r]
+		for row, line := range glyph {
+			for col, pixel := range line {
+				if pixel != '#' {
+					continue
+				}
+				px := x + col*scale
+				py := plate.Min.Y + pad + row*scale
+				draw.Draw(img, image.Rect(px, py, px+scale, py+scale), labelInk, image.Point{}, draw.Over)
+			}
+		}
+		x += (glyphWidth + glyphGap) * scale
+	}
+	return true
+}
+
+// pngTextChunk собирает чанк tEXt с keyword и text
+func pngTextChunk(keyword, text string) []byte {
+	payload := append([]byte("tEXt"+keyword+"\x00"), text...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)-4))
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(payload))
+}
+
+// jpegSegments собирает сегменты APPn исходного файла (EXIF, ICC-профиль) и комментарий COM с text
+func jpegSegments(src io.ReaderAt, size int64, text string) ([]byte, error) {
+	if len(text) > 0xffff-2 {
+		return nil, fmt.Errorf("%w: слишком длинный комментарий JPEG", ErrUnsupported)
+	}
+	header := make([]byte, min(size, jpegHeaderSize))
+	if _, err := src.ReadAt(header, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	segments := jpegAppSegments(header)
+	segments = append(segments, 0xff, 0xfe)
+	segments = binary.BigEndian.AppendUint16(segments, uint16(len(text)+2))
+	return append(segments, text...), nil
+}
+
+// insertWriter передает в w первые n байт, затем один раз extra и дальше все остальное
+type insertWriter struct {
+	w     io.Writer
+	n     int
+	extra []byte
+}
+
+func (iw *insertWriter) Write(p []byte) (int, error) {
+	if iw.extra == nil {
+		return iw.w.Write(p)
+	}
+	if len(p) < iw.n {
+		n, err := iw.w.Write(p)
+		iw.n -= n
+		return n, err
+	}
+	head, err := iw.w.Write(p[:iw.n])
+	if err != nil {
+		return head, err
+	}
+	if _, err := iw.w.Write(iw.extra); err != nil {
+		return head, err
+	}
+	iw.extra = nil
+	n, err := iw.w.Write(p[head:])
+	return head + n, err
+}
+
+// jpegAppSegments возвращает сегменты APP0-APP15 из заголовка JPEG (до начала данных SOS)
+func jpegAppSegments(data []byte) []byte {
+	var segments []byte
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xda || length < 2 || i+2+length > len(data) {
+			break
+		}
+		if marker >= 0xe0 && marker <= 0xef {
+			segments = append(segments, data[i:i+2+length]...)
+		}
+		i += 2 + length
+	}
+	return segments
+}
@@ -0,0 +1,312 @@
+package watermark
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PDF меняется инкрементальным обновлением: исходные байты остаются нетронутыми, в конец
+// дописываются новый словарь /Info (старые поля плюс MarketplaceLicense и MarketplaceWatermark)
+// и секция перекрестных ссылок с /Prev на прежнюю. Так не нужно разбирать весь документ
+// и держать его в памяти: исходный файл копируется потоком, а читаются только трейлер
+// и прежний словарь /Info.
+
+var (
+	pdfStartXrefRegex = regexp.MustCompile(`startxref\s+(\d+)`)
+	pdfObjectRegex    = regexp.MustCompile(`^\s*\d+\s+\d+\s+obj`)
+	pdfRootRegex      = regexp.MustCompile(`/Root\s+(\d+\s+\d+)\s+R`)
+	pdfInfoRegex      = regexp.MustCompile(`/Info\s+(\d+)\s+(\d+)\s+R`)
+	pdfSizeRegex      = regexp.MustCompile(`/Size\s+(\d+)`)
+	pdfIDRegex        = regexp.MustCompile(`/ID\s*\[[^\]]*\]`)
+)
+
+const (
+	// pdfTailSize - в скольких последних байтах файла ищется startxref
+	pdfTailSize = 4096
+	// pdfDictWindow - сколько байт читается, чтобы разобрать словарь трейлера или /Info
+	pdfDictWindow = 64 << 10
+	// pdfScanChunk - по сколько байт просматривается файл в поисках трейлера и объекта /Info
+	pdfScanChunk = 1 << 20
+	// pdfScanOverlap - перекрытие соседних кусков, чтобы не пропустить совпадение на границе
+	pdfScanOverlap = 256
+)
+
+// markPDF разбирает трейлер документа src размером size и возвращает функцию, которая
+// копирует документ в dst и дописывает к нему словарь /Info с водяным знаком
+func markPDF(src io.ReaderAt, size int64, mark Mark) (writeFunc, error) {
+	tail, err := readPDFAt(src, size, max(0, size-pdfTailSize), pdfTailSize)
+	if err != nil {
+		return nil, err
+	}
+	head, err := readPDFAt(src, size, 0, 5)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(head, []byte("%PDF-")) {
+		return nil, fmt.Errorf("%w: нет заголовка PDF", ErrUnsupported)
+	}
+
+	matches := pdfStartXrefRegex.FindAllSubmatch(tail, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w: не найден startxref", ErrUnsupported)
+	}
+	prev, err := strconv.ParseInt(string(matches[len(matches)-1][1]), 10, 64)
+	if err != nil || prev <= 0 || prev >= size {
+		return nil, fmt.Errorf("%w: некорректный startxref", ErrUnsupported)
+	}
+
+	// Словарь трейлера: после классической таблицы xref или в заголовке потока /XRef (PDF 1.5+)
+	var trailer []byte
+	var ok bool
+	section, err := readPDFAt(src, size, prev, pdfDictWindow)
+	if err != nil {
+		return nil, err
+	}
+	xrefStream := false
+	switch {
+	case bytes.HasPrefix(section, []byte("xref")):
+		// Таблица ссылок большого документа может не поместиться в окно - тогда ищем дальше
+		at, found := scanPDF(src, size, prev, func(chunk []byte) int {
+			if i := bytes.Index(chunk, []byte("trailer")); i >= 0 {
+				return i + len("trailer")
+			}
+			return -1
+		}, true)
+		if !found {
+			return nil, fmt.Errorf("%w: не найден trailer", ErrUnsupported)
+		}
+		window, err := readPDFAt(src, size, at, pdfDictWindow)
+		if err != nil {
+			return nil, err
+		}
+		trailer, ok = readPDFDict(window, 0)
+	case pdfObjectRegex.Match(section):
+		xrefStream = true
+		trailer, ok = readPDFDict(section, bytes.Index(section, []byte("obj"))+len("obj"))
+		ok = ok && bytes.Contains(trailer, []byte("/XRef"))
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: не удалось прочитать трейлер", ErrUnsupported)
+	}
+	if bytes.Contains(trailer, []byte("/Encrypt")) {
+		return nil, fmt.Errorf("%w: документ зашифрован", ErrUnsupported)
+	}
+	root := pdfRootRegex.FindSubmatch(trailer)
+	sizeKey := pdfSizeRegex.FindSubmatch(trailer)
+	if root == nil || sizeKey == nil {
+		return nil, fmt.Errorf("%w: в трейлере нет /Root или /Size", ErrUnsupported)
+	}
+	infoNum, err := strconv.Atoi(string(sizeKey[1]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: некорректный /Size", ErrUnsupported)
+	}
+
+	// Прежние поля /Info (название, автор) сохраняются, если словарь не упакован в поток объектов
+	var oldInfo []byte
+	if ref := pdfInfoRegex.FindSubmatch(trailer); ref != nil {
+		oldInfo, err = findPDFObjectDict(src, size, string(ref[1]), string(ref[2]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Обновление собирается заранее: смещения в нем зависят только от размера исходного файла
+	var update bytes.Buffer
+	if last := tail[len(tail)-1]; last != '\n' && last != '\r' {
+		update.WriteByte('\n')
+	}
+	offset := func() int64 { return size + int64(update.Len()) }
+
+	infoOffset := offset()
+	fmt.Fprintf(&update, "%d 0 obj\n<<%s /MarketplaceLicense %s /MarketplaceWatermark (%s)>>\nendobj\n",
+		infoNum, oldInfo, pdfString(mark.Summary()), mark.Code)
+
+	trailerKeys := fmt.Sprintf("/Root %s R /Info %d 0 R /Prev %d", root[1], infoNum, prev)
+	if id := pdfIDRegex.Find(trailer); id != nil {
+		trailerKeys += " " + string(id)
+	}
+
+	xrefOffset := offset()
+	if xrefStream {
+		// Документ с потоком ссылок получает такой же поток: записи для /Info и для самого потока
+		xrefNum := infoNum + 1
+		entries := make([]byte, 0, 14)
+		for _, offset := range []int64{infoOffset, xrefOffset} {
+			entries = append(entries, 1)
+			entries = binary.BigEndian.AppendUint32(entries, uint32(offset))
+			entries = append(entries, 0, 0)
+		}
+		fmt.Fprintf(&update, "%d 0 obj\n<</Type /XRef /Size %d /Index [%d 2] /W [1 4 2] /Length %d %s>>\nstream\n",
+			xrefNum, xrefNum+1, infoNum, len(entries), trailerKeys)
+		update.Write(entries)
+		update.WriteString("\nendstream\nendobj\n")
+	} else {
+		fmt.Fprintf(&update, "xref\n%d 1\n%010d 00000 n \ntrailer\n<</Size %d %s>>\n",
+			infoNum, infoOffset, infoNum+1, trailerKeys)
+	}
+	fmt.Fprintf(&update, "startxref\n%d\n%%%%EOF\n", xrefOffset)
+
+	return func(dst io.Writer) error {
+		if _, err := io.Copy(dst, io.NewSectionReader(src, 0, size)); err != nil {
+			return err
+		}
+		_, err := dst.Write(update.Bytes())
+		return err
+	}, nil
+}
+
+// findPDFObjectDict возвращает содержимое словаря последней версии объекта num gen
+// или nil, если объект не найден или не является словарем
+func findPDFObjectDict(src io.ReaderAt, size int64, num, gen string) ([]byte, error) {
+	// Документ начинается с %PDF-, поэтому перед номером объекта всегда есть другой символ
+	re, err := regexp.Compile(`[^0-9]` + num + `\s+` + gen + `\s+obj`)
+	if err != nil {
+		return nil, nil
+	}
+	at, found := scanPDF(src, size, 0, func(chunk []byte) int {
+		matches := re.FindAllIndex(chunk, -1)
+		if len(matches) == 0 {
+			return -1
+		}
+		return matches[len(matches)-1][1]
+	}, false)
+	if !found {
+		return nil, nil
+	}
+	window, err := readPDFAt(src, size, at, pdfDictWindow)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := readPDFDict(window, 0)
+	if !ok {
+		return nil, nil
+	}
+	return dict, nil
+}
+
+// scanPDF просматривает src с позиции from кусками по pdfScanChunk байт. match возвращает
+// позицию в куске после найденного совпадения или -1. Если first, поиск останавливается
+// на первом куске с совпадением, иначе возвращается последнее совпадение в файле.
+// Куски перекрываются, поэтому совпадение на границе целиком попадает в один из них.
+func scanPDF(src io.ReaderAt, size, from int64, match func(chunk []byte) int, first bool) (int64, bool) {
+	buf := make([]byte, pdfScanChunk+pdfScanOverlap)
+	var at int64
+	found := false
+	for offset := from; offset < size; offset += pdfScanChunk {
+		n, err := src.ReadAt(buf[:min(int64(len(buf)), size-offset)], offset)
+		if err != nil && err != io.EOF {
+			return 0, false
+		}
+		if end := match(buf[:n]); end >= 0 {
+			at, found = offset+int64(end), true
+			if first {
+				break
+			}
+		}
+	}
+	return at, found
+}
+
+// readPDFAt читает до n байт документа с позиции offset
+func readPDFAt(src io.ReaderAt, size, offset int64, n int) ([]byte, error) {
+	buf := make([]byte, min(int64(n), size-offset))
+	if len(buf) == 0 {
+		return nil, fmt.Errorf("%w: пустой документ", ErrUnsupported)
+	}
+	if _, err := src.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readPDFDict читает словарь << ... >>, начинающийся с позиции pos (после пробелов),
+// и возвращает его содержимое без внешних скобок. Учитываются вложенные словари,
+// строки в скобках и шестнадцатеричные строки.
+func readPDFDict(data []byte, pos int) ([]byte, bool) {
+	for pos < len(data) && isPDFSpace(data[pos]) {
+		pos++
+	}
+	if !bytes.HasPrefix(data[pos:], []byte("<<")) {
+		return nil, false
+	}
+
+	depth := 0
+	for i := pos; i < len(data); {
+		switch {
+		case bytes.HasPrefix(data[i:], []byte("<<")):
+			depth++
+			i += 2
+		case bytes.HasPrefix(data[i:], []byte(">>")):
+			depth--
+			i += 2
+			if depth == 0 {
+				return data[pos+2 : i-2], true
+			}
+		case data[i] == '(':
+			i = skipPDFString(data, i)
+		case data[i] == '<':
+			end := bytes.IndexByte(data[i:], '>')
+			if end < 0 {
+				return nil, false
+			}
+			i += end + 1
+		case data[i] == '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		default:
+			i++
+		}
+	}
+	return nil, false
+}
+
+// skipPDFString возвращает позицию после строки (...), начинающейся в i
+func skipPDFString(data []byte, i int) int {
+	depth := 0
+	for ; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(data)
+}
+
+func isPDFSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == '\f' || b == 0
+}
+
+// pdfString кодирует текстовую строку PDF: ASCII - в скобках, остальное - UTF-16BE с BOM
+func pdfString(s string) string {
+	ascii := true
+	for _, r := range s {
+		if r < 0x20 || r > 0x7e {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return "(" + strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(s) + ")"
+	}
+
+	encoded := []byte{0xfe, 0xff}
+	for _, unit := range utf16.Encode([]rune(s)) {
+		encoded = binary.BigEndian.AppendUint16(encoded, unit)
+	}
+	return "<" + strings.ToUpper(hex.EncodeToString(encoded)) + ">"
+}
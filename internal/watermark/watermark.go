@@ -0,0 +1,204 @@
+// Package watermark встраивает в скачиваемые файлы товара данные покупателя:
+// в zip-архив добавляется файл лицензии и комментарий, в PDF - поля метаданных,
+// на изображения PNG и JPEG - надпись и текстовый комментарий. По коду из файла
+// (см. Find) можно найти скачивание, а по нему - покупателя.
+//
+// Пакет не зависит от базы данных и внешних библиотек: только стандартная библиотека Go.
+package watermark
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// codePrefix начинает каждый код водяного знака
+const codePrefix = "WM-"
+
+// maxMarkedSize - файлы архива крупнее не распаковываются для водяного знака и Find,
+// а копируются без изменений
+const maxMarkedSize = 100 << 20
+
+// ErrUnsupported - формат файла не поддерживается или файл не удалось разобрать
+var ErrUnsupported = errors.New("формат файла не поддерживает водяной знак")
+
+// codeRegex находит коды водяных знаков в произвольных данных
+var codeRegex = regexp.MustCompile(codePrefix + `[A-Z2-7]{16}`)
+
+// Mark - данные, которые встраиваются в файл для одного скачивания
+type Mark struct {
+	Code         string // Уникальный код скачивания, по нему находится покупатель
+	BuyerID      uint
+	OrderID      uint // 0, если заказ не найден
+	ProductID    uint
+	ProductTitle string
+	Version      int
+	IssuedAt     time.Time
+}
+
+// NewCode создает случайный код водяного знака вида WM-XXXXXXXXXXXXXXXX
+func NewCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return codePrefix + base32.StdEncoding.EncodeToString(b), nil
+}
+
+// Summary - одна строка с товаром, покупателем, заказом и кодом для комментариев и метаданных
+func (m Mark) Summary() string {
+	return fmt.Sprintf("\"%s\" licensed to buyer #%d, %s. Watermark %s", m.ProductTitle, m.BuyerID, m.orderText(), m.Code)
+}
+
+// Label - короткая надпись на изображении (шрифт поддерживает только заглавные латинские буквы)
+func (m Mark) Label() string {
+	return strings.ToUpper(fmt.Sprintf("Buyer #%d %s %s", m.BuyerID, m.orderText(), m.Code))
+}
+
+// License - текст файла лицензии, который добавляется в архив
+func (m Mark) License() string {
+	return fmt.Sprintf(`LICENSE
+
+This copy of "%s" (version %d) was licensed to buyer #%d, %s, on %s.
+It is for the buyer's own use. Redistributing or sharing these files is not permitted.
+
+Every download is personalised: this file, the archive comment and the metadata
+of documents and images identify the buyer.
+
+Watermark: %s
+`, m.ProductTitle, m.Version, m.BuyerID, m.orderText(), m.IssuedAt.UTC().Format("2006-01-02"), m.Code)
+}
+
+func (m Mark) orderText() string {
+	if m.OrderID == 0 {
+		return "no order"
+	}
+	return fmt.Sprintf("order #%d", m.OrderID)
+}
+
+// kind - формат файла, который умеет обрабатывать пакет
+type kind int
+
+const (
+	kindNone kind = iota
+	kindZip
+	kindPDF
+	kindPNG
+	kindJPEG
+)
+
+func kindOf(name string) kind {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".zip":
+		return kindZip
+	case ".pdf":
+		return kindPDF
+	case ".png":
+		return kindPNG
+	case ".jpg", ".jpeg":
+		return kindJPEG
+	}
+	return kindNone
+}
+
+// Supported сообщает, можно ли встроить водяной знак в файл с таким именем
+func Supported(name string) bool {
+	return kindOf(name) != kindNone
+}
+
+// writeFunc пишет в dst подготовленную копию файла с водяным знаком
+type writeFunc func(dst io.Writer) error
+
+// Apply записывает в dst копию файла path с водяным знаком mark. Если файл не удалось
+// разобрать, Apply возвращает ошибку до того, как что-либо записано в dst, и файл можно
+// отдать без изменений. Вложенные в архив PDF и изображения, которые не удалось обработать,
+// копируются как есть. Файл не читается в память целиком: PDF копируется потоком,
+// а изображение распаковывается, только если укладывается в maxImagePixels.
+func Apply(dst io.Writer, path string, mark Mark) error {
+	k := kindOf(path)
+	switch k {
+	case kindZip:
+		return applyZip(dst, path, mark)
+	case kindNone:
+		return ErrUnsupported
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	write, err := markFile(k, f, info.Size(), mark)
+	if err != nil {
+		return err
+	}
+	return write(dst)
+}
+
+// markFile готовит водяной знак для отдельного PDF или изображения src размером size.
+// Ошибка разбора возвращается до записи, сама запись выполняется возвращенной функцией.
+func markFile(k kind, src io.ReaderAt, size int64, mark Mark) (writeFunc, error) {
+	switch k {
+	case kindPDF:
+		return markPDF(src, size, mark)
+	case kindPNG, kindJPEG:
+		return markImage(k, src, size, mark)
+	}
+	return nil, ErrUnsupported
+}
+
+// Find возвращает коды водяных знаков, найденные в содержимом файла: в открытом виде,
+// в комментарии и файлах zip-архива (в том числе сжатых), без повторов
+func Find(data []byte) []string {
+	seen := make(map[string]bool)
+	var codes []string
+	var scan func(data []byte, depth int)
+	scan = func(data []byte, depth int) {
+		for _, code := range codeRegex.FindAll(data, -1) {
+			if !seen[string(code)] {
+				seen[string(code)] = true
+				codes = append(codes, string(code))
+			}
+		}
+		// Архив могли пересжать - тогда код виден только после распаковки
+		r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil || depth > 2 {
+			return
+		}
+		scan([]byte(r.Comment), depth+1)
+		for _, f := range r.File {
+			if f.UncompressedSize64 > maxMarkedSize {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				continue
+			}
+			content, err := io.ReadAll(rc)
+			rc.Close()
+			if err == nil {
+				scan(content, depth+1)
+			}
+		}
+	}
+	scan(data, 0)
+	return codes
+}
+
+// ValidCode проверяет формат кода, введенного вручную (например, с надписи на изображении)
+func ValidCode(code string) bool {
+	return len(code) == len(codePrefix)+16 && codeRegex.MatchString(code)
+}
@@ -0,0 +1,218 @@
+package watermark
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMark(t *testing.T) Mark {
+	t.Helper()
+	code, err := NewCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Mark{Code: code, BuyerID: 7, OrderID: 3, ProductID: 1, ProductTitle: "Шаблон", Version: 1, IssuedAt: time.Now()}
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testPDF собирает документ с классической таблицей ссылок и словарем /Info
+func testPDF() []byte {
+	objects := []string{
+		"<</Type /Catalog /Pages 2 0 R>>",
+		"<</Type /Pages /Kids [] /Count 0>>",
+		"<</Title (Old title)>>",
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<</Size %d /Root 1 0 R /Info 3 0 R>>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestApplyPNG(t *testing.T) {
+	mark := testMark(t)
+	var out bytes.Buffer
+	if err := Apply(&out, writeFile(t, "cover.png", encodePNG(t, 400, 100)), mark); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(out.Bytes())); err != nil {
+		t.Fatalf("результат не читается как PNG: %v", err)
+	}
+	if codes := Find(out.Bytes()); len(codes) != 1 || codes[0] != mark.Code {
+		t.Errorf("найдены коды %v, ожидался %s", codes, mark.Code)
+	}
+}
+
+func TestApplyJPEGKeepsAppSegments(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 80))
+	for i := range img.Pix {
+		img.Pix[i] = 120
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Сегмент APP1 после SOI должен перейти в файл с водяным знаком
+	app := []byte("Exif\x00\x00test")
+	data := append([]byte{0xff, 0xd8, 0xff, 0xe1}, binary.BigEndian.AppendUint16(nil, uint16(len(app)+2))...)
+	data = append(append(data, app...), encoded.Bytes()[2:]...)
+
+	mark := testMark(t)
+	var out bytes.Buffer
+	if err := Apply(&out, writeFile(t, "photo.jpg", data), mark); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out.Bytes())); err != nil {
+		t.Fatalf("результат не читается как JPEG: %v", err)
+	}
+	if !bytes.Contains(out.Bytes(), app) || !bytes.Contains(out.Bytes(), []byte(mark.Code)) {
+		t.Error("в результате нет сегмента APP1 или кода водяного знака")
+	}
+}
+
+func TestApplyRejectsHugeImageBeforeDecoding(t *testing.T) {
+	// Заголовок объявляет 20000x20000 пикселей, а данных почти нет: отказ должен прийти
+	// по заголовку, без распаковки и без записи в dst
+	data := encodePNG(t, 1, 1)
+	binary.BigEndian.PutUint32(data[16:], 20000)
+	binary.BigEndian.PutUint32(data[20:], 20000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	if _, err := png.DecodeConfig(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err := Apply(&out, writeFile(t, "huge.png", data), testMark(t))
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("ошибка %v, ожидалась ErrUnsupported", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("при отказе записано %d байт", out.Len())
+	}
+}
+
+func TestApplyPDF(t *testing.T) {
+	original := testPDF()
+	mark := testMark(t)
+	var out bytes.Buffer
+	if err := Apply(&out, writeFile(t, "book.pdf", original), mark); err != nil {
+		t.Fatal(err)
+	}
+	result := out.Bytes()
+	if !bytes.HasPrefix(result, original) {
+		t.Fatal("исходные байты документа изменены")
+	}
+	update := string(result[len(original):])
+	for _, want := range []string{"/Title (Old title)", "/MarketplaceWatermark (" + mark.Code + ")", "/Prev "} {
+		if !strings.Contains(update, want) {
+			t.Errorf("в обновлении нет %q:\n%s", want, update)
+		}
+	}
+
+	// Новый startxref указывает на новую таблицу ссылок, а она - на словарь /Info
+	matches := regexp.MustCompile(`startxref\s+(\d+)`).FindAllStringSubmatch(string(result), -1)
+	xref, _ := strconv.Atoi(matches[len(matches)-1][1])
+	if !bytes.HasPrefix(result[xref:], []byte("xref\n4 1\n")) {
+		t.Fatalf("startxref %d не указывает на новую таблицу", xref)
+	}
+	info, _ := strconv.Atoi(string(result[xref+len("xref\n4 1\n"):][:10]))
+	if !bytes.HasPrefix(result[info:], []byte("4 0 obj")) {
+		t.Errorf("таблица ссылок указывает на %d, а не на объект /Info", info)
+	}
+}
+
+func TestApplyZip(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for name, content := range map[string][]byte{"cover.png": encodePNG(t, 400, 100), "readme.txt": []byte("hello")} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mark := testMark(t)
+	var out bytes.Buffer
+	if err := Apply(&out, writeFile(t, "pack.zip", archive.Bytes()), mark); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, f := range r.File {
+		names[f.Name] = true
+		if f.Name != "cover.png" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cover, err := png.Decode(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("изображение в архиве не читается: %v", err)
+		}
+		// Правый нижний угол закрыт плашкой с надписью
+		if c := color.RGBAModel.Convert(cover.At(399, 99)).(color.RGBA); c.R >= 200 {
+			t.Errorf("на изображении в архиве нет надписи: %v", c)
+		}
+	}
+	if !names["readme.txt"] || !names[licenseFileName] {
+		t.Errorf("файлы архива: %v", names)
+	}
+	if codes := Find(out.Bytes()); len(codes) == 0 || codes[0] != mark.Code {
+		t.Errorf("найдены коды %v, ожидался %s", codes, mark.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package watermark
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// licenseFileName - файл лицензии, который добавляется в корень архива
+const licenseFileName = "LICENSE-MARKETPLACE.txt"
+
+// applyZip переупаковывает архив: PDF и изображения получают водяной знак, остальные файлы
+// копируются без пересжатия. В конец добавляется файл лицензии, в комментарий архива - Summary.
+func applyZip(dst io.Writer, path string, mark Mark) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	defer r.Close()
+
+	w := zip.NewWriter(dst)
+	for _, f := range r.File {
+		if err := copyZipEntry(w, f, mark); err != nil {
+			return err
+		}
+	}
+
+	// Лицензия хранится без сжатия, чтобы код находился и в байтах архива
+	license, err := w.CreateHeader(&zip.FileHeader{
+		Name:     licenseFileName,
+		Method:   zip.Store,
+		Modified: mark.IssuedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(license, mark.License()); err != nil {
+		return err
+	}
+	if err := w.SetComment(mark.Summary()); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// copyZipEntry копирует файл архива, встраивая водяной знак в PDF и изображения
+func copyZipEntry(w *zip.Writer, f *zip.File, mark Mark) error {
+	k := kindOf(f.Name)
+	if k == kindNone || k == kindZip || f.FileInfo().IsDir() || f.UncompressedSize64 > maxMarkedSize {
+		return w.Copy(f)
+	}
+
+	// Для разбора нужен произвольный доступ, поэтому файл распаковывается во временный
+	tmp, size, err := extractZipEntry(f)
+	if err != nil {
+		// Поврежденный, зашифрованный или сжатый неизвестным методом файл покупатель получает как есть
+		return w.Copy(f)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	write, err := markFile(k, tmp, size, mark)
+	if err != nil {
+		return w.Copy(f)
+	}
+
+	// Размеры, контрольную сумму и дополнительные поля zip.Writer заполнит заново
+	entry, err := w.CreateHeader(&zip.FileHeader{
+		Name:           f.Name,
+		Comment:        f.Comment,
+		Method:         f.Method,
+		Modified:       f.Modified,
+		ExternalAttrs:  f.ExternalAttrs,
+		CreatorVersion: f.CreatorVersion,
+	})
+	if err != nil {
+		return err
+	}
+	return write(entry)
+}
+
+// extractZipEntry распаковывает файл архива (не больше maxMarkedSize) во временный файл.
+// Временный файл удаляет вызывающий код.
+func extractZipEntry(f *zip.File) (*os.File, int64, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp("", "watermark-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := io.Copy(tmp, io.LimitReader(rc, maxMarkedSize+1))
+	if err == nil && size > maxMarkedSize {
+		err = fmt.Errorf("%w: файл больше %d МБ", ErrUnsupported, maxMarkedSize>>20)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, err
+	}
+	return tmp, size, nil
+}
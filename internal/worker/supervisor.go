@@ -0,0 +1,114 @@
+// Package worker запускает фоновые задачи приложения (письма, рассылки, очистку токенов)
+// и дожидается их завершения при остановке сервера.
+package worker
+
+import (
+	"context"
+	"digital-marketplace/internal/logging"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Supervisor следит за фоновыми задачами. Каждая задача получает контекст,
+// который отменяется в Shutdown и содержит логгер с полем job; Shutdown ждет, пока все задачи вернутся.
+// Паника в задаче записывается в журнал и не роняет процесс.
+type Supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+// NewSupervisor создает супервизор фоновых задач
+func NewSupervisor() *Supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Supervisor{ctx: ctx, cancel: cancel}
+}
+
+// Go запускает задачу в отдельной горутине. После начала остановки новые задачи
+// не запускаются, и Go возвращает false.
+func (s *Supervisor) Go(name string, job func(ctx context.Context)) bool {
+	return s.Spawn(context.Background(), name, job)
+}
+
+// Spawn запускает задачу от имени запроса: задача наследует логгер из parent (request_id, user_id)
+// и трассу запроса, чтобы ее записи в журнале и спаны связывались с запросом,
+// но не его отмену - ответ уже может быть отправлен.
+func (s *Supervisor) Spawn(parent context.Context, name string, job func(ctx context.Context)) bool {
+	logger := logging.FromContext(parent).With(slog.String("job", name))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		logger.Warn("Фоновая задача не запущена: сервер останавливается")
+		return false
+	}
+
+	ctx := logging.WithContext(s.ctx, logger)
+	ctx = trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(parent))
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer recoverJob(ctx)
+		job(ctx)
+	}()
+	return true
+}
+
+// Every запускает задачу раз в interval до остановки супервизора.
+// Первый запуск - через interval после старта.
+func (s *Supervisor) Every(name string, interval time.Duration, job func(ctx context.Context)) bool {
+	return s.Go(name, func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				runOnce(ctx, job)
+			}
+		}
+	})
+}
+
+// runOnce выполняет один проход периодической задачи; паника в нем не останавливает расписание
+func runOnce(ctx context.Context, job func(ctx context.Context)) {
+	defer recoverJob(ctx)
+	job(ctx)
+}
+
+// recoverJob записывает в журнал панику фоновой задачи вместо падения процесса
+func recoverJob(ctx context.Context) {
+	if r := recover(); r != nil {
+		logging.FromContext(ctx).Error("Паника в фоновой задаче", slog.Any("panic", r), slog.String("stack", string(debug.Stack())))
+	}
+}
+
+// Shutdown отменяет контекст задач и ждет их завершения, но не дольше, чем позволяет ctx
+func (s *Supervisor) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -8,8 +8,8 @@ HOST=${1:-"http://localhost"}
 
 echo "Проверка работоспособности для $HOST..."
 
-# Проверяем доступность эндпоинта /health
-response=$(curl -s -o /dev/null -w "%{http_code}" $HOST/health)
+# Проверяем доступность эндпоинта /health/ready
+response=$(curl -s -o /dev/null -w "%{http_code}" $HOST/health/ready)
 
 if [ $response -eq 200 ]; then
     echo "✅ Приложение работает нормально (код ответа: $response)"